@@ -6,12 +6,25 @@ import (
 )
 
 func Example_usage() {
-	os.Args = append(os.Args, "-h")
+	os.Args = []string{"scoreplay", "-h"}
 	fmt.Println()
 	main()
 
 	// Output:
-	// Usage:
+	// Usage: scoreplay [-h] [command] [-h] [flags] [args]
+	//
+	// Commands:
+	//   serve    Run the server, the default command
+	//   migrate  Bring the data model stored to the version of the binary
+	//   fsck     Check the consistency of the catalog, and repair it
+	//   backup   Take a snapshot of the catalog, or list the snapshots
+	//   restore  Rebuild the catalog from a snapshot, the latest one by default
+	//   reindex  Rebuild the missing media from the metadata of their objects
+	//   export   Write the media of a tenant as JSON Lines or CSV
+	//   import   Upload and register the files of a directory or a manifest
+	//   tags     Manage the tags of a tenant
+	//
+	// Every command is configured by:
 	//   LOGGER_PRETTY               bool           default false
 	//   LOGGER_LEVEL                string         default info
 	//   LOGGER_CALLER               bool           default false
@@ -23,10 +36,48 @@ func Example_usage() {
 	//   REDIS_PASSWORD              string         required
 	//   REDIS_SELECT_DB             int            required
 	//   REDIS_DISABLE_CACHE         bool           default false
+	//   REDIS_KEY_PREFIX            string         default <empty>
+	//   REDIS_HASH_TAGS             bool           default false
+	//   REDIS_MODE                  string         default standalone
+	//   REDIS_REPLICA_READS         bool           default false
+	//   REDIS_SENTINEL_MASTER_SET   string         default <empty>
+	//   REDIS_SENTINEL_USERNAME     string         default <empty>
+	//   REDIS_SENTINEL_PASSWORD     string         default <empty>
+	//   REDIS_TLS_ENABLED           bool           default false
+	//   REDIS_TLS_CA_FILE           string         default <empty>
+	//   REDIS_TLS_CERT_FILE         string         default <empty>
+	//   REDIS_TLS_KEY_FILE          string         default <empty>
 	//   STORAGE_BUCKET              string         required
+	//   MEDIA_REPAIR_INDEXES        bool           default false
+	//   SCHEMA_AUTO_MIGRATE         bool           default false
+	//   SCHEMA_LEGACY_TENANT        string         default <empty>
+	//   JANITOR_INTERVAL            time.Duration  default 10m
+	//   JANITOR_GRACE               time.Duration  default 1h
+	//   JANITOR_BATCH_SIZE          int64          default 100
+	//   JANITOR_METADATA_WORKERS    int            default 8
+	//   BACKUP_INTERVAL             time.Duration  default 24h
+	//   BACKUP_PREFIX               string         default .backups/
+	//   BACKUP_KEEP                 int            default 7
+	//   TAGS_MAX_LENGTH             int            default 64
+	//   TAGS_ALLOWED                string         default ^[\p{L}\p{M}\p{N}\p{P}\p{S} ]+$
+	//   TAGS_CASE                   string         default preserve
+	//   TAGS_STRICT                 bool           default false
+	//   TENANCY_HEADER              string         default X-Tenant-ID
+	//   AUTH_ADMIN_KEY              string         default <empty>
+	//   AUTH_JWT_JWKS_FILE          string         default <empty>
+	//   AUTH_JWT_JWKS_URL           string         default <empty>
+	//   AUTH_JWT_ISSUER             string         default <empty>
+	//   AUTH_JWT_AUDIENCE           string         default <empty>
+	//   AUTH_JWT_ROLES_CLAIM        string         default roles
+	//   AUTH_JWT_TENANT_CLAIM       string         default tenant
+	//   RATELIMIT_REQUESTS          int            default 600
+	//   RATELIMIT_PERIOD            time.Duration  default 1m
+	//   RATELIMIT_OPERATIONS        []string       default []
+	//   RATELIMIT_IP_REQUESTS       int            default 1200
 	//   SERVER_ADDRESS              string         default :8080
 	//   SERVER_SHUTDOWN_TIMEOUT     time.Duration  default 30s
 	//   SERVER_READ_HEADER_TIMEOUT  time.Duration  default 5s
+	//   SERVER_DEBUG_ADDRESS        string         default localhost:8081
 	//   HEALTHCHECK_CACHE_DURATION  time.Duration  default 1s
 	//   HEALTHCHECK_TIMEOUT         time.Duration  default 10s
 }
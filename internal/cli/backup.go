@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"scoreplay/internal/server"
+	"scoreplay/internal/service"
+)
+
+// backupCommand takes a snapshot of the catalog now, or lists the snapshots.
+func backupCommand(flags *flag.FlagSet) runFunc {
+	list := flags.Bool("list", false, "List the snapshots, oldest first, rather than taking one")
+
+	return func(ctx context.Context, cfg server.Config, args []string, w io.Writer) error {
+		if len(args) != 0 {
+			return fmt.Errorf("%w: backup with %d arguments", ErrUsage, len(args))
+		}
+
+		return withBackups(ctx, cfg, false, func(b backups) error {
+			if *list {
+				keys, err := b.List(ctx)
+				if err != nil {
+					return err
+				}
+				for _, key := range keys {
+					fmt.Fprintln(w, key)
+				}
+				return nil
+			}
+
+			key, err := b.Snapshot(ctx)
+			if key != "" {
+				fmt.Fprintln(w, key)
+			}
+			if err != nil {
+				return fmt.Errorf("taking snapshot: %w", err)
+			}
+			return nil
+		})
+	}
+}
+
+// restoreCommand rebuilds the catalog from a snapshot, the latest one unless one is given.
+func restoreCommand(flags *flag.FlagSet) runFunc {
+	var opts service.RestoreOptions
+	flags.StringVar(&opts.Tenant, "tenant", "", "ID of the single tenant restored, rather than all of them")
+	flags.BoolVar(&opts.Replace, "replace", false, "Delete the keys of the tenants restored first, rather than failing if there are any")
+
+	return func(ctx context.Context, cfg server.Config, args []string, w io.Writer) error {
+		if len(args) > 1 {
+			return fmt.Errorf("%w: restore with %d arguments", ErrUsage, len(args))
+		}
+		var key string
+		if len(args) == 1 {
+			key = args[0]
+		}
+
+		return withBackups(ctx, cfg, true, func(b backups) error {
+			restored, err := b.Restore(ctx, key, opts)
+			for _, id := range restored {
+				fmt.Fprintf(w, "restored tenant=%q\n", id)
+			}
+			if err != nil {
+				return fmt.Errorf("restoring snapshot: %w", err)
+			}
+			return nil
+		})
+	}
+}
+
+type backups interface {
+	Snapshot(ctx context.Context) (string, error)
+	List(ctx context.Context) ([]string, error)
+	Restore(ctx context.Context, key string, opts service.RestoreOptions) ([]string, error)
+}
+
+// withBackups calls fn with the backups described by the configuration. writes tells whether fn
+// writes to Redis.
+func withBackups(ctx context.Context, cfg server.Config, writes bool, fn func(b backups) error) error {
+	ns, client, err := newRedisClient(ctx, cfg, writes)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	s3Client, err := server.NewS3Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	b, err := service.NewBackups(client, ns, s3Client, cfg.Storage.Bucket, cfg.Backup)
+	if err != nil {
+		return fmt.Errorf("creating backups: %w", err)
+	}
+
+	return fn(b)
+}
@@ -0,0 +1,138 @@
+// Package cli implements the commands of the scoreplay binary. Every command is configured by
+// the environment variables of the server.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/redis/rueidis"
+	"github.com/rs/zerolog/log"
+	"go-simpler.org/env"
+
+	"scoreplay/internal/logger"
+	"scoreplay/internal/server"
+	"scoreplay/internal/service"
+)
+
+var opt = &env.Options{NameSep: "_", SliceSep: ","} //nolint: gochecknoglobals
+
+// runFunc runs a command with the configuration and the arguments left after its flags.
+type runFunc func(ctx context.Context, cfg server.Config, args []string, w io.Writer) error
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	// setup registers the flags of the command and returns the function running it.
+	setup func(flags *flag.FlagSet) runFunc
+}
+
+func commands() []command {
+	return []command{
+		{name: "serve", summary: "Run the server, the default command", setup: serveCommand},
+		{name: "migrate", summary: "Bring the data model stored to the version of the binary", setup: migrateCommand},
+		{name: "fsck", summary: "Check the consistency of the catalog, and repair it", setup: fsckCommand},
+		{name: "backup", summary: "Take a snapshot of the catalog, or list the snapshots", setup: backupCommand},
+		{name: "restore", args: "[SNAPSHOT]", summary: "Rebuild the catalog from a snapshot, the latest one by default", setup: restoreCommand},
+		{name: "reindex", summary: "Rebuild the missing media from the metadata of their objects", setup: reindexCommand},
+		{name: "export", summary: "Write the media of a tenant as JSON Lines or CSV", setup: exportCommand},
+		{name: "import", args: "DIRECTORY | MANIFEST", summary: "Upload and register the files of a directory or a manifest", setup: importCommand},
+		{name: "tags", args: "list | create TAG... | rename FROM TO | delete TAG...", summary: "Manage the tags of a tenant", setup: tagsCommand},
+	}
+}
+
+// Run runs the command named by the first argument, the server if there is none.
+func Run(ctx context.Context, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("scoreplay", flag.ContinueOnError)
+	flags.SetOutput(w)
+	flags.Usage = func() { usage(w) }
+	if err := flags.Parse(args); err != nil {
+		return parseError(err)
+	}
+
+	name, args := "serve", flags.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	i := slices.IndexFunc(commands(), func(c command) bool { return c.name == name })
+	if i < 0 {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	cmd := commands()[i]
+
+	flags = flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(w)
+	flags.Usage = func() {
+		fmt.Fprintf(w, "Usage: scoreplay %s [flags] %s\n\n%s.\n", cmd.name, cmd.args, cmd.summary)
+		flags.PrintDefaults()
+	}
+	run := cmd.setup(flags)
+	if err := flags.Parse(args); err != nil {
+		return parseError(err)
+	}
+
+	var cfg server.Config
+	if err := env.Load(&cfg, opt); err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	ctx = logger.NewLogger(log.Logger, cfg.Logger).WithContext(ctx)
+	log.Ctx(ctx).Info().Interface("cfg", cfg.Redacted()).Msg("config loaded")
+
+	return run(ctx, cfg, flags.Args(), w)
+}
+
+// parseError tells a request for help, answered by the usage already, from a parsing failure.
+func parseError(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+
+	return fmt.Errorf("parsing flags: %w", err)
+}
+
+// newRedisClient creates the key namespace and the Redis client described by the configuration,
+// failing unless the data model stored is at the version of the binary. A command writing data
+// records that version on a keyspace without data first.
+func newRedisClient(ctx context.Context, cfg server.Config, writes bool) (service.Namespace, rueidis.Client, error) {
+	ns, err := server.NewNamespace(cfg)
+	if err != nil {
+		return service.Namespace{}, nil, err
+	}
+	client, err := server.NewRedisClient(cfg)
+	if err != nil {
+		return service.Namespace{}, nil, err
+	}
+	check := server.CheckSchema
+	if writes {
+		check = server.InitSchema
+	}
+	if err := check(ctx, client, ns, cfg); err != nil {
+		client.Close()
+		return service.Namespace{}, nil, err
+	}
+
+	return ns, client, nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: scoreplay [-h] [command] [-h] [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command is configured by:")
+	env.Usage(&server.Config{}, w, opt)
+}
+
+func serveCommand(_ *flag.FlagSet) runFunc {
+	return func(ctx context.Context, cfg server.Config, _ []string, _ io.Writer) error {
+		return server.Run(ctx, cfg)
+	}
+}
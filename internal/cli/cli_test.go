@@ -0,0 +1,287 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// setupEnv configures the commands to use the given Redis server and fake AWS credentials.
+func setupEnv(t *testing.T, s *miniredis.Miniredis) {
+	t.Helper()
+	for name, value := range map[string]string{
+		"REDIS_INIT_ADDRESS":    s.Addr(),
+		"REDIS_USERNAME":        "",
+		"REDIS_PASSWORD":        "",
+		"REDIS_SELECT_DB":       "0",
+		"REDIS_DISABLE_CACHE":   "true",
+		"STORAGE_BUCKET":        "bucket",
+		"AWS_S3_USE_PATH_STYLE": "true",
+		"AWS_REGION":            "us-east-1",
+		"AWS_ACCESS_KEY_ID":     "key",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+	} {
+		t.Setenv(name, value)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it shows the commands", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"-h"}, &out)
+
+		require.NoError(t, err)
+		require.Contains(t, out.String(), "  fsck     Check the consistency of the catalog, and repair it\n")
+		require.Contains(t, out.String(), "REDIS_INIT_ADDRESS")
+	})
+
+	t.Run("it shows the flags of a command without loading the config", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"fsck", "-h"}, &out)
+
+		require.NoError(t, err)
+		require.Contains(t, out.String(), "Usage: scoreplay fsck [flags]")
+		require.Contains(t, out.String(), "-repair")
+	})
+
+	t.Run("it fails on unknown commands", func(t *testing.T) {
+		err := Run(ctx, []string{"fly"}, &bytes.Buffer{})
+		require.EqualError(t, err, `unknown command "fly"`)
+	})
+
+	t.Run("it fails on unknown flags", func(t *testing.T) {
+		err := Run(ctx, []string{"fsck", "-fly"}, &bytes.Buffer{})
+		require.EqualError(t, err, "parsing flags: flag provided but not defined: -fly")
+	})
+
+	t.Run("it fails if the config cannot be loaded", func(t *testing.T) {
+		err := Run(ctx, []string{"tags"}, &bytes.Buffer{})
+		require.ErrorContains(t, err, "loading config: ")
+	})
+
+	t.Run("it fails if the schema is outdated", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		setupEnv(t, s)
+		s.HSet("tenant:club:media:key1", "name", "a", "tags", "t1")
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.jpg"), []byte("a"), 0o600))
+
+		for _, args := range [][]string{
+			{"fsck"},
+			{"backup"},
+			{"restore"},
+			{"reindex"},
+			{"export", "-tenant", "club"},
+			{"import", "-tenant", "club", dir},
+			{"tags", "-tenant", "club", "list"},
+		} {
+			err := Run(ctx, args, &bytes.Buffer{})
+			require.EqualError(t, err, "checking schema: schema is outdated: version 1, expected 4", args[0])
+		}
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	setupEnv(t, s)
+	s.HSet("tenant:club:media:key1", "name", "a", "tags", "t1")
+	_, err := s.ZAdd("uploads:pending", 1, "club/key1")
+	require.NoError(t, err)
+
+	t.Run("it writes the pending migrations on a dry run", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"migrate", "-dry-run"}, &out)
+
+		require.NoError(t, err)
+		require.Equal(t, `pending version=2 summary="Store the tags of the media records as JSON arrays"`+"\n"+
+			`pending version=3 summary="Move the pending uploads under their tenants"`+"\n"+
+			`pending version=4 summary="Move the data stored before tenancy into the legacy tenant"`+"\n", out.String())
+		require.Equal(t, "t1", s.HGet("tenant:club:media:key1", "tags"))
+	})
+
+	t.Run("it migrates the schema", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"migrate"}, &out)
+
+		require.NoError(t, err)
+		require.Equal(t, `migrated version=2 keys=1 summary="Store the tags of the media records as JSON arrays"`+"\n"+
+			`migrated version=3 keys=1 summary="Move the pending uploads under their tenants"`+"\n"+
+			`migrated version=4 keys=0 summary="Move the data stored before tenancy into the legacy tenant"`+"\n", out.String())
+		require.Equal(t, `["t1"]`, s.HGet("tenant:club:media:key1", "tags"))
+		pending, err := s.ZMembers("tenant:club:uploads:pending")
+		require.NoError(t, err)
+		require.Equal(t, []string{"club/key1"}, pending)
+	})
+
+	t.Run("it fails with wrong arguments", func(t *testing.T) {
+		err := Run(ctx, []string{"migrate", "now"}, &bytes.Buffer{})
+		require.EqualError(t, err, "wrong arguments: migrate with 1 arguments")
+	})
+}
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	setupEnv(t, s)
+
+	run := func(t *testing.T, args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		require.NoError(t, Run(ctx, append([]string{"tags", "-tenant", "club"}, args...), &out))
+		return out.String()
+	}
+
+	t.Run("it manages the tags", func(t *testing.T) {
+		run(t, "create", "t2", " t1 ")
+		require.Equal(t, "t1\nt2\n", run(t, "list"))
+
+		run(t, "rename", "t1", "t3")
+		require.Equal(t, "t2\nt3\n", run(t, "list"))
+
+		run(t, "delete", "t2", "t3")
+		require.Equal(t, "", run(t, "list"))
+	})
+
+	t.Run("it fails without a valid tenant", func(t *testing.T) {
+		err := Run(ctx, []string{"tags", "list"}, &bytes.Buffer{})
+		require.ErrorIs(t, err, ErrUsage)
+		require.EqualError(t, err, `wrong arguments: invalid tenant ""`)
+	})
+
+	t.Run("it fails with wrong arguments", func(t *testing.T) {
+		err := Run(ctx, []string{"tags", "-tenant", "club", "rename", "t1"}, &bytes.Buffer{})
+		require.EqualError(t, err, "wrong arguments: tags rename with 1 arguments")
+	})
+
+	t.Run("it fails if the tag does not exist", func(t *testing.T) {
+		err := Run(ctx, []string{"tags", "-tenant", "club", "delete", "t9"}, &bytes.Buffer{})
+		require.EqualError(t, err, `deleting tag "t9": tag not found`)
+	})
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	setupEnv(t, s)
+	t.Setenv("AWS_ENDPOINT_URL", "https://s3.example.com")
+	require.NoError(t, s.Set("schema:version", "4"))
+	s.HSet("tenant:club:media:key1", "name", "a", "tags", `["t1"]`)
+
+	t.Run("it writes the media", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"export", "-tenant", "club", "-format", "csv"}, &out)
+
+		require.NoError(t, err)
+		require.Equal(t, "id,name,tags,createdAt,url\nkey1,a,t1,,https://s3.example.com/bucket/club/key1\n", out.String())
+	})
+
+	t.Run("it writes the media to a file", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "media.jsonl")
+		var out bytes.Buffer
+		err := Run(ctx, []string{"export", "-tenant", "club", "-output", output}, &out)
+
+		require.NoError(t, err)
+		require.Empty(t, out.String())
+		content, err := os.ReadFile(output)
+		require.NoError(t, err)
+		require.Equal(t, `{"id":"key1","name":"a","tags":["t1"],"url":"https://s3.example.com/bucket/club/key1"}`+"\n", string(content))
+	})
+
+	t.Run("it fails on an unknown format", func(t *testing.T) {
+		err := Run(ctx, []string{"export", "-tenant", "club", "-format", "xml"}, &bytes.Buffer{})
+		require.EqualError(t, err, `wrong arguments: format "xml"`)
+	})
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	setupEnv(t, s)
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+			`<Name>bucket</Name><KeyCount>1</KeyCount><IsTruncated>false</IsTruncated>` +
+			`<Contents><Key>.backups/snapshot-20240501T123015Z.json.gz</Key></Contents>` +
+			`</ListBucketResult>`))
+	}))
+	defer bucket.Close()
+	t.Setenv("AWS_ENDPOINT_URL", bucket.URL)
+
+	t.Run("it lists the snapshots", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"backup", "-list"}, &out)
+
+		require.NoError(t, err)
+		require.Equal(t, ".backups/snapshot-20240501T123015Z.json.gz\n", out.String())
+	})
+
+	t.Run("it fails on a prefix overlapping a tenant", func(t *testing.T) {
+		t.Setenv("BACKUP_PREFIX", "club/")
+		err := Run(ctx, []string{"backup"}, &bytes.Buffer{})
+		require.EqualError(t, err, `creating backups: backup prefix "club/" overlaps the objects of tenant "club"`)
+	})
+
+	t.Run("it fails with wrong arguments", func(t *testing.T) {
+		err := Run(ctx, []string{"restore", "a", "b"}, &bytes.Buffer{})
+		require.EqualError(t, err, "wrong arguments: restore with 2 arguments")
+	})
+}
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	setupEnv(t, s)
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("X-Amz-Meta-Name", "a+1")
+			w.Header().Set("X-Amz-Meta-Tags", "t1")
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+			`<Name>bucket</Name><KeyCount>1</KeyCount><IsTruncated>false</IsTruncated>` +
+			`<Contents><Key>club/key1</Key></Contents>` +
+			`</ListBucketResult>`))
+	}))
+	defer bucket.Close()
+	t.Setenv("AWS_ENDPOINT_URL", bucket.URL)
+
+	t.Run("it writes the media without storing them on a dry run", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"reindex", "-dry-run"}, &out)
+
+		require.NoError(t, err)
+		require.Equal(t, `reindexed tenant="club" media="key1" name="a 1" tags="t1"`+"\n", out.String())
+		require.Empty(t, s.Keys())
+	})
+
+	t.Run("it rebuilds the missing media", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"reindex", "-tenant", "club"}, &out)
+
+		require.NoError(t, err)
+		require.Equal(t, `reindexed tenant="club" media="key1" name="a 1" tags="t1"`+"\n", out.String())
+		require.Equal(t, "a 1", s.HGet("tenant:club:media:key1", "name"))
+
+		out.Reset()
+		err = Run(ctx, []string{"reindex"}, &out)
+
+		require.NoError(t, err)
+		require.Empty(t, out.String())
+	})
+
+	t.Run("it fails with wrong arguments", func(t *testing.T) {
+		err := Run(ctx, []string{"reindex", "club"}, &bytes.Buffer{})
+		require.EqualError(t, err, "wrong arguments: reindex with 1 arguments")
+	})
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"scoreplay/internal/server"
+	"scoreplay/internal/service"
+	"scoreplay/internal/tenant"
+)
+
+// mediaExporter exports media.
+type mediaExporter interface {
+	ExportMedia(ctx context.Context, w io.Writer, format string) error
+}
+
+// exportCommand writes the media of a tenant as JSON Lines or CSV, to a file or to the output.
+func exportCommand(flags *flag.FlagSet) runFunc {
+	tenantID := flags.String("tenant", "", "ID of the tenant owning the media")
+	format := flags.String("format", service.ExportJSONLines, "Format of the export, jsonl or csv")
+	output := flags.String("output", "", "File written instead of the output")
+
+	return func(ctx context.Context, cfg server.Config, args []string, w io.Writer) error {
+		if !tenant.Valid(*tenantID) {
+			return fmt.Errorf("%w: invalid tenant %q", ErrUsage, *tenantID)
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("%w: export with %d arguments", ErrUsage, len(args))
+		}
+		if *format != service.ExportJSONLines && *format != service.ExportCSV {
+			return fmt.Errorf("%w: format %q", ErrUsage, *format)
+		}
+		ctx = tenant.WithID(ctx, *tenantID)
+
+		ns, client, err := newRedisClient(ctx, cfg, false)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		endpointURL, err := url.Parse(cfg.AWS.EndpointURL)
+		if err != nil {
+			return fmt.Errorf("parsing endpoint url: %w", err)
+		}
+		ms := service.NewMediaService(client, nil, ns, nil, *endpointURL, cfg.Storage.Bucket, service.TagRules{}, false)
+
+		if *output == "" {
+			return exportMedia(ctx, ms, w, *format)
+		}
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("creating output: %w", err)
+		}
+		if err := exportMedia(ctx, ms, f, *format); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("closing output: %w", err)
+		}
+
+		return nil
+	}
+}
+
+func exportMedia(ctx context.Context, ms mediaExporter, w io.Writer, format string) error {
+	if err := ms.ExportMedia(ctx, w, format); err != nil {
+		return fmt.Errorf("exporting media: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"scoreplay/internal/server"
+	"scoreplay/internal/service"
+)
+
+var ErrInconsistent = errors.New("catalog is inconsistent")
+
+// fsckCommand checks the consistency of the catalog, writing every problem found. It fails with
+// ErrInconsistent if problems are left unrepaired.
+func fsckCommand(flags *flag.FlagSet) runFunc {
+	var opts service.FsckOptions
+	flags.BoolVar(&opts.Repair, "repair", false, "Repair the problems found, except for the orphan objects")
+	flags.BoolVar(&opts.DeleteOrphanObjects, "delete-orphan-objects", false, "Also delete the objects without media when repairing")
+	flags.DurationVar(&opts.Grace, "grace", time.Hour, "Time given to uploads before media and objects are reported")
+
+	return func(ctx context.Context, cfg server.Config, _ []string, w io.Writer) error {
+		ns, client, err := newRedisClient(ctx, cfg, opts.Repair)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		s3Client, err := server.NewS3Client(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		problems, err := service.NewChecker(client, ns, s3Client, cfg.Storage.Bucket).Check(ctx, opts)
+		var unrepaired int
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+			if !p.Repaired {
+				unrepaired++
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("checking catalog: %w", err)
+		}
+		if unrepaired > 0 {
+			return fmt.Errorf("%w: %d problems left", ErrInconsistent, unrepaired)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	setupEnv(t, s)
+	// The bucket is only listed under the directories of the tenants found in Redis.
+	require.NoError(t, s.Set("schema:version", "4"))
+	_, err := s.SAdd("tenant:club:tags", "t1")
+	require.NoError(t, err)
+
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prefix") != "club/" {
+			http.Error(w, "unexpected prefix", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+			`<Name>bucket</Name><KeyCount>1</KeyCount><IsTruncated>false</IsTruncated>` +
+			`<Contents><Key>club/orphan</Key><LastModified>2020-01-01T00:00:00.000Z</LastModified></Contents>` +
+			`</ListBucketResult>`))
+	}))
+	defer bucket.Close()
+	t.Setenv("AWS_ENDPOINT_URL", bucket.URL)
+
+	t.Run("it reports the problems left", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(ctx, []string{"fsck", "-repair"}, &out)
+
+		require.ErrorIs(t, err, ErrInconsistent)
+		require.EqualError(t, err, "catalog is inconsistent: 1 problems left")
+		require.Equal(t, "orphan-object object=\"club/orphan\"\n", out.String())
+	})
+
+	t.Run("it fails if the redis client cannot be created", func(t *testing.T) {
+		t.Setenv("REDIS_INIT_ADDRESS", "")
+		err := Run(ctx, []string{"fsck"}, &bytes.Buffer{})
+		require.EqualError(t, err, "creating redis client: dial tcp: missing address")
+	})
+}
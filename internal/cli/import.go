@@ -0,0 +1,404 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+
+	"scoreplay/internal/server"
+	"scoreplay/internal/service"
+	"scoreplay/internal/tenant"
+)
+
+var ErrIncomplete = errors.New("import is incomplete")
+
+// importItem is a file to import, as listed by a manifest.
+type importItem struct {
+	Path string   `json:"path"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+type importOptions struct {
+	tenant      string
+	concurrency int
+	timeout     time.Duration
+	journal     string
+	tags        string
+	dirTags     bool
+	createTags  bool
+	dryRun      bool
+}
+
+// importCommand uploads the files of a directory or a manifest, and registers them as media. The
+// files imported are recorded in a journal, so that a restarted import skips them.
+func importCommand(flags *flag.FlagSet) runFunc {
+	var opts importOptions
+	flags.StringVar(&opts.tenant, "tenant", "", "ID of the tenant owning the media")
+	flags.IntVar(&opts.concurrency, "concurrency", 4, "Number of files imported at once")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Minute, "Time given to the upload of a file, after which it fails")
+	flags.StringVar(&opts.journal, "journal", "scoreplay-import.journal", "File recording the files imported, skipped when the import runs again")
+	flags.StringVar(&opts.tags, "tags", "", "Comma-separated tags given to every file")
+	flags.BoolVar(&opts.dirTags, "dir-tags", false, "Tag the files of a directory with the names of its subdirectories they are in")
+	flags.BoolVar(&opts.createTags, "create-tags", false, "Create the tags of the files which do not exist yet")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "Write the files which would be imported, and stop")
+
+	return func(ctx context.Context, cfg server.Config, args []string, w io.Writer) error {
+		if !tenant.Valid(opts.tenant) {
+			return fmt.Errorf("%w: invalid tenant %q", ErrUsage, opts.tenant)
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("%w: import with %d arguments", ErrUsage, len(args))
+		}
+		if opts.concurrency < 1 {
+			return fmt.Errorf("%w: concurrency %d", ErrUsage, opts.concurrency)
+		}
+		ctx = tenant.WithID(ctx, opts.tenant)
+
+		items, err := listImportItems(args[0], opts)
+		if err != nil {
+			return err
+		}
+		done, err := readJournal(opts.journal, opts.tenant)
+		if err != nil {
+			return err
+		}
+		items = slices.DeleteFunc(items, func(item importItem) bool { return done[item.Path] })
+
+		tagRules, err := service.NewTagRules(cfg.Tags)
+		if err != nil {
+			return fmt.Errorf("creating tag rules: %w", err)
+		}
+		for i := range items {
+			if items[i].Tags, err = tagRules.NormalizeAll(items[i].Tags); err != nil {
+				return fmt.Errorf("importing %s: %w", items[i].Path, err)
+			}
+		}
+		if opts.dryRun {
+			for _, item := range items {
+				fmt.Fprintf(w, "import path=%q name=%q tags=%q\n", item.Path, item.Name, strings.Join(item.Tags, ","))
+			}
+			return nil
+		}
+
+		ns, client, err := newRedisClient(ctx, cfg, true)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		s3Client, err := server.NewS3Client(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		ms := service.NewMediaService(client, nil, ns, s3.NewPresignClient(s3Client), url.URL{}, cfg.Storage.Bucket, tagRules, false)
+
+		if opts.createTags {
+			for _, tag := range importTags(items) {
+				if err := ms.CreateTag(ctx, service.CreateTagParams{Name: tag}); err != nil {
+					return fmt.Errorf("creating tag %q: %w", tag, err)
+				}
+			}
+		}
+
+		journal, err := os.OpenFile(opts.journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening journal: %w", err)
+		}
+		defer journal.Close()
+
+		var mu sync.Mutex
+		var failed atomic.Int64
+		// A stalled upload fails rather than holding its slot forever.
+		hc := &http.Client{Timeout: opts.timeout}
+		var g errgroup.Group
+		g.SetLimit(opts.concurrency)
+		for _, item := range items {
+			g.Go(func() error {
+				key, err := importFile(ctx, ms, hc, item)
+				if err != nil {
+					failed.Add(1)
+					log.Ctx(ctx).Error().Err(err).Str("path", item.Path).Msg("importing file")
+					return nil
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				fmt.Fprintf(w, "imported path=%q key=%q\n", item.Path, key)
+				if _, err := fmt.Fprintf(journal, "%s %q %s\n", opts.tenant, item.Path, key); err != nil {
+					failed.Add(1)
+					log.Ctx(ctx).Error().Err(err).Str("path", item.Path).Msg("writing journal")
+				}
+				return nil
+			})
+		}
+		_ = g.Wait()
+
+		if n := failed.Load(); n > 0 {
+			return fmt.Errorf("%w: %d of %d files failed", ErrIncomplete, n, len(items))
+		}
+
+		return nil
+	}
+}
+
+// mediaCreator registers media.
+type mediaCreator interface {
+	CreateMedia(ctx context.Context, params service.CreateMediaParams) (*service.CreateMediaResult, error)
+}
+
+// importFile registers the media of the given file, and uploads it to the presigned request. The
+// idempotency key derived from the path makes a restarted import reuse the media of a file whose
+// upload was interrupted, for as long as the key is kept.
+func importFile(ctx context.Context, ms mediaCreator, hc *http.Client, item importItem) (string, error) {
+	f, err := os.Open(item.Path)
+	if err != nil {
+		return "", fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("opening file: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(item.Path))
+	result, err := ms.CreateMedia(ctx, service.CreateMediaParams{
+		Name:           item.Name,
+		Tags:           item.Tags,
+		IdempotencyKey: "import-" + hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating media: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, result.Method, result.URL, f)
+	if err != nil {
+		return "", fmt.Errorf("creating upload request: %w", err)
+	}
+	for name, values := range result.SignedHeader {
+		req.Header[name] = values
+	}
+	req.ContentLength = info.Size()
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("uploading file: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("uploading file: unexpected status %s", resp.Status)
+	}
+
+	return result.Key, nil
+}
+
+// listImportItems lists the files of the given directory, or reads the given manifest, a .csv or
+// .json file. The paths of a manifest are relative to its directory.
+func listImportItems(source string, opts importOptions) ([]importItem, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("reading source: %w", err)
+	}
+
+	var items []importItem
+	if info.IsDir() {
+		items, err = walkImportItems(source, opts.dirTags)
+	} else {
+		items, err = readManifest(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	if opts.tags != "" {
+		tags = strings.Split(opts.tags, ",")
+	}
+	for i := range items {
+		if items[i].Path, err = filepath.Abs(items[i].Path); err != nil {
+			return nil, fmt.Errorf("reading source: %w", err)
+		}
+		if items[i].Name == "" {
+			items[i].Name = filepath.Base(items[i].Path)
+		}
+		items[i].Tags = append(items[i].Tags, tags...)
+	}
+
+	return items, nil
+}
+
+// walkImportItems lists the regular files of the given directory, leaving hidden files out.
+func walkImportItems(root string, dirTags bool) ([]importItem, error) {
+	var items []importItem
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		item := importItem{Path: path}
+		if dirTags {
+			rel, err := filepath.Rel(root, filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			if rel != "." {
+				item.Tags = strings.Split(filepath.ToSlash(rel), "/")
+			}
+		}
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking directory: %w", err)
+	}
+
+	return items, nil
+}
+
+// readManifest reads a JSON array of items, or a CSV file whose header names the path, name and
+// tags columns. The tags of a CSV row are separated by commas.
+func readManifest(manifest string) ([]importItem, error) {
+	f, err := os.Open(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	defer f.Close()
+
+	var items []importItem
+	switch ext := filepath.Ext(manifest); ext {
+	case ".json":
+		if err := json.NewDecoder(f).Decode(&items); err != nil {
+			return nil, fmt.Errorf("reading manifest: %w", err)
+		}
+	case ".csv":
+		if items, err = readCSVManifest(f); err != nil {
+			return nil, fmt.Errorf("reading manifest: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: manifest format %q", ErrUsage, ext)
+	}
+
+	dir := filepath.Dir(manifest)
+	for i, item := range items {
+		if item.Path == "" {
+			return nil, fmt.Errorf("reading manifest: item %d: missing path", i+1)
+		}
+		if !filepath.IsAbs(item.Path) {
+			items[i].Path = filepath.Join(dir, item.Path)
+		}
+	}
+
+	return items, nil
+}
+
+func readCSVManifest(r io.Reader) ([]importItem, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"path": -1, "name": -1, "tags": -1}
+	for i, column := range records[0] {
+		if _, ok := columns[column]; ok {
+			columns[column] = i
+		}
+	}
+	if columns["path"] < 0 {
+		return nil, errors.New("missing path column")
+	}
+	field := func(record []string, column string) string {
+		if i := columns[column]; i >= 0 {
+			return record[i]
+		}
+		return ""
+	}
+
+	items := make([]importItem, 0, len(records)-1)
+	for _, record := range records[1:] {
+		item := importItem{Path: field(record, "path"), Name: field(record, "name")}
+		if tags := field(record, "tags"); tags != "" {
+			item.Tags = strings.Split(tags, ",")
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// importTags returns the distinct tags of the given items, sorted.
+func importTags(items []importItem) []string {
+	seen := map[string]bool{}
+	for _, item := range items {
+		for _, tag := range item.Tags {
+			seen[tag] = true
+		}
+	}
+
+	return slices.Sorted(maps.Keys(seen))
+}
+
+// readJournal returns the paths of the files the tenant imported already. Each line of the
+// journal holds a tenant, a quoted path and a media key.
+func readJournal(journal, tenantID string) (map[string]bool, error) {
+	done := map[string]bool{}
+	f, err := os.Open(journal)
+	if errors.Is(err, fs.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id, rest, _ := strings.Cut(scanner.Text(), " ")
+		i := strings.LastIndexByte(rest, ' ')
+		if id != tenantID || i < 0 {
+			continue
+		}
+		// A line cut short by a crash does not unquote, and its file is imported again.
+		if path, err := strconv.Unquote(rest[:i]); err == nil {
+			done[path] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+
+	return done, nil
+}
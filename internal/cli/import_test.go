@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles writes the given files, by path relative to the returned directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+// fakeBucket records the objects uploaded, answering with the given status.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string]string
+	status  int
+}
+
+func newFakeBucket(t *testing.T, status int) *fakeBucket {
+	t.Helper()
+	b := &fakeBucket{objects: map[string]string{}, status: status}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if r.Method == http.MethodPut && b.status == http.StatusOK {
+			b.objects[r.URL.Path] = string(body)
+		}
+		w.WriteHeader(b.status)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	return b
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it imports a directory once", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		setupEnv(t, s)
+		bucket := newFakeBucket(t, http.StatusOK)
+		dir := writeFiles(t, map[string]string{"2009/final/a.jpg": "a", "b.jpg": "b", ".DS_Store": "x"})
+		journal := filepath.Join(t.TempDir(), "journal")
+		args := []string{"import", "-tenant", "club", "-journal", journal, "-tags", "archive", "-dir-tags", "-create-tags", dir}
+
+		var out bytes.Buffer
+		err := Run(ctx, args, &out)
+
+		require.NoError(t, err)
+		require.Len(t, strings.Split(strings.TrimSpace(out.String()), "\n"), 2)
+		require.Len(t, bucket.objects, 2)
+		for object, content := range bucket.objects {
+			require.True(t, strings.HasPrefix(object, "/bucket/club/"), object)
+			key := strings.TrimPrefix(object, "/bucket/club/")
+			require.Equal(t, content+".jpg", s.HGet("tenant:club:media:"+key, "name"))
+		}
+		tags, err := s.SMembers("tenant:club:tags")
+		require.NoError(t, err)
+		require.Equal(t, []string{"2009", "archive", "final"}, tags)
+		members, err := s.SMembers("tenant:club:tags:final")
+		require.NoError(t, err)
+		require.Len(t, members, 1)
+
+		out.Reset()
+		err = Run(ctx, args, &out)
+
+		require.NoError(t, err)
+		require.Empty(t, out.String())
+		require.Len(t, bucket.objects, 2)
+	})
+
+	t.Run("it writes the files of a manifest on a dry run", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		setupEnv(t, s)
+		dir := writeFiles(t, map[string]string{
+			"manifest.csv": "tags,path,name\n\"t1,t2\",photos/a.jpg,Final\n,/abs/b.jpg,\n",
+		})
+
+		var out bytes.Buffer
+		err := Run(ctx, []string{"import", "-tenant", "club", "-journal", filepath.Join(dir, "journal"), "-dry-run", filepath.Join(dir, "manifest.csv")}, &out)
+
+		require.NoError(t, err)
+		require.Equal(t, `import path="`+filepath.Join(dir, "photos/a.jpg")+`" name="Final" tags="t1,t2"`+"\n"+
+			`import path="/abs/b.jpg" name="b.jpg" tags=""`+"\n", out.String())
+		require.Empty(t, s.Keys())
+	})
+
+	t.Run("it fails on the files which were not imported", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		setupEnv(t, s)
+		bucket := newFakeBucket(t, http.StatusForbidden)
+		dir := writeFiles(t, map[string]string{
+			"manifest.json": `[{"path": "a.jpg", "tags": ["t1"]}, {"path": "b.jpg"}]`,
+			"a.jpg":         "a",
+			"b.jpg":         "b",
+		})
+		journal := filepath.Join(dir, "journal")
+
+		err := Run(ctx, []string{"import", "-tenant", "club", "-journal", journal, filepath.Join(dir, "manifest.json")}, &bytes.Buffer{})
+
+		require.ErrorIs(t, err, ErrIncomplete)
+		require.EqualError(t, err, "import is incomplete: 2 of 2 files failed")
+		require.Empty(t, bucket.objects)
+		content, err := os.ReadFile(journal)
+		require.NoError(t, err)
+		require.Empty(t, content)
+	})
+
+	t.Run("it fails on the uploads which stall", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		setupEnv(t, s)
+		stalled := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			<-stalled
+		}))
+		t.Cleanup(srv.Close)
+		t.Cleanup(func() { close(stalled) })
+		t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+		dir := writeFiles(t, map[string]string{"a.jpg": "a"})
+
+		err := Run(ctx, []string{"import", "-tenant", "club", "-journal", filepath.Join(t.TempDir(), "journal"), "-timeout", "50ms", dir}, &bytes.Buffer{})
+
+		require.EqualError(t, err, "import is incomplete: 1 of 1 files failed")
+	})
+
+	t.Run("it fails on an unknown manifest format", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		setupEnv(t, s)
+		dir := writeFiles(t, map[string]string{"manifest.txt": "a.jpg"})
+
+		err := Run(ctx, []string{"import", "-tenant", "club", filepath.Join(dir, "manifest.txt")}, &bytes.Buffer{})
+
+		require.ErrorIs(t, err, ErrUsage)
+		require.EqualError(t, err, `wrong arguments: manifest format ".txt"`)
+	})
+}
+
+func TestReadJournal(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"journal": "club \"/a b.jpg\" key1\nother \"/c.jpg\" key2\nclub \"/d.jpg key3\nclub \"/e.jpg\"",
+	})
+
+	done, err := readJournal(filepath.Join(dir, "journal"), "club")
+
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"/a b.jpg": true}, done)
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"scoreplay/internal/server"
+	"scoreplay/internal/service"
+)
+
+// migrateCommand brings the data model stored to the version of the binary, writing every
+// migration run.
+func migrateCommand(flags *flag.FlagSet) runFunc {
+	dryRun := flags.Bool("dry-run", false, "Write the migrations which would run without running them")
+
+	return func(ctx context.Context, cfg server.Config, args []string, w io.Writer) error {
+		if len(args) != 0 {
+			return fmt.Errorf("%w: migrate with %d arguments", ErrUsage, len(args))
+		}
+
+		ns, err := server.NewNamespace(cfg)
+		if err != nil {
+			return err
+		}
+		client, err := server.NewRedisClient(cfg)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		s3Client, err := server.NewS3Client(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		results, err := service.NewMigrator(client, ns, s3Client, cfg.Storage.Bucket, cfg.Schema.LegacyTenant).Migrate(ctx, *dryRun)
+		for _, r := range results {
+			if *dryRun {
+				fmt.Fprintf(w, "pending version=%d summary=%q\n", r.Version, r.Summary)
+			} else {
+				fmt.Fprintf(w, "migrated version=%d keys=%d summary=%q\n", r.Version, r.Migrated, r.Summary)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("migrating schema: %w", err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"scoreplay/internal/server"
+	"scoreplay/internal/service"
+)
+
+// reindexCommand rebuilds the media missing from the catalog from the metadata of their objects,
+// writing every media rebuilt or skipped.
+func reindexCommand(flags *flag.FlagSet) runFunc {
+	var opts service.ReindexOptions
+	flags.StringVar(&opts.Tenant, "tenant", "", "ID of the single tenant reindexed, rather than all of them")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "Write the media which would be rebuilt without storing them")
+
+	return func(ctx context.Context, cfg server.Config, args []string, w io.Writer) error {
+		if len(args) != 0 {
+			return fmt.Errorf("%w: reindex with %d arguments", ErrUsage, len(args))
+		}
+
+		ns, client, err := newRedisClient(ctx, cfg, !opts.DryRun)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		s3Client, err := server.NewS3Client(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		reindexed, err := service.NewReindexer(client, ns, s3Client, cfg.Storage.Bucket).Reindex(ctx, opts)
+		for _, m := range reindexed {
+			fmt.Fprintln(w, m)
+		}
+		if err != nil {
+			return fmt.Errorf("reindexing catalog: %w", err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+
+	"scoreplay/internal/server"
+	"scoreplay/internal/service"
+	"scoreplay/internal/tenant"
+)
+
+var ErrUsage = errors.New("wrong arguments")
+
+// tagsCommand lists, creates, renames and deletes the tags of a tenant.
+func tagsCommand(flags *flag.FlagSet) runFunc {
+	tenantID := flags.String("tenant", "", "ID of the tenant owning the tags")
+
+	return func(ctx context.Context, cfg server.Config, args []string, w io.Writer) error {
+		if !tenant.Valid(*tenantID) {
+			return fmt.Errorf("%w: invalid tenant %q", ErrUsage, *tenantID)
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%w: missing tags command", ErrUsage)
+		}
+		action, args := args[0], args[1:]
+		ctx = tenant.WithID(ctx, *tenantID)
+
+		ns, client, err := newRedisClient(ctx, cfg, action != "list")
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		tagRules, err := service.NewTagRules(cfg.Tags)
+		if err != nil {
+			return fmt.Errorf("creating tag rules: %w", err)
+		}
+		ms := service.NewMediaService(client, nil, ns, nil, url.URL{}, cfg.Storage.Bucket, tagRules, false)
+
+		switch {
+		case action == "list" && len(args) == 0:
+			tags, err := ms.ListTags(ctx)
+			if err != nil {
+				return err
+			}
+			slices.Sort(tags)
+			for _, tag := range tags {
+				fmt.Fprintln(w, tag)
+			}
+		case action == "create" && len(args) > 0:
+			for _, tag := range args {
+				if err := ms.CreateTag(ctx, service.CreateTagParams{Name: tag}); err != nil {
+					return fmt.Errorf("creating tag %q: %w", tag, err)
+				}
+			}
+		case action == "rename" && len(args) == 2:
+			if err := ms.RenameTag(ctx, service.RenameTagParams{From: args[0], To: args[1]}); err != nil {
+				return fmt.Errorf("renaming tag %q: %w", args[0], err)
+			}
+		case action == "delete" && len(args) > 0:
+			for _, tag := range args {
+				if err := ms.DeleteTag(ctx, tag); err != nil {
+					return fmt.Errorf("deleting tag %q: %w", tag, err)
+				}
+			}
+		default:
+			return fmt.Errorf("%w: tags %s with %d arguments", ErrUsage, action, len(args))
+		}
+
+		return nil
+	}
+}
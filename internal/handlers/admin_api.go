@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"scoreplay/internal/service"
+	"scoreplay/pkg/api"
+)
+
+type apiKeyService interface {
+	IssueAPIKey(ctx context.Context, params service.IssueAPIKeyParams) (*service.IssueAPIKeyResult, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+}
+
+func (h handler) PostAdminApiKeys(ctx context.Context, request api.PostAdminApiKeysRequestObject) (api.PostAdminApiKeysResponseObject, error) {
+	key, err := h.apiKeyService.IssueAPIKey(ctx, service.IssueAPIKeyParams{Tenant: request.Body.Tenant, Name: request.Body.Name})
+	if err != nil {
+		return nil, fmt.Errorf("issuing API key: %w", err)
+	}
+
+	return api.PostAdminApiKeys201JSONResponse{
+		Id:     key.ID,
+		Tenant: key.Tenant,
+		Name:   key.Name,
+		Key:    key.Secret,
+	}, nil
+}
+
+func (h handler) DeleteAdminApiKeysId(ctx context.Context, request api.DeleteAdminApiKeysIdRequestObject) (api.DeleteAdminApiKeysIdResponseObject, error) {
+	err := h.apiKeyService.RevokeAPIKey(ctx, request.Id)
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return api.DeleteAdminApiKeysId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("revoking API key: %w", err)
+	}
+
+	return api.DeleteAdminApiKeysId204Response{}, nil
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/service"
+	"scoreplay/pkg/api"
+)
+
+type mockAPIKeyService struct {
+	m *mock.Mock
+}
+
+func (m *mockAPIKeyService) IssueAPIKey(ctx context.Context, params service.IssueAPIKeyParams) (*service.IssueAPIKeyResult, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*service.IssueAPIKeyResult), args.Error(1)
+}
+
+func (m *mockAPIKeyService) RevokeAPIKey(ctx context.Context, id string) error {
+	return m.m.Called(ctx, id).Error(0)
+}
+
+func TestHandler_PostAdminApiKeys(t *testing.T) {
+	ctx := context.Background()
+	params := service.IssueAPIKeyParams{Tenant: "club", Name: "photographers"}
+	body := &api.PostAdminApiKeysJSONRequestBody{Tenant: "club", Name: "photographers"}
+
+	t.Run("if fails if API key service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("IssueAPIKey", ctx, params).Return((*service.IssueAPIKeyResult)(nil), assert.AnError).Once()
+
+		h := NewMediaAPI(nil, nil, &mockAPIKeyService{m: m})
+		_, err := h.PostAdminApiKeys(ctx, api.PostAdminApiKeysRequestObject{Body: body})
+
+		require.EqualError(t, err, `issuing API key: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns the key", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("IssueAPIKey", ctx, params).Return(&service.IssueAPIKeyResult{
+			APIKey: service.APIKey{ID: "id", Tenant: "club", Name: "photographers"},
+			Secret: "sp_secret",
+		}, nil).Once()
+
+		h := NewMediaAPI(nil, nil, &mockAPIKeyService{m: m})
+		resp, err := h.PostAdminApiKeys(ctx, api.PostAdminApiKeysRequestObject{Body: body})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PostAdminApiKeys201JSONResponse{Id: "id", Tenant: "club", Name: "photographers", Key: "sp_secret"}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_DeleteAdminApiKeysId(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("if fails if API key service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("RevokeAPIKey", ctx, "id").Return(assert.AnError).Once()
+
+		h := NewMediaAPI(nil, nil, &mockAPIKeyService{m: m})
+		_, err := h.DeleteAdminApiKeysId(ctx, api.DeleteAdminApiKeysIdRequestObject{Id: "id"})
+
+		require.EqualError(t, err, `revoking API key: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it reports an unknown key", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("RevokeAPIKey", ctx, "id").Return(service.ErrAPIKeyNotFound).Once()
+
+		h := NewMediaAPI(nil, nil, &mockAPIKeyService{m: m})
+		resp, err := h.DeleteAdminApiKeysId(ctx, api.DeleteAdminApiKeysIdRequestObject{Id: "id"})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.DeleteAdminApiKeysId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(service.ErrAPIKeyNotFound)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it revokes the key", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("RevokeAPIKey", ctx, "id").Return(nil).Once()
+
+		h := NewMediaAPI(nil, nil, &mockAPIKeyService{m: m})
+		resp, err := h.DeleteAdminApiKeysId(ctx, api.DeleteAdminApiKeysIdRequestObject{Id: "id"})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.DeleteAdminApiKeysId204Response{}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
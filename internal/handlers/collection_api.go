@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"scoreplay/internal/service"
+	"scoreplay/pkg/api"
+)
+
+type collectionService interface {
+	CreateCollection(ctx context.Context, params service.CreateCollectionParams) (*service.Collection, error)
+	ListCollections(ctx context.Context) ([]service.Collection, error)
+	GetCollection(ctx context.Context, key string) (*service.Collection, error)
+	UpdateCollection(ctx context.Context, params service.UpdateCollectionParams) (*service.Collection, error)
+	DeleteCollection(ctx context.Context, key string) error
+	ListCollectionItems(ctx context.Context, key string) ([]service.MediaRecord, error)
+	AddCollectionItems(ctx context.Context, params service.AddCollectionItemsParams) error
+	ReplaceCollectionItems(ctx context.Context, params service.ReplaceCollectionItemsParams) error
+	RemoveCollectionItem(ctx context.Context, params service.RemoveCollectionItemParams) error
+}
+
+func (h handler) PostCollections(ctx context.Context, request api.PostCollectionsRequestObject) (api.PostCollectionsResponseObject, error) {
+	params := service.CreateCollectionParams{Name: request.Body.Name}
+	if request.Body.Cover != nil {
+		params.Cover = *request.Body.Cover
+	}
+
+	collection, err := h.collectionService.CreateCollection(ctx, params)
+	switch {
+	case unprocessable(err):
+		return api.PostCollections422ApplicationProblemPlusJSONResponse{UnprocessableEntityApplicationProblemPlusJSONResponse: unprocessableEntity(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("creating collection: %w", err)
+	}
+
+	return api.PostCollections201JSONResponse(toCollection(*collection)), nil
+}
+
+func (h handler) GetCollections(ctx context.Context, request api.GetCollectionsRequestObject) (api.GetCollectionsResponseObject, error) {
+	collections, err := h.collectionService.ListCollections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing collections: %w", err)
+	}
+
+	response := make(api.GetCollections200JSONResponse, len(collections))
+	for i, c := range collections {
+		response[i] = toCollection(c)
+	}
+
+	return response, nil
+}
+
+func (h handler) GetCollectionsId(ctx context.Context, request api.GetCollectionsIdRequestObject) (api.GetCollectionsIdResponseObject, error) {
+	collection, err := h.collectionService.GetCollection(ctx, request.Id)
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return api.GetCollectionsId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("getting collection: %w", err)
+	}
+
+	return api.GetCollectionsId200JSONResponse(toCollection(*collection)), nil
+}
+
+func (h handler) PatchCollectionsId(ctx context.Context, request api.PatchCollectionsIdRequestObject) (api.PatchCollectionsIdResponseObject, error) {
+	collection, err := h.collectionService.UpdateCollection(ctx, service.UpdateCollectionParams{
+		Key:   request.Id,
+		Name:  request.Body.Name,
+		Cover: request.Body.Cover,
+	})
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return api.PatchCollectionsId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(err)}, nil
+	case unprocessable(err):
+		return api.PatchCollectionsId422ApplicationProblemPlusJSONResponse{UnprocessableEntityApplicationProblemPlusJSONResponse: unprocessableEntity(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("updating collection: %w", err)
+	}
+
+	return api.PatchCollectionsId200JSONResponse(toCollection(*collection)), nil
+}
+
+func (h handler) DeleteCollectionsId(ctx context.Context, request api.DeleteCollectionsIdRequestObject) (api.DeleteCollectionsIdResponseObject, error) {
+	err := h.collectionService.DeleteCollection(ctx, request.Id)
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return api.DeleteCollectionsId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("deleting collection: %w", err)
+	}
+
+	return api.DeleteCollectionsId204Response{}, nil
+}
+
+func (h handler) GetCollectionsIdItems(ctx context.Context, request api.GetCollectionsIdItemsRequestObject) (api.GetCollectionsIdItemsResponseObject, error) {
+	items, err := h.collectionService.ListCollectionItems(ctx, request.Id)
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return api.GetCollectionsIdItems404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("listing collection items: %w", err)
+	}
+
+	return api.GetCollectionsIdItems200JSONResponse(toMediaList(items)), nil
+}
+
+func (h handler) PostCollectionsIdItems(ctx context.Context, request api.PostCollectionsIdItemsRequestObject) (api.PostCollectionsIdItemsResponseObject, error) {
+	err := h.collectionService.AddCollectionItems(ctx, service.AddCollectionItemsParams{
+		Key:       request.Id,
+		MediaKeys: request.Body.Ids,
+		Position:  request.Body.Position,
+	})
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return api.PostCollectionsIdItems404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(err)}, nil
+	case unprocessable(err):
+		return api.PostCollectionsIdItems422ApplicationProblemPlusJSONResponse{UnprocessableEntityApplicationProblemPlusJSONResponse: unprocessableEntity(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("adding collection items: %w", err)
+	}
+
+	return api.PostCollectionsIdItems204Response{}, nil
+}
+
+func (h handler) PutCollectionsIdItems(ctx context.Context, request api.PutCollectionsIdItemsRequestObject) (api.PutCollectionsIdItemsResponseObject, error) {
+	err := h.collectionService.ReplaceCollectionItems(ctx, service.ReplaceCollectionItemsParams{
+		Key:       request.Id,
+		MediaKeys: request.Body.Ids,
+	})
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return api.PutCollectionsIdItems404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(err)}, nil
+	case unprocessable(err):
+		return api.PutCollectionsIdItems422ApplicationProblemPlusJSONResponse{UnprocessableEntityApplicationProblemPlusJSONResponse: unprocessableEntity(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("replacing collection items: %w", err)
+	}
+
+	return api.PutCollectionsIdItems204Response{}, nil
+}
+
+func (h handler) DeleteCollectionsIdItemsMediaId(ctx context.Context, request api.DeleteCollectionsIdItemsMediaIdRequestObject) (api.DeleteCollectionsIdItemsMediaIdResponseObject, error) {
+	err := h.collectionService.RemoveCollectionItem(ctx, service.RemoveCollectionItemParams{
+		Key:      request.Id,
+		MediaKey: request.MediaId,
+	})
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return api.DeleteCollectionsIdItemsMediaId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(err)}, nil
+	case err != nil:
+		return nil, fmt.Errorf("removing collection item: %w", err)
+	}
+
+	return api.DeleteCollectionsIdItemsMediaId204Response{}, nil
+}
+
+func toCollection(c service.Collection) api.Collection {
+	collection := api.Collection{Id: c.Key, Name: c.Name}
+	if c.Cover != "" {
+		coverURL := c.CoverURL.String()
+		collection.Cover = &c.Cover
+		collection.CoverUrl = &coverURL
+	}
+
+	return collection
+}
@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/service"
+	"scoreplay/pkg/api"
+)
+
+type mockCollectionService struct {
+	m *mock.Mock
+}
+
+func (m *mockCollectionService) CreateCollection(ctx context.Context, params service.CreateCollectionParams) (*service.Collection, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*service.Collection), args.Error(1)
+}
+
+func (m *mockCollectionService) ListCollections(ctx context.Context) ([]service.Collection, error) {
+	args := m.m.Called(ctx)
+	return args.Get(0).([]service.Collection), args.Error(1)
+}
+
+func (m *mockCollectionService) GetCollection(ctx context.Context, key string) (*service.Collection, error) {
+	args := m.m.Called(ctx, key)
+	return args.Get(0).(*service.Collection), args.Error(1)
+}
+
+func (m *mockCollectionService) UpdateCollection(ctx context.Context, params service.UpdateCollectionParams) (*service.Collection, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*service.Collection), args.Error(1)
+}
+
+func (m *mockCollectionService) DeleteCollection(ctx context.Context, key string) error {
+	return m.m.Called(ctx, key).Error(0)
+}
+
+func (m *mockCollectionService) ListCollectionItems(ctx context.Context, key string) ([]service.MediaRecord, error) {
+	args := m.m.Called(ctx, key)
+	return args.Get(0).([]service.MediaRecord), args.Error(1)
+}
+
+func (m *mockCollectionService) AddCollectionItems(ctx context.Context, params service.AddCollectionItemsParams) error {
+	return m.m.Called(ctx, params).Error(0)
+}
+
+func (m *mockCollectionService) ReplaceCollectionItems(ctx context.Context, params service.ReplaceCollectionItemsParams) error {
+	return m.m.Called(ctx, params).Error(0)
+}
+
+func (m *mockCollectionService) RemoveCollectionItem(ctx context.Context, params service.RemoveCollectionItemParams) error {
+	return m.m.Called(ctx, params).Error(0)
+}
+
+func TestHandler_PostCollections(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("CreateCollection", ctx, service.CreateCollectionParams{Name: "name"}).Return((*service.Collection)(nil), assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.PostCollections(ctx, api.PostCollectionsRequestObject{Body: &api.PostCollectionsJSONRequestBody{Name: "name"}})
+
+		require.EqualError(t, err, `creating collection: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects an unknown cover", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("CreateCollection", ctx, service.CreateCollectionParams{Name: "name", Cover: "media"}).Return((*service.Collection)(nil), service.ErrUnknownMedia).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PostCollections(ctx, api.PostCollectionsRequestObject{Body: &api.PostCollectionsJSONRequestBody{Name: "name", Cover: pT("media")}})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PostCollections422ApplicationProblemPlusJSONResponse{UnprocessableEntityApplicationProblemPlusJSONResponse: unprocessableEntity(service.ErrUnknownMedia)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns the collection", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("CreateCollection", ctx, service.CreateCollectionParams{Name: "name", Cover: "media"}).
+			Return(&service.Collection{Key: "key", Name: "name", Cover: "media", CoverURL: url.URL{Scheme: "http", Host: "test", Path: "/bucket/media"}}, nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PostCollections(ctx, api.PostCollectionsRequestObject{Body: &api.PostCollectionsJSONRequestBody{Name: "name", Cover: pT("media")}})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PostCollections201JSONResponse{Id: "key", Name: "name", Cover: pT("media"), CoverUrl: pT("http://test/bucket/media")}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_GetCollections(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListCollections", ctx).Return(([]service.Collection)(nil), assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.GetCollections(ctx, api.GetCollectionsRequestObject{})
+
+		require.EqualError(t, err, `listing collections: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns collections", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListCollections", ctx).Return([]service.Collection{{Key: "key1", Name: "name1"}, {Key: "key2", Name: "name2"}}, nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.GetCollections(ctx, api.GetCollectionsRequestObject{})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.GetCollections200JSONResponse{{Id: "key1", Name: "name1"}, {Id: "key2", Name: "name2"}}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_GetCollectionsId(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("GetCollection", ctx, "key").Return((*service.Collection)(nil), assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.GetCollectionsId(ctx, api.GetCollectionsIdRequestObject{Id: "key"})
+
+		require.EqualError(t, err, `getting collection: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns not found", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("GetCollection", ctx, "key").Return((*service.Collection)(nil), service.ErrCollectionNotFound).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.GetCollectionsId(ctx, api.GetCollectionsIdRequestObject{Id: "key"})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.GetCollectionsId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(service.ErrCollectionNotFound)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns the collection", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("GetCollection", ctx, "key").Return(&service.Collection{Key: "key", Name: "name"}, nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.GetCollectionsId(ctx, api.GetCollectionsIdRequestObject{Id: "key"})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.GetCollectionsId200JSONResponse{Id: "key", Name: "name"}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_PatchCollectionsId(t *testing.T) {
+	ctx := context.Background()
+	params := service.UpdateCollectionParams{Key: "key", Name: pT("name"), Cover: pT("media")}
+	request := api.PatchCollectionsIdRequestObject{Id: "key", Body: &api.PatchCollectionsIdJSONRequestBody{Name: pT("name"), Cover: pT("media")}}
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("UpdateCollection", ctx, params).Return((*service.Collection)(nil), assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.PatchCollectionsId(ctx, request)
+
+		require.EqualError(t, err, `updating collection: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns not found", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("UpdateCollection", ctx, params).Return((*service.Collection)(nil), service.ErrCollectionNotFound).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PatchCollectionsId(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PatchCollectionsId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(service.ErrCollectionNotFound)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects an unknown cover", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("UpdateCollection", ctx, params).Return((*service.Collection)(nil), service.ErrUnknownMedia).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PatchCollectionsId(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PatchCollectionsId422ApplicationProblemPlusJSONResponse{UnprocessableEntityApplicationProblemPlusJSONResponse: unprocessableEntity(service.ErrUnknownMedia)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns the collection", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("UpdateCollection", ctx, params).Return(&service.Collection{Key: "key", Name: "name"}, nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PatchCollectionsId(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PatchCollectionsId200JSONResponse{Id: "key", Name: "name"}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_DeleteCollectionsId(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("DeleteCollection", ctx, "key").Return(assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.DeleteCollectionsId(ctx, api.DeleteCollectionsIdRequestObject{Id: "key"})
+
+		require.EqualError(t, err, `deleting collection: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns not found", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("DeleteCollection", ctx, "key").Return(service.ErrCollectionNotFound).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.DeleteCollectionsId(ctx, api.DeleteCollectionsIdRequestObject{Id: "key"})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.DeleteCollectionsId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(service.ErrCollectionNotFound)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns success", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("DeleteCollection", ctx, "key").Return(nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.DeleteCollectionsId(ctx, api.DeleteCollectionsIdRequestObject{Id: "key"})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.DeleteCollectionsId204Response{}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_GetCollectionsIdItems(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListCollectionItems", ctx, "key").Return(([]service.MediaRecord)(nil), assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.GetCollectionsIdItems(ctx, api.GetCollectionsIdItemsRequestObject{Id: "key"})
+
+		require.EqualError(t, err, `listing collection items: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns not found", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListCollectionItems", ctx, "key").Return(([]service.MediaRecord)(nil), service.ErrCollectionNotFound).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.GetCollectionsIdItems(ctx, api.GetCollectionsIdItemsRequestObject{Id: "key"})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.GetCollectionsIdItems404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(service.ErrCollectionNotFound)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns the items", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListCollectionItems", ctx, "key").Return([]service.MediaRecord{{Key: "media2", Name: "name2", Tags: []string{"tag1"}}, {Key: "media1", Name: "name1", Tags: []string{}}}, nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.GetCollectionsIdItems(ctx, api.GetCollectionsIdItemsRequestObject{Id: "key"})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.GetCollectionsIdItems200JSONResponse{{Id: "media2", Name: "name2", Tags: []string{"tag1"}}, {Id: "media1", Name: "name1", Tags: []string{}}}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_PostCollectionsIdItems(t *testing.T) {
+	ctx := context.Background()
+	params := service.AddCollectionItemsParams{Key: "key", MediaKeys: []string{"media1", "media2"}, Position: pT(1)}
+	request := api.PostCollectionsIdItemsRequestObject{Id: "key", Body: &api.PostCollectionsIdItemsJSONRequestBody{Ids: []string{"media1", "media2"}, Position: pT(1)}}
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("AddCollectionItems", ctx, params).Return(assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.PostCollectionsIdItems(ctx, request)
+
+		require.EqualError(t, err, `adding collection items: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns not found", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("AddCollectionItems", ctx, params).Return(service.ErrCollectionNotFound).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PostCollectionsIdItems(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PostCollectionsIdItems404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(service.ErrCollectionNotFound)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects unknown media", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("AddCollectionItems", ctx, params).Return(service.ErrUnknownMedia).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PostCollectionsIdItems(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PostCollectionsIdItems422ApplicationProblemPlusJSONResponse{UnprocessableEntityApplicationProblemPlusJSONResponse: unprocessableEntity(service.ErrUnknownMedia)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns success", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("AddCollectionItems", ctx, params).Return(nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PostCollectionsIdItems(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PostCollectionsIdItems204Response{}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_PutCollectionsIdItems(t *testing.T) {
+	ctx := context.Background()
+	params := service.ReplaceCollectionItemsParams{Key: "key", MediaKeys: []string{"media2", "media1"}}
+	request := api.PutCollectionsIdItemsRequestObject{Id: "key", Body: &api.PutCollectionsIdItemsJSONRequestBody{Ids: []string{"media2", "media1"}}}
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ReplaceCollectionItems", ctx, params).Return(assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.PutCollectionsIdItems(ctx, request)
+
+		require.EqualError(t, err, `replacing collection items: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns not found", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ReplaceCollectionItems", ctx, params).Return(service.ErrCollectionNotFound).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PutCollectionsIdItems(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PutCollectionsIdItems404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(service.ErrCollectionNotFound)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects unknown media", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ReplaceCollectionItems", ctx, params).Return(service.ErrUnknownMedia).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PutCollectionsIdItems(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PutCollectionsIdItems422ApplicationProblemPlusJSONResponse{UnprocessableEntityApplicationProblemPlusJSONResponse: unprocessableEntity(service.ErrUnknownMedia)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns success", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ReplaceCollectionItems", ctx, params).Return(nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.PutCollectionsIdItems(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PutCollectionsIdItems204Response{}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_DeleteCollectionsIdItemsMediaId(t *testing.T) {
+	ctx := context.Background()
+	params := service.RemoveCollectionItemParams{Key: "key", MediaKey: "media"}
+	request := api.DeleteCollectionsIdItemsMediaIdRequestObject{Id: "key", MediaId: "media"}
+
+	t.Run("if fails if collection service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("RemoveCollectionItem", ctx, params).Return(assert.AnError).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		_, err := h.DeleteCollectionsIdItemsMediaId(ctx, request)
+
+		require.EqualError(t, err, `removing collection item: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns not found", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("RemoveCollectionItem", ctx, params).Return(service.ErrCollectionNotFound).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.DeleteCollectionsIdItemsMediaId(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.DeleteCollectionsIdItemsMediaId404ApplicationProblemPlusJSONResponse{NotFoundApplicationProblemPlusJSONResponse: notFound(service.ErrCollectionNotFound)}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns success", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("RemoveCollectionItem", ctx, params).Return(nil).Once()
+
+		h := NewMediaAPI(nil, &mockCollectionService{m: m}, nil)
+		resp, err := h.DeleteCollectionsIdItemsMediaId(ctx, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, api.DeleteCollectionsIdItemsMediaId204Response{}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
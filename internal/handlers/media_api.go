@@ -3,6 +3,10 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
 
 	"scoreplay/internal/service"
 	"scoreplay/pkg/api"
@@ -11,16 +15,22 @@ import (
 type mediaService interface {
 	CreateTag(ctx context.Context, params service.CreateTagParams) error
 	ListTags(ctx context.Context) (service.ListTagsResult, error)
+	ListRelatedTags(ctx context.Context, params service.ListRelatedTagsParams) (service.ListRelatedTagsResult, error)
 	ListMedia(ctx context.Context, params service.ListMediaParams) (service.ListMediaResult, error)
 	CreateMedia(ctx context.Context, params service.CreateMediaParams) (*service.CreateMediaResult, error)
+	CreateMediaBatch(ctx context.Context, params []service.CreateMediaParams) service.CreateMediaBatchResult
+	TagMediaBatch(ctx context.Context, params service.TagMediaBatchParams) service.TagMediaBatchResult
+	ExportMedia(ctx context.Context, w io.Writer, format string) error
 }
 
 type handler struct {
-	mediaService mediaService
+	mediaService      mediaService
+	collectionService collectionService
+	apiKeyService     apiKeyService
 }
 
-func NewMediaAPI(mediaService mediaService) *handler {
-	return &handler{mediaService: mediaService}
+func NewMediaAPI(mediaService mediaService, collectionService collectionService, apiKeyService apiKeyService) *handler {
+	return &handler{mediaService: mediaService, collectionService: collectionService, apiKeyService: apiKeyService}
 }
 
 func (h handler) GetTags(ctx context.Context, request api.GetTagsRequestObject) (api.GetTagsResponseObject, error) {
@@ -33,45 +43,197 @@ func (h handler) GetTags(ctx context.Context, request api.GetTagsRequestObject)
 }
 
 func (h handler) PostTags(ctx context.Context, request api.PostTagsRequestObject) (api.PostTagsResponseObject, error) {
-	if err := h.mediaService.CreateTag(ctx, service.CreateTagParams{Name: request.Body.Name}); err != nil {
+	params := service.CreateTagParams{Name: request.Body.Name}
+	if request.Params.IdempotencyKey != nil {
+		params.IdempotencyKey = *request.Params.IdempotencyKey
+	}
+
+	if err := h.mediaService.CreateTag(ctx, params); err != nil {
 		return nil, fmt.Errorf("creating tag: %w", err)
 	}
 
 	return api.PostTags201Response{}, nil
 }
 
+func (h handler) GetTagsNameRelated(ctx context.Context, request api.GetTagsNameRelatedRequestObject) (api.GetTagsNameRelatedResponseObject, error) {
+	params := service.ListRelatedTagsParams{Tag: request.Name}
+	if request.Params.Limit != nil {
+		params.Limit = *request.Params.Limit
+	}
+
+	related, err := h.mediaService.ListRelatedTags(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("listing related tags: %w", err)
+	}
+
+	response := make(api.GetTagsNameRelated200JSONResponse, len(related))
+	for i, r := range related {
+		response[i] = api.TagCount{Tag: r.Tag, Count: r.Count}
+	}
+
+	return response, nil
+}
+
 func (h handler) PostMedia(ctx context.Context, request api.PostMediaRequestObject) (api.PostMediaResponseObject, error) {
-	ur, err := h.mediaService.CreateMedia(ctx,
-		service.CreateMediaParams{
-			Name: request.Body.Name,
-			Tags: request.Body.Tags,
-		},
-	)
+	params := service.CreateMediaParams{
+		Name: request.Body.Name,
+		Tags: request.Body.Tags,
+	}
+	if request.Params.IdempotencyKey != nil {
+		params.IdempotencyKey = *request.Params.IdempotencyKey
+	}
+
+	ur, err := h.mediaService.CreateMedia(ctx, params)
 	if err != nil {
 		return nil, fmt.Errorf("creating upload: %w", err)
 	}
 
 	return api.PostMedia201JSONResponse{
+		Id:           ur.Key,
 		Method:       ur.Method,
 		SignedHeader: ur.SignedHeader,
 		Url:          ur.URL,
 	}, nil
 }
 
+func (h handler) PostMediaBatch(ctx context.Context, request api.PostMediaBatchRequestObject) (api.PostMediaBatchResponseObject, error) {
+	params := make([]service.CreateMediaParams, len(*request.Body))
+	for i, m := range *request.Body {
+		params[i] = service.CreateMediaParams{Name: m.Name, Tags: m.Tags}
+	}
+
+	result := h.mediaService.CreateMediaBatch(ctx, params)
+
+	response := make(api.PostMediaBatch200JSONResponse, len(result))
+	for i, item := range result {
+		if item.Err != nil {
+			response[i].Error, response[i].UnknownTags = itemError(ctx, item.Err)
+			continue
+		}
+		response[i].Upload = &api.UploadRequest{
+			Id:           item.Result.Key,
+			Method:       item.Result.Method,
+			SignedHeader: item.Result.SignedHeader,
+			Url:          item.Result.URL,
+		}
+	}
+
+	return response, nil
+}
+
+func (h handler) PostMediaTagsBulk(ctx context.Context, request api.PostMediaTagsBulkRequestObject) (api.PostMediaTagsBulkResponseObject, error) {
+	params := service.TagMediaBatchParams{Keys: request.Body.Ids}
+	if request.Body.Add != nil {
+		params.Add = *request.Body.Add
+	}
+	if request.Body.Remove != nil {
+		params.Remove = *request.Body.Remove
+	}
+
+	result := h.mediaService.TagMediaBatch(ctx, params)
+
+	response := make(api.PostMediaTagsBulk200JSONResponse, len(result))
+	for i, item := range result {
+		response[i].Id = item.Key
+		if item.Err != nil {
+			response[i].Error, response[i].UnknownTags = itemError(ctx, item.Err)
+		}
+	}
+
+	return response, nil
+}
+
 func (h handler) GetMedia(ctx context.Context, request api.GetMediaRequestObject) (api.GetMediaResponseObject, error) {
-	media, err := h.mediaService.ListMedia(ctx, service.ListMediaParams{Tag: request.Params.Tag})
+	facets := request.Params.Facets != nil && *request.Params.Facets
+	result, err := h.mediaService.ListMedia(ctx, service.ListMediaParams{Tag: request.Params.Tag, Facets: facets})
 	if err != nil {
 		return nil, fmt.Errorf("listing media: %w", err)
 	}
 
-	response := make(api.GetMedia200JSONResponse, len(media))
-	for i, m := range media {
-		response[i] = api.Media{
+	media := toMediaList(result.Media)
+
+	var response api.MediaSearchResult
+	if facets {
+		counts := make([]api.TagCount, len(result.Facets))
+		for i, f := range result.Facets {
+			counts[i] = api.TagCount{Tag: f.Tag, Count: f.Count}
+		}
+		err = response.FromFacetedMediaList(api.FacetedMediaList{Media: media, Facets: counts})
+	} else {
+		err = response.FromMediaList(media)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encoding media: %w", err)
+	}
+
+	return api.GetMedia200JSONResponse(response), nil
+}
+
+func (h handler) GetExport(ctx context.Context, request api.GetExportRequestObject) (api.GetExportResponseObject, error) {
+	format := api.Jsonl
+	if request.Params.Format != nil {
+		format = *request.Params.Format
+	}
+
+	return exportResponse{ctx: ctx, format: string(format), export: h.mediaService.ExportMedia}, nil
+}
+
+// exportResponse streams the export to the client as it is written.
+type exportResponse struct {
+	ctx    context.Context
+	format string
+	export func(ctx context.Context, w io.Writer, format string) error
+}
+
+func (r exportResponse) VisitGetExportResponse(w http.ResponseWriter) error {
+	contentType := "application/x-ndjson"
+	if r.format == service.ExportCSV {
+		contentType = "text/csv"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="media.%s"`, r.format))
+
+	fw := &flushWriter{w: w}
+	err := r.export(r.ctx, fw, r.format)
+	if err == nil {
+		return nil
+	}
+	// Nothing was sent yet, the error can be responded with a problem.
+	if !fw.written {
+		w.Header().Del("Content-Disposition")
+		return fmt.Errorf("exporting media: %w", err)
+	}
+	// Aborting the response keeps the client from taking the export for a complete one.
+	log.Ctx(r.ctx).Error().Err(err).Msg("Exporting media")
+	panic(http.ErrAbortHandler)
+}
+
+// flushWriter sends every write to the client right away, and tells whether anything was written.
+type flushWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.written = true
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, http.NewResponseController(f.w).Flush()
+}
+
+func toMediaList(records []service.MediaRecord) api.MediaList {
+	media := make(api.MediaList, len(records))
+	for i, m := range records {
+		media[i] = api.Media{
+			Id:   m.Key,
 			Name: m.Name,
 			Url:  m.URL.String(),
 			Tags: m.Tags,
 		}
 	}
 
-	return response, nil
+	return media
 }
@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,6 +16,10 @@ import (
 	"scoreplay/pkg/api"
 )
 
+func pT[T any](v T) *T {
+	return &v
+}
+
 type mockService struct {
 	m *mock.Mock
 }
@@ -26,6 +33,11 @@ func (m *mockService) ListTags(ctx context.Context) (service.ListTagsResult, err
 	return args.Get(0).(service.ListTagsResult), args.Error(1)
 }
 
+func (m *mockService) ListRelatedTags(ctx context.Context, params service.ListRelatedTagsParams) (service.ListRelatedTagsResult, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(service.ListRelatedTagsResult), args.Error(1)
+}
+
 func (m *mockService) ListMedia(ctx context.Context, params service.ListMediaParams) (service.ListMediaResult, error) {
 	args := m.m.Called(ctx, params)
 	return args.Get(0).(service.ListMediaResult), args.Error(1)
@@ -36,10 +48,24 @@ func (m *mockService) CreateMedia(ctx context.Context, params service.CreateMedi
 	return args.Get(0).(*service.CreateMediaResult), args.Error(1)
 }
 
+func (m *mockService) CreateMediaBatch(ctx context.Context, params []service.CreateMediaParams) service.CreateMediaBatchResult {
+	return m.m.Called(ctx, params).Get(0).(service.CreateMediaBatchResult)
+}
+
+func (m *mockService) TagMediaBatch(ctx context.Context, params service.TagMediaBatchParams) service.TagMediaBatchResult {
+	return m.m.Called(ctx, params).Get(0).(service.TagMediaBatchResult)
+}
+
+func (m *mockService) ExportMedia(ctx context.Context, w io.Writer, format string) error {
+	return m.m.Called(ctx, w, format).Error(0)
+}
+
 func TestNewMediaAPI(t *testing.T) {
 	ms := &mockService{}
-	h := NewMediaAPI(ms)
-	require.Equal(t, &handler{mediaService: ms}, h)
+	cs := &mockCollectionService{}
+	ks := &mockAPIKeyService{}
+	h := NewMediaAPI(ms, cs, ks)
+	require.Equal(t, &handler{mediaService: ms, collectionService: cs, apiKeyService: ks}, h)
 }
 
 func TestHandler_GetTags(t *testing.T) {
@@ -49,7 +75,7 @@ func TestHandler_GetTags(t *testing.T) {
 		m := &mock.Mock{}
 		m.On("ListTags", ctx).Return((service.ListTagsResult)(nil), assert.AnError).Once()
 
-		h := NewMediaAPI(&mockService{m: m})
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
 		_, err := h.GetTags(ctx, api.GetTagsRequestObject{})
 
 		require.EqualError(t, err, `listing tags: `+assert.AnError.Error())
@@ -60,7 +86,7 @@ func TestHandler_GetTags(t *testing.T) {
 		m := &mock.Mock{}
 		m.On("ListTags", ctx).Return(service.ListTagsResult{"tag1", "tag2"}, nil).Once()
 
-		h := NewMediaAPI(&mockService{m: m})
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
 		resp, err := h.GetTags(ctx, api.GetTagsRequestObject{})
 
 		require.NoError(t, err)
@@ -76,7 +102,7 @@ func TestHandler_PostTags(t *testing.T) {
 		m := &mock.Mock{}
 		m.On("CreateTag", ctx, service.CreateTagParams{Name: "tag"}).Return(assert.AnError).Once()
 
-		h := NewMediaAPI(&mockService{m: m})
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
 		_, err := h.PostTags(ctx, api.PostTagsRequestObject{Body: &api.PostTagsJSONRequestBody{Name: "tag"}})
 
 		require.EqualError(t, err, `creating tag: `+assert.AnError.Error())
@@ -87,13 +113,55 @@ func TestHandler_PostTags(t *testing.T) {
 		m := &mock.Mock{}
 		m.On("CreateTag", ctx, service.CreateTagParams{Name: "tag"}).Return(nil).Once()
 
-		h := NewMediaAPI(&mockService{m: m})
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
 		resp, err := h.PostTags(ctx, api.PostTagsRequestObject{Body: &api.PostTagsJSONRequestBody{Name: "tag"}})
 
 		require.NoError(t, err)
 		assert.Equal(t, api.PostTags201Response{}, resp)
 		require.True(t, m.AssertExpectations(t))
 	})
+
+	t.Run("it passes the idempotency key", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("CreateTag", ctx, service.CreateTagParams{Name: "tag", IdempotencyKey: "idem"}).Return(nil).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		_, err := h.PostTags(ctx, api.PostTagsRequestObject{
+			Params: api.PostTagsParams{IdempotencyKey: pT("idem")},
+			Body:   &api.PostTagsJSONRequestBody{Name: "tag"},
+		})
+
+		require.NoError(t, err)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_GetTagsNameRelated(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("if fails if tags service fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListRelatedTags", ctx, service.ListRelatedTagsParams{Tag: "tag"}).Return((service.ListRelatedTagsResult)(nil), assert.AnError).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		_, err := h.GetTagsNameRelated(ctx, api.GetTagsNameRelatedRequestObject{Name: "tag"})
+
+		require.EqualError(t, err, `listing related tags: `+assert.AnError.Error())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns related tags", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListRelatedTags", ctx, service.ListRelatedTagsParams{Tag: "tag", Limit: 5}).
+			Return(service.ListRelatedTagsResult{{Tag: "tag1", Count: 3}, {Tag: "tag2", Count: 1}}, nil).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		resp, err := h.GetTagsNameRelated(ctx, api.GetTagsNameRelatedRequestObject{Name: "tag", Params: api.GetTagsNameRelatedParams{Limit: pT(5)}})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.GetTagsNameRelated200JSONResponse{{Tag: "tag1", Count: 3}, {Tag: "tag2", Count: 1}}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
 }
 
 func TestHandler_PostMedia(t *testing.T) {
@@ -103,7 +171,7 @@ func TestHandler_PostMedia(t *testing.T) {
 		m := &mock.Mock{}
 		m.On("CreateMedia", ctx, service.CreateMediaParams{Name: "name", Tags: []string{"tag1", "tag2"}}).Return((*service.CreateMediaResult)(nil), assert.AnError).Once()
 
-		h := NewMediaAPI(&mockService{m: m})
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
 		_, err := h.PostMedia(ctx, api.PostMediaRequestObject{Body: &api.PostMediaJSONRequestBody{Name: "name", Tags: []string{"tag1", "tag2"}}})
 
 		require.EqualError(t, err, `creating upload: `+assert.AnError.Error())
@@ -113,13 +181,87 @@ func TestHandler_PostMedia(t *testing.T) {
 	t.Run("it returns success", func(t *testing.T) {
 		m := &mock.Mock{}
 		m.On("CreateMedia", ctx, service.CreateMediaParams{Name: "name", Tags: []string{"tag1", "tag2"}}).
-			Return(&service.CreateMediaResult{URL: "url", Method: http.MethodPut, SignedHeader: http.Header{"x-amz-meta-name": []string{"name"}, "x-amz-meta-tags": []string{"tag1", "tag2"}}}, nil).Once()
+			Return(&service.CreateMediaResult{Key: "key", URL: "url", Method: http.MethodPut, SignedHeader: http.Header{"x-amz-meta-name": []string{"name"}, "x-amz-meta-tags": []string{"tag1", "tag2"}}}, nil).Once()
 
-		h := NewMediaAPI(&mockService{m: m})
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
 		resp, err := h.PostMedia(ctx, api.PostMediaRequestObject{Body: &api.PostMediaJSONRequestBody{Name: "name", Tags: []string{"tag1", "tag2"}}})
 
 		require.NoError(t, err)
-		assert.Equal(t, api.PostMedia201JSONResponse{Url: "url", Method: http.MethodPut, SignedHeader: http.Header{"x-amz-meta-name": []string{"name"}, "x-amz-meta-tags": []string{"tag1", "tag2"}}}, resp)
+		assert.Equal(t, api.PostMedia201JSONResponse{Id: "key", Url: "url", Method: http.MethodPut, SignedHeader: http.Header{"x-amz-meta-name": []string{"name"}, "x-amz-meta-tags": []string{"tag1", "tag2"}}}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it passes the idempotency key", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("CreateMedia", ctx, service.CreateMediaParams{Name: "name", Tags: []string{"tag1"}, IdempotencyKey: "idem"}).
+			Return(&service.CreateMediaResult{Key: "key", URL: "url", Method: http.MethodPut}, nil).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		_, err := h.PostMedia(ctx, api.PostMediaRequestObject{
+			Params: api.PostMediaParams{IdempotencyKey: pT("idem")},
+			Body:   &api.PostMediaJSONRequestBody{Name: "name", Tags: []string{"tag1"}},
+		})
+
+		require.NoError(t, err)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_PostMediaBatch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it reports results per item", func(t *testing.T) {
+		m := &mock.Mock{}
+		body := api.PostMediaBatchJSONRequestBody{{Name: "name1", Tags: []string{"tag1"}}, {Name: "name2", Tags: []string{"tag2"}}, {Name: "name3", Tags: []string{"tag3", "tag, 4"}}, {Name: "name4"}}
+		m.On("CreateMediaBatch", ctx, []service.CreateMediaParams{{Name: "name1", Tags: []string{"tag1"}}, {Name: "name2", Tags: []string{"tag2"}}, {Name: "name3", Tags: []string{"tag3", "tag, 4"}}, {Name: "name4"}}).
+			Return(service.CreateMediaBatchResult{
+				{Result: &service.CreateMediaResult{Key: "key1", URL: "url1", Method: http.MethodPut, SignedHeader: http.Header{}}},
+				{Err: assert.AnError},
+				{Err: &service.UnknownTagsError{Tags: []string{"tag3", "tag, 4"}}},
+				{Err: fmt.Errorf("storing media: %w", service.ErrUnavailable)},
+			}).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		resp, err := h.PostMediaBatch(ctx, api.PostMediaBatchRequestObject{Body: &body})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PostMediaBatch200JSONResponse{
+			{Upload: &api.UploadRequest{Id: "key1", Url: "url1", Method: http.MethodPut, SignedHeader: http.Header{}}},
+			{Error: pT("Internal Server Error")},
+			{Error: pT("unknown tags: tag3, tag, 4"), UnknownTags: &[]api.Tag{"tag3", "tag, 4"}},
+			{Error: pT("Service Unavailable")},
+		}, resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestHandler_PostMediaTagsBulk(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it reports results per item", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("TagMediaBatch", ctx, service.TagMediaBatchParams{Keys: []string{"key1", "key2", "key3", "key4"}, Add: []string{"tag1"}, Remove: []string{"tag2"}}).
+			Return(service.TagMediaBatchResult{
+				{Key: "key1"},
+				{Key: "key2", Err: assert.AnError},
+				{Key: "key3", Err: &service.UnknownTagsError{Tags: []string{"tag1"}}},
+				{Key: "key4", Err: fmt.Errorf("media %s: %w", "key4", service.ErrNotFound)},
+			}).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		resp, err := h.PostMediaTagsBulk(ctx, api.PostMediaTagsBulkRequestObject{Body: &api.PostMediaTagsBulkJSONRequestBody{
+			Ids:    []string{"key1", "key2", "key3", "key4"},
+			Add:    &[]api.Tag{"tag1"},
+			Remove: &[]api.Tag{"tag2"},
+		}})
+
+		require.NoError(t, err)
+		assert.Equal(t, api.PostMediaTagsBulk200JSONResponse{
+			{Id: "key1"},
+			{Id: "key2", Error: pT("Internal Server Error")},
+			{Id: "key3", Error: pT("unknown tags: tag1"), UnknownTags: &[]api.Tag{"tag1"}},
+			{Id: "key4", Error: pT("media key4: not found")},
+		}, resp)
 		require.True(t, m.AssertExpectations(t))
 	})
 }
@@ -129,9 +271,9 @@ func TestHandler_GetMedia(t *testing.T) {
 
 	t.Run("if fails if query service fails", func(t *testing.T) {
 		m := &mock.Mock{}
-		m.On("ListMedia", ctx, service.ListMediaParams{Tag: "tag1"}).Return((service.ListMediaResult)(nil), assert.AnError).Once()
+		m.On("ListMedia", ctx, service.ListMediaParams{Tag: "tag1"}).Return(service.ListMediaResult{}, assert.AnError).Once()
 
-		h := NewMediaAPI(&mockService{m: m})
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
 		_, err := h.GetMedia(ctx, api.GetMediaRequestObject{Params: api.GetMediaParams{Tag: "tag1"}})
 
 		require.EqualError(t, err, `listing media: `+assert.AnError.Error())
@@ -141,13 +283,93 @@ func TestHandler_GetMedia(t *testing.T) {
 	t.Run("it returns media", func(t *testing.T) {
 		m := &mock.Mock{}
 		m.On("ListMedia", ctx, service.ListMediaParams{Tag: "tag1"}).
-			Return(service.ListMediaResult{{Name: "name1", Tags: []string{"tag1", "tag2"}}, {Name: "name2", Tags: []string{"tag2", "tag3"}}}, nil).Once()
+			Return(service.ListMediaResult{Media: []service.MediaRecord{{Key: "key1", Name: "name1", Tags: []string{"tag1", "tag2"}}, {Key: "key2", Name: "name2", Tags: []string{"tag2", "tag3"}}}}, nil).Once()
 
-		h := NewMediaAPI(&mockService{m: m})
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
 		resp, err := h.GetMedia(ctx, api.GetMediaRequestObject{Params: api.GetMediaParams{Tag: "tag1"}})
 
 		require.NoError(t, err)
-		assert.Equal(t, api.GetMedia200JSONResponse{{Name: "name1", Tags: []string{"tag1", "tag2"}}, {Name: "name2", Tags: []string{"tag2", "tag3"}}}, resp)
+		var expected api.MediaSearchResult
+		require.NoError(t, expected.FromMediaList(api.MediaList{{Id: "key1", Name: "name1", Tags: []string{"tag1", "tag2"}}, {Id: "key2", Name: "name2", Tags: []string{"tag2", "tag3"}}}))
+		assert.Equal(t, api.GetMedia200JSONResponse(expected), resp)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it returns media with facets", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListMedia", ctx, service.ListMediaParams{Tag: "tag1", Facets: true}).
+			Return(service.ListMediaResult{
+				Media:  []service.MediaRecord{{Name: "name1", Tags: []string{"tag1", "tag2"}}},
+				Facets: []service.TagCount{{Tag: "tag2", Count: 1}},
+			}, nil).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		resp, err := h.GetMedia(ctx, api.GetMediaRequestObject{Params: api.GetMediaParams{Tag: "tag1", Facets: pT(true)}})
+
+		require.NoError(t, err)
+		var expected api.MediaSearchResult
+		require.NoError(t, expected.FromFacetedMediaList(api.FacetedMediaList{
+			Media:  api.MediaList{{Name: "name1", Tags: []string{"tag1", "tag2"}}},
+			Facets: []api.TagCount{{Tag: "tag2", Count: 1}},
+		}))
+		assert.Equal(t, api.GetMedia200JSONResponse(expected), resp)
 		require.True(t, m.AssertExpectations(t))
 	})
 }
+
+func TestHandler_GetExport(t *testing.T) {
+	ctx := context.Background()
+	export := func(content string) func(args mock.Arguments) {
+		return func(args mock.Arguments) {
+			_, _ = io.WriteString(args.Get(1).(io.Writer), content)
+		}
+	}
+
+	t.Run("it streams the export", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ExportMedia", ctx, mock.Anything, "csv").Run(export("id,name\n")).Return(nil).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		response, err := h.GetExport(ctx, api.GetExportRequestObject{Params: api.GetExportParams{Format: pT(api.Csv)}})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		err = response.VisitGetExportResponse(w)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		require.Equal(t, `attachment; filename="media.csv"`, w.Header().Get("Content-Disposition"))
+		require.Equal(t, "id,name\n", w.Body.String())
+		require.True(t, w.Flushed)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it fails if the export fails before writing", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ExportMedia", ctx, mock.Anything, "jsonl").Return(assert.AnError).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		response, err := h.GetExport(ctx, api.GetExportRequestObject{})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		err = response.VisitGetExportResponse(w)
+
+		require.EqualError(t, err, "exporting media: "+assert.AnError.Error())
+		require.Empty(t, w.Header().Get("Content-Disposition"))
+		require.Empty(t, w.Body.String())
+	})
+
+	t.Run("it aborts the response if the export fails after writing", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ExportMedia", ctx, mock.Anything, "jsonl").Run(export("{}\n")).Return(assert.AnError).Once()
+
+		h := NewMediaAPI(&mockService{m: m}, nil, nil)
+		response, err := h.GetExport(ctx, api.GetExportRequestObject{})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		require.PanicsWithValue(t, http.ErrAbortHandler, func() { _ = response.VisitGetExportResponse(w) })
+		require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		require.Equal(t, "{}\n", w.Body.String())
+	})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	nethttpmiddleware "github.com/oapi-codegen/nethttp-middleware"
+	"github.com/rs/zerolog/log"
+
+	"scoreplay/internal/problem"
+	"scoreplay/internal/service"
+	"scoreplay/pkg/api"
+)
+
+// RequestErrorHandler responds to requests that cannot be decoded with a 400 problem.
+func RequestErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	problem.Write(w, http.StatusBadRequest, err.Error())
+}
+
+// statusClientClosedRequest is the status, borrowed from nginx, of the requests whose client went
+// away before the response.
+const statusClientClosedRequest = 499
+
+// ResponseErrorHandler responds to the errors returned by the handlers with the problem matching
+// their category. Server errors are logged, but kept out of the response. Requests canceled by
+// their client are no server errors, and nobody reads their response.
+func ResponseErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		log.Ctx(r.Context()).Debug().Err(err).Msg("Request canceled")
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	status := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		log.Ctx(r.Context()).Error().Err(err).Msg("Handling request")
+		problem.Write(w, status, "")
+		return
+	}
+
+	problem.Respond(w, newProblem(status, err))
+}
+
+// ValidationErrorHandler rejects the requests breaking the API spec with a 400, or with a 422 when
+// only their tags break it, like the tag rules of the service layer do. The request validator
+// must report all errors for it to tell them apart.
+func ValidationErrorHandler(swagger *openapi3.T) nethttpmiddleware.MultiErrorHandler {
+	var tag *openapi3.Schema
+	if ref := swagger.Components.Schemas["Tag"]; ref != nil {
+		tag = ref.Value
+	}
+
+	return func(me openapi3.MultiError) (int, error) {
+		status := http.StatusBadRequest
+		if tag != nil && onlyInvalidTags(me, tag) {
+			status = http.StatusUnprocessableEntity
+		}
+
+		// Like the validator does, keep the first line of every error, leaving out the schema.
+		messages := make([]string, len(me))
+		for i, err := range me {
+			messages[i], _, _ = strings.Cut(err.Error(), "\n")
+		}
+		return status, errors.New(strings.Join(messages, " | "))
+	}
+}
+
+// onlyInvalidTags reports whether every validation error is a value breaking the tag schema.
+func onlyInvalidTags(err error, tag *openapi3.Schema) bool {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		for _, err := range e {
+			if !onlyInvalidTags(err, tag) {
+				return false
+			}
+		}
+		return len(e) > 0
+	case *openapi3filter.RequestError:
+		return e.Err != nil && onlyInvalidTags(e.Err, tag)
+	case *openapi3.SchemaError:
+		return e.Schema == tag
+	default:
+		return false
+	}
+}
+
+// errorStatus maps the error categories of the service layer to status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrConflict):
+		return http.StatusConflict
+	case unprocessable(err):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// unprocessable reports whether the error comes from a well formed request the service refused.
+func unprocessable(err error) bool {
+	return errors.Is(err, service.ErrInvalidTag) || errors.Is(err, service.ErrUnknownTags) || errors.Is(err, service.ErrUnknownMedia)
+}
+
+func notFound(err error) api.NotFoundApplicationProblemPlusJSONResponse {
+	return api.NotFoundApplicationProblemPlusJSONResponse(problem.New(http.StatusNotFound, err.Error()))
+}
+
+func unprocessableEntity(err error) api.UnprocessableEntityApplicationProblemPlusJSONResponse {
+	return api.UnprocessableEntityApplicationProblemPlusJSONResponse(newProblem(http.StatusUnprocessableEntity, err))
+}
+
+// newProblem returns the problem details of the error, with the unknown tags it reports listed
+// in the unknownTags extension member.
+func newProblem(status int, err error) api.Problem {
+	p := problem.New(status, err.Error())
+	p.UnknownTags = unknownTags(err)
+	return p
+}
+
+// itemError returns the message of the error failing an item of a batch, and the unknown tags it
+// reports. Like ResponseErrorHandler does, server errors are logged, but kept out of the message.
+func itemError(ctx context.Context, err error) (*string, *[]api.Tag) {
+	msg := err.Error()
+	if status := errorStatus(err); status >= http.StatusInternalServerError {
+		log.Ctx(ctx).Error().Err(err).Msg("Handling batch item")
+		msg = http.StatusText(status)
+	}
+
+	return &msg, unknownTags(err)
+}
+
+// unknownTags returns the tags the error reports as never created, if any.
+func unknownTags(err error) *[]api.Tag {
+	var unknown *service.UnknownTagsError
+	if !errors.As(err, &unknown) {
+		return nil
+	}
+	return &unknown.Tags
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	nethttpmiddleware "github.com/oapi-codegen/nethttp-middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/problem"
+	"scoreplay/internal/service"
+	"scoreplay/pkg/api"
+)
+
+func TestRequestErrorHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/tags", nil)
+
+	RequestErrorHandler(w, r, assert.AnError)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"`+assert.AnError.Error()+`"}`, w.Body.String())
+}
+
+func TestResponseErrorHandler(t *testing.T) {
+	t.Run("it hides server errors", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/tags", nil)
+
+		ResponseErrorHandler(w, r, assert.AnError)
+
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		require.JSONEq(t, `{"type":"about:blank","title":"Internal Server Error","status":500}`, w.Body.String())
+	})
+
+	t.Run("it reports no error for requests canceled by their client", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/media", nil)
+
+		ResponseErrorHandler(w, r, fmt.Errorf("getting media keys from redis: %w", context.Canceled))
+
+		require.Equal(t, 499, w.Code)
+		require.Empty(t, w.Body.String())
+	})
+
+	t.Run("it hides canceled operations of live requests", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/media", nil)
+
+		ResponseErrorHandler(w, r, context.Canceled)
+
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("it details client errors", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/collections/id", nil)
+
+		ResponseErrorHandler(w, r, fmt.Errorf("getting collection: %w", service.ErrCollectionNotFound))
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.JSONEq(t, `{"type":"about:blank","title":"Not Found","status":404,"detail":"getting collection: collection not found"}`, w.Body.String())
+	})
+
+	t.Run("it lists the unknown tags", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/media", nil)
+
+		ResponseErrorHandler(w, r, fmt.Errorf("creating upload: %w", &service.UnknownTagsError{Tags: []string{"tag1", "tag, 2"}}))
+
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		require.JSONEq(t, `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"creating upload: unknown tags: tag1, tag, 2","unknownTags":["tag1","tag, 2"]}`, w.Body.String())
+	})
+}
+
+func TestErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{err: service.ErrCollectionNotFound, status: http.StatusNotFound},
+		{err: service.ErrAPIKeyNotFound, status: http.StatusNotFound},
+		{err: service.ErrConflict, status: http.StatusConflict},
+		{err: service.ErrInvalidTag, status: http.StatusUnprocessableEntity},
+		{err: service.ErrUnknownTags, status: http.StatusUnprocessableEntity},
+		{err: service.ErrUnknownMedia, status: http.StatusUnprocessableEntity},
+		{err: fmt.Errorf("listing tags: %w", service.ErrUnavailable), status: http.StatusServiceUnavailable},
+		{err: assert.AnError, status: http.StatusInternalServerError},
+	} {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			require.Equal(t, tc.status, errorStatus(tc.err))
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	detail := "collection not found"
+	require.Equal(t, api.NotFoundApplicationProblemPlusJSONResponse{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: &detail},
+		notFound(service.ErrCollectionNotFound))
+}
+
+func TestUnprocessableEntity(t *testing.T) {
+	detail := "unknown media"
+	require.Equal(t, api.UnprocessableEntityApplicationProblemPlusJSONResponse{Type: "about:blank", Title: "Unprocessable Entity", Status: http.StatusUnprocessableEntity, Detail: &detail},
+		unprocessableEntity(service.ErrUnknownMedia))
+}
+
+func TestValidationErrorHandler(t *testing.T) {
+	swagger, err := api.GetSwagger()
+	require.NoError(t, err)
+	swagger.Servers = nil
+	validator := nethttpmiddleware.OapiRequestValidatorWithOptions(swagger, &nethttpmiddleware.Options{
+		Options: openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			MultiError:         true,
+		},
+		ErrorHandler: func(w http.ResponseWriter, message string, statusCode int) {
+			problem.Write(w, statusCode, message)
+		},
+		MultiErrorHandler: ValidationErrorHandler(swagger),
+	})
+	handler := validator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{name: "it accepts valid tags", method: http.MethodPost, target: "/media", body: `{"name":"a","tags":["Arsenal"]}`, status: http.StatusNoContent},
+		{name: "it rejects empty tags with a 422", method: http.MethodPost, target: "/tags", body: `{"name":""}`, status: http.StatusUnprocessableEntity},
+		{name: "it rejects long tags with a 422", method: http.MethodPost, target: "/media", body: `{"name":"a","tags":["` + strings.Repeat("a", 256) + `"]}`, status: http.StatusUnprocessableEntity},
+		{name: "it rejects control characters with a 422", method: http.MethodGet, target: "/media?tag=a%0Ab", status: http.StatusUnprocessableEntity},
+		{name: "it rejects other errors with a 400", method: http.MethodPost, target: "/tags", body: `{}`, status: http.StatusBadRequest},
+		{name: "it rejects mixed errors with a 400", method: http.MethodPost, target: "/media", body: `{"tags":[""]}`, status: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", "application/json")
+
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tc.status, w.Code, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"scoreplay/internal/problem"
+	"scoreplay/internal/service"
+	"scoreplay/internal/tenant"
+	"scoreplay/internal/token"
+	"scoreplay/pkg/api"
+)
+
+const (
+	APIKeyHeader   = "X-API-Key"
+	AdminKeyHeader = "X-Admin-Key"
+
+	bearerPrefix = "Bearer "
+)
+
+type apiKeyLookup interface {
+	LookupAPIKey(ctx context.Context, secret string) (*service.APIKey, error)
+}
+
+type tokenVerifier interface {
+	Verify(ctx context.Context, raw string) (*token.Claims, error)
+}
+
+type adminContextKey struct{}
+
+// AuthMiddleware authenticates the operations declaring a security requirement in the API spec.
+// Admin operations require the configured admin key, which disables them when empty. Every other
+// operation requires either an API key, or a bearer token carrying the role named by the scopes
+// of the operation. Both are scoped to the tenant they were issued for.
+func AuthMiddleware(keys apiKeyLookup, tokens tokenVerifier, adminKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			status := http.StatusOK
+			bearerScopes, bearer := ctx.Value(api.BearerAuthScopes).([]string)
+			switch secret := r.Header.Get(APIKeyHeader); {
+			case ctx.Value(api.AdminKeyAuthScopes) != nil:
+				ctx, status = authenticateAdmin(ctx, r.Header.Get(AdminKeyHeader), adminKey)
+			case ctx.Value(api.ApiKeyAuthScopes) != nil && secret != "":
+				ctx, status = authenticateAPIKey(ctx, keys, secret)
+			case bearer && strings.HasPrefix(r.Header.Get("Authorization"), bearerPrefix):
+				ctx, status = authenticateBearer(ctx, tokens, strings.TrimPrefix(r.Header.Get("Authorization"), bearerPrefix), bearerScopes)
+			case bearer || ctx.Value(api.ApiKeyAuthScopes) != nil:
+				status = http.StatusUnauthorized
+			}
+			if status != http.StatusOK {
+				problem.Write(w, status, "")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func authenticateAdmin(ctx context.Context, given, adminKey string) (context.Context, int) {
+	if adminKey == "" || subtle.ConstantTimeCompare([]byte(given), []byte(adminKey)) != 1 {
+		return ctx, http.StatusUnauthorized
+	}
+
+	return withClientID(context.WithValue(ctx, adminContextKey{}, true), "admin"), http.StatusOK
+}
+
+func authenticateAPIKey(ctx context.Context, keys apiKeyLookup, secret string) (context.Context, int) {
+	key, err := keys.LookupAPIKey(ctx, secret)
+	if errors.Is(err, service.ErrInvalidAPIKey) {
+		return ctx, http.StatusUnauthorized
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Looking up API key")
+		return ctx, http.StatusInternalServerError
+	}
+
+	return withClientID(tenant.WithID(ctx, key.Tenant), "key:"+key.ID), http.StatusOK
+}
+
+func authenticateBearer(ctx context.Context, tokens tokenVerifier, raw string, scopes []string) (context.Context, int) {
+	claims, err := tokens.Verify(ctx, raw)
+	if err != nil {
+		log.Ctx(ctx).Debug().Err(err).Msg("Rejecting bearer token")
+		return ctx, http.StatusUnauthorized
+	}
+	for _, scope := range scopes {
+		if !claims.Role.Allows(token.Role(scope)) {
+			return ctx, http.StatusForbidden
+		}
+	}
+
+	return withClientID(tenant.WithID(ctx, claims.Tenant), "token:"+claims.Tenant+":"+claims.Subject), http.StatusOK
+}
+
+func isAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminContextKey{}).(bool)
+	return admin
+}
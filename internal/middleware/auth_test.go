@@ -0,0 +1,221 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/service"
+	"scoreplay/internal/tenant"
+	"scoreplay/internal/token"
+	"scoreplay/pkg/api"
+)
+
+type mockKeyLookup struct {
+	m *mock.Mock
+}
+
+func (m *mockKeyLookup) LookupAPIKey(ctx context.Context, secret string) (*service.APIKey, error) {
+	args := m.m.Called(ctx, secret)
+	key, _ := args.Get(0).(*service.APIKey)
+	return key, args.Error(1)
+}
+
+type mockTokenVerifier struct {
+	m *mock.Mock
+}
+
+func (m *mockTokenVerifier) Verify(ctx context.Context, raw string) (*token.Claims, error) {
+	args := m.m.Called(ctx, raw)
+	claims, _ := args.Get(0).(*token.Claims)
+	return claims, args.Error(1)
+}
+
+func withScopes(r *http.Request, scheme string, scopes ...string) *http.Request {
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return r.WithContext(context.WithValue(r.Context(), scheme, scopes))
+}
+
+// withUserScopes declares the security requirements of the operations open to API keys and tokens.
+func withUserScopes(r *http.Request, role token.Role) *http.Request {
+	r = withScopes(r, api.ApiKeyAuthScopes)
+	return withScopes(r, api.BearerAuthScopes, string(role))
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Run("it scopes the request to the tenant of the API key", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withScopes(httptest.NewRequest(http.MethodGet, "/", nil), api.ApiKeyAuthScopes)
+		r.Header.Set(APIKeyHeader, "sp_secret")
+		m.On("LookupAPIKey", r.Context(), "sp_secret").Return(&service.APIKey{ID: "id", Tenant: "club"}, nil).Once()
+		m.On("ServeHTTP", w, mock.MatchedBy(func(r *http.Request) bool {
+			id, ok := tenant.FromContext(r.Context())
+			return ok && id == "club" && clientID(r) == "key:id"
+		})).Return().Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects a request without API key", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withScopes(httptest.NewRequest(http.MethodGet, "/", nil), api.ApiKeyAuthScopes)
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects an unknown API key", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withScopes(httptest.NewRequest(http.MethodGet, "/", nil), api.ApiKeyAuthScopes)
+		r.Header.Set(APIKeyHeader, "sp_secret")
+		m.On("LookupAPIKey", r.Context(), "sp_secret").Return(nil, service.ErrInvalidAPIKey).Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it fails if the API key cannot be looked up", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withScopes(httptest.NewRequest(http.MethodGet, "/", nil), api.ApiKeyAuthScopes)
+		r.Header.Set(APIKeyHeader, "sp_secret")
+		m.On("LookupAPIKey", r.Context(), "sp_secret").Return(nil, assert.AnError).Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it accepts the admin key", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withScopes(httptest.NewRequest(http.MethodPost, "/", nil), api.AdminKeyAuthScopes)
+		r.Header.Set(AdminKeyHeader, "admin")
+		m.On("ServeHTTP", w, mock.MatchedBy(func(r *http.Request) bool {
+			return isAdmin(r.Context())
+		})).Return().Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "admin")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects a wrong admin key", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withScopes(httptest.NewRequest(http.MethodPost, "/", nil), api.AdminKeyAuthScopes)
+		r.Header.Set(AdminKeyHeader, "guess")
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "admin")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects admin requests if no admin key is configured", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withScopes(httptest.NewRequest(http.MethodPost, "/", nil), api.AdminKeyAuthScopes)
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it moves on operations without security requirement", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		m.On("ServeHTTP", w, mock.Anything).Return().Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it scopes the request to the tenant of the token", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withUserScopes(httptest.NewRequest(http.MethodPost, "/", nil), token.RoleContributor)
+		r.Header.Set("Authorization", "Bearer jwt")
+		m.On("Verify", r.Context(), "jwt").Return(&token.Claims{Subject: "jane", Tenant: "club", Role: token.RoleAdmin}, nil).Once()
+		m.On("ServeHTTP", w, mock.MatchedBy(func(r *http.Request) bool {
+			id, ok := tenant.FromContext(r.Context())
+			return ok && id == "club" && clientID(r) == "token:club:jane"
+		})).Return().Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it forbids a token without the required role", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withUserScopes(httptest.NewRequest(http.MethodPost, "/", nil), token.RoleContributor)
+		r.Header.Set("Authorization", "Bearer jwt")
+		m.On("Verify", r.Context(), "jwt").Return(&token.Claims{Subject: "jane", Tenant: "club", Role: token.RoleViewer}, nil).Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it rejects an invalid token", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withUserScopes(httptest.NewRequest(http.MethodGet, "/", nil), token.RoleViewer)
+		r.Header.Set("Authorization", "Bearer jwt")
+		m.On("Verify", r.Context(), "jwt").Return(nil, token.ErrInvalidToken).Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it prefers the API key over the token", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := withUserScopes(httptest.NewRequest(http.MethodPost, "/", nil), token.RoleAdmin)
+		r.Header.Set(APIKeyHeader, "sp_secret")
+		r.Header.Set("Authorization", "Bearer jwt")
+		m.On("LookupAPIKey", r.Context(), "sp_secret").Return(&service.APIKey{ID: "id", Tenant: "club"}, nil).Once()
+		m.On("ServeHTTP", w, mock.Anything).Return().Once()
+
+		handler := AuthMiddleware(&mockKeyLookup{m: m}, &mockTokenVerifier{m: m}, "")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.True(t, m.AssertExpectations(t))
+	})
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"scoreplay/internal/problem"
+	"scoreplay/internal/service"
+)
+
+type rateLimiter interface {
+	Allow(ctx context.Context, key string, limit service.RateLimit) (*service.RateLimitResult, error)
+}
+
+// RateLimits configures the rate limits of the operations, keyed by route pattern such as
+// "POST /media". Operations without a limit of their own share the default one. A limit allowing
+// no requests is disabled.
+type RateLimits struct {
+	Default    service.RateLimit
+	Operations map[string]service.RateLimit
+}
+
+// ParseRateLimits parses per-operation limits written as pattern=requests/period, such as
+// "POST /media=10/1m".
+func ParseRateLimits(specs []string) (map[string]service.RateLimit, error) {
+	limits := make(map[string]service.RateLimit, len(specs))
+	for _, spec := range specs {
+		if spec == "" {
+			continue
+		}
+		pattern, value, ok := cutLast(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("parsing rate limit %q: missing =", spec)
+		}
+		requests, period, ok := strings.Cut(strings.TrimSpace(value), "/")
+		if !ok {
+			return nil, fmt.Errorf("parsing rate limit %q: missing /", spec)
+		}
+		n, err := strconv.Atoi(requests)
+		if err != nil {
+			return nil, fmt.Errorf("parsing rate limit %q: %w", spec, err)
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil {
+			return nil, fmt.Errorf("parsing rate limit %q: %w", spec, err)
+		}
+		limits[strings.TrimSpace(pattern)] = service.RateLimit{Requests: n, Period: d}
+	}
+
+	return limits, nil
+}
+
+// RateLimitMiddleware limits the requests of every client, identified by its API key, token or IP
+// address. It reports the state of the limit in RateLimit-* headers, and denies requests over
+// the limit with 429 Too Many Requests. Requests are let through when the limiter fails.
+func RateLimitMiddleware(limiter rateLimiter, limits RateLimits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, key := limits.Default, clientID(r)
+			if l, ok := limits.Operations[r.Pattern]; ok {
+				limit, key = l, r.Pattern+":"+key
+			}
+			if allow(w, r, limiter, key, limit) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// IPRateLimitMiddleware limits the requests of every IP address, all operations counted together.
+// It runs before the authentication, so that the requests failing it are limited too, and
+// reports and denies requests like RateLimitMiddleware.
+func IPRateLimitMiddleware(limiter rateLimiter, limit service.RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Counted apart from the clients identified by their IP address after authentication.
+			if allow(w, r, limiter, "address:"+remoteIP(r), limit) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// allow counts the request against the limit under the given key. It denies the request, and
+// reports false, if it is over the limit.
+func allow(w http.ResponseWriter, r *http.Request, limiter rateLimiter, key string, limit service.RateLimit) bool {
+	if limit.Requests <= 0 {
+		return true
+	}
+
+	result, err := limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		log.Ctx(r.Context()).Warn().Err(err).Msg("Rate limiting request")
+		return true
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, seconds(limit.Period)))
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	h.Set("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	h.Set("RateLimit-Reset", strconv.FormatInt(seconds(result.Reset), 10))
+	if !result.Allowed {
+		h.Set("Retry-After", strconv.FormatInt(seconds(result.RetryAfter), 10))
+		problem.Write(w, http.StatusTooManyRequests, "")
+		return false
+	}
+
+	return true
+}
+
+type clientContextKey struct{}
+
+func withClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, id)
+}
+
+// clientID identifies the client by the credentials it authenticated with, or else by its IP address.
+func clientID(r *http.Request) string {
+	if id, ok := r.Context().Value(clientContextKey{}).(string); ok {
+		return id
+	}
+
+	return "ip:" + remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
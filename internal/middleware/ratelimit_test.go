@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/problem"
+	"scoreplay/internal/service"
+)
+
+type mockRateLimiter struct {
+	m *mock.Mock
+}
+
+func (m *mockRateLimiter) Allow(ctx context.Context, key string, limit service.RateLimit) (*service.RateLimitResult, error) {
+	args := m.m.Called(ctx, key, limit)
+	result, _ := args.Get(0).(*service.RateLimitResult)
+	return result, args.Error(1)
+}
+
+func TestParseRateLimits(t *testing.T) {
+	t.Run("it parses the limits", func(t *testing.T) {
+		limits, err := ParseRateLimits([]string{"POST /media=10/1m", "", "POST /media:batch = 2/1h"})
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]service.RateLimit{
+			"POST /media":       {Requests: 10, Period: time.Minute},
+			"POST /media:batch": {Requests: 2, Period: time.Hour},
+		}, limits)
+	})
+
+	t.Run("it fails on a malformed limit", func(t *testing.T) {
+		for spec, msg := range map[string]string{
+			"POST /media":        `parsing rate limit "POST /media": missing =`,
+			"POST /media=10":     `parsing rate limit "POST /media=10": missing /`,
+			"POST /media=ten/1m": `parsing rate limit "POST /media=ten/1m": strconv.Atoi: parsing "ten": invalid syntax`,
+			"POST /media=10/1":   `parsing rate limit "POST /media=10/1": time: missing unit in duration "1"`,
+		} {
+			_, err := ParseRateLimits([]string{spec})
+			require.EqualError(t, err, msg)
+		}
+	})
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limits := RateLimits{
+		Default:    service.RateLimit{Requests: 100, Period: time.Minute},
+		Operations: map[string]service.RateLimit{"POST /media": {Requests: 10, Period: time.Minute}, "GET /tags": {}},
+	}
+
+	t.Run("it allows a request under the limit", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/media", nil)
+		r = r.WithContext(withClientID(r.Context(), "key:id"))
+		m.On("Allow", r.Context(), "key:id", limits.Default).Return(&service.RateLimitResult{Allowed: true, Remaining: 99, Reset: 600 * time.Millisecond}, nil).Once()
+		m.On("ServeHTTP", w, r).Return().Once()
+
+		handler := RateLimitMiddleware(&mockRateLimiter{m: m}, limits)(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, "100;w=60", w.Header().Get("RateLimit-Policy"))
+		require.Equal(t, "100", w.Header().Get("RateLimit-Limit"))
+		require.Equal(t, "99", w.Header().Get("RateLimit-Remaining"))
+		require.Equal(t, "1", w.Header().Get("RateLimit-Reset"))
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it denies a request over the limit of its operation", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/media", nil)
+		r.Pattern = "POST /media"
+		r.RemoteAddr = "192.0.2.1:1234"
+		m.On("Allow", r.Context(), "POST /media:ip:192.0.2.1", limits.Operations["POST /media"]).
+			Return(&service.RateLimitResult{RetryAfter: 5500 * time.Millisecond, Reset: time.Minute}, nil).Once()
+
+		handler := RateLimitMiddleware(&mockRateLimiter{m: m}, limits)(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		require.Equal(t, "10", w.Header().Get("RateLimit-Limit"))
+		require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+		require.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+		require.Equal(t, "6", w.Header().Get("Retry-After"))
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it does not limit an operation with a disabled limit", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/tags", nil)
+		r.Pattern = "GET /tags"
+		m.On("ServeHTTP", w, r).Return().Once()
+
+		handler := RateLimitMiddleware(&mockRateLimiter{m: m}, limits)(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Empty(t, w.Header().Get("RateLimit-Limit"))
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it lets the request through if the limiter fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/media", nil)
+		m.On("Allow", r.Context(), "ip:192.0.2.1", limits.Default).Return(nil, assert.AnError).Once()
+		m.On("ServeHTTP", w, r).Return().Once()
+
+		handler := RateLimitMiddleware(&mockRateLimiter{m: m}, limits)(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.True(t, m.AssertExpectations(t))
+	})
+}
+
+func TestIPRateLimitMiddleware(t *testing.T) {
+	limit := service.RateLimit{Requests: 1000, Period: time.Minute}
+
+	t.Run("it allows a request under the limit of its address", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/media", nil)
+		m.On("Allow", r.Context(), "address:192.0.2.1", limit).Return(&service.RateLimitResult{Allowed: true, Remaining: 999}, nil).Once()
+		m.On("ServeHTTP", w, r).Return().Once()
+
+		handler := IPRateLimitMiddleware(&mockRateLimiter{m: m}, limit)(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, "999", w.Header().Get("RateLimit-Remaining"))
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it denies a request over the limit of its address", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/media", nil)
+		r.RemoteAddr = "192.0.2.2"
+		m.On("Allow", r.Context(), "address:192.0.2.2", limit).Return(&service.RateLimitResult{RetryAfter: time.Second}, nil).Once()
+
+		handler := IPRateLimitMiddleware(&mockRateLimiter{m: m}, limit)(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, "1", w.Header().Get("Retry-After"))
+		require.True(t, m.AssertExpectations(t))
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/rs/zerolog/log"
+
+	"scoreplay/internal/problem"
 )
 
 func RecoveryMiddleware(next http.Handler) http.Handler {
@@ -14,7 +16,7 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 				if err, ok := rvr.(error); !ok || !errors.Is(err, http.ErrAbortHandler) {
 					log.Ctx(r.Context()).Error().Interface("panic", rvr).Msg("Panic occurred")
 
-					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					problem.Write(w, http.StatusInternalServerError, "")
 				}
 			}
 		}()
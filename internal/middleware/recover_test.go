@@ -7,6 +7,8 @@ import (
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/problem"
 )
 
 type mockHTTPHandler struct {
@@ -37,7 +39,8 @@ func TestRecoveryMiddleware(t *testing.T) {
 		handler := RecoveryMiddleware(&mockHTTPHandler{m: m})
 		handler.ServeHTTP(w, r)
 		require.Equal(t, http.StatusInternalServerError, w.Code)
-		require.Equal(t, http.StatusText(http.StatusInternalServerError)+"\n", w.Body.String())
+		require.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		require.JSONEq(t, `{"type":"about:blank","title":"Internal Server Error","status":500}`, w.Body.String())
 		require.True(t, m.AssertExpectations(t))
 	})
 }
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"scoreplay/internal/problem"
+	"scoreplay/internal/tenant"
+)
+
+// TenantMiddleware forbids the requests naming, in the given header, another tenant than the one
+// of their API key or token. The auth middleware scopes every request but the admin ones, which
+// are scoped to no tenant, so the header never selects the tenant itself.
+func TenantMiddleware(header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if scoped, ok := tenant.FromContext(r.Context()); ok && id != "" && id != scoped {
+				problem.Write(w, http.StatusForbidden, "tenant does not match credentials")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/tenant"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	t.Run("it keeps the tenant of the credentials", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(tenant.WithID(r.Context(), "club"))
+		r.Header.Set("X-Tenant-ID", "club")
+		m.On("ServeHTTP", w, r).Return().Once()
+
+		handler := TenantMiddleware("X-Tenant-ID")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it forbids another tenant than the one of the credentials", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(tenant.WithID(r.Context(), "club"))
+		r.Header.Set("X-Tenant-ID", "rival")
+
+		handler := TenantMiddleware("X-Tenant-ID")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.JSONEq(t, `{"type":"about:blank","title":"Forbidden","status":403,"detail":"tenant does not match credentials"}`, w.Body.String())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it does not scope admin requests", func(t *testing.T) {
+		m := &mock.Mock{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), adminContextKey{}, true))
+		r.Header.Set("X-Tenant-ID", "club")
+		m.On("ServeHTTP", w, r).Return().Once()
+
+		handler := TenantMiddleware("X-Tenant-ID")(&mockHTTPHandler{m: m})
+		handler.ServeHTTP(w, r)
+
+		require.True(t, m.AssertExpectations(t))
+	})
+}
@@ -0,0 +1,38 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"scoreplay/pkg/api"
+)
+
+const (
+	ContentType = "application/problem+json"
+
+	// blankType is the problem type of problems described by their status code alone.
+	blankType = "about:blank"
+)
+
+// New returns RFC 7807 problem details for the given status code.
+func New(status int, detail string) api.Problem {
+	p := api.Problem{Type: blankType, Title: http.StatusText(status), Status: status}
+	if detail != "" {
+		p.Detail = &detail
+	}
+
+	return p
+}
+
+// Write responds with the problem details for the given status code.
+func Write(w http.ResponseWriter, status int, detail string) {
+	Respond(w, New(status, detail))
+}
+
+// Respond responds with the given problem details, for problems carrying extension members.
+func Respond(w http.ResponseWriter, p api.Problem) {
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
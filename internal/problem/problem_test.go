@@ -0,0 +1,39 @@
+package problem
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/pkg/api"
+)
+
+func TestNew(t *testing.T) {
+	detail := "collection not found"
+	require.Equal(t, api.Problem{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: &detail}, New(http.StatusNotFound, detail))
+	require.Equal(t, api.Problem{Type: "about:blank", Title: "Internal Server Error", Status: http.StatusInternalServerError}, New(http.StatusInternalServerError, ""))
+}
+
+func TestWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	Write(w, http.StatusTooManyRequests, "slow down")
+
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"slow down"}`, w.Body.String())
+}
+
+func TestRespond(t *testing.T) {
+	w := httptest.NewRecorder()
+	p := New(http.StatusUnprocessableEntity, "unknown tags: tag1")
+	p.UnknownTags = &[]api.Tag{"tag1"}
+
+	Respond(w, p)
+
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"unknown tags: tag1","unknownTags":["tag1"]}`, w.Body.String())
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/redis/rueidis"
+)
+
+// Modes of the Redis deployment.
+const (
+	RedisStandalone = "standalone"
+	RedisCluster    = "cluster"
+	RedisSentinel   = "sentinel"
+)
+
+// redisOptions returns the options of the Redis client described by the configuration.
+func redisOptions(cfg Config) (rueidis.ClientOption, error) {
+	opt := rueidis.ClientOption{
+		InitAddress:  cfg.Redis.InitAddress,
+		Username:     cfg.Redis.Username,
+		Password:     cfg.Redis.Password,
+		SelectDB:     cfg.Redis.SelectDB,
+		DisableCache: cfg.Redis.DisableCache,
+	}
+
+	switch cfg.Redis.Mode {
+	// The zero value of the configuration connects to a single Redis, as the default does.
+	case "", RedisStandalone:
+		if cfg.Redis.ReplicaReads {
+			return opt, errors.New("replica reads need the cluster or sentinel mode")
+		}
+		opt.ForceSingleClient = true
+	case RedisCluster:
+		// The Lua scripts and the multi-key commands touch several keys of a tenant, which Redis
+		// Cluster only allows within a single slot.
+		if !cfg.Redis.HashTags {
+			return opt, errors.New("the cluster mode needs hash tags")
+		}
+		if cfg.Redis.SelectDB != 0 {
+			return opt, errors.New("the cluster mode only has database 0")
+		}
+	case RedisSentinel:
+		if cfg.Redis.Sentinel.MasterSet == "" {
+			return opt, errors.New("the sentinel mode needs a master set")
+		}
+		opt.Sentinel = rueidis.SentinelOption{
+			MasterSet: cfg.Redis.Sentinel.MasterSet,
+			Username:  cfg.Redis.Sentinel.Username,
+			Password:  cfg.Redis.Sentinel.Password,
+		}
+	default:
+		return opt, fmt.Errorf("unknown mode %q", cfg.Redis.Mode)
+	}
+
+	if cfg.Redis.TLS.Enabled {
+		tlsConfig, err := redisTLSConfig(cfg)
+		if err != nil {
+			return opt, err
+		}
+		opt.TLSConfig = tlsConfig
+		opt.Sentinel.TLSConfig = tlsConfig
+	}
+
+	return opt, nil
+}
+
+// redisTLSConfig returns the TLS configuration of the connections to Redis and its sentinels.
+// The server certificate is verified against the CA when given, and against the system roots
+// otherwise. The client certificate is presented when given.
+func redisTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.Redis.TLS.CAFile != "" {
+		ca, err := os.ReadFile(cfg.Redis.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("reading CA file: no certificate in %s", cfg.Redis.TLS.CAFile)
+		}
+	}
+
+	if cfg.Redis.TLS.CertFile != "" || cfg.Redis.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Redis.TLS.CertFile, cfg.Redis.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate valid for 127.0.0.1, and its key, to the
+// returned files.
+func writeCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func TestRedisOptions(t *testing.T) {
+	t.Run("it connects to a single redis by default", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.InitAddress = []string{"redis:6379"}
+		cfg.Redis.SelectDB = 1
+
+		opt, err := redisOptions(cfg)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"redis:6379"}, opt.InitAddress)
+		require.Equal(t, 1, opt.SelectDB)
+		require.True(t, opt.ForceSingleClient)
+		require.Nil(t, opt.TLSConfig)
+	})
+
+	t.Run("it connects to a cluster", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.Mode = RedisCluster
+		cfg.Redis.HashTags = true
+
+		opt, err := redisOptions(cfg)
+
+		require.NoError(t, err)
+		require.False(t, opt.ForceSingleClient)
+		require.Empty(t, opt.Sentinel.MasterSet)
+	})
+
+	t.Run("it connects through sentinels", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.Mode = RedisSentinel
+		cfg.Redis.Sentinel.MasterSet = "primary"
+		cfg.Redis.Sentinel.Username = "watcher"
+		cfg.Redis.Sentinel.Password = "secret"
+
+		opt, err := redisOptions(cfg)
+
+		require.NoError(t, err)
+		require.Equal(t, rueidis.SentinelOption{MasterSet: "primary", Username: "watcher", Password: "secret"}, opt.Sentinel)
+	})
+
+	t.Run("it secures the connections to redis and the sentinels", func(t *testing.T) {
+		certFile, keyFile := writeCertificate(t)
+		var cfg Config
+		cfg.Redis.Mode = RedisSentinel
+		cfg.Redis.Sentinel.MasterSet = "primary"
+		cfg.Redis.TLS.Enabled = true
+		cfg.Redis.TLS.CAFile = certFile
+		cfg.Redis.TLS.CertFile = certFile
+		cfg.Redis.TLS.KeyFile = keyFile
+
+		opt, err := redisOptions(cfg)
+
+		require.NoError(t, err)
+		require.NotNil(t, opt.TLSConfig.RootCAs)
+		require.Len(t, opt.TLSConfig.Certificates, 1)
+		require.Same(t, opt.TLSConfig, opt.Sentinel.TLSConfig)
+	})
+
+	for name, tc := range map[string]struct {
+		configure func(cfg *Config)
+		expected  string
+	}{
+		"replica reads on a single redis": {
+			configure: func(cfg *Config) { cfg.Redis.ReplicaReads = true },
+			expected:  "replica reads need the cluster or sentinel mode",
+		},
+		"a cluster without hash tags": {
+			configure: func(cfg *Config) { cfg.Redis.Mode = RedisCluster },
+			expected:  "the cluster mode needs hash tags",
+		},
+		"a cluster database other than 0": {
+			configure: func(cfg *Config) { cfg.Redis.Mode, cfg.Redis.HashTags, cfg.Redis.SelectDB = RedisCluster, true, 1 },
+			expected:  "the cluster mode only has database 0",
+		},
+		"sentinels without master set": {
+			configure: func(cfg *Config) { cfg.Redis.Mode = RedisSentinel },
+			expected:  "the sentinel mode needs a master set",
+		},
+		"an unknown mode": {
+			configure: func(cfg *Config) { cfg.Redis.Mode = "replicated" },
+			expected:  `unknown mode "replicated"`,
+		},
+		"a missing CA file": {
+			configure: func(cfg *Config) { cfg.Redis.TLS.Enabled, cfg.Redis.TLS.CAFile = true, "/nonexistent/ca.pem" },
+			expected:  "reading CA file: open /nonexistent/ca.pem: no such file or directory",
+		},
+		"a client certificate without key": {
+			configure: func(cfg *Config) { cfg.Redis.TLS.Enabled, cfg.Redis.TLS.CertFile = true, "/nonexistent/cert.pem" },
+			expected:  "loading client certificate: open /nonexistent/cert.pem: no such file or directory",
+		},
+	} {
+		t.Run("it fails on "+name, func(t *testing.T) {
+			var cfg Config
+			tc.configure(&cfg)
+
+			_, err := redisOptions(cfg)
+
+			require.EqualError(t, err, tc.expected)
+		})
+	}
+
+	t.Run("it fails on a CA file without certificate", func(t *testing.T) {
+		_, keyFile := writeCertificate(t)
+		var cfg Config
+		cfg.Redis.TLS.Enabled = true
+		cfg.Redis.TLS.CAFile = keyFile
+
+		_, err := redisOptions(cfg)
+
+		require.EqualError(t, err, "reading CA file: no certificate in "+keyFile)
+	})
+}
+
+func TestNewRedisClient(t *testing.T) {
+	t.Run("it connects over TLS", func(t *testing.T) {
+		certFile, keyFile := writeCertificate(t)
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		require.NoError(t, err)
+		s := miniredis.NewMiniRedis()
+		require.NoError(t, s.StartTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}))
+		defer s.Close()
+
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.Redis.TLS.Enabled = true
+		cfg.Redis.TLS.CAFile = certFile
+		client, err := NewRedisClient(cfg)
+		require.NoError(t, err)
+		defer client.Close()
+
+		require.NoError(t, client.Do(context.Background(), client.B().Set().Key("k").Value("v").Build()).Error())
+		v, err := s.Get("k")
+		require.NoError(t, err)
+		require.Equal(t, "v", v)
+	})
+
+	t.Run("it fails if the options are invalid", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.Mode = "replicated"
+
+		_, err := NewRedisClient(cfg)
+
+		require.EqualError(t, err, `creating redis client: unknown mode "replicated"`)
+	})
+}
+
+func TestNewRedisReplicaClient(t *testing.T) {
+	t.Run("it creates no client unless replica reads are configured", func(t *testing.T) {
+		client, err := NewRedisReplicaClient(Config{})
+
+		require.NoError(t, err)
+		require.Nil(t, client)
+	})
+
+	t.Run("it fails if the options are invalid", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.ReplicaReads = true
+
+		_, err := NewRedisReplicaClient(cfg)
+
+		require.EqualError(t, err, "creating redis replica client: replica reads need the cluster or sentinel mode")
+	})
+}
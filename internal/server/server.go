@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,15 +13,19 @@ import (
 	"github.com/alexliesenfeld/health"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/getkin/kin-openapi/openapi3filter"
 	nethttpmiddleware "github.com/oapi-codegen/nethttp-middleware"
 	"github.com/redis/rueidis"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
 
 	"scoreplay/internal/handlers"
 	"scoreplay/internal/logger"
 	"scoreplay/internal/middleware"
+	"scoreplay/internal/problem"
 	"scoreplay/internal/service"
 	"scoreplay/internal/signal"
+	"scoreplay/internal/token"
 	"scoreplay/pkg/api"
 )
 
@@ -38,14 +43,56 @@ type Config struct {
 		Password     string   `env:"PASSWORD,required"`
 		SelectDB     int      `env:"SELECT_DB,required"`
 		DisableCache bool     `env:"DISABLE_CACHE" default:"false"`
+		KeyPrefix    string   `env:"KEY_PREFIX" default:""`
+		HashTags     bool     `env:"HASH_TAGS" default:"false"`
+		// Mode is standalone, cluster or sentinel.
+		Mode         string `env:"MODE" default:"standalone"`
+		ReplicaReads bool   `env:"REPLICA_READS" default:"false"`
+		Sentinel     struct {
+			MasterSet string `env:"MASTER_SET" default:""`
+			Username  string `env:"USERNAME" default:""`
+			Password  string `env:"PASSWORD" default:""`
+		} `env:"SENTINEL"`
+		TLS struct {
+			Enabled  bool   `env:"ENABLED" default:"false"`
+			CAFile   string `env:"CA_FILE" default:""`
+			CertFile string `env:"CERT_FILE" default:""`
+			KeyFile  string `env:"KEY_FILE" default:""`
+		} `env:"TLS"`
 	} `env:"REDIS"`
 	Storage struct {
 		Bucket string `env:"BUCKET,required"`
 	} `env:"STORAGE"`
+	Media struct {
+		RepairIndexes bool `env:"REPAIR_INDEXES" default:"false"`
+	} `env:"MEDIA"`
+	Schema struct {
+		AutoMigrate bool `env:"AUTO_MIGRATE" default:"false"`
+		// LegacyTenant receives the data stored before tenancy, which is left alone unless set.
+		LegacyTenant string `env:"LEGACY_TENANT" default:""`
+	} `env:"SCHEMA"`
+	Janitor service.JanitorConfig `env:"JANITOR"`
+	Backup  service.BackupConfig  `env:"BACKUP"`
+	Tags    service.TagConfig     `env:"TAGS"`
+	Tenancy struct {
+		Header string `env:"HEADER" default:"X-Tenant-ID"`
+	} `env:"TENANCY"`
+	Auth struct {
+		AdminKey string       `env:"ADMIN_KEY" default:""`
+		JWT      token.Config `env:"JWT"`
+	} `env:"AUTH"`
+	RateLimit struct {
+		Requests   int           `env:"REQUESTS" default:"600"`
+		Period     time.Duration `env:"PERIOD" default:"1m"`
+		Operations []string      `env:"OPERATIONS"`
+		IPRequests int           `env:"IP_REQUESTS" default:"1200"`
+	} `env:"RATELIMIT"`
 	Server struct {
 		Address           string        `env:"ADDRESS" default:":8080"`
 		ShutdownTimeout   time.Duration `env:"SHUTDOWN_TIMEOUT" default:"30s"`
 		ReadHeaderTimeout time.Duration `env:"READ_HEADER_TIMEOUT" default:"5s"`
+		// DebugAddress serves the metrics apart from the API; empty disables them.
+		DebugAddress string `env:"DEBUG_ADDRESS" default:"localhost:8081"`
 	} `env:"SERVER"`
 	Healthcheck struct {
 		CacheDuration time.Duration `env:"CACHE_DURATION" default:"1s"`
@@ -53,35 +100,73 @@ type Config struct {
 	} `env:"HEALTHCHECK"`
 }
 
+// redacted replaces the secrets set in the copies of the configuration written to the logs.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration whose secrets are hidden, to be logged.
+func (c Config) Redacted() Config {
+	for _, secret := range []*string{&c.Redis.Password, &c.Redis.Sentinel.Password, &c.Auth.AdminKey} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+
+	return c
+}
+
 func Run(ctx context.Context, cfg Config) error {
-	client, err := rueidis.NewClient(rueidis.ClientOption{
-		InitAddress:  cfg.Redis.InitAddress,
-		Username:     cfg.Redis.Username,
-		Password:     cfg.Redis.Password,
-		SelectDB:     cfg.Redis.SelectDB,
-		DisableCache: cfg.Redis.DisableCache,
-	})
+	ns, err := NewNamespace(cfg)
+	if err != nil {
+		return err
+	}
+	client, err := NewRedisClient(cfg)
 	if err != nil {
-		return fmt.Errorf("creating redis client: %w", err)
+		return err
 	}
 	defer client.Close()
+	replicaClient, err := NewRedisReplicaClient(cfg)
+	if err != nil {
+		return err
+	}
+	if replicaClient != nil {
+		defer replicaClient.Close()
+	}
 
-	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	s3Client, err := NewS3Client(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("loading aws config: %w", err)
+		return err
 	}
-	presignClient := s3.NewPresignClient(s3.NewFromConfig(awsCfg, func(o *s3.Options) {
-		// https://github.com/aws/aws-sdk-go-v2/discussions/2578
-		if cfg.AWS.S3.UsePathStyle {
-			o.UsePathStyle = true
-		}
-	}))
+
+	if err := migrateSchema(ctx, client, ns, s3Client, cfg); err != nil {
+		return err
+	}
+	presignClient := s3.NewPresignClient(s3Client)
 
 	endpointURL, err := url.Parse(cfg.AWS.EndpointURL)
 	if err != nil {
 		return fmt.Errorf("parsing endpoint url: %w", err)
 	}
-	qs := service.NewMediaService(client, presignClient, *endpointURL, cfg.Storage.Bucket)
+	tagRules, err := service.NewTagRules(cfg.Tags)
+	if err != nil {
+		return fmt.Errorf("creating tag rules: %w", err)
+	}
+	qs := service.NewMediaService(client, replicaClient, ns, presignClient, *endpointURL, cfg.Storage.Bucket, tagRules, cfg.Media.RepairIndexes)
+	cs := service.NewCollectionService(qs)
+	ks := service.NewAPIKeyService(client, ns)
+	backups, err := service.NewBackups(client, ns, s3Client, cfg.Storage.Bucket, cfg.Backup)
+	if err != nil {
+		return fmt.Errorf("creating backups: %w", err)
+	}
+
+	tokens, err := token.NewVerifier(ctx, cfg.Auth.JWT)
+	if err != nil {
+		return fmt.Errorf("creating token verifier: %w", err)
+	}
+
+	operationLimits, err := middleware.ParseRateLimits(cfg.RateLimit.Operations)
+	if err != nil {
+		return fmt.Errorf("parsing rate limits: %w", err)
+	}
 
 	swagger, err := api.GetSwagger()
 	if err != nil {
@@ -89,42 +174,185 @@ func Run(ctx context.Context, cfg Config) error {
 	}
 	swagger.Servers = nil
 
+	limiter := service.NewRateLimiter(client, ns)
 	r := http.NewServeMux()
 	r.Handle("/health", health.NewHandler(health.NewChecker(
 		health.WithCacheDuration(cfg.Healthcheck.CacheDuration),
 		health.WithTimeout(cfg.Healthcheck.Timeout),
 	)))
-	api.HandlerWithOptions(api.NewStrictHandler(handlers.NewMediaAPI(qs), nil), api.StdHTTPServerOptions{
-		BaseRouter: r,
+	strictHandler := api.NewStrictHandlerWithOptions(handlers.NewMediaAPI(qs, cs, ks), nil, api.StrictHTTPServerOptions{
+		RequestErrorHandlerFunc:  handlers.RequestErrorHandler,
+		ResponseErrorHandlerFunc: handlers.ResponseErrorHandler,
+	})
+	api.HandlerWithOptions(strictHandler, api.StdHTTPServerOptions{
+		BaseRouter:       r,
+		ErrorHandlerFunc: handlers.RequestErrorHandler,
+		// The last middleware is the outermost one.
 		Middlewares: []api.MiddlewareFunc{
+			middleware.TenantMiddleware(cfg.Tenancy.Header),
+			middleware.RateLimitMiddleware(limiter, middleware.RateLimits{
+				Default:    service.RateLimit{Requests: cfg.RateLimit.Requests, Period: cfg.RateLimit.Period},
+				Operations: operationLimits,
+			}),
+			middleware.AuthMiddleware(ks, tokens, cfg.Auth.AdminKey),
 			middleware.RecoveryMiddleware,
-			nethttpmiddleware.OapiRequestValidator(swagger),
+			// Security requirements are enforced by the auth middleware.
+			nethttpmiddleware.OapiRequestValidatorWithOptions(swagger, &nethttpmiddleware.Options{
+				Options: openapi3filter.Options{
+					AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+					MultiError:         true,
+				},
+				ErrorHandler: func(w http.ResponseWriter, message string, statusCode int) {
+					problem.Write(w, statusCode, message)
+				},
+				MultiErrorHandler: handlers.ValidationErrorHandler(swagger),
+			}),
+			middleware.IPRateLimitMiddleware(limiter, service.RateLimit{Requests: cfg.RateLimit.IPRequests, Period: cfg.RateLimit.Period}),
 		},
 	})
 
 	g, ctx := errgroup.WithContext(ctx)
 
-	srv := http.Server{
+	servers := []*http.Server{{
 		Handler:           r,
 		Addr:              cfg.Server.Address,
 		BaseContext:       func(_ net.Listener) context.Context { return ctx },
 		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+	}}
+	// The metrics are not authenticated, so they are served on their own address.
+	if cfg.Server.DebugAddress != "" {
+		debug := http.NewServeMux()
+		debug.Handle("/debug/vars", expvar.Handler())
+		servers = append(servers, &http.Server{
+			Handler:           debug,
+			Addr:              cfg.Server.DebugAddress,
+			BaseContext:       func(_ net.Listener) context.Context { return ctx },
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		})
 	}
 
 	g.Go(func() error { return signal.WaitForSignal(ctx) })
-	g.Go(func() error {
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			return err
-		}
-		return nil
-	})
-	g.Go(func() error {
-		<-ctx.Done()
-		srv.SetKeepAlivesEnabled(false)
-		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
-		defer cancel()
-		return srv.Shutdown(ctx) //nolint: contextcheck
-	})
+	if cfg.Janitor.Interval > 0 {
+		janitor := service.NewJanitor(client, ns, s3Client, cfg.Storage.Bucket, cfg.Janitor)
+		g.Go(func() error { return janitor.Run(ctx) })
+	}
+	if cfg.Backup.Interval > 0 {
+		g.Go(func() error { return backups.Run(ctx) })
+	}
+	for _, srv := range servers {
+		g.Go(func() error {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		})
+		g.Go(func() error {
+			<-ctx.Done()
+			srv.SetKeepAlivesEnabled(false)
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+			defer cancel()
+			return srv.Shutdown(ctx) //nolint: contextcheck
+		})
+	}
 
 	return g.Wait()
 }
+
+// migrateSchema brings the data model stored to the version of the server when asked to, and
+// fails otherwise unless it is there already.
+func migrateSchema(ctx context.Context, client rueidis.Client, ns service.Namespace, s3Client *s3.Client, cfg Config) error {
+	if !cfg.Schema.AutoMigrate {
+		return InitSchema(ctx, client, ns, cfg)
+	}
+
+	migrator := service.NewMigrator(client, ns, s3Client, cfg.Storage.Bucket, cfg.Schema.LegacyTenant)
+	results, err := migrator.Migrate(ctx, false)
+	for _, r := range results {
+		log.Ctx(ctx).Info().Int("version", r.Version).Int("migrated", r.Migrated).Msg(r.Summary)
+	}
+	if err != nil {
+		return fmt.Errorf("migrating schema: %w", err)
+	}
+
+	return nil
+}
+
+// InitSchema records the version of the binary on a keyspace without data, then checks the data
+// model stored is at that version, which every command writing data but migrate requires.
+func InitSchema(ctx context.Context, client rueidis.Client, ns service.Namespace, cfg Config) error {
+	// Recording the version moves no data, so no object store is needed.
+	if err := service.NewMigrator(client, ns, nil, "", cfg.Schema.LegacyTenant).Init(ctx); err != nil {
+		return fmt.Errorf("initializing schema: %w", err)
+	}
+
+	return CheckSchema(ctx, client, ns, cfg)
+}
+
+// CheckSchema fails unless the data model stored is at the version of the binary, which every
+// command but migrate requires. It writes nothing.
+func CheckSchema(ctx context.Context, client rueidis.Client, ns service.Namespace, cfg Config) error {
+	// Checking the version moves no data, so no object store is needed.
+	if err := service.NewMigrator(client, ns, nil, "", cfg.Schema.LegacyTenant).Check(ctx); err != nil {
+		return fmt.Errorf("checking schema: %w", err)
+	}
+
+	return nil
+}
+
+// NewNamespace creates the namespace of the Redis keys described by the configuration.
+func NewNamespace(cfg Config) (service.Namespace, error) {
+	ns, err := service.NewNamespace(cfg.Redis.KeyPrefix, cfg.Redis.HashTags)
+	if err != nil {
+		return service.Namespace{}, fmt.Errorf("creating key namespace: %w", err)
+	}
+
+	return ns, nil
+}
+
+// NewRedisClient creates the Redis client described by the configuration.
+func NewRedisClient(cfg Config) (rueidis.Client, error) {
+	opt, err := redisOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating redis client: %w", err)
+	}
+	client, err := rueidis.NewClient(opt)
+	if err != nil {
+		return nil, fmt.Errorf("creating redis client: %w", err)
+	}
+
+	return client, nil
+}
+
+// NewRedisReplicaClient creates the client reading from the Redis replicas, or returns nil unless
+// replica reads are configured.
+func NewRedisReplicaClient(cfg Config) (rueidis.Client, error) {
+	if !cfg.Redis.ReplicaReads {
+		return nil, nil //nolint: nilnil
+	}
+	opt, err := redisOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating redis replica client: %w", err)
+	}
+	opt.ReplicaOnly = true
+	client, err := rueidis.NewClient(opt)
+	if err != nil {
+		return nil, fmt.Errorf("creating redis replica client: %w", err)
+	}
+
+	return client, nil
+}
+
+// NewS3Client creates the S3 client described by the configuration and the AWS environment.
+func NewS3Client(ctx context.Context, cfg Config) (*s3.Client, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading aws config: %w", err)
+	}
+
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		// https://github.com/aws/aws-sdk-go-v2/discussions/2578
+		if cfg.AWS.S3.UsePathStyle {
+			o.UsePathStyle = true
+		}
+	}), nil
+}
@@ -3,11 +3,29 @@ package server
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/require"
 )
 
+func TestConfig_Redacted(t *testing.T) {
+	var cfg Config
+	cfg.Redis.Username = "app"
+	cfg.Redis.Password = "secret"
+	cfg.Redis.Sentinel.Password = "secret"
+	cfg.Auth.AdminKey = "secret"
+
+	redactedCfg := cfg.Redacted()
+
+	require.Equal(t, "app", redactedCfg.Redis.Username)
+	require.Equal(t, "REDACTED", redactedCfg.Redis.Password)
+	require.Equal(t, "REDACTED", redactedCfg.Redis.Sentinel.Password)
+	require.Equal(t, "REDACTED", redactedCfg.Auth.AdminKey)
+	require.Equal(t, "secret", cfg.Auth.AdminKey)
+	require.Empty(t, Config{}.Redacted().Auth.AdminKey)
+}
+
 func TestRun(t *testing.T) {
 	ctx := context.Background()
 	s := miniredis.RunT(t)
@@ -15,13 +33,15 @@ func TestRun(t *testing.T) {
 
 	t.Run("it stops if the context is canceled", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(ctx)
-		cancel()
+		time.AfterFunc(100*time.Millisecond, cancel)
 
 		var cfg Config
 		cfg.Redis.InitAddress = []string{s.Addr()}
 		cfg.Redis.DisableCache = true
 		cfg.AWS.S3.UsePathStyle = true
 		cfg.Server.Address = ":"
+		cfg.Janitor.Interval = time.Minute
+		cfg.Backup.Interval = time.Minute
 		err := Run(ctx, cfg)
 
 		require.ErrorIs(t, err, context.Canceled)
@@ -34,6 +54,68 @@ func TestRun(t *testing.T) {
 		require.EqualError(t, err, "creating redis client: no alive address in InitAddress")
 	})
 
+	t.Run("it fails if the key prefix holds braces with hash tags", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.KeyPrefix = "{app}:"
+		cfg.Redis.HashTags = true
+		err := Run(ctx, cfg)
+		require.EqualError(t, err, `creating key namespace: key prefix "{app}:" holds braces, which would override the hash tags`)
+	})
+
+	t.Run("it checks the schema under the key prefix", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		_, err := s.SAdd("app:tenant:{club}:tags", "t1")
+		require.NoError(t, err)
+
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.Redis.KeyPrefix = "app:"
+		cfg.Redis.HashTags = true
+		err = Run(ctx, cfg)
+		require.EqualError(t, err, "checking schema: schema is outdated: version 1, expected 4")
+	})
+
+	t.Run("it fails if the schema is outdated", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		_, err := s.SAdd("tenant:club:tags", "t1")
+		require.NoError(t, err)
+
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		err = Run(ctx, cfg)
+		require.EqualError(t, err, "checking schema: schema is outdated: version 1, expected 4")
+	})
+
+	t.Run("it records the schema version on an empty keyspace", func(t *testing.T) {
+		s := miniredis.RunT(t)
+
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.AWS.EndpointURL = "1:/1:-"
+		err := Run(ctx, cfg)
+		require.EqualError(t, err, `parsing endpoint url: parse "1:/1:-": first path segment in URL cannot contain colon`)
+		version, err := s.Get("schema:version")
+		require.NoError(t, err)
+		require.Equal(t, "4", version)
+	})
+
+	t.Run("it migrates the schema if asked to", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		s.HSet("tenant:club:media:key1", "name", "a", "tags", "t1")
+
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.Schema.AutoMigrate = true
+		cfg.AWS.EndpointURL = "1:/1:-"
+		err := Run(ctx, cfg)
+		require.EqualError(t, err, `parsing endpoint url: parse "1:/1:-": first path segment in URL cannot contain colon`)
+		require.Equal(t, `["t1"]`, s.HGet("tenant:club:media:key1", "tags"))
+	})
+
 	t.Run("it fails if it cannot parse AWS endpoint URL", func(t *testing.T) {
 		var cfg Config
 		cfg.Redis.InitAddress = []string{s.Addr()}
@@ -44,6 +126,42 @@ func TestRun(t *testing.T) {
 		require.EqualError(t, err, `parsing endpoint url: parse "1:/1:-": first path segment in URL cannot contain colon`)
 	})
 
+	t.Run("it fails if it cannot load the JWKS", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.Auth.JWT.JWKSFile = "/nonexistent/jwks.json"
+		err := Run(ctx, cfg)
+		require.EqualError(t, err, `creating token verifier: reading JWKS file: open /nonexistent/jwks.json: no such file or directory`)
+	})
+
+	t.Run("it fails if the tag rules are invalid", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.Tags.Allowed = "["
+		err := Run(ctx, cfg)
+		require.EqualError(t, err, "creating tag rules: compiling allowed characters: error parsing regexp: missing closing ]: `[`")
+	})
+
+	t.Run("it fails if the backup prefix overlaps a tenant", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.Backup.Prefix = "club/backups/"
+		err := Run(ctx, cfg)
+		require.EqualError(t, err, `creating backups: backup prefix "club/backups/" overlaps the objects of tenant "club"`)
+	})
+
+	t.Run("it fails if it cannot parse the rate limits", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.RateLimit.Operations = []string{"POST /media"}
+		err := Run(ctx, cfg)
+		require.EqualError(t, err, `parsing rate limits: parsing rate limit "POST /media": missing =`)
+	})
+
 	t.Run("it fails if it cannot serve", func(t *testing.T) {
 		var cfg Config
 		cfg.Redis.InitAddress = []string{s.Addr()}
@@ -52,4 +170,14 @@ func TestRun(t *testing.T) {
 		err := Run(ctx, cfg)
 		require.EqualError(t, err, `listen tcp: address 1:/1:-: too many colons in address`)
 	})
+
+	t.Run("it fails if it cannot serve the metrics", func(t *testing.T) {
+		var cfg Config
+		cfg.Redis.InitAddress = []string{s.Addr()}
+		cfg.Redis.DisableCache = true
+		cfg.Server.Address = ":"
+		cfg.Server.DebugAddress = "1:/1:-"
+		err := Run(ctx, cfg)
+		require.EqualError(t, err, `listen tcp: address 1:/1:-: too many colons in address`)
+	})
 }
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+)
+
+const (
+	apiKeyPrefix   = "apikey:"
+	apiKeyIDPrefix = "apikey-id:"
+	idField        = "id"
+	tenantField    = "tenant"
+
+	apiKeySecretPrefix = "sp_"
+	apiKeySecretBytes  = 32
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrAPIKeyNotFound = fmt.Errorf("API key %w", ErrNotFound)
+)
+
+// apiKeyService manages the API keys of all tenants. Keys are stored by their SHA-256 hash, so a
+// leaked Redis snapshot does not leak usable keys.
+type apiKeyService struct {
+	generateUUID   func() (uuid.UUID, error)
+	generateSecret func() (string, error)
+	ns             Namespace
+	rueidisClient  rueidis.Client
+}
+
+func NewAPIKeyService(rueidisClient rueidis.Client, ns Namespace) *apiKeyService {
+	return &apiKeyService{
+		generateUUID:   uuid.NewV7,
+		generateSecret: generateAPIKeySecret,
+		ns:             ns,
+		rueidisClient:  rueidisClient,
+	}
+}
+
+type APIKey struct {
+	ID     string
+	Tenant string
+	Name   string
+}
+
+type IssueAPIKeyParams struct {
+	Tenant string
+	Name   string
+}
+
+type IssueAPIKeyResult struct {
+	APIKey
+	Secret string
+}
+
+func (s apiKeyService) IssueAPIKey(ctx context.Context, params IssueAPIKeyParams) (*IssueAPIKeyResult, error) {
+	id, err := s.generateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("generating UUID: %w", err)
+	}
+	secret, err := s.generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("generating secret: %w", err)
+	}
+	hash := hashAPIKey(secret)
+
+	for i, resp := range s.rueidisClient.DoMulti(ctx,
+		s.rueidisClient.B().Hset().Key(s.ns.key(apiKeyPrefix+hash)).FieldValue().
+			FieldValue(idField, id.String()).
+			FieldValue(tenantField, params.Tenant).
+			FieldValue(nameField, params.Name).
+			Build(),
+		s.rueidisClient.B().Set().Key(s.ns.key(apiKeyIDPrefix+id.String())).Value(hash).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return nil, fmt.Errorf("executing command %d: %w", i, unavailable(err))
+		}
+	}
+
+	return &IssueAPIKeyResult{
+		APIKey: APIKey{ID: id.String(), Tenant: params.Tenant, Name: params.Name},
+		Secret: secret,
+	}, nil
+}
+
+// RevokeAPIKey deletes the key record before the ID index, so an interrupted revocation never
+// leaves a usable key behind.
+func (s apiKeyService) RevokeAPIKey(ctx context.Context, id string) error {
+	hash, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Get().Key(s.ns.key(apiKeyIDPrefix+id)).Build()).ToString()
+	if rueidis.IsRedisNil(err) {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("getting API key: %w", unavailable(err))
+	}
+
+	if err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Del().Key(s.ns.key(apiKeyPrefix+hash)).Build()).Error(); err != nil {
+		return fmt.Errorf("deleting API key: %w", unavailable(err))
+	}
+	if err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Del().Key(s.ns.key(apiKeyIDPrefix+id)).Build()).Error(); err != nil {
+		return fmt.Errorf("deleting API key index: %w", unavailable(err))
+	}
+
+	return nil
+}
+
+func (s apiKeyService) LookupAPIKey(ctx context.Context, secret string) (*APIKey, error) {
+	record, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Hgetall().Key(s.ns.key(apiKeyPrefix+hashAPIKey(secret))).Build()).AsStrMap()
+	if err != nil {
+		return nil, fmt.Errorf("getting API key: %w", unavailable(err))
+	}
+	if len(record) == 0 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return &APIKey{ID: record[idField], Tenant: record[tenantField], Name: record[nameField]}, nil
+}
+
+func generateAPIKeySecret() (string, error) {
+	b := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return apiKeySecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
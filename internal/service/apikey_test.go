@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+	rmock "github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewAPIKeyService(t *testing.T) {
+	rc := rmock.NewClient(nil)
+
+	s := NewAPIKeyService(rc, Namespace{})
+
+	require.NotNil(t, s)
+	require.NotNil(t, s.generateUUID)
+	require.NotNil(t, s.generateSecret)
+	require.Equal(t, rc, s.rueidisClient)
+}
+
+func TestAPIKeyService_IssueAPIKey(t *testing.T) {
+	ctx := context.Background()
+	id, err := uuid.NewV7()
+	require.NoError(t, err)
+	hash := hashAPIKey("sp_secret")
+
+	t.Run("it stores the hash of the key", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id, nil).Once()
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("HSET", apiKeyPrefix+hash, idField, id.String(), tenantField, "club", nameField, "photographers"),
+			rmock.Match("SET", apiKeyIDPrefix+id.String(), hash),
+		).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(3)), rmock.Result(rmock.RedisString("OK"))})
+
+		s := NewAPIKeyService(rc, Namespace{})
+		s.generateUUID = mockUUID(m)
+		s.generateSecret = func() (string, error) { return "sp_secret", nil }
+		result, err := s.IssueAPIKey(ctx, IssueAPIKeyParams{Tenant: "club", Name: "photographers"})
+
+		require.NoError(t, err)
+		require.Equal(t, &IssueAPIKeyResult{
+			APIKey: APIKey{ID: id.String(), Tenant: "club", Name: "photographers"},
+			Secret: "sp_secret",
+		}, result)
+		require.True(t, ctrl.Satisfied())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id, nil).Once()
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx, gomock.Any(), gomock.Any()).
+			Return([]rueidis.RedisResult{rmock.ErrorResult(assert.AnError), rmock.Result(rmock.RedisString("OK"))})
+
+		s := NewAPIKeyService(rc, Namespace{})
+		s.generateUUID = mockUUID(m)
+		s.generateSecret = func() (string, error) { return "sp_secret", nil }
+		_, err := s.IssueAPIKey(ctx, IssueAPIKeyParams{Tenant: "club", Name: "photographers"})
+
+		require.EqualError(t, err, "executing command 0: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the secret cannot be generated", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id, nil).Once()
+
+		s := NewAPIKeyService(rmock.NewClient(nil), Namespace{})
+		s.generateUUID = mockUUID(m)
+		s.generateSecret = func() (string, error) { return "", assert.AnError }
+		_, err := s.IssueAPIKey(ctx, IssueAPIKeyParams{Tenant: "club", Name: "photographers"})
+
+		require.EqualError(t, err, "generating secret: "+assert.AnError.Error())
+	})
+}
+
+func TestAPIKeyService_RevokeAPIKey(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it deletes the key and its index", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		gomock.InOrder(
+			rc.EXPECT().Do(ctx, rmock.Match("GET", apiKeyIDPrefix+"id")).Return(rmock.Result(rmock.RedisString("hash"))),
+			rc.EXPECT().Do(ctx, rmock.Match("DEL", apiKeyPrefix+"hash")).Return(rmock.Result(rmock.RedisInt64(1))),
+			rc.EXPECT().Do(ctx, rmock.Match("DEL", apiKeyIDPrefix+"id")).Return(rmock.Result(rmock.RedisInt64(1))),
+		)
+
+		err := NewAPIKeyService(rc, Namespace{}).RevokeAPIKey(ctx, "id")
+
+		require.NoError(t, err)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the key does not exist", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("GET", apiKeyIDPrefix+"id")).Return(rmock.Result(rmock.RedisNil()))
+
+		err := NewAPIKeyService(rc, Namespace{}).RevokeAPIKey(ctx, "id")
+
+		require.ErrorIs(t, err, ErrAPIKeyNotFound)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("GET", apiKeyIDPrefix+"id")).Return(rmock.Result(rmock.RedisString("hash")))
+		rc.EXPECT().Do(ctx, rmock.Match("DEL", apiKeyPrefix+"hash")).Return(rmock.ErrorResult(assert.AnError))
+
+		err := NewAPIKeyService(rc, Namespace{}).RevokeAPIKey(ctx, "id")
+
+		require.EqualError(t, err, "deleting API key: "+assert.AnError.Error())
+		require.ErrorIs(t, err, ErrUnavailable)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestAPIKeyService_LookupAPIKey(t *testing.T) {
+	ctx := context.Background()
+	hash := hashAPIKey("sp_secret")
+
+	t.Run("it returns the key", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("HGETALL", apiKeyPrefix+hash)).Return(rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
+			idField:     rmock.RedisString("id"),
+			tenantField: rmock.RedisString("club"),
+			nameField:   rmock.RedisString("photographers"),
+		})))
+
+		key, err := NewAPIKeyService(rc, Namespace{}).LookupAPIKey(ctx, "sp_secret")
+
+		require.NoError(t, err)
+		require.Equal(t, &APIKey{ID: "id", Tenant: "club", Name: "photographers"}, key)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the key is unknown", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("HGETALL", apiKeyPrefix+hash)).Return(rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{})))
+
+		_, err := NewAPIKeyService(rc, Namespace{}).LookupAPIKey(ctx, "sp_secret")
+
+		require.ErrorIs(t, err, ErrInvalidAPIKey)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestGenerateAPIKeySecret(t *testing.T) {
+	a, err := generateAPIKeySecret()
+	require.NoError(t, err)
+	b, err := generateAPIKeySecret()
+	require.NoError(t, err)
+
+	require.True(t, strings.HasPrefix(a, apiKeySecretPrefix))
+	require.Len(t, a, len(apiKeySecretPrefix)+43)
+	require.NotEqual(t, a, b)
+}
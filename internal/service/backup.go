@@ -0,0 +1,548 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/redis/rueidis"
+	"github.com/rs/zerolog/log"
+
+	"scoreplay/internal/tenant"
+)
+
+const (
+	// backupLockKey is held by the server taking the scheduled snapshot, so that a single server
+	// takes it when several run.
+	backupLockKey = "backup:lock"
+
+	snapshotVersion    = 1
+	snapshotPrefix     = "snapshot-"
+	snapshotSuffix     = ".json.gz"
+	snapshotTimeFormat = "20060102T150405Z"
+)
+
+var (
+	ErrSnapshotNotFound = fmt.Errorf("snapshot %w", ErrNotFound)
+	ErrTenantNotFound   = fmt.Errorf("tenant %w", ErrNotFound)
+	ErrTenantNotEmpty   = fmt.Errorf("%w: tenant is not empty", ErrConflict)
+)
+
+type BackupConfig struct {
+	// Interval between scheduled snapshots; zero disables them.
+	Interval time.Duration `env:"INTERVAL" default:"24h"`
+	// Prefix of the snapshots in the bucket, which must not start with a tenant directory.
+	Prefix string `env:"PREFIX" default:".backups/"`
+	// Keep is the number of snapshots kept; zero keeps them all.
+	Keep int `env:"KEEP" default:"7"`
+}
+
+type backupStore interface {
+	objectStore
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// Snapshot is the catalog of every tenant, as archived by a backup.
+type Snapshot struct {
+	Version   int                        `json:"version"`
+	CreatedAt time.Time                  `json:"createdAt"`
+	Tenants   map[string]*TenantSnapshot `json:"tenants"`
+}
+
+type TenantSnapshot struct {
+	Tags        []Tag                         `json:"tags"`
+	Media       map[string]SnapshotMedia      `json:"media"`
+	Index       map[Tag][]string              `json:"index"`
+	Related     map[Tag]map[Tag]float64       `json:"related"`
+	Collections map[string]SnapshotCollection `json:"collections"`
+	// Uploads are the objects of the pending uploads, scored by the creation time of their media.
+	Uploads map[string]float64 `json:"uploads"`
+}
+
+type SnapshotMedia struct {
+	Name string `json:"name"`
+	Tags []Tag  `json:"tags"`
+}
+
+type SnapshotCollection struct {
+	Name  string   `json:"name"`
+	Cover string   `json:"cover,omitempty"`
+	Items []string `json:"items"`
+}
+
+type backups struct {
+	bucket        string
+	cfg           BackupConfig
+	now           func() time.Time
+	ns            Namespace
+	objects       backupStore
+	rueidisClient rueidis.Client
+}
+
+// NewBackups creates the backups of the catalog to the bucket. It fails if the snapshots could be
+// taken for the objects of a tenant.
+func NewBackups(rueidisClient rueidis.Client, ns Namespace, objects backupStore, bucket string, cfg BackupConfig) (*backups, error) {
+	if id, _, ok := strings.Cut(cfg.Prefix, "/"); ok && tenant.Valid(id) {
+		return nil, fmt.Errorf("backup prefix %q overlaps the objects of tenant %q", cfg.Prefix, id)
+	}
+
+	return &backups{
+		bucket:        bucket,
+		cfg:           cfg,
+		now:           time.Now,
+		ns:            ns,
+		objects:       objects,
+		rueidisClient: rueidisClient,
+	}, nil
+}
+
+// Run takes a snapshot at every interval until the context is done, unless another server took
+// it already. A failed snapshot is logged, and taken again at the next interval.
+func (b backups) Run(ctx context.Context) error {
+	ticker := time.NewTicker(b.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// The lock expires a bit before the next interval, so that clock drift skips none.
+			ttl := max(b.cfg.Interval-b.cfg.Interval/10, time.Millisecond)
+			err := b.rueidisClient.Do(ctx, b.rueidisClient.B().Set().Key(b.ns.key(backupLockKey)).Value(b.now().UTC().Format(time.RFC3339)).
+				Nx().Px(ttl).Build()).Error()
+			if rueidis.IsRedisNil(err) {
+				continue
+			}
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("locking backup")
+				continue
+			}
+
+			key, err := b.Snapshot(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Ctx(ctx).Error().Err(err).Msg("taking snapshot")
+			}
+			if key != "" {
+				log.Ctx(ctx).Info().Str("key", key).Msg("snapshot taken")
+			}
+		}
+	}
+}
+
+// Snapshot archives the catalog of every tenant to the bucket, and deletes the snapshots beyond
+// the ones kept. It returns the key of the snapshot, even if deleting the old ones failed.
+//
+// The keyspace is scanned rather than frozen, so writes made meanwhile may be archived in part.
+func (b backups) Snapshot(ctx context.Context) (string, error) {
+	snapshot, err := b.dump(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(snapshot); err != nil {
+		return "", fmt.Errorf("encoding snapshot: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	key := b.cfg.Prefix + snapshotPrefix + snapshot.CreatedAt.Format(snapshotTimeFormat) + snapshotSuffix
+	_, err = b.objects.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &b.bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/gzip"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("storing snapshot: %w", err)
+	}
+
+	if err := b.prune(ctx); err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+// List returns the keys of the snapshots, oldest first.
+func (b backups) List(ctx context.Context) ([]string, error) {
+	var keys []string
+	pages := s3.NewListObjectsV2Paginator(b.objects, &s3.ListObjectsV2Input{Bucket: &b.bucket, Prefix: aws.String(b.cfg.Prefix + snapshotPrefix)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing snapshots: %w", err)
+		}
+		for _, o := range page.Contents {
+			if o.Key != nil && strings.HasSuffix(*o.Key, snapshotSuffix) {
+				keys = append(keys, *o.Key)
+			}
+		}
+	}
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+// prune deletes the oldest snapshots beyond the ones kept.
+func (b backups) prune(ctx context.Context) error {
+	if b.cfg.Keep <= 0 {
+		return nil
+	}
+	keys, err := b.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys[:max(len(keys)-b.cfg.Keep, 0)] {
+		if _, err := b.objects.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &b.bucket, Key: &key}); err != nil {
+			return fmt.Errorf("deleting snapshot %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+type RestoreOptions struct {
+	// Tenant restores a single tenant of the snapshot rather than all of them.
+	Tenant string
+	// Replace deletes the keys of the tenants restored first, rather than failing if there are any.
+	Replace bool
+}
+
+// Restore rebuilds the catalog of the tenants of the given snapshot, the latest one if the key is
+// empty. It returns the tenants restored, in order, and stops at the first one failing.
+func (b backups) Restore(ctx context.Context, key string, opts RestoreOptions) ([]string, error) {
+	if key == "" {
+		keys, err := b.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			return nil, ErrSnapshotNotFound
+		}
+		key = keys[len(keys)-1]
+	}
+
+	snapshot, err := b.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := sortedKeys(snapshot.Tenants)
+	if opts.Tenant != "" {
+		if snapshot.Tenants[opts.Tenant] == nil {
+			return nil, fmt.Errorf("%w in snapshot: %s", ErrTenantNotFound, opts.Tenant)
+		}
+		ids = []string{opts.Tenant}
+	}
+
+	var restored []string
+	for _, id := range ids {
+		if err := b.restoreTenant(ctx, b.ns.keyspace(id), snapshot.Tenants[id], opts.Replace); err != nil {
+			return restored, fmt.Errorf("restoring tenant %s: %w", id, err)
+		}
+		restored = append(restored, id)
+	}
+
+	return restored, nil
+}
+
+// load reads the snapshot stored under the given key.
+func (b backups) load(ctx context.Context, key string) (*Snapshot, error) {
+	out, err := b.objects.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.bucket, Key: &key})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting snapshot: %w", err)
+	}
+	defer out.Body.Close()
+
+	zr, err := gzip.NewReader(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	var snapshot Snapshot
+	if err := json.NewDecoder(zr).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("decoding snapshot: unsupported version %d", snapshot.Version)
+	}
+
+	return &snapshot, nil
+}
+
+// dump reads the catalog of every tenant, along with its pending uploads, so that the janitor still
+// deletes the media restored whose upload never completed. Idempotency keys and the media queued
+// for a metadata write are left out, as are the media records and collections found without a
+// record by the time they are read.
+func (b backups) dump(ctx context.Context) (*Snapshot, error) {
+	snapshot := &Snapshot{Version: snapshotVersion, CreatedAt: b.now().UTC().Truncate(time.Second), Tenants: map[string]*TenantSnapshot{}}
+	tenantOf := func(key string) (*TenantSnapshot, string) {
+		id, rest, _ := b.ns.splitKey(key)
+		ts := snapshot.Tenants[id]
+		if ts == nil {
+			ts = &TenantSnapshot{
+				Tags:        []Tag{},
+				Media:       map[string]SnapshotMedia{},
+				Index:       map[Tag][]string{},
+				Related:     map[Tag]map[Tag]float64{},
+				Collections: map[string]SnapshotCollection{},
+				Uploads:     map[string]float64{},
+			}
+			snapshot.Tenants[id] = ts
+		}
+		return ts, rest
+	}
+
+	var tags, index, related, media, collections, items, uploads []string
+	err := scanKeys(ctx, b.rueidisClient, b.ns.tenantPattern(), func(keys []string) error {
+		for _, key := range keys {
+			_, rest, ok := b.ns.splitKey(key)
+			if !ok {
+				continue
+			}
+			switch {
+			case rest == tagsKey:
+				tags = append(tags, key)
+			case rest == pendingUploadsKey:
+				uploads = append(uploads, key)
+			case strings.HasPrefix(rest, tagsPrefix):
+				index = append(index, key)
+			case strings.HasPrefix(rest, relatedPrefix):
+				related = append(related, key)
+			case strings.HasPrefix(rest, mediaPrefix):
+				media = append(media, key)
+			case strings.HasPrefix(rest, collectionPrefix) && strings.HasSuffix(rest, itemsSuffix):
+				items = append(items, key)
+			case strings.HasPrefix(rest, collectionPrefix):
+				collections = append(collections, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := func(key string) rueidis.Completed {
+		return b.rueidisClient.B().Smembers().Key(key).Build()
+	}
+	hash := func(key string) rueidis.Completed {
+		return b.rueidisClient.B().Hgetall().Key(key).Build()
+	}
+	err = fetchKeys(ctx, b.rueidisClient, tags, members, func(key string, resp rueidis.RedisResult) error {
+		values, err := resp.AsStrSlice()
+		ts, _ := tenantOf(key)
+		ts.Tags = slices.Sorted(slices.Values(values))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting tags: %w", err)
+	}
+
+	err = fetchKeys(ctx, b.rueidisClient, index, members, func(key string, resp rueidis.RedisResult) error {
+		values, err := resp.AsStrSlice()
+		if err != nil || len(values) == 0 {
+			return err
+		}
+		ts, rest := tenantOf(key)
+		ts.Index[strings.TrimPrefix(rest, tagsPrefix)] = slices.Sorted(slices.Values(values))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting tag indexes: %w", err)
+	}
+
+	err = fetchKeys(ctx, b.rueidisClient, related, func(key string) rueidis.Completed {
+		return b.rueidisClient.B().Zrange().Key(key).Min("0").Max("-1").Withscores().Build()
+	}, func(key string, resp rueidis.RedisResult) error {
+		scores, err := resp.AsZScores()
+		if err != nil || len(scores) == 0 {
+			return err
+		}
+		ts, rest := tenantOf(key)
+		counts := make(map[Tag]float64, len(scores))
+		for _, s := range scores {
+			counts[s.Member] = s.Score
+		}
+		ts.Related[strings.TrimPrefix(rest, relatedPrefix)] = counts
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting related tags: %w", err)
+	}
+
+	err = fetchKeys(ctx, b.rueidisClient, media, hash, func(key string, resp rueidis.RedisResult) error {
+		record, err := resp.AsStrMap()
+		if err != nil || len(record) == 0 {
+			return err
+		}
+		tags, err := decodeTags(record[tagsField])
+		if err != nil {
+			return err
+		}
+		ts, rest := tenantOf(key)
+		ts.Media[strings.TrimPrefix(rest, mediaPrefix)] = SnapshotMedia{Name: record[nameField], Tags: tags}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting media records: %w", err)
+	}
+
+	err = fetchKeys(ctx, b.rueidisClient, collections, hash, func(key string, resp rueidis.RedisResult) error {
+		record, err := resp.AsStrMap()
+		if err != nil || len(record) == 0 {
+			return err
+		}
+		ts, rest := tenantOf(key)
+		ts.Collections[strings.TrimPrefix(rest, collectionPrefix)] = SnapshotCollection{Name: record[nameField], Cover: record[coverField], Items: []string{}}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting collections: %w", err)
+	}
+
+	err = fetchKeys(ctx, b.rueidisClient, items, func(key string) rueidis.Completed {
+		return b.rueidisClient.B().Lrange().Key(key).Start(0).Stop(-1).Build()
+	}, func(key string, resp rueidis.RedisResult) error {
+		values, err := resp.AsStrSlice()
+		if err != nil {
+			return err
+		}
+		ts, rest := tenantOf(key)
+		collection := strings.TrimSuffix(strings.TrimPrefix(rest, collectionPrefix), itemsSuffix)
+		if c, ok := ts.Collections[collection]; ok {
+			c.Items = values
+			ts.Collections[collection] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting collection items: %w", err)
+	}
+
+	err = fetchKeys(ctx, b.rueidisClient, uploads, func(key string) rueidis.Completed {
+		return b.rueidisClient.B().Zrange().Key(key).Min("0").Max("-1").Withscores().Build()
+	}, func(key string, resp rueidis.RedisResult) error {
+		scores, err := resp.AsZScores()
+		if err != nil {
+			return err
+		}
+		ts, _ := tenantOf(key)
+		for _, s := range scores {
+			ts.Uploads[s.Member] = s.Score
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting pending uploads: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// restoreTenant writes the catalog of a tenant, once its keys are deleted if replace is set. The
+// tenant is either restored whole or left alone.
+func (b backups) restoreTenant(ctx context.Context, ks keyspace, ts *TenantSnapshot, replace bool) error {
+	var existing []string
+	err := scanKeys(ctx, b.rueidisClient, escapePattern(ks.prefix())+"*", func(keys []string) error {
+		existing = append(existing, keys...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 && !replace {
+		return ErrTenantNotEmpty
+	}
+
+	cmds := make(rueidis.Commands, 0, len(existing)+len(ts.Media)+len(ts.Index)+len(ts.Related)+2*len(ts.Collections)+3)
+	for _, key := range existing {
+		cmds = append(cmds, b.rueidisClient.B().Del().Key(key).Build())
+	}
+	if len(ts.Tags) > 0 {
+		cmds = append(cmds, b.rueidisClient.B().Sadd().Key(ks.tags()).Member(ts.Tags...).Build())
+	}
+	for _, key := range sortedKeys(ts.Media) {
+		m := ts.Media[key]
+		cmds = append(cmds, b.rueidisClient.B().Hset().Key(ks.media(key)).FieldValue().
+			FieldValue(nameField, m.Name).FieldValue(tagsField, encodeTags(m.Tags)).Build())
+	}
+	for _, tag := range sortedKeys(ts.Index) {
+		if keys := ts.Index[tag]; len(keys) > 0 {
+			cmds = append(cmds, b.rueidisClient.B().Sadd().Key(ks.tag(tag)).Member(keys...).Build())
+		}
+	}
+	for _, tag := range sortedKeys(ts.Related) {
+		if len(ts.Related[tag]) == 0 {
+			continue
+		}
+		zadd := b.rueidisClient.B().Zadd().Key(ks.related(tag)).ScoreMember()
+		for _, other := range sortedKeys(ts.Related[tag]) {
+			zadd = zadd.ScoreMember(ts.Related[tag][other], other)
+		}
+		cmds = append(cmds, zadd.Build())
+	}
+	if len(ts.Collections) > 0 {
+		cmds = append(cmds, b.rueidisClient.B().Sadd().Key(ks.collections()).Member(sortedKeys(ts.Collections)...).Build())
+	}
+	for _, key := range sortedKeys(ts.Collections) {
+		c := ts.Collections[key]
+		hset := b.rueidisClient.B().Hset().Key(ks.collection(key)).FieldValue().FieldValue(nameField, c.Name)
+		if c.Cover != "" {
+			hset = hset.FieldValue(coverField, c.Cover)
+		}
+		cmds = append(cmds, hset.Build())
+		if len(c.Items) > 0 {
+			cmds = append(cmds, b.rueidisClient.B().Rpush().Key(ks.collectionItems(key)).Element(c.Items...).Build())
+		}
+	}
+	if len(ts.Uploads) > 0 {
+		zadd := b.rueidisClient.B().Zadd().Key(ks.pendingUploads()).ScoreMember()
+		for _, object := range sortedKeys(ts.Uploads) {
+			zadd = zadd.ScoreMember(ts.Uploads[object], object)
+		}
+		cmds = append(cmds, zadd.Build())
+	}
+
+	// The keys of a tenant share a slot, so the catalog is written in a single transaction, which
+	// leaves the tenant as it was on failure. It is dropped if the keys deleted change meanwhile.
+	return b.rueidisClient.Dedicated(func(c rueidis.DedicatedClient) error {
+		if len(existing) > 0 {
+			if err := c.Do(ctx, c.B().Watch().Key(existing...).Build()).Error(); err != nil {
+				return fmt.Errorf("watching tenant keys: %w", unavailable(err))
+			}
+		}
+		resps := c.DoMulti(ctx, slices.Concat(rueidis.Commands{c.B().Multi().Build()}, cmds, rueidis.Commands{c.B().Exec().Build()})...)
+		replies, err := resps[len(resps)-1].ToArray()
+		if rueidis.IsRedisNil(err) {
+			return fmt.Errorf("%w: tenant changed during the restore", ErrConflict)
+		}
+		if err != nil {
+			return fmt.Errorf("writing catalog: %w", unavailable(err))
+		}
+		for i, reply := range replies {
+			if err := reply.Error(); err != nil {
+				return fmt.Errorf("writing catalog, command %d: %w", i, unavailable(err))
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/redis/rueidis"
+	rmock "github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func (m *mockObjectStore) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*s3.PutObjectOutput), args.Error(1)
+}
+
+func (m *mockObjectStore) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
+}
+
+// gzipJSON encodes the given value as a snapshot is stored.
+func gzipJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	require.NoError(t, json.NewEncoder(zw).Encode(v))
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func listedSnapshots(keys ...string) *s3.ListObjectsV2Output {
+	out := &s3.ListObjectsV2Output{}
+	for _, key := range keys {
+		out.Contents = append(out.Contents, types.Object{Key: pT(key)})
+	}
+	return out
+}
+
+func TestNewBackups(t *testing.T) {
+	t.Run("it fails on a prefix overlapping a tenant", func(t *testing.T) {
+		_, err := NewBackups(nil, Namespace{}, nil, "bucket", BackupConfig{Prefix: "backups/"})
+		require.EqualError(t, err, `backup prefix "backups/" overlaps the objects of tenant "backups"`)
+	})
+
+	t.Run("it accepts prefixes out of the tenant directories", func(t *testing.T) {
+		for _, prefix := range []string{"", ".backups/", "backup-"} {
+			_, err := NewBackups(nil, Namespace{}, nil, "bucket", BackupConfig{Prefix: prefix})
+			require.NoError(t, err, prefix)
+		}
+	})
+}
+
+func TestBackups_Snapshot(t *testing.T) {
+	ctx, _ := tenantContext()
+	now := time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC)
+	cfg := BackupConfig{Prefix: ".backups/", Keep: 2}
+	key := ".backups/snapshot-20240501T123015Z.json.gz"
+	listInput := &s3.ListObjectsV2Input{Bucket: pT("bucket"), Prefix: pT(".backups/snapshot-")}
+
+	for _, ns := range []Namespace{{}, {Prefix: "app:", HashTags: true}} {
+		t.Run(fmt.Sprintf("it archives the catalog and restores it in namespace %+v", ns), func(t *testing.T) {
+			ks := ns.keyspace("club")
+			s, rc := newMiniredisClient(t)
+			ms := NewMediaService(rc, nil, ns, nil, parseURL(t, ""), "bucket", TagRules{}, false)
+			seedMedia(t, ctx, ms, ks, "a", "t1", "t,2")
+			seedMedia(t, ctx, ms, ks, "b", "t1")
+			s.HSet(ks.collection("col"), nameField, "Gallery", coverField, "a")
+			_, err := s.Push(ks.collectionItems("col"), "b", "a")
+			require.NoError(t, err)
+			_, err = s.SAdd(ks.collections(), "col")
+			require.NoError(t, err)
+			require.NoError(t, s.Set(ks.idempotency(mediaKey, "k"), "x"))
+
+			var stored []byte
+			m := &mock.Mock{}
+			m.On("PutObject", ctx, mock.MatchedBy(func(in *s3.PutObjectInput) bool {
+				return *in.Bucket == "bucket" && *in.Key == key && *in.ContentType == "application/gzip"
+			})).Run(func(args mock.Arguments) {
+				stored, _ = io.ReadAll(args.Get(1).(*s3.PutObjectInput).Body)
+			}).Return(&s3.PutObjectOutput{}, nil).Once().
+				On("ListObjectsV2", ctx, listInput).Return(listedSnapshots(
+				".backups/snapshot-20240301T000000Z.json.gz", key, ".backups/snapshot-20240401T000000Z.json.gz", ".backups/snapshot-notes.txt",
+			), nil).Once().
+				On("DeleteObject", ctx, &s3.DeleteObjectInput{Bucket: pT("bucket"), Key: pT(".backups/snapshot-20240301T000000Z.json.gz")}).
+				Return(&s3.DeleteObjectOutput{}, nil).Once()
+
+			b, err := NewBackups(rc, ns, &mockObjectStore{m: m}, "bucket", cfg)
+			require.NoError(t, err)
+			b.now = func() time.Time { return now }
+			got, err := b.Snapshot(ctx)
+
+			require.NoError(t, err)
+			require.Equal(t, key, got)
+			zr, err := gzip.NewReader(bytes.NewReader(stored))
+			require.NoError(t, err)
+			var snapshot Snapshot
+			require.NoError(t, json.NewDecoder(zr).Decode(&snapshot))
+			createdA, err := s.ZScore(ks.pendingUploads(), ks.object("a"))
+			require.NoError(t, err)
+			createdB, err := s.ZScore(ks.pendingUploads(), ks.object("b"))
+			require.NoError(t, err)
+			require.Equal(t, Snapshot{Version: 1, CreatedAt: now, Tenants: map[string]*TenantSnapshot{"club": {
+				Tags:        []Tag{"t,2", "t1"},
+				Media:       map[string]SnapshotMedia{"a": {Name: "a", Tags: []Tag{"t1", "t,2"}}, "b": {Name: "b", Tags: []Tag{"t1"}}},
+				Index:       map[Tag][]string{"t1": {"a", "b"}, "t,2": {"a"}},
+				Related:     map[Tag]map[Tag]float64{"t1": {"t,2": 1}, "t,2": {"t1": 1}},
+				Collections: map[string]SnapshotCollection{"col": {Name: "Gallery", Cover: "a", Items: []string{"b", "a"}}},
+				Uploads:     map[string]float64{ks.object("a"): createdA, ks.object("b"): createdB},
+			}}}, snapshot)
+			m.AssertExpectations(t)
+
+			dumped := s.Dump()
+			s.FlushAll()
+			require.NoError(t, s.Set(ks.media("stale"), "x"))
+			for range 2 {
+				m.On("GetObject", ctx, &s3.GetObjectInput{Bucket: pT("bucket"), Key: pT(key)}).
+					Return(&s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(stored))}, nil).Once()
+			}
+
+			_, err = b.Restore(ctx, key, RestoreOptions{})
+			require.ErrorIs(t, err, ErrTenantNotEmpty)
+			require.EqualError(t, err, "restoring tenant club: conflict: tenant is not empty")
+
+			restored, err := b.Restore(ctx, key, RestoreOptions{Tenant: "club", Replace: true})
+
+			require.NoError(t, err)
+			require.Equal(t, []string{"club"}, restored)
+			require.NoError(t, s.Set(ks.idempotency(mediaKey, "k"), "x"))
+			require.Equal(t, dumped, s.Dump())
+			m.AssertExpectations(t)
+		})
+	}
+
+	t.Run("it fails if storing the snapshot fails", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		m := &mock.Mock{}
+		m.On("PutObject", ctx, mock.Anything).Return((*s3.PutObjectOutput)(nil), assert.AnError).Once()
+
+		b, err := NewBackups(rc, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		require.NoError(t, err)
+		got, err := b.Snapshot(ctx)
+
+		require.Empty(t, got)
+		require.EqualError(t, err, "storing snapshot: "+assert.AnError.Error())
+	})
+
+	t.Run("it returns the snapshot if pruning fails", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		m := &mock.Mock{}
+		m.On("PutObject", ctx, mock.Anything).Return(&s3.PutObjectOutput{}, nil).Once().
+			On("ListObjectsV2", ctx, listInput).Return((*s3.ListObjectsV2Output)(nil), assert.AnError).Once()
+
+		b, err := NewBackups(rc, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		require.NoError(t, err)
+		b.now = func() time.Time { return now }
+		got, err := b.Snapshot(ctx)
+
+		require.Equal(t, key, got)
+		require.EqualError(t, err, "listing snapshots: "+assert.AnError.Error())
+	})
+
+	t.Run("it fails if scanning fails", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.SetError("boom")
+
+		b, err := NewBackups(rc, Namespace{}, nil, "bucket", cfg)
+		require.NoError(t, err)
+		_, err = b.Snapshot(ctx)
+
+		require.EqualError(t, err, "scanning keys: boom")
+	})
+}
+
+func TestBackups_Restore(t *testing.T) {
+	ctx := context.Background()
+	cfg := BackupConfig{Prefix: ".backups/"}
+	listInput := &s3.ListObjectsV2Input{Bucket: pT("bucket"), Prefix: pT(".backups/snapshot-")}
+
+	t.Run("it restores the latest snapshot", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		latest := ".backups/snapshot-20240401T000000Z.json.gz"
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, listInput).Return(listedSnapshots(latest, ".backups/snapshot-20240301T000000Z.json.gz"), nil).Once().
+			On("GetObject", ctx, &s3.GetObjectInput{Bucket: pT("bucket"), Key: pT(latest)}).
+			Return(&s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(gzipJSON(t, Snapshot{Version: 1, Tenants: map[string]*TenantSnapshot{
+				"b": {Tags: []Tag{"t1"}},
+				"a": {Media: map[string]SnapshotMedia{"key1": {Name: "x", Tags: []Tag{}}}},
+			}})))}, nil).Once()
+
+		b, err := NewBackups(rc, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		require.NoError(t, err)
+		restored, err := b.Restore(ctx, "", RestoreOptions{})
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, restored)
+		require.Equal(t, "x", s.HGet("tenant:a:media:key1", nameField))
+		require.Equal(t, "[]", s.HGet("tenant:a:media:key1", tagsField))
+		ok, err := s.SIsMember("tenant:b:tags", "t1")
+		require.NoError(t, err)
+		require.True(t, ok)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it fails without snapshot", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, listInput).Return(listedSnapshots(), nil).Once()
+
+		b, err := NewBackups(nil, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		require.NoError(t, err)
+		_, err = b.Restore(ctx, "", RestoreOptions{})
+
+		require.ErrorIs(t, err, ErrSnapshotNotFound)
+	})
+
+	t.Run("it fails on an unknown snapshot", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("GetObject", ctx, &s3.GetObjectInput{Bucket: pT("bucket"), Key: pT("nope")}).
+			Return((*s3.GetObjectOutput)(nil), &types.NoSuchKey{}).Once()
+
+		b, err := NewBackups(nil, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		require.NoError(t, err)
+		_, err = b.Restore(ctx, "nope", RestoreOptions{})
+
+		require.ErrorIs(t, err, ErrSnapshotNotFound)
+		require.EqualError(t, err, "snapshot not found: nope")
+	})
+
+	t.Run("it fails on an unsupported version", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("GetObject", ctx, &s3.GetObjectInput{Bucket: pT("bucket"), Key: pT("key")}).
+			Return(&s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(gzipJSON(t, Snapshot{Version: 9})))}, nil).Once()
+
+		b, err := NewBackups(nil, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		require.NoError(t, err)
+		_, err = b.Restore(ctx, "key", RestoreOptions{})
+
+		require.EqualError(t, err, "decoding snapshot: unsupported version 9")
+	})
+
+	t.Run("it fails on a tenant missing from the snapshot", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("GetObject", ctx, &s3.GetObjectInput{Bucket: pT("bucket"), Key: pT("key")}).
+			Return(&s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(gzipJSON(t, Snapshot{Version: 1})))}, nil).Once()
+
+		b, err := NewBackups(nil, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		require.NoError(t, err)
+		_, err = b.Restore(ctx, "key", RestoreOptions{Tenant: "club"})
+
+		require.ErrorIs(t, err, ErrTenantNotFound)
+		require.EqualError(t, err, "tenant not found in snapshot: club")
+	})
+}
+
+func TestBackups_restoreTenant(t *testing.T) {
+	ctx := context.Background()
+	ks := keyspace{tenant: "club"}
+	ts := &TenantSnapshot{Tags: []Tag{"t1"}}
+
+	// setup expects a restore replacing the tags of the tenant, up to the transaction.
+	setup := func(t *testing.T) (*backups, *rmock.DedicatedClient) {
+		t.Helper()
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		dc := rmock.NewDedicatedClient(ctrl)
+		rc.EXPECT().Nodes().Return(map[string]rueidis.Client{"node": rc})
+		rc.EXPECT().Do(ctx, rmock.Match("SCAN", "0", "MATCH", "tenant:club:*", "COUNT", "1000")).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("0"), rmock.RedisArray(rmock.RedisString(ks.tags())))))
+		rc.EXPECT().Dedicated(gomock.Any()).DoAndReturn(func(fn func(rueidis.DedicatedClient) error) error { return fn(dc) })
+		dc.EXPECT().Do(ctx, rmock.Match("WATCH", ks.tags())).Return(rmock.Result(rmock.RedisString("OK")))
+		b, err := NewBackups(rc, Namespace{}, nil, "bucket", BackupConfig{})
+		require.NoError(t, err)
+		return b, dc
+	}
+	transaction := []any{rmock.Match("MULTI"), rmock.Match("DEL", ks.tags()), rmock.Match("SADD", ks.tags(), "t1"), rmock.Match("EXEC")}
+	queued := []rueidis.RedisResult{
+		rmock.Result(rmock.RedisString("OK")), rmock.Result(rmock.RedisString("QUEUED")), rmock.Result(rmock.RedisString("QUEUED")),
+	}
+
+	t.Run("it restores the pending uploads in the transaction", func(t *testing.T) {
+		b, dc := setup(t)
+		dc.EXPECT().DoMulti(ctx,
+			rmock.Match("MULTI"), rmock.Match("DEL", ks.tags()), rmock.Match("SADD", ks.tags(), "t1"),
+			rmock.Match("ZADD", ks.pendingUploads(), "1000", ks.object("a")), rmock.Match("EXEC"),
+		).Return(append(queued, rmock.Result(rmock.RedisString("QUEUED")), rmock.Result(rmock.RedisArray(
+			rmock.RedisInt64(1), rmock.RedisInt64(1), rmock.RedisInt64(1),
+		))))
+
+		err := b.restoreTenant(ctx, ks, &TenantSnapshot{Tags: []Tag{"t1"}, Uploads: map[string]float64{ks.object("a"): 1000}}, true)
+
+		require.NoError(t, err)
+	})
+
+	t.Run("it fails if the transaction fails", func(t *testing.T) {
+		b, dc := setup(t)
+		dc.EXPECT().DoMulti(ctx, transaction...).Return(append(queued, rmock.ErrorResult(assert.AnError)))
+
+		err := b.restoreTenant(ctx, ks, ts, true)
+
+		require.EqualError(t, err, "writing catalog: "+assert.AnError.Error())
+	})
+
+	t.Run("it fails if the tenant changes during the restore", func(t *testing.T) {
+		b, dc := setup(t)
+		dc.EXPECT().DoMulti(ctx, transaction...).Return(append(queued, rmock.Result(rmock.RedisNil())))
+
+		err := b.restoreTenant(ctx, ks, ts, true)
+
+		require.ErrorIs(t, err, ErrConflict)
+		require.EqualError(t, err, "conflict: tenant changed during the restore")
+	})
+}
+
+func TestBackups_Run(t *testing.T) {
+	t.Run("it stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		b, err := NewBackups(nil, Namespace{}, nil, "bucket", BackupConfig{Interval: time.Minute})
+		require.NoError(t, err)
+
+		require.NoError(t, b.Run(ctx))
+	})
+}
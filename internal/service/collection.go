@@ -0,0 +1,309 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+)
+
+const (
+	collectionsKey   = "collections"
+	collectionPrefix = "collection:"
+	itemsSuffix      = ":items"
+	coverField       = "cover"
+)
+
+var (
+	ErrCollectionNotFound = fmt.Errorf("collection %w", ErrNotFound)
+	ErrUnknownMedia       = errors.New("unknown media")
+)
+
+type collectionService struct {
+	generateUUID  func() (uuid.UUID, error)
+	media         *mediaService
+	rueidisClient rueidis.Client
+}
+
+func NewCollectionService(media *mediaService) *collectionService {
+	return &collectionService{
+		generateUUID:  uuid.NewV7,
+		media:         media,
+		rueidisClient: media.rueidisClient,
+	}
+}
+
+type Collection struct {
+	Key      string
+	Name     string
+	Cover    string
+	CoverURL url.URL
+}
+
+type CreateCollectionParams struct {
+	Name  string
+	Cover string
+}
+
+func (s collectionService) CreateCollection(ctx context.Context, params CreateCollectionParams) (*Collection, error) {
+	ks, err := s.media.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkMedia(ctx, ks, params.Cover); err != nil {
+		return nil, err
+	}
+
+	key, err := s.generateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("generating UUID: %w", err)
+	}
+	keyStr := key.String()
+
+	record := map[string]string{nameField: params.Name}
+	hset := s.rueidisClient.B().Hset().Key(ks.collection(keyStr)).FieldValue().FieldValue(nameField, params.Name)
+	if params.Cover != "" {
+		record[coverField] = params.Cover
+		hset = hset.FieldValue(coverField, params.Cover)
+	}
+	for i, resp := range s.rueidisClient.DoMulti(ctx,
+		hset.Build(),
+		s.rueidisClient.B().Sadd().Key(ks.collections()).Member(keyStr).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return nil, fmt.Errorf("executing command %d: %w", i, unavailable(err))
+		}
+	}
+
+	collection := s.toCollection(ks, keyStr, record)
+	return &collection, nil
+}
+
+func (s collectionService) ListCollections(ctx context.Context) ([]Collection, error) {
+	ks, err := s.media.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Smembers().Key(ks.collections()).Build()).AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("getting collection keys from redis: %w", unavailable(err))
+	}
+	// UUIDv7 keys sort in creation order.
+	slices.Sort(keys)
+
+	cmds := make(rueidis.Commands, len(keys))
+	for i, key := range keys {
+		cmds[i] = s.rueidisClient.B().Hgetall().Key(ks.collection(key)).Build()
+	}
+	collections := make([]Collection, len(keys))
+	for i, resp := range s.rueidisClient.DoMulti(ctx, cmds...) {
+		record, err := resp.AsStrMap()
+		if err != nil {
+			return nil, fmt.Errorf("getting collection record %d: %w", i, unavailable(err))
+		}
+		collections[i] = s.toCollection(ks, keys[i], record)
+	}
+
+	return collections, nil
+}
+
+func (s collectionService) GetCollection(ctx context.Context, key string) (*Collection, error) {
+	ks, err := s.media.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Hgetall().Key(ks.collection(key)).Build()).AsStrMap()
+	if err != nil {
+		return nil, fmt.Errorf("getting collection record: %w", unavailable(err))
+	}
+	if len(record) == 0 {
+		return nil, ErrCollectionNotFound
+	}
+
+	collection := s.toCollection(ks, key, record)
+	return &collection, nil
+}
+
+type UpdateCollectionParams struct {
+	Key   string
+	Name  *string
+	Cover *string
+}
+
+// UpdateCollection changes the given fields of a collection. An empty cover removes it.
+func (s collectionService) UpdateCollection(ctx context.Context, params UpdateCollectionParams) (*Collection, error) {
+	ks, err := s.media.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	if params.Name != nil {
+		args = append(args, nameField, *params.Name)
+	}
+	if params.Cover != nil {
+		if err := s.checkMedia(ctx, ks, *params.Cover); err != nil {
+			return nil, err
+		}
+		args = append(args, coverField, *params.Cover)
+	}
+
+	if len(args) > 0 {
+		found, err := updateCollectionScript.Exec(ctx, s.rueidisClient, []string{ks.collection(params.Key)}, args).AsBool()
+		if err != nil {
+			return nil, fmt.Errorf("updating collection: %w", unavailable(err))
+		}
+		if !found {
+			return nil, ErrCollectionNotFound
+		}
+	}
+
+	return s.GetCollection(ctx, params.Key)
+}
+
+func (s collectionService) DeleteCollection(ctx context.Context, key string) error {
+	ks, err := s.media.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return err
+	}
+
+	resps := s.rueidisClient.DoMulti(ctx,
+		s.rueidisClient.B().Del().Key(ks.collection(key), ks.collectionItems(key)).Build(),
+		s.rueidisClient.B().Srem().Key(ks.collections()).Member(key).Build(),
+	)
+	for i, resp := range resps {
+		if err := resp.Error(); err != nil {
+			return fmt.Errorf("executing command %d: %w", i, unavailable(err))
+		}
+	}
+	if deleted, _ := resps[0].AsInt64(); deleted == 0 {
+		return ErrCollectionNotFound
+	}
+
+	return nil
+}
+
+func (s collectionService) ListCollectionItems(ctx context.Context, key string) ([]MediaRecord, error) {
+	ks, err := s.media.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resps := s.rueidisClient.DoMulti(ctx,
+		s.rueidisClient.B().Exists().Key(ks.collection(key)).Build(),
+		s.rueidisClient.B().Lrange().Key(ks.collectionItems(key)).Start(0).Stop(-1).Build(),
+	)
+	exists, err := resps[0].AsBool()
+	if err != nil {
+		return nil, fmt.Errorf("checking collection: %w", unavailable(err))
+	}
+	if !exists {
+		return nil, ErrCollectionNotFound
+	}
+	keys, err := resps[1].AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("getting collection items from redis: %w", unavailable(err))
+	}
+
+	media, _, err := s.media.getMedia(ctx, s.media.rueidisClient, ks, keys)
+	return media, err
+}
+
+type AddCollectionItemsParams struct {
+	Key       string
+	MediaKeys []string
+	// Position of the first inserted media; nil appends them.
+	Position *int
+}
+
+// AddCollectionItems inserts media into a collection, moving the ones it already contains.
+func (s collectionService) AddCollectionItems(ctx context.Context, params AddCollectionItemsParams) error {
+	position := -1
+	if params.Position != nil {
+		position = *params.Position
+	}
+
+	return s.updateItems(ctx, params.Key, "insert", position, params.MediaKeys)
+}
+
+type ReplaceCollectionItemsParams struct {
+	Key       string
+	MediaKeys []string
+}
+
+// ReplaceCollectionItems replaces the items of a collection, which is how they get reordered.
+func (s collectionService) ReplaceCollectionItems(ctx context.Context, params ReplaceCollectionItemsParams) error {
+	return s.updateItems(ctx, params.Key, "replace", -1, params.MediaKeys)
+}
+
+type RemoveCollectionItemParams struct {
+	Key      string
+	MediaKey string
+}
+
+func (s collectionService) RemoveCollectionItem(ctx context.Context, params RemoveCollectionItemParams) error {
+	return s.updateItems(ctx, params.Key, "remove", -1, []string{params.MediaKey})
+}
+
+func (s collectionService) updateItems(ctx context.Context, key, op string, position int, mediaKeys []string) error {
+	ks, err := s.media.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return err
+	}
+
+	msg, err := updateCollectionItemsScript.Exec(ctx, s.rueidisClient,
+		ks.appendMediaKeys([]string{ks.collection(key), ks.collectionItems(key)}, mediaKeys),
+		append([]string{op, strconv.Itoa(position)}, mediaKeys...),
+	).ToMessage()
+	if err != nil {
+		return fmt.Errorf("updating collection items: %w", unavailable(err))
+	}
+
+	if msg.IsArray() {
+		unknown, err := msg.AsStrSlice()
+		if err != nil {
+			return fmt.Errorf("decoding unknown media: %w", err)
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownMedia, strings.Join(unknown, ", "))
+	}
+	if found, _ := msg.AsBool(); !found {
+		return ErrCollectionNotFound
+	}
+
+	return nil
+}
+
+// checkMedia makes sure the given media exists, if one is given.
+func (s collectionService) checkMedia(ctx context.Context, ks keyspace, key string) error {
+	if key == "" {
+		return nil
+	}
+
+	exists, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Exists().Key(ks.media(key)).Build()).AsBool()
+	if err != nil {
+		return fmt.Errorf("checking media: %w", unavailable(err))
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownMedia, key)
+	}
+
+	return nil
+}
+
+func (s collectionService) toCollection(ks keyspace, key string, record map[string]string) Collection {
+	collection := Collection{Key: key, Name: record[nameField], Cover: record[coverField]}
+	if collection.Cover != "" {
+		collection.CoverURL = s.media.mediaURL(ks, collection.Cover)
+	}
+
+	return collection
+}
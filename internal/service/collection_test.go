@@ -0,0 +1,435 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+	rmock "github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func scriptSHA(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestNewCollectionService(t *testing.T) {
+	rc := rmock.NewClient(nil)
+	ms := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "bucket", TagRules{}, false)
+
+	s := NewCollectionService(ms)
+
+	require.NotNil(t, s)
+	require.NotNil(t, s.generateUUID)
+	require.Equal(t, ms, s.media)
+	require.Equal(t, rc, s.rueidisClient)
+}
+
+func TestCollectionService_CreateCollection(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it fails if the cover is unknown", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EXISTS", ks.media("media"))).Return(rmock.Result(rmock.RedisInt64(0)))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.CreateCollection(ctx, CreateCollectionParams{Name: "name", Cover: "media"})
+
+		require.ErrorIs(t, err, ErrUnknownMedia)
+		require.EqualError(t, err, "unknown media: media")
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		id, err := uuid.NewV7()
+		require.NoError(t, err)
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id, nil).Once()
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("HSET", ks.collection(id.String()), nameField, "name"),
+			rmock.Match("SADD", ks.collections(), id.String()),
+		).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(1)), rmock.ErrorResult(assert.AnError)})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		s.generateUUID = mockUUID(m)
+		_, err = s.CreateCollection(ctx, CreateCollectionParams{Name: "name"})
+
+		require.EqualError(t, err, "executing command 1: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		id, err := uuid.NewV7()
+		require.NoError(t, err)
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id, nil).Once()
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EXISTS", ks.media("media"))).Return(rmock.Result(rmock.RedisInt64(1)))
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("HSET", ks.collection(id.String()), nameField, "name", coverField, "media"),
+			rmock.Match("SADD", ks.collections(), id.String()),
+		).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(2)), rmock.Result(rmock.RedisInt64(1))})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "bucket", TagRules{}, false))
+		s.generateUUID = mockUUID(m)
+		collection, err := s.CreateCollection(ctx, CreateCollectionParams{Name: "name", Cover: "media"})
+
+		require.NoError(t, err)
+		require.Equal(t, &Collection{Key: id.String(), Name: "name", Cover: "media", CoverURL: parseURL(t, "http://test/bucket/club/media")}, collection)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestCollectionService_ListCollections(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.collections())).Return(rmock.ErrorResult(assert.AnError))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.ListCollections(ctx)
+
+		require.EqualError(t, err, "getting collection keys from redis: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.collections())).Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key2"), rmock.RedisString("key1"))))
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("HGETALL", ks.collection("key1")),
+			rmock.Match("HGETALL", ks.collection("key2")),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{nameField: rmock.RedisString("name1")})),
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{nameField: rmock.RedisString("name2"), coverField: rmock.RedisString("media")})),
+		})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "bucket", TagRules{}, false))
+		collections, err := s.ListCollections(ctx)
+
+		require.NoError(t, err)
+		require.Equal(t, []Collection{
+			{Key: "key1", Name: "name1"},
+			{Key: "key2", Name: "name2", Cover: "media", CoverURL: parseURL(t, "http://test/bucket/club/media")},
+		}, collections)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestCollectionService_GetCollection(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("HGETALL", ks.collection("key"))).Return(rmock.ErrorResult(assert.AnError))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.GetCollection(ctx, "key")
+
+		require.EqualError(t, err, "getting collection record: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the collection does not exist", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("HGETALL", ks.collection("key"))).Return(rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{})))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.GetCollection(ctx, "key")
+
+		require.ErrorIs(t, err, ErrCollectionNotFound)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("HGETALL", ks.collection("key"))).Return(rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{nameField: rmock.RedisString("name")})))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		collection, err := s.GetCollection(ctx, "key")
+
+		require.NoError(t, err)
+		require.Equal(t, &Collection{Key: "key", Name: "name"}, collection)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestCollectionService_UpdateCollection(t *testing.T) {
+	ctx, ks := tenantContext()
+	sha := scriptSHA(updateCollectionSource)
+
+	t.Run("it fails if the cover is unknown", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EXISTS", ks.media("media"))).Return(rmock.Result(rmock.RedisInt64(0)))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.UpdateCollection(ctx, UpdateCollectionParams{Key: "key", Cover: pT("media")})
+
+		require.ErrorIs(t, err, ErrUnknownMedia)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the collection does not exist", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "1", ks.collection("key"), nameField, "name")).Return(rmock.Result(rmock.RedisInt64(0)))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.UpdateCollection(ctx, UpdateCollectionParams{Key: "key", Name: pT("name")})
+
+		require.ErrorIs(t, err, ErrCollectionNotFound)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "1", ks.collection("key"), coverField, "")).Return(rmock.ErrorResult(assert.AnError))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.UpdateCollection(ctx, UpdateCollectionParams{Key: "key", Cover: pT("")})
+
+		require.EqualError(t, err, "updating collection: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EXISTS", ks.media("media"))).Return(rmock.Result(rmock.RedisInt64(1)))
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "1", ks.collection("key"), nameField, "name", coverField, "media")).Return(rmock.Result(rmock.RedisInt64(1)))
+		rc.EXPECT().Do(ctx, rmock.Match("HGETALL", ks.collection("key"))).
+			Return(rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{nameField: rmock.RedisString("name"), coverField: rmock.RedisString("media")})))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "bucket", TagRules{}, false))
+		collection, err := s.UpdateCollection(ctx, UpdateCollectionParams{Key: "key", Name: pT("name"), Cover: pT("media")})
+
+		require.NoError(t, err)
+		require.Equal(t, &Collection{Key: "key", Name: "name", Cover: "media", CoverURL: parseURL(t, "http://test/bucket/club/media")}, collection)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestCollectionService_DeleteCollection(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("DEL", ks.collection("key"), ks.collectionItems("key")),
+			rmock.Match("SREM", ks.collections(), "key"),
+		).Return([]rueidis.RedisResult{rmock.ErrorResult(assert.AnError), rmock.Result(rmock.RedisInt64(0))})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.DeleteCollection(ctx, "key")
+
+		require.EqualError(t, err, "executing command 0: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the collection does not exist", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("DEL", ks.collection("key"), ks.collectionItems("key")),
+			rmock.Match("SREM", ks.collections(), "key"),
+		).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(0)), rmock.Result(rmock.RedisInt64(0))})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.DeleteCollection(ctx, "key")
+
+		require.ErrorIs(t, err, ErrCollectionNotFound)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("DEL", ks.collection("key"), ks.collectionItems("key")),
+			rmock.Match("SREM", ks.collections(), "key"),
+		).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(2)), rmock.Result(rmock.RedisInt64(1))})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.DeleteCollection(ctx, "key")
+
+		require.NoError(t, err)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestCollectionService_ListCollectionItems(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it fails if the collection does not exist", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("EXISTS", ks.collection("key")),
+			rmock.Match("LRANGE", ks.collectionItems("key"), "0", "-1"),
+		).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(0)), rmock.Result(rmock.RedisArray())})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.ListCollectionItems(ctx, "key")
+
+		require.ErrorIs(t, err, ErrCollectionNotFound)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("EXISTS", ks.collection("key")),
+			rmock.Match("LRANGE", ks.collectionItems("key"), "0", "-1"),
+		).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(1)), rmock.ErrorResult(assert.AnError)})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		_, err := s.ListCollectionItems(ctx, "key")
+
+		require.EqualError(t, err, "getting collection items from redis: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("EXISTS", ks.collection("key")),
+			rmock.Match("LRANGE", ks.collectionItems("key"), "0", "-1"),
+		).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(1)), rmock.Result(rmock.RedisArray(rmock.RedisString("media2"), rmock.RedisString("media1")))})
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("HGETALL", ks.media("media2")),
+			rmock.Match("HGETALL", ks.media("media1")),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{nameField: rmock.RedisString("name2"), tagsField: rmock.RedisString("tag1")})),
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{nameField: rmock.RedisString("name1"), tagsField: rmock.RedisString("")})),
+		})
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "bucket", TagRules{}, false))
+		media, err := s.ListCollectionItems(ctx, "key")
+
+		require.NoError(t, err)
+		require.Equal(t, []MediaRecord{
+			{Key: "media2", Name: "name2", Tags: []string{"tag1"}, URL: parseURL(t, "http://test/bucket/club/media2")},
+			{Key: "media1", Name: "name1", Tags: []string{}, URL: parseURL(t, "http://test/bucket/club/media1")},
+		}, media)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestCollectionService_AddCollectionItems(t *testing.T) {
+	ctx, ks := tenantContext()
+	sha := scriptSHA(updateCollectionItemsSource)
+	keys := []string{ks.collection("key"), ks.collectionItems("key")}
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "3", keys[0], keys[1], ks.media("media1"), "insert", "-1", "media1")).Return(rmock.ErrorResult(assert.AnError))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.AddCollectionItems(ctx, AddCollectionItemsParams{Key: "key", MediaKeys: []string{"media1"}})
+
+		require.EqualError(t, err, "updating collection items: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the collection does not exist", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "3", keys[0], keys[1], ks.media("media1"), "insert", "-1", "media1")).Return(rmock.Result(rmock.RedisInt64(0)))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.AddCollectionItems(ctx, AddCollectionItemsParams{Key: "key", MediaKeys: []string{"media1"}})
+
+		require.ErrorIs(t, err, ErrCollectionNotFound)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if media are unknown", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "5", keys[0], keys[1], ks.media("media1"), ks.media("media2"), ks.media("media3"), "insert", "2", "media1", "media2", "media3")).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("media1"), rmock.RedisString("media3"))))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.AddCollectionItems(ctx, AddCollectionItemsParams{Key: "key", MediaKeys: []string{"media1", "media2", "media3"}, Position: pT(2)})
+
+		require.ErrorIs(t, err, ErrUnknownMedia)
+		require.EqualError(t, err, "unknown media: media1, media3")
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it loads the script if redis does not know it", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "3", keys[0], keys[1], ks.media("media1"), "insert", "0", "media1")).
+			Return(rmock.Result(rmock.RedisError("NOSCRIPT No matching script.")))
+		rc.EXPECT().Do(ctx, rmock.Match("EVAL", updateCollectionItemsSource, "3", keys[0], keys[1], ks.media("media1"), "insert", "0", "media1")).
+			Return(rmock.Result(rmock.RedisInt64(1)))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.AddCollectionItems(ctx, AddCollectionItemsParams{Key: "key", MediaKeys: []string{"media1"}, Position: pT(0)})
+
+		require.NoError(t, err)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestCollectionService_ReplaceCollectionItems(t *testing.T) {
+	ctx, ks := tenantContext()
+	sha := scriptSHA(updateCollectionItemsSource)
+
+	t.Run("happy path", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "4", ks.collection("key"), ks.collectionItems("key"), ks.media("media2"), ks.media("media1"), "replace", "-1", "media2", "media1")).
+			Return(rmock.Result(rmock.RedisInt64(1)))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.ReplaceCollectionItems(ctx, ReplaceCollectionItemsParams{Key: "key", MediaKeys: []string{"media2", "media1"}})
+
+		require.NoError(t, err)
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestCollectionService_RemoveCollectionItem(t *testing.T) {
+	ctx, ks := tenantContext()
+	sha := scriptSHA(updateCollectionItemsSource)
+
+	t.Run("happy path", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "3", ks.collection("key"), ks.collectionItems("key"), ks.media("media"), "remove", "-1", "media")).
+			Return(rmock.Result(rmock.RedisInt64(1)))
+
+		s := NewCollectionService(NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false))
+		err := s.RemoveCollectionItem(ctx, RemoveCollectionItemParams{Key: "key", MediaKey: "media"})
+
+		require.NoError(t, err)
+		require.True(t, ctrl.Satisfied())
+	})
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/rueidis"
+)
+
+// The categories of the errors returned by the services. Specific errors wrap one of them, so
+// callers can tell them apart with errors.Is without knowing every error of every service.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrInvalidTag  = errors.New("invalid tag")
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// unavailableError marks an error of a backend that could not be reached, keeping its message.
+type unavailableError struct {
+	err error
+}
+
+func (e unavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e unavailableError) Unwrap() []error {
+	return []error{ErrUnavailable, e.err}
+}
+
+// unavailable marks the given Redis error as ErrUnavailable, unless Redis did answer with an error
+// reply or a malformed one, which retrying will not fix, or the caller gave up on the request.
+func unavailable(err error) error {
+	var redisErr *rueidis.RedisError
+	if err == nil || errors.As(err, &redisErr) || rueidis.IsParseErr(err) || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return unavailableError{err: err}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/redis/rueidis"
+	rmock "github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestErrors(t *testing.T) {
+	require.ErrorIs(t, ErrCollectionNotFound, ErrNotFound)
+	require.ErrorIs(t, ErrAPIKeyNotFound, ErrNotFound)
+	require.ErrorIs(t, ErrMediaNotFound, ErrNotFound)
+	require.EqualError(t, ErrCollectionNotFound, "collection not found")
+}
+
+func TestUnavailable(t *testing.T) {
+	t.Run("it marks transport errors", func(t *testing.T) {
+		err := unavailable(assert.AnError)
+
+		require.ErrorIs(t, err, ErrUnavailable)
+		require.ErrorIs(t, err, assert.AnError)
+		require.EqualError(t, err, assert.AnError.Error())
+	})
+
+	t.Run("it keeps error replies", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(context.Background(), rmock.Match("GET", "key")).Return(rmock.Result(rmock.RedisError("WRONGTYPE")))
+
+		err := rc.Do(context.Background(), rc.B().Get().Key("key").Build()).Error()
+
+		require.NotErrorIs(t, unavailable(err), ErrUnavailable)
+	})
+
+	t.Run("it keeps canceled requests", func(t *testing.T) {
+		err := unavailable(fmt.Errorf("reading: %w", context.Canceled))
+
+		require.NotErrorIs(t, err, ErrUnavailable)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("it keeps nil replies", func(t *testing.T) {
+		require.NotErrorIs(t, unavailable(rueidis.Nil), ErrUnavailable)
+		require.NoError(t, unavailable(nil))
+	})
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The formats of an export.
+const (
+	ExportJSONLines = "jsonl"
+	ExportCSV       = "csv"
+)
+
+// exportedMedia is a media as written by an export. The creation time is left out of the media
+// whose key is not a UUIDv7.
+type exportedMedia struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Tags      []Tag      `json:"tags"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	URL       string     `json:"url"`
+}
+
+// ExportMedia writes every media of the tenant in the given format, in no particular order. The
+// media are written page by page as the keyspace is scanned, so media created or deleted
+// meanwhile may be left out, and nothing is written before the first page is fetched.
+func (s mediaService) ExportMedia(ctx context.Context, w io.Writer, format string) error {
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return err
+	}
+
+	var write func(m exportedMedia) error
+	var flush func() error
+	switch format {
+	case ExportJSONLines:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		write = func(m exportedMedia) error { return enc.Encode(m) }
+		flush = bw.Flush
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		write = func(m exportedMedia) error {
+			var createdAt string
+			if m.CreatedAt != nil {
+				createdAt = m.CreatedAt.Format(time.RFC3339Nano)
+			}
+			return cw.Write([]string{m.ID, csvText(m.Name), csvText(strings.Join(m.Tags, ",")), createdAt, m.URL})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		if err := cw.Write([]string{"id", "name", "tags", "createdAt", "url"}); err != nil {
+			return fmt.Errorf("writing media: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	prefix := ks.media("")
+	err = scanKeys(ctx, s.rueidisClient, escapePattern(prefix)+"*", func(keys []string) error {
+		for i, key := range keys {
+			keys[i] = strings.TrimPrefix(key, prefix)
+		}
+		// The media deleted since the scan are skipped.
+		media, _, err := s.getMedia(ctx, s.rueidisClient, ks, keys)
+		if err != nil {
+			return err
+		}
+		for _, m := range media {
+			exported := exportedMedia{ID: m.Key, Name: m.Name, Tags: m.Tags, URL: m.URL.String()}
+			if createdAt, ok := mediaCreatedAt(m.Key); ok {
+				createdAt = createdAt.UTC()
+				exported.CreatedAt = &createdAt
+			}
+			err := write(exported)
+			if err != nil {
+				return fmt.Errorf("writing media: %w", err)
+			}
+		}
+		if err := flush(); err != nil {
+			return fmt.Errorf("writing media: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
+		return fmt.Errorf("writing media: %w", err)
+	}
+
+	return nil
+}
+
+// csvText escapes a text written by users, which a spreadsheet would run as a formula if it
+// started with one of =, +, -, @, a tab or a carriage return, by prefixing it with a quote.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+
+	return text
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/tenant"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, assert.AnError
+}
+
+// clusterClient presents a primary and its replica as the nodes of a cluster.
+type clusterClient struct {
+	rueidis.Client
+	nodes map[string]rueidis.Client
+}
+
+func (c clusterClient) Nodes() map[string]rueidis.Client {
+	return c.nodes
+}
+
+// setRole makes the given server report the role, which miniredis does not implement.
+func setRole(t *testing.T, s *miniredis.Miniredis, role string) {
+	t.Helper()
+	require.NoError(t, s.Server().Register("ROLE", func(c *server.Peer, _ string, _ []string) {
+		c.WriteLen(1)
+		c.WriteBulk(role)
+	}))
+}
+
+func TestMediaService_ExportMedia(t *testing.T) {
+	ctx, ks := tenantContext()
+	createdAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	a := uuidAt(t, createdAt)
+
+	setup := func(t *testing.T) *mediaService {
+		t.Helper()
+		_, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "https://s3.example.com"), "bucket", TagRules{}, false)
+		seedMedia(t, ctx, s, ks, a, "t1", "t,2")
+		seedMedia(t, tenant.WithID(context.Background(), "other"), s, keyspace{tenant: "other"}, uuidAt(t, createdAt))
+		return s
+	}
+
+	t.Run("it writes JSON lines", func(t *testing.T) {
+		s := setup(t)
+		var out bytes.Buffer
+
+		err := s.ExportMedia(ctx, &out, ExportJSONLines)
+
+		require.NoError(t, err)
+		require.Equal(t, `{"id":"`+a+`","name":"`+a+`","tags":["t1","t,2"],"createdAt":"2024-05-01T12:30:00Z",`+
+			`"url":"https://s3.example.com/bucket/club/`+a+`"}`+"\n", out.String())
+	})
+
+	t.Run("it writes CSV", func(t *testing.T) {
+		s := setup(t)
+		var out bytes.Buffer
+
+		err := s.ExportMedia(ctx, &out, ExportCSV)
+
+		require.NoError(t, err)
+		require.Equal(t, "id,name,tags,createdAt,url\n"+
+			a+","+a+",\"t1,t,2\",2024-05-01T12:30:00Z,https://s3.example.com/bucket/club/"+a+"\n", out.String())
+	})
+
+	t.Run("it escapes the texts a spreadsheet would run as formulas", func(t *testing.T) {
+		mr, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "https://s3.example.com"), "bucket", TagRules{}, false)
+		mr.HSet(ks.media(a), nameField, `=HYPERLINK("https://evil.example.com")`, tagsField, `["@t1","-2"]`)
+		var out bytes.Buffer
+
+		err := s.ExportMedia(ctx, &out, ExportCSV)
+
+		require.NoError(t, err)
+		require.Equal(t, "id,name,tags,createdAt,url\n"+
+			a+`,"'=HYPERLINK(""https://evil.example.com"")","'@t1,-2",2024-05-01T12:30:00Z,https://s3.example.com/bucket/club/`+a+"\n", out.String())
+	})
+
+	t.Run("it writes the media of a cluster once, replicas aside", func(t *testing.T) {
+		primary, rc := newMiniredisClient(t)
+		setRole(t, primary, "master")
+		replica, replicaRC := newMiniredisClient(t)
+		setRole(t, replica, "slave")
+		cluster := clusterClient{Client: rc, nodes: map[string]rueidis.Client{"primary": rc, "replica": replicaRC}}
+		s := NewMediaService(cluster, nil, Namespace{}, nil, parseURL(t, "https://s3.example.com"), "bucket", TagRules{}, false)
+		seedMedia(t, ctx, s, ks, a, "t1")
+		// The replica holds a copy of the keys of its primary.
+		seedMedia(t, ctx, NewMediaService(replicaRC, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false), ks, a, "t1")
+		var out bytes.Buffer
+
+		err := s.ExportMedia(ctx, &out, ExportCSV)
+
+		require.NoError(t, err)
+		require.Equal(t, "id,name,tags,createdAt,url\n"+
+			a+","+a+",t1,2024-05-01T12:30:00Z,https://s3.example.com/bucket/club/"+a+"\n", out.String())
+	})
+
+	t.Run("it fails if the role of a node is unknown", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		cluster := clusterClient{Client: rc, nodes: map[string]rueidis.Client{"primary": rc, "replica": rc}}
+
+		err := NewMediaService(cluster, nil, Namespace{}, nil, parseURL(t, ""), "bucket", TagRules{}, false).ExportMedia(ctx, &bytes.Buffer{}, ExportCSV)
+
+		require.ErrorContains(t, err, "getting node role: ")
+	})
+
+	t.Run("it writes nothing but the header without media", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		var out bytes.Buffer
+
+		err := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, ""), "bucket", TagRules{}, false).ExportMedia(ctx, &out, ExportCSV)
+
+		require.NoError(t, err)
+		require.Equal(t, "id,name,tags,createdAt,url\n", out.String())
+	})
+
+	t.Run("it fails on an unknown format", func(t *testing.T) {
+		err := NewMediaService(nil, nil, Namespace{}, nil, parseURL(t, ""), "bucket", TagRules{}, false).ExportMedia(ctx, &bytes.Buffer{}, "xml")
+		require.EqualError(t, err, `unknown export format "xml"`)
+	})
+
+	t.Run("it fails without writing if scanning fails", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.SetError("boom")
+		var out bytes.Buffer
+
+		err := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, ""), "bucket", TagRules{}, false).ExportMedia(ctx, &out, ExportCSV)
+
+		require.EqualError(t, err, "scanning keys: boom")
+		require.Empty(t, out.String())
+	})
+
+	t.Run("it fails if writing fails", func(t *testing.T) {
+		s := setup(t)
+
+		err := s.ExportMedia(ctx, failingWriter{}, ExportJSONLines)
+
+		require.EqualError(t, err, "writing media: "+assert.AnError.Error())
+	})
+
+	t.Run("it fails without tenant", func(t *testing.T) {
+		err := NewMediaService(nil, nil, Namespace{}, nil, parseURL(t, ""), "bucket", TagRules{}, false).ExportMedia(context.Background(), &bytes.Buffer{}, ExportCSV)
+		require.ErrorIs(t, err, ErrNoTenant)
+	})
+}
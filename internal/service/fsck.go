@@ -0,0 +1,457 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/rueidis"
+	"golang.org/x/sync/errgroup"
+
+	"scoreplay/internal/tenant"
+)
+
+// The kinds of problems found by the checker.
+const (
+	// FsckDanglingIndexEntry is a media in the index of a tag without a record.
+	FsckDanglingIndexEntry = "dangling-index-entry"
+	// FsckStrayIndexEntry is a media in the index of a tag its record does not carry.
+	FsckStrayIndexEntry = "stray-index-entry"
+	// FsckMissingIndexEntry is a media missing from the index of a tag its record carries.
+	FsckMissingIndexEntry = "missing-index-entry"
+	// FsckDanglingCollectionItem is a media in the items of a collection without a record.
+	FsckDanglingCollectionItem = "dangling-collection-item"
+	// FsckMissingObject is a media whose object never made it to the bucket.
+	FsckMissingObject = "missing-object"
+	// FsckOrphanObject is an object of the bucket without a media record.
+	FsckOrphanObject = "orphan-object"
+)
+
+type objectStore interface {
+	s3.ListObjectsV2APIClient
+	objectHeader
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+type FsckOptions struct {
+	// Repair fixes the problems found, except for the orphan objects.
+	Repair bool
+	// DeleteOrphanObjects deletes the orphan objects along with the repair.
+	DeleteOrphanObjects bool
+	// Grace is how long media and objects are given to show up on the other side, since an
+	// upload happens after its media was created.
+	Grace time.Duration
+}
+
+type FsckProblem struct {
+	Kind       string
+	Tenant     string
+	Media      string
+	Tag        Tag
+	Collection string
+	// Object is the key of the orphan object in the bucket.
+	Object   string
+	Repaired bool
+}
+
+func (p FsckProblem) String() string {
+	var b strings.Builder
+	b.WriteString(p.Kind)
+	for _, f := range []struct{ name, value string }{
+		{"tenant", p.Tenant}, {"media", p.Media}, {"tag", p.Tag}, {"collection", p.Collection}, {"object", p.Object},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(&b, " %s=%q", f.name, f.value)
+		}
+	}
+	if p.Repaired {
+		b.WriteString(" repaired")
+	}
+
+	return b.String()
+}
+
+type checker struct {
+	bucket        string
+	now           func() time.Time
+	ns            Namespace
+	objects       objectStore
+	rueidisClient rueidis.Client
+}
+
+// NewChecker creates a checker of the consistency between the records of the media, their
+// indexes and the objects of the bucket, across all tenants.
+func NewChecker(rueidisClient rueidis.Client, ns Namespace, objects objectStore, bucket string) *checker {
+	return &checker{
+		bucket:        bucket,
+		now:           time.Now,
+		ns:            ns,
+		objects:       objects,
+		rueidisClient: rueidisClient,
+	}
+}
+
+// fsckObjectChecks bounds the objects checked at once.
+const fsckObjectChecks = 16
+
+// page holds the keys of a tenant found in a page of the scan, without their prefix.
+type page struct {
+	media []string
+	index []Tag
+	items []string
+}
+
+// Check reports the inconsistencies of the catalog, and repairs them if asked to. The keyspace is
+// checked page by page of the scan, so the catalog is never loaded whole, and the bucket is only
+// listed under the directories of the tenants found, so the objects of other deployments sharing
+// it are left alone. The indexes are repaired by scripts checking the records again, so a write
+// racing the check is never undone.
+func (c checker) Check(ctx context.Context, opts FsckOptions) ([]FsckProblem, error) {
+	var problems []FsckProblem
+	tenants := make(map[string]bool)
+	err := scanKeys(ctx, c.rueidisClient, c.ns.tenantPattern(), func(keys []string) error {
+		pages := make(map[string]*page)
+		for _, key := range keys {
+			id, rest, ok := c.ns.splitKey(key)
+			if !ok {
+				continue
+			}
+			tenants[id] = true
+			p := pages[id]
+			if p == nil {
+				p = &page{}
+				pages[id] = p
+			}
+			switch {
+			case strings.HasPrefix(rest, mediaPrefix):
+				p.media = append(p.media, strings.TrimPrefix(rest, mediaPrefix))
+			case strings.HasPrefix(rest, tagsPrefix):
+				p.index = append(p.index, strings.TrimPrefix(rest, tagsPrefix))
+			case strings.HasPrefix(rest, collectionPrefix) && strings.HasSuffix(rest, itemsSuffix):
+				p.items = append(p.items, strings.TrimSuffix(strings.TrimPrefix(rest, collectionPrefix), itemsSuffix))
+			}
+		}
+		for _, id := range sortedKeys(pages) {
+			found, err := c.checkPage(ctx, c.ns.keyspace(id), pages[id], opts.Grace)
+			problems = append(problems, found...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range sortedKeys(tenants) {
+		// The objects of a tenant ID which is no valid directory name cannot be told apart.
+		if !tenant.Valid(id) {
+			continue
+		}
+		found, err := c.listOrphans(ctx, c.ns.keyspace(id), opts.Grace)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, found...)
+	}
+
+	if opts.Repair {
+		if err := c.repair(ctx, problems, opts.DeleteOrphanObjects); err != nil {
+			return problems, err
+		}
+	}
+
+	return problems, nil
+}
+
+// checkPage checks the tag indexes, media records and collection items of a page of the scan.
+func (c checker) checkPage(ctx context.Context, ks keyspace, p *page, grace time.Duration) ([]FsckProblem, error) {
+	var problems []FsckProblem
+	id := ks.tenant
+
+	members := make(map[Tag][]string, len(p.index))
+	err := fetchKeys(ctx, c.rueidisClient, p.index, func(tag string) rueidis.Completed {
+		return c.rueidisClient.B().Smembers().Key(ks.tag(tag)).Build()
+	}, func(tag string, resp rueidis.RedisResult) error {
+		keys, err := resp.AsStrSlice()
+		members[tag] = keys
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting tag indexes: %w", err)
+	}
+	var indexed []string
+	for _, keys := range members {
+		indexed = append(indexed, keys...)
+	}
+	records, err := c.mediaTags(ctx, ks, indexed)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range sortedKeys(members) {
+		for _, key := range slices.Sorted(slices.Values(members[tag])) {
+			tags, ok := records[key]
+			switch {
+			case !ok:
+				problems = append(problems, FsckProblem{Kind: FsckDanglingIndexEntry, Tenant: id, Media: key, Tag: tag})
+			case !slices.Contains(tags, tag):
+				problems = append(problems, FsckProblem{Kind: FsckStrayIndexEntry, Tenant: id, Media: key, Tag: tag})
+			}
+		}
+	}
+
+	found, err := c.checkMedia(ctx, ks, p.media, grace)
+	problems = append(problems, found...)
+	if err != nil {
+		return problems, err
+	}
+
+	items := make(map[string][]string, len(p.items))
+	err = fetchKeys(ctx, c.rueidisClient, p.items, func(collection string) rueidis.Completed {
+		return c.rueidisClient.B().Lrange().Key(ks.collectionItems(collection)).Start(0).Stop(-1).Build()
+	}, func(collection string, resp rueidis.RedisResult) error {
+		keys, err := resp.AsStrSlice()
+		items[collection] = keys
+		return err
+	})
+	if err != nil {
+		return problems, fmt.Errorf("getting collection items: %w", err)
+	}
+	var listed []string
+	for _, keys := range items {
+		listed = append(listed, keys...)
+	}
+	if records, err = c.mediaTags(ctx, ks, listed); err != nil {
+		return problems, err
+	}
+	for _, collection := range sortedKeys(items) {
+		for _, key := range items[collection] {
+			if _, ok := records[key]; !ok {
+				problems = append(problems, FsckProblem{Kind: FsckDanglingCollectionItem, Tenant: id, Media: key, Collection: collection})
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// checkMedia checks that the given media are in the index of every tag they carry, and that their
+// object is in the bucket.
+func (c checker) checkMedia(ctx context.Context, ks keyspace, keys []string, grace time.Duration) ([]FsckProblem, error) {
+	records, err := c.mediaTags(ctx, ks, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		key string
+		tag Tag
+	}
+	var entries []entry
+	var uploaded []string
+	for _, key := range sortedKeys(records) {
+		for _, tag := range records[key] {
+			entries = append(entries, entry{key: key, tag: tag})
+		}
+		// Media created within the grace period may still be uploading.
+		if createdAt, ok := mediaCreatedAt(key); !ok || c.now().Sub(createdAt) >= grace {
+			uploaded = append(uploaded, key)
+		}
+	}
+
+	var problems []FsckProblem
+	for chunk := range slices.Chunk(entries, scanCount) {
+		cmds := make(rueidis.Commands, len(chunk))
+		for i, e := range chunk {
+			cmds[i] = c.rueidisClient.B().Sismember().Key(ks.tag(e.tag)).Member(e.key).Build()
+		}
+		for i, resp := range c.rueidisClient.DoMulti(ctx, cmds...) {
+			indexed, err := resp.AsBool()
+			if err != nil {
+				return nil, fmt.Errorf("checking tag indexes: %w", unavailable(err))
+			}
+			if !indexed {
+				problems = append(problems, FsckProblem{Kind: FsckMissingIndexEntry, Tenant: ks.tenant, Media: chunk[i].key, Tag: chunk[i].tag})
+			}
+		}
+	}
+
+	exists := make([]bool, len(uploaded))
+	var g errgroup.Group
+	g.SetLimit(fsckObjectChecks)
+	for i, key := range uploaded {
+		g.Go(func() error {
+			var err error
+			exists[i], err = objectExists(ctx, c.objects, c.bucket, ks.object(key))
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return problems, err
+	}
+	for i, key := range uploaded {
+		if !exists[i] {
+			problems = append(problems, FsckProblem{Kind: FsckMissingObject, Tenant: ks.tenant, Media: key})
+		}
+	}
+
+	return problems, nil
+}
+
+// mediaTags returns the tags of the given media, leaving out the media without record, which may
+// have been deleted since the scan.
+func (c checker) mediaTags(ctx context.Context, ks keyspace, keys []string) (map[string][]Tag, error) {
+	records := make(map[string][]Tag, len(keys))
+	err := fetchKeys(ctx, c.rueidisClient, keys, func(key string) rueidis.Completed {
+		return c.rueidisClient.B().Hgetall().Key(ks.media(key)).Build()
+	}, func(key string, resp rueidis.RedisResult) error {
+		record, err := resp.AsStrMap()
+		if err != nil || len(record) == 0 {
+			return err
+		}
+		tags, err := decodeTags(record[tagsField])
+		records[key] = tags
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting media records: %w", err)
+	}
+
+	return records, nil
+}
+
+// listOrphans lists the objects in the directory of the tenant, page by page, and returns the ones
+// older than the grace period without a media record.
+func (c checker) listOrphans(ctx context.Context, ks keyspace, grace time.Duration) ([]FsckProblem, error) {
+	var problems []FsckProblem
+	pages := s3.NewListObjectsV2Paginator(c.objects, &s3.ListObjectsV2Input{Bucket: &c.bucket, Prefix: aws.String(ks.object(""))})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing objects: %w", err)
+		}
+		var keys []string
+		for _, o := range page.Contents {
+			if o.Key == nil {
+				continue
+			}
+			key := strings.TrimPrefix(*o.Key, ks.object(""))
+			// Objects laid out otherwise do not belong to the catalog, and objects younger than
+			// the grace period may belong to a media being created.
+			if key == "" || strings.Contains(key, "/") || (o.LastModified != nil && c.now().Sub(*o.LastModified) < grace) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+
+		records, err := c.mediaTags(ctx, ks, keys)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if _, ok := records[key]; !ok {
+				problems = append(problems, FsckProblem{Kind: FsckOrphanObject, Object: ks.object(key)})
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// repair fixes the given problems, marking the ones it fixed.
+func (c checker) repair(ctx context.Context, problems []FsckProblem, deleteOrphanObjects bool) error {
+	// Index entries are synced per tag, in a single script run per tag.
+	type tagIndex struct{ tenant, tag string }
+	synced := make(map[tagIndex][]int)
+	var order []tagIndex
+	for i, p := range problems {
+		switch p.Kind {
+		case FsckDanglingIndexEntry, FsckStrayIndexEntry, FsckMissingIndexEntry:
+			ti := tagIndex{tenant: p.Tenant, tag: p.Tag}
+			if _, ok := synced[ti]; !ok {
+				order = append(order, ti)
+			}
+			synced[ti] = append(synced[ti], i)
+		}
+	}
+	for _, ti := range order {
+		var keys []string
+		for _, i := range synced[ti] {
+			keys = append(keys, problems[i].Media)
+		}
+		if err := syncTagIndex(ctx, c.rueidisClient, c.ns.keyspace(ti.tenant), ti.tag, keys); err != nil {
+			return err
+		}
+		for _, i := range synced[ti] {
+			problems[i].Repaired = true
+		}
+	}
+
+	for i, p := range problems {
+		var err error
+		switch p.Kind {
+		case FsckDanglingCollectionItem:
+			err = c.removeCollectionItem(ctx, c.ns.keyspace(p.Tenant), p.Collection, p.Media)
+		case FsckMissingObject:
+			err = c.deleteMissingObjectMedia(ctx, c.ns.keyspace(p.Tenant), p.Media)
+		case FsckOrphanObject:
+			if !deleteOrphanObjects {
+				continue
+			}
+			_, err = c.objects.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &c.bucket, Key: &p.Object})
+			if err != nil {
+				err = fmt.Errorf("deleting object: %w", err)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		problems[i].Repaired = true
+	}
+
+	return nil
+}
+
+// removeCollectionItem removes the given media from the items of the collection, unless its
+// record showed up in the meantime.
+func (c checker) removeCollectionItem(ctx context.Context, ks keyspace, collection, key string) error {
+	exists, err := c.rueidisClient.Do(ctx, c.rueidisClient.B().Exists().Key(ks.media(key)).Build()).AsBool()
+	if err != nil {
+		return fmt.Errorf("checking media: %w", unavailable(err))
+	}
+	if exists {
+		return nil
+	}
+	if err := c.rueidisClient.Do(ctx, c.rueidisClient.B().Lrem().Key(ks.collectionItems(collection)).Count(0).Element(key).Build()).Error(); err != nil {
+		return fmt.Errorf("removing collection item: %w", unavailable(err))
+	}
+
+	return nil
+}
+
+// deleteMissingObjectMedia deletes the record of the given media, unless its object was uploaded
+// in the meantime.
+func (c checker) deleteMissingObjectMedia(ctx context.Context, ks keyspace, key string) error {
+	exists, err := objectExists(ctx, c.objects, c.bucket, ks.object(key))
+	if err != nil || exists {
+		return err
+	}
+
+	return deleteMedia(ctx, c.rueidisClient, ks, key)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
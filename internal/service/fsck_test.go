@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockObjectStore struct {
+	m *mock.Mock
+}
+
+func (m *mockObjectStore) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
+}
+
+func (m *mockObjectStore) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*s3.HeadObjectOutput), args.Error(1)
+}
+
+func (m *mockObjectStore) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*s3.CopyObjectOutput), args.Error(1)
+}
+
+func (m *mockObjectStore) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	args := m.m.Called(ctx, params)
+	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
+}
+
+// uuidAt returns a UUIDv7 created at the given time.
+func uuidAt(t *testing.T, at time.Time) string {
+	t.Helper()
+	id, err := uuid.NewV7()
+	require.NoError(t, err)
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(at.UnixMilli()))
+	copy(id[:6], ms[2:])
+	return id.String()
+}
+
+func newMiniredisClient(t *testing.T) (*miniredis.Miniredis, rueidis.Client) {
+	t.Helper()
+	s := miniredis.RunT(t)
+	// Like the standalone mode of the server, which lets the keys of a script span several slots.
+	rc, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{s.Addr()}, DisableCache: true, ForceSingleClient: true})
+	require.NoError(t, err)
+	t.Cleanup(rc.Close)
+	return s, rc
+}
+
+func TestFsckProblem_String(t *testing.T) {
+	require.Equal(t, `missing-index-entry tenant="club" media="key1" tag="tag 1" repaired`,
+		FsckProblem{Kind: FsckMissingIndexEntry, Tenant: "club", Media: "key1", Tag: "tag 1", Repaired: true}.String())
+	require.Equal(t, `orphan-object object="club/key1"`, FsckProblem{Kind: FsckOrphanObject, Object: "club/key1"}.String())
+}
+
+func TestChecker_Check(t *testing.T) {
+	ctx := context.Background()
+	ks := keyspace{tenant: "club"}
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	// Keys created a millisecond apart sort in creation order.
+	a, b, c, d := uuidAt(t, old), uuidAt(t, old.Add(time.Millisecond)), uuidAt(t, old.Add(2*time.Millisecond)), uuidAt(t, now)
+
+	setup := func(t *testing.T) (*miniredis.Miniredis, rueidis.Client) {
+		t.Helper()
+		s, rc := newMiniredisClient(t)
+		s.HSet(ks.media(a), nameField, "a", tagsField, "t1,t%2C2")
+		s.HSet(ks.media(c), nameField, "c", tagsField, "t1")
+		s.HSet(ks.media(d), nameField, "d", tagsField, "")
+		_, err := s.SAdd(ks.tags(), "t1", "t,2", "t3")
+		require.NoError(t, err)
+		_, err = s.SAdd(ks.tag("t1"), a, b)
+		require.NoError(t, err)
+		_, err = s.SAdd(ks.tag("t3"), c)
+		require.NoError(t, err)
+		_, err = s.Push(ks.collectionItems("col"), a, b)
+		require.NoError(t, err)
+		// The keys of another deployment sharing the Redis.
+		s.HSet(Namespace{Prefix: "app:"}.keyspace("rival").media(a), nameField, "a", tagsField, "[]")
+		return s, rc
+	}
+	listInput := &s3.ListObjectsV2Input{Bucket: pT("bucket"), Prefix: pT("club/")}
+	listed := &s3.ListObjectsV2Output{Contents: []types.Object{
+		{Key: pT(ks.object(a)), LastModified: &old},
+		{Key: pT(ks.object("e")), LastModified: &old},
+		{Key: pT(ks.object("f")), LastModified: &now},
+		{Key: pT(ks.object("nested/e")), LastModified: &old},
+	}}
+	// expectObjects expects the objects of the media older than the grace period to be checked,
+	// with c missing.
+	expectObjects := func(m *mock.Mock) {
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(a))}).Return(&s3.HeadObjectOutput{}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(c))}).
+			Return((*s3.HeadObjectOutput)(nil), &types.NotFound{}).Once()
+	}
+	problems := []FsckProblem{
+		{Kind: FsckDanglingIndexEntry, Tenant: "club", Media: b, Tag: "t1"},
+		{Kind: FsckStrayIndexEntry, Tenant: "club", Media: c, Tag: "t3"},
+		{Kind: FsckMissingIndexEntry, Tenant: "club", Media: a, Tag: "t,2"},
+		{Kind: FsckMissingIndexEntry, Tenant: "club", Media: c, Tag: "t1"},
+		{Kind: FsckMissingObject, Tenant: "club", Media: c},
+		{Kind: FsckDanglingCollectionItem, Tenant: "club", Media: b, Collection: "col"},
+		{Kind: FsckOrphanObject, Object: ks.object("e")},
+	}
+
+	t.Run("it reports the problems", func(t *testing.T) {
+		s, rc := setup(t)
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, listInput).Return(listed, nil).Once()
+		expectObjects(m)
+
+		ch := NewChecker(rc, Namespace{}, &mockObjectStore{m: m}, "bucket")
+		ch.now = func() time.Time { return now }
+		found, err := ch.Check(ctx, FsckOptions{Grace: time.Hour})
+
+		require.NoError(t, err)
+		require.ElementsMatch(t, problems, found)
+		members, err := s.SMembers(ks.tag("t1"))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{a, b}, members)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it repairs the problems", func(t *testing.T) {
+		s, rc := setup(t)
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, listInput).Return(listed, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(c))}).
+			Return((*s3.HeadObjectOutput)(nil), &types.NotFound{}).Once().
+			On("DeleteObject", ctx, &s3.DeleteObjectInput{Bucket: pT("bucket"), Key: pT(ks.object("e"))}).
+			Return(&s3.DeleteObjectOutput{}, nil).Once()
+		expectObjects(m)
+
+		ch := NewChecker(rc, Namespace{}, &mockObjectStore{m: m}, "bucket")
+		ch.now = func() time.Time { return now }
+		found, err := ch.Check(ctx, FsckOptions{Repair: true, DeleteOrphanObjects: true, Grace: time.Hour})
+
+		require.NoError(t, err)
+		require.Len(t, found, len(problems))
+		for _, p := range found {
+			require.True(t, p.Repaired, p.String())
+		}
+		members, err := s.SMembers(ks.tag("t1"))
+		require.NoError(t, err)
+		require.Equal(t, []string{a}, members)
+		members, err = s.SMembers(ks.tag("t,2"))
+		require.NoError(t, err)
+		require.Equal(t, []string{a}, members)
+		require.False(t, s.Exists(ks.tag("t3")))
+		require.False(t, s.Exists(ks.media(c)))
+		require.True(t, s.Exists(ks.media(d)))
+		items, err := s.List(ks.collectionItems("col"))
+		require.NoError(t, err)
+		require.Equal(t, []string{a}, items)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it only lists the directories of the tenants of its namespace", func(t *testing.T) {
+		_, rc := setup(t)
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, &s3.ListObjectsV2Input{Bucket: pT("bucket"), Prefix: pT("rival/")}).
+			Return(&s3.ListObjectsV2Output{Contents: []types.Object{{Key: pT("rival/" + a), LastModified: &old}}}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT("rival/" + a)}).Return(&s3.HeadObjectOutput{}, nil).Once()
+
+		ch := NewChecker(rc, Namespace{Prefix: "app:"}, &mockObjectStore{m: m}, "bucket")
+		ch.now = func() time.Time { return now }
+		found, err := ch.Check(ctx, FsckOptions{Grace: time.Hour})
+
+		require.NoError(t, err)
+		require.Empty(t, found)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it keeps media whose object showed up", func(t *testing.T) {
+		s, rc := setup(t)
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, listInput).Return(listed, nil).Once()
+		expectObjects(m)
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(c))}).Return(&s3.HeadObjectOutput{}, nil).Once()
+
+		ch := NewChecker(rc, Namespace{}, &mockObjectStore{m: m}, "bucket")
+		ch.now = func() time.Time { return now }
+		_, err := ch.Check(ctx, FsckOptions{Repair: true, Grace: time.Hour})
+
+		require.NoError(t, err)
+		require.True(t, s.Exists(ks.media(c)))
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it fails if checking an object fails", func(t *testing.T) {
+		_, rc := setup(t)
+		m := &mock.Mock{}
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(a))}).Return(&s3.HeadObjectOutput{}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(c))}).
+			Return((*s3.HeadObjectOutput)(nil), assert.AnError).Once()
+
+		ch := NewChecker(rc, Namespace{}, &mockObjectStore{m: m}, "bucket")
+		ch.now = func() time.Time { return now }
+		_, err := ch.Check(ctx, FsckOptions{Repair: true, Grace: time.Hour})
+
+		require.EqualError(t, err, "checking object: "+assert.AnError.Error())
+	})
+
+	t.Run("it fails if listing objects fails", func(t *testing.T) {
+		_, rc := setup(t)
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, listInput).
+			Return((*s3.ListObjectsV2Output)(nil), assert.AnError).Once()
+		expectObjects(m)
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(d))}).Return(&s3.HeadObjectOutput{}, nil).Once()
+
+		found, err := NewChecker(rc, Namespace{}, &mockObjectStore{m: m}, "bucket").Check(ctx, FsckOptions{})
+
+		require.Nil(t, found)
+		require.EqualError(t, err, "listing objects: "+assert.AnError.Error())
+	})
+
+	t.Run("it fails if scanning fails", func(t *testing.T) {
+		s, rc := setup(t)
+		s.SetError("boom")
+
+		found, err := NewChecker(rc, Namespace{}, nil, "bucket").Check(ctx, FsckOptions{})
+
+		require.Nil(t, found)
+		require.EqualError(t, err, "scanning keys: boom")
+	})
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+const (
+	idempotencyPrefix = "idempotency:"
+	idempotencyTTL    = 24 * time.Hour
+)
+
+var ErrIdempotencyKeyReused = fmt.Errorf("%w: idempotency key used by another request", ErrConflict)
+
+// claimIdempotencyKey records the outcome of a request under its idempotency key, unless an
+// earlier request did. In that case, it returns the outcome of the earlier request, once it made
+// sure both requests are the same.
+func claimIdempotencyKey(ctx context.Context, rc rueidis.Client, key, fingerprint, outcome string) (string, bool, error) {
+	earlier, err := rc.Do(ctx, rc.B().Set().Key(key).Value(fingerprint+" "+outcome).Nx().Get().Px(idempotencyTTL).Build()).ToString()
+	if rueidis.IsRedisNil(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("claiming idempotency key: %w", unavailable(err))
+	}
+
+	earlierFingerprint, earlierOutcome, _ := strings.Cut(earlier, " ")
+	if earlierFingerprint != fingerprint {
+		return "", false, ErrIdempotencyKeyReused
+	}
+
+	return earlierOutcome, true, nil
+}
+
+// releaseIdempotencyKey forgets the idempotency key of a failed request, so that it can be retried.
+// Failing to do so only delays the retries until the key expires.
+func releaseIdempotencyKey(ctx context.Context, rc rueidis.Client, key string) {
+	_ = rc.Do(ctx, rc.B().Del().Key(key).Build()).Error()
+}
+
+// fingerprint identifies the parameters of a request.
+func fingerprint(params any) string {
+	b, _ := json.Marshal(params)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
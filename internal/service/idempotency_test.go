@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	rmock "github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it claims a new key", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SET", "key", "fp outcome", "NX", "GET", "PX", "86400000")).Return(rmock.Result(rmock.RedisNil()))
+
+		earlier, replay, err := claimIdempotencyKey(ctx, rc, "key", "fp", "outcome")
+
+		require.NoError(t, err)
+		require.False(t, replay)
+		require.Empty(t, earlier)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it returns the outcome of the earlier request", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SET", "key", "fp outcome", "NX", "GET", "PX", "86400000")).Return(rmock.Result(rmock.RedisString("fp earlier")))
+
+		earlier, replay, err := claimIdempotencyKey(ctx, rc, "key", "fp", "outcome")
+
+		require.NoError(t, err)
+		require.True(t, replay)
+		require.Equal(t, "earlier", earlier)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if another request used the key", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SET", "key", "fp outcome", "NX", "GET", "PX", "86400000")).Return(rmock.Result(rmock.RedisString("other earlier")))
+
+		_, _, err := claimIdempotencyKey(ctx, rc, "key", "fp", "outcome")
+
+		require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+		require.ErrorIs(t, err, ErrConflict)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SET", "key", "fp outcome", "NX", "GET", "PX", "86400000")).Return(rmock.ErrorResult(assert.AnError))
+
+		_, _, err := claimIdempotencyKey(ctx, rc, "key", "fp", "outcome")
+
+		require.EqualError(t, err, "claiming idempotency key: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestReleaseIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	rc := rmock.NewClient(ctrl)
+	rc.EXPECT().Do(ctx, rmock.Match("DEL", "key")).Return(rmock.ErrorResult(assert.AnError))
+
+	releaseIdempotencyKey(ctx, rc, "key")
+
+	require.True(t, ctrl.Satisfied())
+}
+
+func TestFingerprint(t *testing.T) {
+	a := fingerprint(CreateMediaParams{Name: "name", Tags: []string{"tag1"}, IdempotencyKey: "key1"})
+	b := fingerprint(CreateMediaParams{Name: "name", Tags: []string{"tag1"}, IdempotencyKey: "key2"})
+	c := fingerprint(CreateMediaParams{Name: "name", Tags: []string{"tag2"}, IdempotencyKey: "key1"})
+
+	require.Len(t, a, 64)
+	require.Equal(t, a, b)
+	require.NotEqual(t, a, c)
+}
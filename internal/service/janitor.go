@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/redis/rueidis"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+
+	"scoreplay/internal/tenant"
+)
+
+const (
+	// pendingUploadsKey holds the objects of the media created by a tenant whose upload was not
+	// confirmed yet, scored by the creation time of the media.
+	pendingUploadsKey = "uploads:pending"
+	// pendingMetadataKey holds the media of a tenant whose tags changed since the metadata of their
+	// object was last written, scored by the time of the change.
+	pendingMetadataKey = "metadata:pending"
+)
+
+// janitorMetrics counts what the janitor did since the server started.
+var janitorMetrics = expvar.NewMap("janitor") //nolint: gochecknoglobals
+
+type JanitorConfig struct {
+	// Interval between sweeps; zero disables the janitor.
+	Interval time.Duration `env:"INTERVAL" default:"10m"`
+	// Grace is how long an upload is given to complete. It must exceed the lifetime of the
+	// presigned requests.
+	Grace     time.Duration `env:"GRACE" default:"1h"`
+	BatchSize int64         `env:"BATCH_SIZE" default:"100"`
+	// MetadataWorkers bounds the objects whose metadata is written at once.
+	MetadataWorkers int `env:"METADATA_WORKERS" default:"8"`
+}
+
+type objectHeader interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// objectCopier copies objects within the bucket, which rewrites their metadata.
+type objectCopier interface {
+	objectHeader
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+}
+
+type janitor struct {
+	bucket        string
+	cfg           JanitorConfig
+	now           func() time.Time
+	ns            Namespace
+	objects       objectCopier
+	rueidisClient rueidis.Client
+}
+
+// NewJanitor creates the janitor deleting the media whose upload never completed, and writing the
+// tags of the media retagged into the metadata of their objects.
+func NewJanitor(rueidisClient rueidis.Client, ns Namespace, objects objectCopier, bucket string, cfg JanitorConfig) *janitor {
+	return &janitor{
+		bucket:        bucket,
+		cfg:           cfg,
+		now:           time.Now,
+		ns:            ns,
+		objects:       objects,
+		rueidisClient: rueidisClient,
+	}
+}
+
+// Run sweeps the orphaned uploads and writes the queued metadata at every interval until the
+// context is done. Failures are logged, and their uploads and media handled again by the next run.
+func (j janitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			result, err := j.Sweep(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Ctx(ctx).Error().Err(err).Msg("sweeping orphaned uploads")
+			}
+			if result.Deleted > 0 {
+				log.Ctx(ctx).Info().Int("deleted", result.Deleted).Msg("orphaned uploads deleted")
+			}
+			written, err := j.WriteMetadata(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Ctx(ctx).Error().Err(err).Msg("writing object metadata")
+			}
+			if written > 0 {
+				log.Ctx(ctx).Info().Int("written", written).Msg("object metadata written")
+			}
+		}
+	}
+}
+
+type SweepResult struct {
+	Checked   int
+	Confirmed int
+	Deleted   int
+}
+
+// Sweep checks the uploads pending for longer than the grace period, tenant by tenant and batch
+// by batch. The media whose object arrived are confirmed, and the others deleted along with their
+// index entries.
+func (j janitor) Sweep(ctx context.Context) (SweepResult, error) {
+	janitorMetrics.Add("sweeps", 1)
+	var result SweepResult
+	keys, err := j.tenantKeys(ctx, j.ns.pendingUploadsPattern(), pendingUploadsKey)
+	if err != nil {
+		janitorMetrics.Add("errors", 1)
+		return result, err
+	}
+
+	var errs []error
+	maxScore := strconv.FormatInt(j.now().Add(-j.cfg.Grace).UnixMilli(), 10)
+	for _, key := range keys {
+		errs = append(errs, j.sweepTenant(ctx, key, maxScore, &result)...)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// tenantKeys returns the keys of every tenant matching the given pattern that are named name.
+func (j janitor) tenantKeys(ctx context.Context, pattern, name string) ([]string, error) {
+	var keys []string
+	err := scanKeys(ctx, j.rueidisClient, pattern, func(found []string) error {
+		for _, key := range found {
+			if _, rest, ok := j.ns.splitKey(key); ok && rest == name {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys are reported once per node holding them.
+	return slices.Compact(slices.Sorted(slices.Values(keys))), nil
+}
+
+// sweepTenant checks the uploads of the given pending uploads key scored up to maxScore.
+func (j janitor) sweepTenant(ctx context.Context, key, maxScore string, result *SweepResult) []error {
+	var errs []error
+	for {
+		// Checked uploads leave the set, except for the failed ones, which are skipped.
+		objects, err := j.rueidisClient.Do(ctx, j.rueidisClient.B().Zrange().Key(key).
+			Min("-inf").Max(maxScore).Byscore().Limit(int64(len(errs)), j.cfg.BatchSize).Build()).AsStrSlice()
+		if err != nil {
+			janitorMetrics.Add("errors", 1)
+			errs = append(errs, fmt.Errorf("getting pending uploads: %w", unavailable(err)))
+			break
+		}
+		if len(objects) == 0 {
+			break
+		}
+
+		for _, object := range objects {
+			deleted, err := j.check(ctx, key, object)
+			if err != nil {
+				janitorMetrics.Add("errors", 1)
+				errs = append(errs, fmt.Errorf("checking upload %s: %w", object, err))
+				continue
+			}
+			result.Checked++
+			janitorMetrics.Add("checked", 1)
+			if deleted {
+				result.Deleted++
+				janitorMetrics.Add("deleted", 1)
+			} else {
+				result.Confirmed++
+				janitorMetrics.Add("confirmed", 1)
+			}
+		}
+	}
+
+	return errs
+}
+
+// check confirms the upload of the given object if it exists, and deletes its media otherwise.
+func (j janitor) check(ctx context.Context, pendingKey, object string) (bool, error) {
+	id, key, ok := strings.Cut(object, "/")
+	if !ok || !tenant.Valid(id) {
+		return false, j.confirm(ctx, pendingKey, object)
+	}
+
+	exists, err := objectExists(ctx, j.objects, j.bucket, object)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, j.confirm(ctx, pendingKey, object)
+	}
+
+	return true, deleteMedia(ctx, j.rueidisClient, j.ns.keyspace(id), key)
+}
+
+// confirm removes the given object from the pending uploads.
+func (j janitor) confirm(ctx context.Context, pendingKey, object string) error {
+	err := j.rueidisClient.Do(ctx, j.rueidisClient.B().Zrem().Key(pendingKey).Member(object).Build()).Error()
+	if err != nil {
+		return fmt.Errorf("confirming upload: %w", unavailable(err))
+	}
+
+	return nil
+}
+
+// objectExists tells whether the given object is in the bucket.
+func objectExists(ctx context.Context, objects objectHeader, bucket, key string) (bool, error) {
+	_, err := objects.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err == nil {
+		return true, nil
+	}
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("checking object: %w", err)
+}
+
+// WriteMetadata writes the current name and tags of the media queued by the tag updates into the
+// metadata of their objects, tenant by tenant and oldest first. It returns the number of objects
+// written.
+func (j janitor) WriteMetadata(ctx context.Context) (int, error) {
+	keys, err := j.tenantKeys(ctx, j.ns.pendingMetadataPattern(), pendingMetadataKey)
+	if err != nil {
+		janitorMetrics.Add("errors", 1)
+		return 0, err
+	}
+
+	var written int
+	var errs []error
+	// The media queued while writing are left to the next run.
+	maxScore := strconv.FormatInt(j.now().UnixMilli(), 10)
+	for _, key := range keys {
+		n, tenantErrs := j.writeTenantMetadata(ctx, key, maxScore)
+		written += n
+		errs = append(errs, tenantErrs...)
+	}
+
+	return written, errors.Join(errs...)
+}
+
+// writeTenantMetadata writes the metadata of the media of the given queue scored up to maxScore,
+// batch by batch, MetadataWorkers objects at once. The failed media are queued again.
+func (j janitor) writeTenantMetadata(ctx context.Context, key, maxScore string) (int, []error) {
+	id, _, _ := j.ns.splitKey(key)
+	ks := j.ns.keyspace(id)
+	var written int
+	var errs []error
+	var failed []string
+	for {
+		media, err := j.rueidisClient.Do(ctx, j.rueidisClient.B().Zrange().Key(key).
+			Min("-inf").Max(maxScore).Byscore().Limit(0, j.cfg.BatchSize).Build()).AsStrSlice()
+		if err != nil {
+			janitorMetrics.Add("errors", 1)
+			errs = append(errs, fmt.Errorf("getting queued metadata: %w", unavailable(err)))
+			break
+		}
+		if len(media) == 0 {
+			break
+		}
+		// The media leave the queue before their record is read, so that a media retagged while its
+		// object is copied is queued again.
+		if err := j.rueidisClient.Do(ctx, j.rueidisClient.B().Zrem().Key(key).Member(media...).Build()).Error(); err != nil {
+			janitorMetrics.Add("errors", 1)
+			errs = append(errs, fmt.Errorf("dequeuing metadata: %w", unavailable(err)))
+			break
+		}
+
+		copied := make([]bool, len(media))
+		results := make([]error, len(media))
+		var g errgroup.Group
+		g.SetLimit(max(j.cfg.MetadataWorkers, 1))
+		for i, m := range media {
+			g.Go(func() error {
+				copied[i], results[i] = j.writeMetadata(ctx, ks, m)
+				return nil
+			})
+		}
+		_ = g.Wait()
+
+		for i, err := range results {
+			switch {
+			case err != nil:
+				janitorMetrics.Add("errors", 1)
+				errs = append(errs, fmt.Errorf("writing metadata of media %s: %w", media[i], err))
+				failed = append(failed, media[i])
+			case copied[i]:
+				written++
+				janitorMetrics.Add("written", 1)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		score := float64(j.now().UnixMilli())
+		cmd := j.rueidisClient.B().Zadd().Key(key).Nx().ScoreMember()
+		for _, m := range failed {
+			cmd = cmd.ScoreMember(score, m)
+		}
+		if err := j.rueidisClient.Do(ctx, cmd.Build()).Error(); err != nil {
+			errs = append(errs, fmt.Errorf("queueing metadata again: %w", unavailable(err)))
+		}
+	}
+
+	return written, errs
+}
+
+// writeMetadata replaces the metadata of the object of the given media by copying the object onto
+// itself, keeping its content headers. The record is read right before, so the object gets the
+// tags of the media as they are. The media deleted and the objects not uploaded yet are skipped, so
+// it tells whether the object was copied.
+func (j janitor) writeMetadata(ctx context.Context, ks keyspace, key string) (bool, error) {
+	record, err := j.rueidisClient.Do(ctx, j.rueidisClient.B().Hgetall().Key(ks.media(key)).Build()).AsStrMap()
+	if err != nil {
+		return false, fmt.Errorf("getting media record: %w", unavailable(err))
+	}
+	if len(record) == 0 {
+		return false, nil
+	}
+	tags, err := decodeTags(record[tagsField])
+	if err != nil {
+		return false, err
+	}
+
+	object := ks.object(key)
+	head, err := j.objects.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &j.bucket, Key: &object})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("getting object metadata: %w", err)
+	}
+
+	_, err = j.objects.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:             &j.bucket,
+		Key:                &object,
+		CopySource:         aws.String(copySource(j.bucket, object)),
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		Metadata:           objectMetadata(CreateMediaParams{Name: record[nameField], Tags: tags}),
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		ContentType:        head.ContentType,
+	})
+	if err != nil {
+		return false, fmt.Errorf("copying object: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func janitorMetric(name string) int64 {
+	v, ok := janitorMetrics.Get(name).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func TestJanitor_Sweep(t *testing.T) {
+	ctx, ks := tenantContext()
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	cfg := JanitorConfig{Grace: time.Hour, BatchSize: 2}
+
+	t.Run("it deletes the media whose upload never completed", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		a, b, c, d := uuidAt(t, old), uuidAt(t, old.Add(time.Millisecond)), uuidAt(t, now), uuidAt(t, old.Add(2*time.Millisecond))
+
+		m := &mock.Mock{}
+		for i, id := range []string{a, b, c, d} {
+			parsed, err := uuid.Parse(id)
+			require.NoError(t, err)
+			m.On("generateUUID").Return(parsed, nil).Once().
+				On("PresignPutObject", ctx, &s3.PutObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(id)), Metadata: map[string]string{"name": string(rune('a' + i)), "tags": "tag1,tag+2"}}, ([]func(*s3.PresignOptions))(nil)).
+				Return(&v4.PresignedHTTPRequest{URL: "http://test/bucket/" + ks.object(id), Method: http.MethodPut}, nil).Once()
+		}
+		ms := NewMediaService(rc, nil, Namespace{}, &mockPresignClient{m: m}, url.URL{}, "bucket", TagRules{}, false)
+		ms.generateUUID = mockUUID(m)
+		for _, name := range []string{"a", "b", "c", "d"} {
+			_, err := ms.CreateMedia(ctx, CreateMediaParams{Name: name, Tags: []string{"tag1", "tag 2"}})
+			require.NoError(t, err)
+		}
+		_, err := s.ZAdd(ks.pendingUploads(), float64(old.UnixMilli()), "not-an-object")
+		require.NoError(t, err)
+		other := Namespace{}.keyspace("other")
+		_, err = s.ZAdd(other.pendingUploads(), float64(old.UnixMilli()), "not-an-object")
+		require.NoError(t, err)
+		// The index of a tag ending like the pending uploads is no pending uploads key.
+		_, err = s.SAdd(ks.tag("x:"+pendingUploadsKey), a)
+		require.NoError(t, err)
+
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(a))}).Return(&s3.HeadObjectOutput{}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(b))}).Return((*s3.HeadObjectOutput)(nil), &types.NotFound{}).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(d))}).Return((*s3.HeadObjectOutput)(nil), assert.AnError).Once()
+		deleted := janitorMetric("deleted")
+
+		j := NewJanitor(rc, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		j.now = func() time.Time { return now }
+		result, err := j.Sweep(ctx)
+
+		require.EqualError(t, err, "checking upload "+ks.object(d)+": checking object: "+assert.AnError.Error())
+		require.Equal(t, SweepResult{Checked: 4, Confirmed: 3, Deleted: 1}, result)
+		require.Equal(t, deleted+1, janitorMetric("deleted"))
+		pending, err := s.ZMembers(ks.pendingUploads())
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{ks.object(c), ks.object(d)}, pending)
+		require.False(t, s.Exists(other.pendingUploads()))
+		require.True(t, s.Exists(ks.media(a)))
+		require.False(t, s.Exists(ks.media(b)))
+		members, err := s.SMembers(ks.tag("tag 2"))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{a, c, d}, members)
+		score, err := s.ZScore(ks.related("tag1"), "tag 2")
+		require.NoError(t, err)
+		require.Equal(t, float64(3), score)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it fails if finding pending uploads fails", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.SetError("boom")
+
+		result, err := NewJanitor(rc, Namespace{}, nil, "bucket", cfg).Sweep(ctx)
+
+		require.Equal(t, SweepResult{}, result)
+		require.EqualError(t, err, "scanning keys: boom")
+	})
+}
+
+func TestJanitor_WriteMetadata(t *testing.T) {
+	ctx, ks := tenantContext()
+	now := time.UnixMilli(10_000)
+	cfg := JanitorConfig{BatchSize: 2, MetadataWorkers: 2}
+
+	t.Run("it writes the current tags of the queued media", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		ms := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "bucket", TagRules{}, false)
+		for _, key := range []string{"a", "b", "d", "e"} {
+			seedMedia(t, ctx, ms, ks, key, "t 1")
+		}
+		_, err := s.ZAdd(ks.pendingMetadata(), 1, "a")
+		require.NoError(t, err)
+		_, err = s.ZAdd(ks.pendingMetadata(), 2, "b")
+		require.NoError(t, err)
+		_, err = s.ZAdd(ks.pendingMetadata(), 3, "c")
+		require.NoError(t, err)
+		_, err = s.ZAdd(ks.pendingMetadata(), 4, "d")
+		require.NoError(t, err)
+		_, err = s.ZAdd(ks.pendingMetadata(), float64(now.Add(time.Second).UnixMilli()), "e")
+		require.NoError(t, err)
+		// The tags changed after the media was queued.
+		s.HSet(ks.media("a"), tagsField, `["t 2"]`)
+
+		m := &mock.Mock{}
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object("a"))}).
+			Return(&s3.HeadObjectOutput{ContentType: pT("image/png")}, nil).Once().
+			On("CopyObject", ctx, &s3.CopyObjectInput{
+				Bucket:            pT("bucket"),
+				Key:               pT(ks.object("a")),
+				CopySource:        pT("bucket/" + ks.object("a")),
+				MetadataDirective: types.MetadataDirectiveReplace,
+				Metadata:          map[string]string{nameMetadata: "a", tagsMetadata: "t+2"},
+				ContentType:       pT("image/png"),
+			}).Return(&s3.CopyObjectOutput{}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object("b"))}).
+			Return((*s3.HeadObjectOutput)(nil), &types.NotFound{}).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object("d"))}).
+			Return(&s3.HeadObjectOutput{}, nil).Once().
+			On("CopyObject", ctx, mock.MatchedBy(func(in *s3.CopyObjectInput) bool { return *in.Key == ks.object("d") })).
+			Return((*s3.CopyObjectOutput)(nil), assert.AnError).Once()
+		written := janitorMetric("written")
+
+		j := NewJanitor(rc, Namespace{}, &mockObjectStore{m: m}, "bucket", cfg)
+		j.now = func() time.Time { return now }
+		n, err := j.WriteMetadata(ctx)
+
+		require.EqualError(t, err, "writing metadata of media d: copying object: "+assert.AnError.Error())
+		require.Equal(t, 1, n)
+		require.Equal(t, written+1, janitorMetric("written"))
+		queued, err := s.ZMembers(ks.pendingMetadata())
+		require.NoError(t, err)
+		require.Equal(t, []string{"d", "e"}, queued)
+		score, err := s.ZScore(ks.pendingMetadata(), "d")
+		require.NoError(t, err)
+		require.Equal(t, float64(now.UnixMilli()), score)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it fails if finding queued metadata fails", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.SetError("boom")
+
+		n, err := NewJanitor(rc, Namespace{}, nil, "bucket", cfg).WriteMetadata(ctx)
+
+		require.Zero(t, n)
+		require.EqualError(t, err, "scanning keys: boom")
+	})
+}
+
+func TestJanitor_Run(t *testing.T) {
+	t.Run("it stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := NewJanitor(nil, Namespace{}, nil, "bucket", JanitorConfig{Interval: time.Minute}).Run(ctx)
+
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"scoreplay/internal/tenant"
+)
+
+const tenantPrefix = "tenant:"
+
+var ErrNoTenant = errors.New("no tenant in context")
+
+// Namespace places the Redis keys of a deployment, so that several deployments can share a Redis.
+type Namespace struct {
+	// Prefix is prepended to every key.
+	Prefix string
+	// HashTags wraps the tenant of the keys in a hash tag, so that Redis Cluster stores all the
+	// keys of a tenant in the same slot.
+	HashTags bool
+}
+
+// NewNamespace creates the namespace of the keys. With hash tags, the prefix must not hold braces,
+// as Redis Cluster would hash the keys by the first pair of them.
+func NewNamespace(prefix string, hashTags bool) (Namespace, error) {
+	if hashTags && strings.ContainsAny(prefix, "{}") {
+		return Namespace{}, fmt.Errorf("key prefix %q holds braces, which would override the hash tags", prefix)
+	}
+
+	return Namespace{Prefix: prefix, HashTags: hashTags}, nil
+}
+
+// key returns the given key shared by all tenants.
+func (n Namespace) key(name string) string {
+	return n.Prefix + name
+}
+
+// keyspace returns the keyspace of the given tenant.
+func (n Namespace) keyspace(id string) keyspace {
+	return keyspace{ns: n, tenant: id}
+}
+
+// tenantKeyspace returns the keyspace of the tenant in the context.
+func (n Namespace) tenantKeyspace(ctx context.Context) (keyspace, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return keyspace{}, ErrNoTenant
+	}
+
+	return n.keyspace(id), nil
+}
+
+// tenantPattern matches the keys of every tenant in a SCAN.
+func (n Namespace) tenantPattern() string {
+	return escapePattern(n.Prefix+tenantPrefix) + "*"
+}
+
+// pendingUploadsPattern matches the pending uploads of every tenant in a SCAN, along with the keys
+// of the tags ending like them.
+func (n Namespace) pendingUploadsPattern() string {
+	return escapePattern(n.Prefix+tenantPrefix) + "*:" + escapePattern(pendingUploadsKey)
+}
+
+// pendingMetadataPattern matches the media queued for a metadata write of every tenant in a SCAN,
+// along with the keys of the tags ending like them.
+func (n Namespace) pendingMetadataPattern() string {
+	return escapePattern(n.Prefix+tenantPrefix) + "*:" + escapePattern(pendingMetadataKey)
+}
+
+// splitKey splits a key of a tenant into the tenant and the rest of the key.
+func (n Namespace) splitKey(key string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, n.Prefix+tenantPrefix)
+	if !ok {
+		return "", "", false
+	}
+	sep := ":"
+	if n.HashTags {
+		if rest, ok = strings.CutPrefix(rest, "{"); !ok {
+			return "", "", false
+		}
+		sep = "}:"
+	}
+	id, rest, ok := strings.Cut(rest, sep)
+	if !ok {
+		return "", "", false
+	}
+
+	return id, rest, true
+}
+
+// keyspace names the Redis keys and the objects of a single tenant. Every key the services touch
+// is built from the keyspace of the tenant in the context, so no tenant can reach the data of another.
+type keyspace struct {
+	ns     Namespace
+	tenant string
+}
+
+func (k keyspace) prefix() string {
+	if k.ns.HashTags {
+		return k.ns.Prefix + tenantPrefix + "{" + k.tenant + "}:"
+	}
+
+	return k.ns.Prefix + tenantPrefix + k.tenant + ":"
+}
+
+func (k keyspace) tags() string {
+	return k.prefix() + tagsKey
+}
+
+func (k keyspace) tag(tag Tag) string {
+	return k.prefix() + tagsPrefix + tag
+}
+
+func (k keyspace) related(tag Tag) string {
+	return k.prefix() + relatedPrefix + tag
+}
+
+func (k keyspace) media(key string) string {
+	return k.prefix() + mediaPrefix + key
+}
+
+// appendTagKeys appends the media set and the co-occurrence sorted set of every given tag, in
+// the order the scripts expect them.
+func (k keyspace) appendTagKeys(keys []string, tags []Tag) []string {
+	for _, tag := range tags {
+		keys = append(keys, k.tag(tag), k.related(tag))
+	}
+	return keys
+}
+
+// appendMediaKeys appends the hash of every given media.
+func (k keyspace) appendMediaKeys(keys []string, mediaKeys []string) []string {
+	for _, key := range mediaKeys {
+		keys = append(keys, k.media(key))
+	}
+	return keys
+}
+
+func (k keyspace) collections() string {
+	return k.prefix() + collectionsKey
+}
+
+func (k keyspace) collection(key string) string {
+	return k.prefix() + collectionPrefix + key
+}
+
+func (k keyspace) collectionItems(key string) string {
+	return k.collection(key) + itemsSuffix
+}
+
+func (k keyspace) idempotency(scope, key string) string {
+	return k.prefix() + idempotencyPrefix + scope + ":" + key
+}
+
+func (k keyspace) pendingUploads() string {
+	return k.prefix() + pendingUploadsKey
+}
+
+func (k keyspace) pendingMetadata() string {
+	return k.prefix() + pendingMetadataKey
+}
+
+// object returns the key of the object holding the given media in the bucket.
+func (k keyspace) object(key string) string {
+	return k.tenant + "/" + key
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"scoreplay/internal/tenant"
+)
+
+func TestNewNamespace(t *testing.T) {
+	t.Run("it accepts any prefix without hash tags", func(t *testing.T) {
+		ns, err := NewNamespace("{app}:", false)
+		require.NoError(t, err)
+		require.Equal(t, Namespace{Prefix: "{app}:"}, ns)
+	})
+
+	t.Run("it fails if the prefix holds braces with hash tags", func(t *testing.T) {
+		_, err := NewNamespace("{app}:", true)
+		require.EqualError(t, err, `key prefix "{app}:" holds braces, which would override the hash tags`)
+	})
+}
+
+func TestNamespace_TenantKeyspace(t *testing.T) {
+	t.Run("it fails without tenant", func(t *testing.T) {
+		_, err := Namespace{}.tenantKeyspace(context.Background())
+		require.ErrorIs(t, err, ErrNoTenant)
+	})
+
+	t.Run("it scopes every key to the tenant", func(t *testing.T) {
+		ks, err := Namespace{}.tenantKeyspace(tenant.WithID(context.Background(), "club"))
+		require.NoError(t, err)
+
+		require.Equal(t, "tenant:club:tags", ks.tags())
+		require.Equal(t, "tenant:club:tags:tag", ks.tag("tag"))
+		require.Equal(t, "tenant:club:related:tag", ks.related("tag"))
+		require.Equal(t, "tenant:club:media:key", ks.media("key"))
+		require.Equal(t, "tenant:club:collections", ks.collections())
+		require.Equal(t, "tenant:club:collection:key", ks.collection("key"))
+		require.Equal(t, "tenant:club:collection:key:items", ks.collectionItems("key"))
+		require.Equal(t, "tenant:club:uploads:pending", ks.pendingUploads())
+		require.Equal(t, "club/key", ks.object("key"))
+	})
+
+	t.Run("it prefixes every key", func(t *testing.T) {
+		ks := Namespace{Prefix: "app:"}.keyspace("club")
+
+		require.Equal(t, "app:tenant:club:media:key", ks.media("key"))
+		require.Equal(t, "app:tenant:club:uploads:pending", ks.pendingUploads())
+		require.Equal(t, "club/key", ks.object("key"))
+	})
+
+	t.Run("it wraps the tenant in a hash tag", func(t *testing.T) {
+		ks := Namespace{Prefix: "app:", HashTags: true}.keyspace("club")
+
+		require.Equal(t, "app:tenant:{club}:media:key", ks.media("key"))
+		require.Equal(t, "app:tenant:{club}:collection:key:items", ks.collectionItems("key"))
+		require.Equal(t, "club/key", ks.object("key"))
+	})
+}
+
+func TestNamespace_SplitKey(t *testing.T) {
+	for _, tc := range []struct {
+		ns   Namespace
+		key  string
+		id   string
+		rest string
+		ok   bool
+	}{
+		{ns: Namespace{}, key: "tenant:club:media:key", id: "club", rest: "media:key", ok: true},
+		{ns: Namespace{Prefix: "app:"}, key: "app:tenant:club:tags", id: "club", rest: "tags", ok: true},
+		{ns: Namespace{Prefix: "app:"}, key: "tenant:club:tags"},
+		{ns: Namespace{HashTags: true}, key: "tenant:{club}:media:key", id: "club", rest: "media:key", ok: true},
+		{ns: Namespace{HashTags: true}, key: "tenant:club:media:key"},
+		{ns: Namespace{}, key: "tenant:club"},
+	} {
+		id, rest, ok := tc.ns.splitKey(tc.key)
+		require.Equal(t, tc.id, id, tc.key)
+		require.Equal(t, tc.rest, rest, tc.key)
+		require.Equal(t, tc.ok, ok, tc.key)
+	}
+}
+
+func TestNamespace_TenantPattern(t *testing.T) {
+	require.Equal(t, "tenant:*", Namespace{}.tenantPattern())
+	require.Equal(t, `app\[1\]:tenant:*`, Namespace{Prefix: "app[1]:"}.tenantPattern())
+}
@@ -0,0 +1,48 @@
+-- Stores a new media record, indexes it by its tags, so that no tag index ever points at a media
+-- whose hash is missing, and marks its upload as pending. A media stored already is left alone,
+-- so that its tags are not counted twice.
+--
+-- KEYS[1]: media hash
+-- KEYS[2]: set of all tags
+-- KEYS[3]: sorted set of pending uploads
+-- KEYS[2+2i], KEYS[3+2i]: media set and co-occurrence sorted set of the i-th tag
+-- ARGV[1]: media id
+-- ARGV[2]: media name
+-- ARGV[3]: key of the object of the media
+-- ARGV[4]: creation time of the media, in milliseconds
+-- ARGV[5 ..]: tags
+--
+-- Returns 0 if the media exists already, 1 otherwise.
+
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return 0
+end
+
+local id, name = ARGV[1], ARGV[2]
+
+local tags = {}
+for i = 5, #ARGV do
+  tags[#tags + 1] = ARGV[i]
+end
+
+-- cjson encodes an empty table as an object.
+local field = '[]'
+if #tags > 0 then
+  field = cjson.encode(tags)
+end
+redis.call('HSET', KEYS[1], 'name', name, 'tags', field)
+for i, tag in ipairs(tags) do
+  redis.call('SADD', KEYS[2], tag)
+  redis.call('SADD', KEYS[2 + 2 * i], id)
+end
+-- Every pair of tags on the media counts as a co-occurrence for both of them.
+for i, tag in ipairs(tags) do
+  for _, other in ipairs(tags) do
+    if other ~= tag then
+      redis.call('ZINCRBY', KEYS[3 + 2 * i], 1, other)
+    end
+  end
+end
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[3])
+
+return 1
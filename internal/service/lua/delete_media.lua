@@ -0,0 +1,42 @@
+-- Deletes a media record, removing it from the indexes of its tags, from the co-occurrence counts
+-- and from the pending uploads. The caller reads the tags field beforehand to declare the keys of
+-- the tags, so the record is left alone if the field changed since.
+--
+-- KEYS[1]: media hash
+-- KEYS[2]: sorted set of pending uploads
+-- KEYS[1+2i], KEYS[2+2i]: media set and co-occurrence sorted set of the i-th tag
+-- ARGV[1]: media id
+-- ARGV[2]: key of the object of the media
+-- ARGV[3]: tags field read by the caller, empty if the record has none
+-- ARGV[4 ..]: tags of that field, without duplicates
+--
+-- Returns 0 if the media does not exist, -1 if its tags field changed, 1 otherwise.
+
+redis.call('ZREM', KEYS[2], ARGV[2])
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return 0
+end
+if (redis.call('HGET', KEYS[1], 'tags') or '') ~= ARGV[3] then
+  return -1
+end
+
+local id = ARGV[1]
+
+local tags = {}
+for i = 4, #ARGV do
+  tags[#tags + 1] = ARGV[i]
+end
+
+for i, tag in ipairs(tags) do
+  redis.call('SREM', KEYS[1 + 2 * i], id)
+  for _, other in ipairs(tags) do
+    if other ~= tag then
+      if tonumber(redis.call('ZINCRBY', KEYS[2 + 2 * i], -1, other)) <= 0 then
+        redis.call('ZREM', KEYS[2 + 2 * i], other)
+      end
+    end
+  end
+end
+redis.call('DEL', KEYS[1])
+
+return 1
@@ -0,0 +1,26 @@
+-- Rewrites the tags field of a media record from the tags escaped and joined by commas to a JSON
+-- array, as of schema version 2. A record migrated already is left alone.
+--
+-- KEYS[1]: media hash
+--
+-- Returns 1 if the record was rewritten, 0 otherwise.
+
+local field = redis.call('HGET', KEYS[1], 'tags')
+if not field or string.sub(field, 1, 1) == '[' then
+  return 0
+end
+
+local tags = {}
+for e in string.gmatch(field, '[^,]+') do
+  e = string.gsub(e, '%+', ' ')
+  tags[#tags + 1] = (string.gsub(e, '%%(%x%x)', function(h) return string.char(tonumber(h, 16)) end))
+end
+
+-- cjson encodes an empty table as an object.
+field = '[]'
+if #tags > 0 then
+  field = cjson.encode(tags)
+end
+redis.call('HSET', KEYS[1], 'tags', field)
+
+return 1
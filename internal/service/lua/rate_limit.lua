@@ -0,0 +1,29 @@
+-- Counts a request against a rate limit with the generic cell rate algorithm, which behaves like
+-- a token bucket refilled continuously, holding a single timestamp per client.
+--
+-- KEYS[1]: theoretical arrival time of the next request, in milliseconds
+-- ARGV[1]: number of requests allowed per period
+-- ARGV[2]: period in milliseconds
+--
+-- Returns whether the request is allowed, the number of requests remaining, the milliseconds to
+-- wait before retrying when denied, and the milliseconds until the limit is fully reset.
+
+local limit, period = tonumber(ARGV[1]), tonumber(ARGV[2])
+local interval = period / limit
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+  tat = now
+end
+
+local next = tat + interval
+local allowAt = next - period
+if allowAt > now then
+  return {0, 0, math.ceil(allowAt - now), math.ceil(tat - now)}
+end
+
+redis.call('SET', KEYS[1], string.format('%.3f', next), 'PX', math.ceil(next - now))
+return {1, math.floor((now - allowAt) / interval), 0, math.ceil(next - now)}
@@ -0,0 +1,13 @@
+-- Records the schema version, unless a newer one was recorded already by a concurrent migration.
+--
+-- KEYS[1]: schema version key
+-- ARGV[1]: schema version
+--
+-- Returns 1 if the version was recorded, 0 otherwise.
+
+if tonumber(redis.call('GET', KEYS[1]) or '0') >= tonumber(ARGV[1]) then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+
+return 1
@@ -0,0 +1,24 @@
+-- Stores a collection, replacing the one stored under its keys if any.
+--
+-- KEYS[1]: set of all collections
+-- KEYS[2]: collection hash
+-- KEYS[3]: collection items list
+-- ARGV[1]: collection id
+-- ARGV[2]: collection name
+-- ARGV[3]: media id of the cover, or empty if none
+-- ARGV[4 ..]: media ids of the items, in order
+--
+-- Returns 1.
+
+redis.call('DEL', KEYS[2], KEYS[3])
+redis.call('HSET', KEYS[2], 'name', ARGV[2])
+if ARGV[3] ~= '' then
+  redis.call('HSET', KEYS[2], 'cover', ARGV[3])
+end
+-- unpack is bounded by the stack of Lua, so long lists are pushed in chunks.
+for i = 4, #ARGV, 1000 do
+  redis.call('RPUSH', KEYS[3], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+redis.call('SADD', KEYS[1], ARGV[1])
+
+return 1
@@ -0,0 +1,49 @@
+-- Brings the index of a tag in line with the records of the given media: the media whose tags
+-- field carries the tag are added to it, and the others, including the media without record,
+-- removed from it.
+--
+-- KEYS[1]: per-tag media set
+-- KEYS[2]: set of all tags
+-- KEYS[2+i]: hash of the i-th media
+-- ARGV[1]: tag
+-- ARGV[1+i]: id of the i-th media
+--
+-- Returns the number of media added to or removed from the index.
+
+local tag = ARGV[1]
+
+-- decode decodes a tags field, a JSON array, or the tags escaped and joined by commas before
+-- schema version 2.
+local function decode(field)
+  if string.sub(field, 1, 1) == '[' then
+    return cjson.decode(field)
+  end
+  local tags = {}
+  for e in string.gmatch(field, '[^,]+') do
+    e = string.gsub(e, '%+', ' ')
+    tags[#tags + 1] = (string.gsub(e, '%%(%x%x)', function(h) return string.char(tonumber(h, 16)) end))
+  end
+  return tags
+end
+
+local function carries(field)
+  for _, t in ipairs(decode(field)) do
+    if t == tag then
+      return true
+    end
+  end
+  return false
+end
+
+local changed = 0
+for i = 2, #ARGV do
+  local field = redis.call('HGET', KEYS[1 + i], 'tags')
+  if field and carries(field) then
+    redis.call('SADD', KEYS[2], tag)
+    changed = changed + redis.call('SADD', KEYS[1], ARGV[i])
+  else
+    changed = changed + redis.call('SREM', KEYS[1], ARGV[i])
+  end
+end
+
+return changed
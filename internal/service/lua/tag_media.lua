@@ -0,0 +1,88 @@
+-- Adds and removes tags on a single media record, keeping the tag indexes and the
+-- co-occurrence counts in step with its tags field. The caller reads the tags field beforehand to
+-- declare the keys of the tags, so the record is left alone if the field changed since.
+--
+-- KEYS[1]: media hash
+-- KEYS[2]: set of all tags
+-- KEYS[1+2i], KEYS[2+2i]: media set and co-occurrence sorted set of the i-th tag given in ARGV
+-- ARGV[1]: media id
+-- ARGV[2]: tags field read by the caller, empty if the record has none
+-- ARGV[3]: number c of tags in that field
+-- ARGV[4]: number n of tags to add
+-- ARGV[5 .. 4+c]: tags of the field
+-- ARGV[5+c .. 4+c+n]: tags to add
+-- ARGV[5+c+n ..]: tags to remove
+--
+-- Returns 0 if the media does not exist, -1 if its tags field changed, 1 otherwise.
+
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return 0
+end
+if (redis.call('HGET', KEYS[1], 'tags') or '') ~= ARGV[2] then
+  return -1
+end
+
+local id, nField, nAdd = ARGV[1], tonumber(ARGV[3]), tonumber(ARGV[4])
+
+local setKeys, relatedKeys = {}, {}
+for i = 5, #ARGV do
+  setKeys[ARGV[i]], relatedKeys[ARGV[i]] = KEYS[1 + 2 * (i - 4)], KEYS[2 + 2 * (i - 4)]
+end
+
+local tags, carried = {}, {}
+for i = 5, 4 + nField do
+  local tag = ARGV[i]
+  if not carried[tag] then
+    tags[#tags + 1] = tag
+    carried[tag] = true
+  end
+end
+
+-- relate moves the co-occurrence counts between tag and every current tag by delta.
+local function relate(tag, delta)
+  for _, other in ipairs(tags) do
+    if other ~= tag then
+      for _, pair in ipairs({ { tag, other }, { other, tag } }) do
+        local key = relatedKeys[pair[1]]
+        if tonumber(redis.call('ZINCRBY', key, delta, pair[2])) <= 0 then
+          redis.call('ZREM', key, pair[2])
+        end
+      end
+    end
+  end
+end
+
+for i = 5 + nField, 4 + nField + nAdd do
+  local tag = ARGV[i]
+  if not carried[tag] then
+    relate(tag, 1)
+    tags[#tags + 1] = tag
+    carried[tag] = true
+    redis.call('SADD', KEYS[2], tag)
+    redis.call('SADD', setKeys[tag], id)
+  end
+end
+
+for i = 5 + nField + nAdd, #ARGV do
+  local tag = ARGV[i]
+  if carried[tag] then
+    for j, t in ipairs(tags) do
+      if t == tag then
+        table.remove(tags, j)
+        break
+      end
+    end
+    carried[tag] = nil
+    relate(tag, -1)
+    redis.call('SREM', setKeys[tag], id)
+  end
+end
+
+-- cjson encodes an empty table as an object.
+local field = '[]'
+if #tags > 0 then
+  field = cjson.encode(tags)
+end
+redis.call('HSET', KEYS[1], 'tags', field)
+
+return 1
@@ -0,0 +1,20 @@
+-- Updates the fields of an existing collection. Fields set to an empty value are removed.
+--
+-- KEYS[1]: collection hash
+-- ARGV: field and value pairs
+--
+-- Returns 0 if the collection does not exist, 1 otherwise.
+
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return 0
+end
+
+for i = 1, #ARGV, 2 do
+  if ARGV[i + 1] == '' then
+    redis.call('HDEL', KEYS[1], ARGV[i])
+  else
+    redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+  end
+end
+
+return 1
@@ -0,0 +1,57 @@
+-- Changes the ordered items of an existing collection.
+--
+-- KEYS[1]: collection hash
+-- KEYS[2]: collection items list
+-- KEYS[3 ..]: hashes of the media, in the order of their ids
+-- ARGV[1]: 'insert' to move the given media to a position, 'replace' to replace all items
+--          with them, or 'remove' to remove them
+-- ARGV[2]: zero-based position of the first inserted media, or -1 to append them
+-- ARGV[3 ..]: media ids
+--
+-- Returns 0 if the collection does not exist, the ids of the given media that do not exist
+-- if any, in which case nothing is changed, and 1 otherwise.
+
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return 0
+end
+
+local op, position = ARGV[1], tonumber(ARGV[2])
+
+local ids, given, unknown = {}, {}, {}
+for i = 3, #ARGV do
+  local id = ARGV[i]
+  if not given[id] then
+    given[id] = true
+    ids[#ids + 1] = id
+    if op ~= 'remove' and redis.call('EXISTS', KEYS[i]) == 0 then
+      unknown[#unknown + 1] = id
+    end
+  end
+end
+if #unknown > 0 then
+  return unknown
+end
+
+local items = {}
+if op ~= 'replace' then
+  for _, id in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+    if not given[id] then
+      items[#items + 1] = id
+    end
+  end
+end
+if op ~= 'remove' then
+  if position < 0 or position > #items then
+    position = #items
+  end
+  for i, id in ipairs(ids) do
+    table.insert(items, position + i, id)
+  end
+end
+
+redis.call('DEL', KEYS[2])
+for i = 1, #items, 1000 do
+  redis.call('RPUSH', KEYS[2], unpack(items, i, math.min(i + 999, #items)))
+end
+
+return 1
@@ -1,24 +1,55 @@
 package service
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
 	"github.com/redis/rueidis"
+	"github.com/rs/zerolog/log"
 )
 
 const (
-	tagsKey     = "tags"
-	tagsPrefix  = tagsKey + ":"
-	mediaPrefix = "media:"
-	nameField   = "name"
-	tagsField   = "tags"
+	tagsKey       = "tags"
+	tagsPrefix    = tagsKey + ":"
+	relatedPrefix = "related:"
+	mediaKey      = "media"
+	mediaPrefix   = mediaKey + ":"
+	nameField     = "name"
+	tagsField     = "tags"
+	// The user metadata of the objects, encoded like the fields of the media records.
+	nameMetadata = "name"
+	tagsMetadata = "tags"
+
+	defaultRelatedTagsLimit = 10
+	// retagBatchSize is the number of media retagged per round trip when a tag is retired.
+	retagBatchSize = 500
+
+	// tagsChanged is the reply of the scripts declaring the keys of the tags of a media, when the
+	// tags changed since the caller read them.
+	tagsChanged = -1
+	// maxTagsReads is the number of times the tags of a media are read before giving up on a
+	// script that keeps finding them changed.
+	maxTagsReads = 5
+)
+
+var (
+	ErrTagNotFound   = fmt.Errorf("tag %w", ErrNotFound)
+	ErrMediaNotFound = fmt.Errorf("media %w", ErrNotFound)
+	ErrUnknownTags   = errors.New("unknown tags")
+	ErrMediaBusy     = fmt.Errorf("%w: media tags changing concurrently", ErrConflict)
 )
 
 type presignClient interface {
@@ -29,27 +60,56 @@ type mediaService struct {
 	bucket        string
 	endpointURL   url.URL
 	generateUUID  func() (uuid.UUID, error)
+	now           func() time.Time
+	ns            Namespace
 	presignClient presignClient
+	repairIndexes bool
+	replicaClient rueidis.Client
 	rueidisClient rueidis.Client
+	tagRules      TagRules
 }
 
-func NewMediaService(rueidisClient rueidis.Client, presignClient presignClient, endpointURL url.URL, bucket string) *mediaService {
+// NewMediaService creates the media service. When repairIndexes is set, listing media removes
+// from the tag index the media whose record is missing. When replicaClient is set, the media are
+// listed from the replicas it reaches.
+func NewMediaService(rueidisClient, replicaClient rueidis.Client, ns Namespace, presignClient presignClient, endpointURL url.URL, bucket string, tagRules TagRules, repairIndexes bool) *mediaService {
 	return &mediaService{
 		bucket:        bucket,
 		endpointURL:   endpointURL,
 		generateUUID:  uuid.NewV7,
+		now:           time.Now,
+		ns:            ns,
 		presignClient: presignClient,
+		repairIndexes: repairIndexes,
+		replicaClient: replicaClient,
 		rueidisClient: rueidisClient,
+		tagRules:      tagRules,
 	}
 }
 
 type CreateTagParams struct {
-	Name string
+	Name           string
+	IdempotencyKey string
 }
 
 func (s mediaService) CreateTag(ctx context.Context, params CreateTagParams) error {
-	if err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Sadd().Key(tagsKey).Member(params.Name).Build()).Error(); err != nil {
-		return fmt.Errorf("creating tag: %w", err)
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return err
+	}
+	name, err := s.tagRules.Normalize(params.Name)
+	if err != nil {
+		return err
+	}
+	// Creating a tag is idempotent already, the key only needs to be checked against reuse.
+	if params.IdempotencyKey != "" {
+		if _, _, err := claimIdempotencyKey(ctx, s.rueidisClient, ks.idempotency(tagsKey, params.IdempotencyKey), fingerprint(name), ""); err != nil {
+			return err
+		}
+	}
+
+	if err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Sadd().Key(ks.tags()).Member(name).Build()).Error(); err != nil {
+		return fmt.Errorf("creating tag: %w", unavailable(err))
 	}
 
 	return nil
@@ -59,16 +119,152 @@ type Tag = string
 type ListTagsResult []Tag
 
 func (s mediaService) ListTags(ctx context.Context) (ListTagsResult, error) {
-	tags, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Smembers().Key(tagsKey).Build()).AsStrSlice()
+	ks, err := s.ns.tenantKeyspace(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("getting tags from redis: %w", err)
+		return nil, err
+	}
+
+	tags, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Smembers().Key(ks.tags()).Build()).AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("getting tags from redis: %w", unavailable(err))
 	}
 
 	return tags, nil
 }
 
+type RenameTagParams struct {
+	From Tag
+	To   Tag
+}
+
+// RenameTag moves every media carrying the tag From to the tag To, creating it if needed, and
+// deletes From.
+func (s mediaService) RenameTag(ctx context.Context, params RenameTagParams) error {
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return err
+	}
+	to, err := s.tagRules.Normalize(params.To)
+	if err != nil {
+		return err
+	}
+	from := s.tagRules.Lookup(params.From)
+	if slices.Contains(from, to) {
+		return nil
+	}
+	if err := s.CreateTag(ctx, CreateTagParams{Name: to}); err != nil {
+		return err
+	}
+
+	return s.retireLookup(ctx, ks, from, []Tag{to})
+}
+
+// DeleteTag removes the tag from every media carrying it, and deletes it.
+func (s mediaService) DeleteTag(ctx context.Context, name string) error {
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.retireLookup(ctx, ks, s.tagRules.Lookup(name), nil)
+}
+
+// retireLookup retires the first of the names a tag may be stored under that exists.
+func (s mediaService) retireLookup(ctx context.Context, ks keyspace, names []Tag, replacements []Tag) error {
+	for _, name := range names {
+		if err := s.retireTag(ctx, ks, name, replacements); !errors.Is(err, ErrTagNotFound) {
+			return err
+		}
+	}
+
+	return ErrTagNotFound
+}
+
+// retireTag replaces the tag with the given tags on every media carrying it, and then deletes it.
+// Each media is retagged atomically, but not the whole tag, so a failed run can simply be retried.
+func (s mediaService) retireTag(ctx context.Context, ks keyspace, tag Tag, replacements []Tag) error {
+	resps := s.rueidisClient.DoMulti(ctx,
+		s.rueidisClient.B().Sismember().Key(ks.tags()).Member(tag).Build(),
+		s.rueidisClient.B().Smembers().Key(ks.tag(tag)).Build(),
+	)
+	exists, err := resps[0].AsBool()
+	if err != nil {
+		return fmt.Errorf("checking tag: %w", unavailable(err))
+	}
+	keys, err := resps[1].AsStrSlice()
+	if err != nil {
+		return fmt.Errorf("getting media keys from redis: %w", unavailable(err))
+	}
+	if !exists && len(keys) == 0 {
+		return ErrTagNotFound
+	}
+
+	var errs []error
+	for chunk := range slices.Chunk(keys, retagBatchSize) {
+		for _, item := range s.tagMediaBatch(ctx, ks, TagMediaBatchParams{Keys: chunk, Add: replacements, Remove: []Tag{tag}}) {
+			// Media without record only need to leave the index, which is deleted below.
+			if item.Err != nil && !errors.Is(item.Err, ErrMediaNotFound) {
+				errs = append(errs, fmt.Errorf("retagging media %s: %w", item.Key, item.Err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for i, resp := range s.rueidisClient.DoMulti(ctx,
+		s.rueidisClient.B().Srem().Key(ks.tags()).Member(tag).Build(),
+		s.rueidisClient.B().Del().Key(ks.tag(tag)).Build(),
+		s.rueidisClient.B().Del().Key(ks.related(tag)).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return fmt.Errorf("deleting tag, command %d: %w", i, unavailable(err))
+		}
+	}
+
+	return nil
+}
+
+type ListRelatedTagsParams struct {
+	Tag   Tag
+	Limit int
+}
+type ListRelatedTagsResult []TagCount
+
+// ListRelatedTags returns the tags most often attached to the same media as the given tag.
+func (s mediaService) ListRelatedTags(ctx context.Context, params ListRelatedTagsParams) (ListRelatedTagsResult, error) {
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultRelatedTagsLimit
+	}
+
+	var scores []rueidis.ZScore
+	for _, name := range s.tagRules.Lookup(params.Tag) {
+		scores, err = s.rueidisClient.Do(ctx, s.rueidisClient.B().Zrange().Key(ks.related(name)).Min("0").Max(strconv.Itoa(limit-1)).Rev().Withscores().Build()).AsZScores()
+		if err != nil {
+			return nil, fmt.Errorf("getting related tags from redis: %w", unavailable(err))
+		}
+		if len(scores) > 0 {
+			break
+		}
+	}
+
+	result := make(ListRelatedTagsResult, len(scores))
+	for i, score := range scores {
+		result[i] = TagCount{Tag: score.Member, Count: int(score.Score)}
+	}
+
+	return result, nil
+}
+
 type ListMediaParams struct {
-	Tag string
+	Tag    string
+	Facets bool
 }
 type MediaRecord struct {
 	Key  string
@@ -76,95 +272,633 @@ type MediaRecord struct {
 	URL  url.URL
 	Tags []string
 }
-type ListMediaResult []MediaRecord
+type TagCount struct {
+	Tag   Tag
+	Count int
+}
+type ListMediaResult struct {
+	Media  []MediaRecord
+	Facets []TagCount
+}
 
 func (s mediaService) ListMedia(ctx context.Context, params ListMediaParams) (ListMediaResult, error) {
-	keys, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Smembers().Key(tagsPrefix+params.Tag).Build()).AsStrSlice()
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return ListMediaResult{}, err
+	}
+
+	// The replicas may lag behind, so a media created or tagged a moment ago may be missing.
+	rc := s.rueidisClient
+	if s.replicaClient != nil {
+		rc = s.replicaClient
+	}
+	var tag Tag
+	var keys []string
+	for _, tag = range s.tagRules.Lookup(params.Tag) {
+		keys, err = rc.Do(ctx, rc.B().Smembers().Key(ks.tag(tag)).Build()).AsStrSlice()
+		if err != nil {
+			return ListMediaResult{}, fmt.Errorf("getting media keys from redis: %w", unavailable(err))
+		}
+		if len(keys) > 0 {
+			break
+		}
+	}
+
+	media, missing, err := s.getMedia(ctx, rc, ks, keys)
 	if err != nil {
-		return nil, fmt.Errorf("getting media keys from redis: %w", err)
+		return ListMediaResult{}, err
+	}
+	if s.repairIndexes && len(missing) > 0 {
+		s.removeDanglingMedia(ctx, ks, tag, missing)
+	}
+
+	result := ListMediaResult{Media: media}
+	if params.Facets {
+		result.Facets = countFacets(media, tag)
 	}
 
+	return result, nil
+}
+
+// getMedia fetches the records of the given media in a single round trip. The media whose record
+// does not exist are skipped, and their keys returned apart.
+func (s mediaService) getMedia(ctx context.Context, rc rueidis.Client, ks keyspace, keys []string) ([]MediaRecord, []string, error) {
 	cmds := make(rueidis.Commands, len(keys))
-	result := make(ListMediaResult, len(cmds))
+	media := make([]MediaRecord, 0, len(cmds))
+	var missing []string
 	for i, key := range keys {
-		cmds[i] = s.rueidisClient.B().Hgetall().Key(mediaPrefix + key).Build()
+		cmds[i] = rc.B().Hgetall().Key(ks.media(key)).Build()
 	}
-	for i, resp := range s.rueidisClient.DoMulti(ctx, cmds...) {
+	for i, resp := range rc.DoMulti(ctx, cmds...) {
 		if err := resp.Error(); err != nil {
-			return nil, fmt.Errorf("getting media record %d: %w", i, err)
+			return nil, nil, fmt.Errorf("getting media record %d: %w", i, unavailable(err))
 		}
 
 		record, err := resp.AsStrMap()
 		if err != nil {
-			return nil, fmt.Errorf("decoding media record %d: %w", i, err)
+			return nil, nil, fmt.Errorf("decoding media record %d: %w", i, err)
+		}
+		if len(record) == 0 {
+			missing = append(missing, keys[i])
+			continue
 		}
 
-		encodedTags := strings.Split(record[tagsField], ",")
-		tags := make([]string, len(encodedTags))
-		for i, encodedTag := range encodedTags {
-			tag, err := url.QueryUnescape(encodedTag)
-			if err != nil {
-				return nil, fmt.Errorf("decoding tag %d: %w", i, err)
-			}
-			tags[i] = tag
+		tags, err := decodeTags(record[tagsField])
+		if err != nil {
+			return nil, nil, err
 		}
 
-		result[i] = MediaRecord{
+		media = append(media, MediaRecord{
 			Key:  keys[i],
 			Name: record[nameField],
 			Tags: tags,
-			URL:  s.endpointURL,
+			URL:  s.mediaURL(ks, keys[i]),
+		})
+	}
+
+	return media, missing, nil
+}
+
+// removeDanglingMedia removes the given missing media from the index of the tag. The repair is
+// best effort: failing it does not fail the listing, which already skipped them.
+func (s mediaService) removeDanglingMedia(ctx context.Context, ks keyspace, tag Tag, missing []string) {
+	_ = syncTagIndex(ctx, s.rueidisClient, ks, tag, missing)
+}
+
+// syncTagIndex adds the given media to the index of the tag if their record carries it, and
+// removes them from it otherwise.
+func syncTagIndex(ctx context.Context, rc rueidis.Client, ks keyspace, tag Tag, keys []string) error {
+	scriptKeys := ks.appendMediaKeys([]string{ks.tag(tag), ks.tags()}, keys)
+	if err := syncTagIndexScript.Exec(ctx, rc, scriptKeys, append([]string{tag}, keys...)).Error(); err != nil {
+		return fmt.Errorf("syncing tag index: %w", unavailable(err))
+	}
+
+	return nil
+}
+
+// deleteMedia deletes the record of the given media along with its index entries and its
+// pending upload. The script is given the keys of the tags the record carried when read, and the
+// media is read again if they changed in between.
+func deleteMedia(ctx context.Context, rc rueidis.Client, ks keyspace, key string) error {
+	for range maxTagsReads {
+		fields, err := readTagsFields(ctx, rc, ks, []string{key})
+		if err != nil {
+			return err
+		}
+		tags, err := decodeTags(fields[0])
+		if err != nil {
+			return err
+		}
+		tags = slices.Compact(slices.Sorted(slices.Values(tags)))
+
+		keys := ks.appendTagKeys([]string{ks.media(key), ks.pendingUploads()}, tags)
+		args := append([]string{key, ks.object(key), fields[0]}, tags...)
+		deleted, err := deleteMediaScript.Exec(ctx, rc, keys, args).AsInt64()
+		if err != nil {
+			return fmt.Errorf("deleting media: %w", unavailable(err))
+		}
+		if deleted != tagsChanged {
+			return nil
 		}
-		result[i].URL.Path = path.Join("/", result[i].URL.Path, s.bucket, keys[i])
 	}
 
-	return result, nil
+	return fmt.Errorf("deleting media: %w", ErrMediaBusy)
+}
+
+// readTagsFields reads the tags field of every given media, empty if the media has none.
+func readTagsFields(ctx context.Context, rc rueidis.Client, ks keyspace, keys []string) ([]string, error) {
+	cmds := make(rueidis.Commands, len(keys))
+	for i, key := range keys {
+		cmds[i] = rc.B().Hget().Key(ks.media(key)).Field(tagsField).Build()
+	}
+	fields := make([]string, len(keys))
+	for i, resp := range rc.DoMulti(ctx, cmds...) {
+		field, err := resp.ToString()
+		if err != nil && !rueidis.IsRedisNil(err) {
+			return nil, fmt.Errorf("getting media tags: %w", unavailable(err))
+		}
+		fields[i] = field
+	}
+
+	return fields, nil
+}
+
+// encodeTags encodes the given tags into the tags field of a media record, a JSON array.
+func encodeTags(tags []Tag) string {
+	if len(tags) == 0 {
+		return "[]"
+	}
+	field, _ := json.Marshal(tags)
+
+	return string(field)
+}
+
+// decodeTags decodes the tags field of a media record. Records written before schema version 2
+// hold the tags escaped and joined by commas instead of a JSON array.
+func decodeTags(field string) ([]Tag, error) {
+	if !strings.HasPrefix(field, "[") {
+		return splitTags(field)
+	}
+	var tags []Tag
+	if err := json.Unmarshal([]byte(field), &tags); err != nil {
+		return nil, fmt.Errorf("decoding tags: %w", err)
+	}
+
+	return tags, nil
+}
+
+// joinTags escapes the given tags and joins them by commas, which keeps them ASCII.
+func joinTags(tags []Tag) string {
+	escapedTags := make([]string, len(tags))
+	for i, tag := range tags {
+		escapedTags[i] = url.QueryEscape(tag)
+	}
+
+	return strings.Join(escapedTags, ",")
+}
+
+// splitTags splits tags joined by joinTags.
+func splitTags(joined string) ([]Tag, error) {
+	var escapedTags []string
+	if joined != "" {
+		escapedTags = strings.Split(joined, ",")
+	}
+	tags := make([]Tag, len(escapedTags))
+	for i, escapedTag := range escapedTags {
+		tag, err := url.QueryUnescape(escapedTag)
+		if err != nil {
+			return nil, fmt.Errorf("decoding tag %d: %w", i, err)
+		}
+		tags[i] = tag
+	}
+
+	return tags, nil
+}
+
+// mediaCreatedAt returns the time the given media was created at, as encoded in its UUIDv7 key.
+func mediaCreatedAt(key string) (time.Time, bool) {
+	id, err := uuid.Parse(key)
+	if err != nil || id.Version() != 7 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(id.Time().UnixTime()), true
+}
+
+// mediaURL returns the URL of the object holding the given media.
+func (s mediaService) mediaURL(ks keyspace, key string) url.URL {
+	u := s.endpointURL
+	u.Path = path.Join("/", u.Path, s.bucket, ks.object(key))
+	return u
+}
+
+// countFacets counts how many of the given media carry each tag other than the searched one,
+// most frequent first.
+func countFacets(media []MediaRecord, searched Tag) []TagCount {
+	counts := make(map[Tag]int)
+	for _, m := range media {
+		for _, tag := range m.Tags {
+			if tag != searched {
+				counts[tag]++
+			}
+		}
+	}
+
+	facets := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		facets = append(facets, TagCount{Tag: tag, Count: count})
+	}
+	slices.SortFunc(facets, func(a, b TagCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+
+	return facets
 }
 
 type CreateMediaParams struct {
-	Name string   `json:"name"`
-	Tags []string `json:"tags"`
+	Name           string   `json:"name"`
+	Tags           []string `json:"tags"`
+	IdempotencyKey string   `json:"-"`
+}
+type CreateMediaResult struct {
+	Key          string
+	URL          string
+	Method       string
+	SignedHeader http.Header
 }
-type CreateMediaResult = v4.PresignedHTTPRequest
 
 func (s mediaService) CreateMedia(ctx context.Context, params CreateMediaParams) (*CreateMediaResult, error) {
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if params.Tags, err = s.tagRules.NormalizeAll(params.Tags); err != nil {
+		return nil, err
+	}
+	if err := s.checkTags(ctx, ks, params.Tags); err != nil {
+		return nil, err
+	}
+
+	result, err := s.presignUpload(ctx, ks, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var idempotencyKey string
+	if params.IdempotencyKey != "" {
+		idempotencyKey = ks.idempotency(mediaKey, params.IdempotencyKey)
+		earlier, replay, err := claimIdempotencyKey(ctx, s.rueidisClient, idempotencyKey, fingerprint(params), result.Key)
+		if err != nil {
+			return nil, err
+		}
+		// The earlier request registered the media, whose upload only needs to be signed again.
+		if replay {
+			return s.presignObject(ctx, ks, earlier, params)
+		}
+	}
+
+	exec := createMediaExec(ks, result.Key, params)
+	if err := createMediaScript.Exec(ctx, s.rueidisClient, exec.Keys, exec.Args).Error(); err != nil {
+		if idempotencyKey != "" {
+			releaseIdempotencyKey(ctx, s.rueidisClient, idempotencyKey)
+		}
+		return nil, fmt.Errorf("storing media: %w", unavailable(err))
+	}
+
+	return result, nil
+}
+
+type CreateMediaBatchItem struct {
+	Result *CreateMediaResult
+	Err    error
+}
+type CreateMediaBatchResult []CreateMediaBatchItem
+
+// CreateMediaBatch registers every item in a single pipelined round trip, each one atomically by its
+// own script run. Items fail independently, so the error of each one is reported in its own entry
+// of the result.
+func (s mediaService) CreateMediaBatch(ctx context.Context, params []CreateMediaParams) CreateMediaBatchResult {
+	result := make(CreateMediaBatchResult, len(params))
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err != nil {
+		for i := range result {
+			result[i].Err = err
+		}
+		return result
+	}
+
+	params = slices.Clone(params)
+	for i := range params {
+		if params[i].Tags, err = s.tagRules.NormalizeAll(params[i].Tags); err != nil {
+			result[i].Err = err
+		}
+	}
+	if err := s.checkBatchTags(ctx, ks, params, result); err != nil {
+		for i := range result {
+			if result[i].Err == nil {
+				result[i].Err = err
+			}
+		}
+		return result
+	}
+
+	var execs []rueidis.LuaExec
+	// owners maps every script run to its item.
+	var owners []int
+	for i, p := range params {
+		if result[i].Err != nil {
+			continue
+		}
+		upload, err := s.presignUpload(ctx, ks, p)
+		if err != nil {
+			result[i].Err = err
+			continue
+		}
+		result[i].Result = upload
+
+		execs = append(execs, createMediaExec(ks, upload.Key, p))
+		owners = append(owners, i)
+	}
+	if len(execs) == 0 {
+		return result
+	}
+
+	for j, resp := range createMediaScript.ExecMulti(ctx, s.rueidisClient, execs...) {
+		if err := resp.Error(); err != nil {
+			result[owners[j]].Result = nil
+			result[owners[j]].Err = fmt.Errorf("storing media: %w", unavailable(err))
+		}
+	}
+
+	return result
+}
+
+// checkBatchTags reports the unknown tags of every item of a batch, checking all of them at once.
+func (s mediaService) checkBatchTags(ctx context.Context, ks keyspace, params []CreateMediaParams, result CreateMediaBatchResult) error {
+	var tags []Tag
+	for i, p := range params {
+		if result[i].Err == nil {
+			tags = append(tags, p.Tags...)
+		}
+	}
+	slices.Sort(tags)
+	unknown, err := s.unknownTags(ctx, ks, slices.Compact(tags))
+	if err != nil || len(unknown) == 0 {
+		return err
+	}
+
+	for i, p := range params {
+		if result[i].Err != nil {
+			continue
+		}
+		var itemUnknown []Tag
+		for _, tag := range p.Tags {
+			if slices.Contains(unknown, tag) {
+				itemUnknown = append(itemUnknown, tag)
+			}
+		}
+		if len(itemUnknown) > 0 {
+			result[i].Err = &UnknownTagsError{Tags: itemUnknown}
+		}
+	}
+
+	return nil
+}
+
+// checkTags makes sure the given tags were created beforehand, when tags are strict.
+func (s mediaService) checkTags(ctx context.Context, ks keyspace, tags []Tag) error {
+	unknown, err := s.unknownTags(ctx, ks, tags)
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return &UnknownTagsError{Tags: unknown}
+	}
+
+	return nil
+}
+
+// unknownTags returns the given tags that were never created, when tags are strict.
+func (s mediaService) unknownTags(ctx context.Context, ks keyspace, tags []Tag) ([]Tag, error) {
+	if !s.tagRules.strict || len(tags) == 0 {
+		return nil, nil
+	}
+
+	created, err := s.rueidisClient.Do(ctx, s.rueidisClient.B().Smismember().Key(ks.tags()).Member(tags...).Build()).AsIntSlice()
+	if err != nil {
+		return nil, fmt.Errorf("checking tags: %w", unavailable(err))
+	}
+
+	var unknown []Tag
+	for i, c := range created {
+		if c == 0 {
+			unknown = append(unknown, tags[i])
+		}
+	}
+
+	return unknown, nil
+}
+
+// UnknownTagsError lists the tags that were never created, when tags are strict.
+type UnknownTagsError struct {
+	Tags []Tag
+}
+
+func (e *UnknownTagsError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnknownTags, strings.Join(e.Tags, ", "))
+}
+
+func (e *UnknownTagsError) Unwrap() error {
+	return ErrUnknownTags
+}
+
+// presignUpload allocates a key for a new media item and presigns its upload.
+func (s mediaService) presignUpload(ctx context.Context, ks keyspace, params CreateMediaParams) (*CreateMediaResult, error) {
 	key, err := s.generateUUID()
 	if err != nil {
 		return nil, fmt.Errorf("generating UUID: %w", err)
 	}
 
-	keyStr := key.String()
+	return s.presignObject(ctx, ks, key.String(), params)
+}
+
+// presignObject presigns the upload of the given media. Its name and tags are bound into the
+// request as user metadata, so that the object alone is enough to rebuild the media.
+func (s mediaService) presignObject(ctx context.Context, ks keyspace, key string, params CreateMediaParams) (*CreateMediaResult, error) {
+	objectKey := ks.object(key)
 	request, err := s.presignClient.PresignPutObject(ctx, &s3.PutObjectInput{
-		Bucket: &s.bucket,
-		Key:    &keyStr,
+		Bucket:   &s.bucket,
+		Key:      &objectKey,
+		Metadata: objectMetadata(params),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("presigning put object: %w", err)
 	}
 
-	encodedTags := make([]string, len(params.Tags))
-	for i, tag := range params.Tags {
-		encodedTags[i] = url.QueryEscape(tag)
+	return &CreateMediaResult{
+		Key:          key,
+		URL:          request.URL,
+		Method:       request.Method,
+		SignedHeader: request.SignedHeader,
+	}, nil
+}
+
+// objectMetadata returns the user metadata of the object of the given media. The values are
+// escaped, as they must be ASCII.
+func objectMetadata(params CreateMediaParams) map[string]string {
+	return map[string]string{
+		nameMetadata: url.QueryEscape(params.Name),
+		tagsMetadata: joinTags(params.Tags),
 	}
+}
 
-	cmds := make(rueidis.Commands, 0, len(params.Tags)*2+1)
-	cmds = append(cmds,
-		s.rueidisClient.B().Hset().Key(mediaPrefix+keyStr).FieldValue().
-			FieldValue(nameField, params.Name).
-			FieldValue(tagsField, strings.Join(encodedTags, ",")).
-			Build(),
-	)
-	for _, tag := range params.Tags {
-		cmds = append(cmds,
-			s.rueidisClient.B().Sadd().Key(tagsKey).Member(tag).Build(),
-			s.rueidisClient.B().Sadd().Key(tagsPrefix+tag).Member(keyStr).Build(),
-		)
+// copySource returns the source of a copy of the given object, URL-encoded.
+func copySource(bucket, key string) string {
+	return (&url.URL{Path: bucket + "/" + key}).EscapedPath()
+}
+
+// queueMetadata queues the given media for the janitor, which writes their current name and tags
+// into the metadata of their objects, so that a reindex rebuilds the media as they are. The
+// records stay the reference, so failures are only logged.
+func (s mediaService) queueMetadata(ctx context.Context, ks keyspace, keys []string) {
+	if len(keys) == 0 {
+		return
 	}
-	for i, resp := range s.rueidisClient.DoMulti(ctx, cmds...) {
-		if err := resp.Error(); err != nil {
-			return nil, fmt.Errorf("executing command %d: %w", i, err)
+	score := float64(s.now().UnixMilli())
+	cmd := s.rueidisClient.B().Zadd().Key(ks.pendingMetadata()).ScoreMember()
+	for _, key := range keys {
+		cmd = cmd.ScoreMember(score, key)
+	}
+	if err := s.rueidisClient.Do(ctx, cmd.Build()).Error(); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Queueing object metadata")
+	}
+}
+
+// createMediaExec builds the script run storing a media record and indexing it by its tags.
+func createMediaExec(ks keyspace, key string, params CreateMediaParams) rueidis.LuaExec {
+	createdAt, ok := mediaCreatedAt(key)
+	if !ok {
+		createdAt = time.Now()
+	}
+	keys := ks.appendTagKeys([]string{ks.media(key), ks.tags(), ks.pendingUploads()}, params.Tags)
+	args := make([]string, 0, 4+len(params.Tags))
+	args = append(args, key, params.Name, ks.object(key), strconv.FormatInt(createdAt.UnixMilli(), 10))
+	args = append(args, params.Tags...)
+
+	return rueidis.LuaExec{Keys: keys, Args: args}
+}
+
+type TagMediaBatchParams struct {
+	Keys   []string
+	Add    []Tag
+	Remove []Tag
+}
+type TagMediaBatchItem struct {
+	Key string
+	Err error
+}
+type TagMediaBatchResult []TagMediaBatchItem
+
+// TagMediaBatch adds and removes tags on every given media. Each media is updated atomically by
+// its own script run, and all runs share a single pipelined round trip.
+func (s mediaService) TagMediaBatch(ctx context.Context, params TagMediaBatchParams) TagMediaBatchResult {
+	result := make(TagMediaBatchResult, len(params.Keys))
+	ks, err := s.ns.tenantKeyspace(ctx)
+	if err == nil {
+		params.Add, err = s.tagRules.NormalizeAll(params.Add)
+	}
+	if err == nil {
+		err = s.checkTags(ctx, ks, params.Add)
+	}
+	if err != nil {
+		for i, key := range params.Keys {
+			result[i] = TagMediaBatchItem{Key: key, Err: err}
+		}
+		return result
+	}
+	params.Remove = s.tagRules.LookupAll(params.Remove)
+
+	return s.tagMediaBatch(ctx, ks, params)
+}
+
+// tagMediaBatch runs TagMediaBatch on tags already normalised and checked.
+func (s mediaService) tagMediaBatch(ctx context.Context, ks keyspace, params TagMediaBatchParams) TagMediaBatchResult {
+	result := make(TagMediaBatchResult, len(params.Keys))
+
+	// The script is given the keys of the tags every media carried when read, and the media whose
+	// tags changed in between are read again.
+	pending := make([]int, len(params.Keys))
+	for i, key := range params.Keys {
+		result[i].Key = key
+		pending[i] = i
+	}
+	for range maxTagsReads {
+		if pending = s.tagMedia(ctx, ks, params, pending, result); len(pending) == 0 {
+			break
+		}
+	}
+	for _, i := range pending {
+		result[i].Err = fmt.Errorf("updating tags: %w", ErrMediaBusy)
+	}
+
+	var tagged []string
+	for _, item := range result {
+		if item.Err == nil {
+			tagged = append(tagged, item.Key)
+		}
+	}
+	s.queueMetadata(ctx, ks, tagged)
+
+	return result
+}
+
+// tagMedia runs the tag script on the given media of the batch, recording their outcome. It
+// returns the media whose tags changed since they were read.
+func (s mediaService) tagMedia(ctx context.Context, ks keyspace, params TagMediaBatchParams, indexes []int, result TagMediaBatchResult) []int {
+	keys := make([]string, len(indexes))
+	for j, i := range indexes {
+		keys[j] = params.Keys[i]
+	}
+	fields, err := readTagsFields(ctx, s.rueidisClient, ks, keys)
+	if err != nil {
+		for _, i := range indexes {
+			result[i].Err = err
+		}
+		return nil
+	}
+
+	var execs []rueidis.LuaExec
+	var run []int
+	for j, i := range indexes {
+		tags, err := decodeTags(fields[j])
+		if err != nil {
+			result[i].Err = err
+			continue
+		}
+		argTags := slices.Concat(tags, params.Add, params.Remove)
+		execs = append(execs, rueidis.LuaExec{
+			Keys: ks.appendTagKeys([]string{ks.media(keys[j]), ks.tags()}, argTags),
+			Args: append([]string{keys[j], fields[j], strconv.Itoa(len(tags)), strconv.Itoa(len(params.Add))}, argTags...),
+		})
+		run = append(run, i)
+	}
+
+	var changed []int
+	for j, resp := range tagMediaScript.ExecMulti(ctx, s.rueidisClient, execs...) {
+		i := run[j]
+		applied, err := resp.AsInt64()
+		switch {
+		case err != nil:
+			result[i].Err = fmt.Errorf("updating tags: %w", unavailable(err))
+		case applied == tagsChanged:
+			changed = append(changed, i)
+		case applied == 0:
+			result[i].Err = fmt.Errorf("%w: %s", ErrMediaNotFound, params.Keys[i])
 		}
 	}
 
-	return request, nil
+	return changed
 }
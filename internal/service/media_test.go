@@ -4,7 +4,11 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -15,12 +19,18 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
+
+	"scoreplay/internal/tenant"
 )
 
 func pT[T any](v T) *T {
 	return &v
 }
 
+func tenantContext() (context.Context, keyspace) {
+	return tenant.WithID(context.Background(), "club"), keyspace{tenant: "club"}
+}
+
 func parseURL(t *testing.T, s string) url.URL {
 	t.Helper()
 	u, err := url.Parse(s)
@@ -44,13 +54,35 @@ func mockUUID(m *mock.Mock) func() (uuid.UUID, error) {
 	}
 }
 
+// createMediaCommand returns the command running the script storing the given media.
+func createMediaCommand(ks keyspace, sha string, id uuid.UUID, name string, tags ...string) []string {
+	key := id.String()
+	createdAt := time.Unix(id.Time().UnixTime()).UnixMilli()
+	keys := ks.appendTagKeys([]string{ks.media(key), ks.tags(), ks.pendingUploads()}, tags)
+	cmd := append([]string{"EVALSHA", sha, strconv.Itoa(len(keys))}, keys...)
+	cmd = append(cmd, key, name, ks.object(key), strconv.FormatInt(createdAt, 10))
+	return append(cmd, tags...)
+}
+
+// tagMediaCommand returns the command running the tag script on a media whose tags field, read
+// beforehand, holds the given tags.
+func tagMediaCommand(ks keyspace, sha, key, field string, fieldTags, add, remove []Tag) []string {
+	argTags := slices.Concat(fieldTags, add, remove)
+	keys := ks.appendTagKeys([]string{ks.media(key), ks.tags()}, argTags)
+	cmd := append([]string{"EVALSHA", sha, strconv.Itoa(len(keys))}, keys...)
+	cmd = append(cmd, key, field, strconv.Itoa(len(fieldTags)), strconv.Itoa(len(add)))
+	return append(cmd, argTags...)
+}
+
 func TestNewMediaService(t *testing.T) {
 	rc := rmock.NewClient(nil)
 	pc := &mockPresignClient{}
 	endpointURL := url.URL{}
 	bucket := "bucket"
 
-	s := NewMediaService(rc, pc, endpointURL, bucket)
+	rules := TagRules{maxLength: 64}
+
+	s := NewMediaService(rc, nil, Namespace{}, pc, endpointURL, bucket, rules, true)
 
 	require.NotNil(t, s)
 	require.Equal(t, bucket, s.bucket)
@@ -58,17 +90,70 @@ func TestNewMediaService(t *testing.T) {
 	require.NotNil(t, s.generateUUID)
 	require.Equal(t, pc, s.presignClient)
 	require.Equal(t, rc, s.rueidisClient)
+	require.Nil(t, s.replicaClient)
+	require.Equal(t, rules, s.tagRules)
+	require.True(t, s.repairIndexes)
 }
 
 func TestMediaService_CreateTag(t *testing.T) {
-	ctx := context.Background()
+	ctx, ks := tenantContext()
+
+	t.Run("it fails without tenant", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		err := s.CreateTag(context.Background(), CreateTagParams{Name: "mytag"})
+
+		require.ErrorIs(t, err, ErrNoTenant)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the tag is invalid", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		err := s.CreateTag(ctx, CreateTagParams{Name: "  "})
+
+		require.ErrorIs(t, err, ErrInvalidTag)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it normalizes the tag", func(t *testing.T) {
+		rules, err := NewTagRules(TagConfig{MaxLength: 64, Allowed: ".*", Case: CaseLower})
+		require.NoError(t, err)
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SADD", ks.tags(), "wembley stadium")).Return(rmock.Result(rmock.RedisInt64(1)))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", rules, false)
+		err = s.CreateTag(ctx, CreateTagParams{Name: " Wembley Stadium "})
+
+		require.NoError(t, err)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if the idempotency key was used by another request", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SET", ks.idempotency(tagsKey, "idem"), fingerprint("mytag")+" ", "NX", "GET", "PX", "86400000")).
+			Return(rmock.Result(rmock.RedisString(fingerprint("other") + " ")))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		err := s.CreateTag(ctx, CreateTagParams{Name: "mytag", IdempotencyKey: "idem"})
+
+		require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+		require.True(t, ctrl.Satisfied())
+	})
 
 	t.Run("it fails if redis fails", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SADD", tagsKey, "mytag")).Return(rmock.ErrorResult(assert.AnError))
+		rc.EXPECT().Do(ctx, rmock.Match("SADD", ks.tags(), "mytag")).Return(rmock.ErrorResult(assert.AnError))
 
-		s := NewMediaService(rc, nil, url.URL{}, "")
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
 		err := s.CreateTag(ctx, CreateTagParams{Name: "mytag"})
 
 		require.EqualError(t, err, "creating tag: "+assert.AnError.Error())
@@ -78,9 +163,9 @@ func TestMediaService_CreateTag(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SADD", tagsKey, "mytag")).Return(rmock.ErrorResult(nil))
+		rc.EXPECT().Do(ctx, rmock.Match("SADD", ks.tags(), "mytag")).Return(rmock.ErrorResult(nil))
 
-		s := NewMediaService(rc, nil, url.URL{}, "")
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
 		err := s.CreateTag(ctx, CreateTagParams{Name: "mytag"})
 
 		require.NoError(t, err)
@@ -89,14 +174,14 @@ func TestMediaService_CreateTag(t *testing.T) {
 }
 
 func TestMediaService_ListTags(t *testing.T) {
-	ctx := context.Background()
+	ctx, ks := tenantContext()
 
 	t.Run("it fails if redis fails", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", tagsKey)).Return(rmock.ErrorResult(assert.AnError))
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tags())).Return(rmock.ErrorResult(assert.AnError))
 
-		s := NewMediaService(rc, nil, url.URL{}, "")
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
 		tags, err := s.ListTags(ctx)
 
 		require.Nil(t, tags)
@@ -107,10 +192,10 @@ func TestMediaService_ListTags(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", tagsKey)).
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tags())).
 			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("tag1"), rmock.RedisString("tag2"))))
 
-		s := NewMediaService(rc, nil, url.URL{}, "")
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
 		tags, err := s.ListTags(ctx)
 
 		require.NoError(t, err)
@@ -119,18 +204,225 @@ func TestMediaService_ListTags(t *testing.T) {
 	})
 }
 
+// seedMedia stores the given media with the script used by CreateMedia.
+func seedMedia(t *testing.T, ctx context.Context, s *mediaService, ks keyspace, key string, tags ...Tag) {
+	t.Helper()
+	exec := createMediaExec(ks, key, CreateMediaParams{Name: key, Tags: tags})
+	require.NoError(t, createMediaScript.Exec(ctx, s.rueidisClient, exec.Keys, exec.Args).Error())
+}
+
+func TestMediaService_RenameTag(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it moves the media to the new tag", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		seedMedia(t, ctx, s, ks, "a", "t1", "t 2")
+		seedMedia(t, ctx, s, ks, "b", "t1")
+		seedMedia(t, ctx, s, ks, "c", "t 2")
+
+		err := s.RenameTag(ctx, RenameTagParams{From: "t1", To: " t3 "})
+
+		require.NoError(t, err)
+		require.Equal(t, `["t 2","t3"]`, m.HGet(ks.media("a"), tagsField))
+		require.Equal(t, `["t3"]`, m.HGet(ks.media("b"), tagsField))
+		tags, err := m.SMembers(ks.tags())
+		require.NoError(t, err)
+		require.Equal(t, []string{"t 2", "t3"}, tags)
+		members, err := m.SMembers(ks.tag("t3"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, members)
+		require.False(t, m.Exists(ks.tag("t1")))
+		require.False(t, m.Exists(ks.related("t1")))
+		score, err := m.ZScore(ks.related("t 2"), "t3")
+		require.NoError(t, err)
+		require.Equal(t, float64(1), score)
+		related, err := m.ZMembers(ks.related("t3"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"t 2"}, related)
+	})
+
+	t.Run("it keeps the keys in the namespace", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		ns := Namespace{Prefix: "app:", HashTags: true}
+		ks := ns.keyspace("club")
+		s := NewMediaService(rc, nil, ns, nil, url.URL{}, "", TagRules{}, false)
+		seedMedia(t, ctx, s, ks, "a", "t1", "t 2")
+
+		err := s.RenameTag(ctx, RenameTagParams{From: "t1", To: "t3"})
+
+		require.NoError(t, err)
+		require.Equal(t, `["t 2","t3"]`, m.HGet("app:tenant:{club}:media:a", tagsField))
+		members, err := m.SMembers("app:tenant:{club}:tags:t3")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a"}, members)
+		for _, key := range m.Keys() {
+			require.True(t, strings.HasPrefix(key, "app:"), key)
+		}
+	})
+
+	t.Run("it does nothing if the tags are the same", func(t *testing.T) {
+		s := NewMediaService(nil, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		err := s.RenameTag(ctx, RenameTagParams{From: "t1", To: "t1 "})
+		require.NoError(t, err)
+	})
+
+	t.Run("it fails if the tag does not exist", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		err := s.RenameTag(ctx, RenameTagParams{From: "t1", To: "t2"})
+		require.ErrorIs(t, err, ErrNotFound)
+		require.EqualError(t, err, "tag not found")
+	})
+
+	t.Run("it renames tags stored before the rules", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{maxLength: 3, lower: true}, false)
+		seedMedia(t, ctx, s, ks, "a", "Legacy")
+
+		err := s.RenameTag(ctx, RenameTagParams{From: "Legacy", To: "New"})
+
+		require.NoError(t, err)
+		require.Equal(t, `["new"]`, m.HGet(ks.media("a"), tagsField))
+		require.False(t, m.Exists(ks.tag("Legacy")))
+	})
+
+	t.Run("it fails if a tag is invalid", func(t *testing.T) {
+		s := NewMediaService(nil, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		err := s.RenameTag(ctx, RenameTagParams{From: "t1", To: " "})
+		require.ErrorIs(t, err, ErrInvalidTag)
+	})
+}
+
+func TestMediaService_DeleteTag(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it removes the tag from every media", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		seedMedia(t, ctx, s, ks, "a", "t1", "t2")
+		seedMedia(t, ctx, s, ks, "b", "t1")
+		_, err := m.SAdd(ks.tag("t1"), "dangling")
+		require.NoError(t, err)
+
+		err = s.DeleteTag(ctx, "t1")
+
+		require.NoError(t, err)
+		require.Equal(t, `["t2"]`, m.HGet(ks.media("a"), tagsField))
+		require.Equal(t, "[]", m.HGet(ks.media("b"), tagsField))
+		tags, err := m.SMembers(ks.tags())
+		require.NoError(t, err)
+		require.Equal(t, []string{"t2"}, tags)
+		require.False(t, m.Exists(ks.tag("t1")))
+		require.False(t, m.Exists(ks.related("t1")))
+		require.False(t, m.Exists(ks.related("t2")))
+	})
+
+	t.Run("it deletes tags stored before the rules", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{maxLength: 3, lower: true}, false)
+		seedMedia(t, ctx, s, ks, "a", "Legacy", "t2")
+
+		err := s.DeleteTag(ctx, "Legacy")
+
+		require.NoError(t, err)
+		require.Equal(t, `["t2"]`, m.HGet(ks.media("a"), tagsField))
+		require.False(t, m.Exists(ks.tag("Legacy")))
+	})
+
+	t.Run("it normalises the tag", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		rules, err := NewTagRules(TagConfig{MaxLength: 64, Allowed: ".*", Case: CaseLower})
+		require.NoError(t, err)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", rules, false)
+		seedMedia(t, ctx, s, ks, "a", "goal", "t2")
+
+		err = s.DeleteTag(ctx, "Goal")
+
+		require.NoError(t, err)
+		require.Equal(t, `["t2"]`, m.HGet(ks.media("a"), tagsField))
+		require.False(t, m.Exists(ks.tag("goal")))
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		m.SetError("boom")
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		err := s.DeleteTag(ctx, "t1")
+		require.EqualError(t, err, "checking tag: boom")
+	})
+}
+
+func TestMediaService_ListRelatedTags(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("ZRANGE", ks.related("mytag"), "0", "9", "REV", "WITHSCORES")).Return(rmock.ErrorResult(assert.AnError))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		tags, err := s.ListRelatedTags(ctx, ListRelatedTagsParams{Tag: "mytag"})
+
+		require.Nil(t, tags)
+		require.EqualError(t, err, "getting related tags from redis: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("ZRANGE", ks.related("mytag"), "0", "1", "REV", "WITHSCORES")).
+			Return(rmock.Result(rmock.RedisArray(
+				rmock.RedisArray(rmock.RedisString("tag1"), rmock.RedisFloat64(3)),
+				rmock.RedisArray(rmock.RedisString("tag2"), rmock.RedisFloat64(1)),
+			)))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		tags, err := s.ListRelatedTags(ctx, ListRelatedTagsParams{Tag: "mytag", Limit: 2})
+
+		require.NoError(t, err)
+		require.Equal(t, ListRelatedTagsResult{{Tag: "tag1", Count: 3}, {Tag: "tag2", Count: 1}}, tags)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it reads tags stored before the rules", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{maxLength: 3, lower: true}, false)
+		seedMedia(t, ctx, s, ks, "a", "Legacy", "t2")
+
+		tags, err := s.ListRelatedTags(ctx, ListRelatedTagsParams{Tag: "Legacy"})
+
+		require.NoError(t, err)
+		require.Equal(t, ListRelatedTagsResult{{Tag: "t2", Count: 1}}, tags)
+	})
+
+	t.Run("it normalises the tag", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		rules, err := NewTagRules(TagConfig{MaxLength: 64, Allowed: ".*", Case: CaseLower})
+		require.NoError(t, err)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", rules, false)
+		seedMedia(t, ctx, s, ks, "a", "goal", "t2")
+
+		tags, err := s.ListRelatedTags(ctx, ListRelatedTagsParams{Tag: " Goal "})
+
+		require.NoError(t, err)
+		require.Equal(t, ListRelatedTagsResult{{Tag: "t2", Count: 1}}, tags)
+	})
+}
+
 func TestMediaService_ListMedia(t *testing.T) {
-	ctx := context.Background()
+	ctx, ks := tenantContext()
 
 	t.Run("it fails if redis fails", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", tagsPrefix+"mytag")).Return(rmock.ErrorResult(assert.AnError))
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).Return(rmock.ErrorResult(assert.AnError))
 
-		s := NewMediaService(rc, nil, url.URL{}, "")
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
 		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag"})
 
-		require.Nil(t, media)
+		require.Empty(t, media)
 		require.EqualError(t, err, "getting media keys from redis: "+assert.AnError.Error())
 		require.True(t, ctrl.Satisfied())
 	})
@@ -138,11 +430,11 @@ func TestMediaService_ListMedia(t *testing.T) {
 	t.Run("it fails if getting media fails", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", tagsPrefix+"mytag")).
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).
 			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key1"), rmock.RedisString("key2"))))
 		rc.EXPECT().DoMulti(ctx,
-			rmock.Match("HGETALL", mediaPrefix+"key1"),
-			rmock.Match("HGETALL", mediaPrefix+"key2"),
+			rmock.Match("HGETALL", ks.media("key1")),
+			rmock.Match("HGETALL", ks.media("key2")),
 		).Return([]rueidis.RedisResult{
 			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
 				nameField: rmock.RedisString("name1"),
@@ -151,10 +443,10 @@ func TestMediaService_ListMedia(t *testing.T) {
 			rmock.ErrorResult(assert.AnError),
 		})
 
-		s := NewMediaService(rc, nil, parseURL(t, "http://test"), "mybucket")
+		s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "mybucket", TagRules{}, false)
 		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag"})
 
-		require.Nil(t, media)
+		require.Empty(t, media)
 		require.EqualError(t, err, "getting media record 1: "+assert.AnError.Error())
 		require.True(t, ctrl.Satisfied())
 	})
@@ -162,11 +454,11 @@ func TestMediaService_ListMedia(t *testing.T) {
 	t.Run("it fails if decoding media fails", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", tagsPrefix+"mytag")).
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).
 			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key1"), rmock.RedisString("key2"))))
 		rc.EXPECT().DoMulti(ctx,
-			rmock.Match("HGETALL", mediaPrefix+"key1"),
-			rmock.Match("HGETALL", mediaPrefix+"key2"),
+			rmock.Match("HGETALL", ks.media("key1")),
+			rmock.Match("HGETALL", ks.media("key2")),
 		).Return([]rueidis.RedisResult{
 			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
 				nameField: rmock.RedisString("name1"),
@@ -175,10 +467,10 @@ func TestMediaService_ListMedia(t *testing.T) {
 			rmock.Result(rmock.RedisString("name2")),
 		})
 
-		s := NewMediaService(rc, nil, parseURL(t, "http://test"), "mybucket")
+		s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "mybucket", TagRules{}, false)
 		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag"})
 
-		require.Nil(t, media)
+		require.Empty(t, media)
 		require.EqualError(t, err, `decoding media record 1: rueidis: parse error: redis message type simple string is not a map/array/set or its length is not even`)
 		require.True(t, ctrl.Satisfied())
 	})
@@ -186,11 +478,11 @@ func TestMediaService_ListMedia(t *testing.T) {
 	t.Run("it fails if decoding tags fails", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", tagsPrefix+"mytag")).
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).
 			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key1"), rmock.RedisString("key2"))))
 		rc.EXPECT().DoMulti(ctx,
-			rmock.Match("HGETALL", mediaPrefix+"key1"),
-			rmock.Match("HGETALL", mediaPrefix+"key2"),
+			rmock.Match("HGETALL", ks.media("key1")),
+			rmock.Match("HGETALL", ks.media("key2")),
 		).Return([]rueidis.RedisResult{
 			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
 				nameField: rmock.RedisString("name1"),
@@ -202,10 +494,10 @@ func TestMediaService_ListMedia(t *testing.T) {
 			})),
 		})
 
-		s := NewMediaService(rc, nil, parseURL(t, "http://test"), "mybucket")
+		s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "mybucket", TagRules{}, false)
 		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag"})
 
-		require.Nil(t, media)
+		require.Empty(t, media)
 		require.EqualError(t, err, `decoding tag 1: invalid URL escape "%"`)
 		require.True(t, ctrl.Satisfied())
 	})
@@ -213,11 +505,11 @@ func TestMediaService_ListMedia(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", tagsPrefix+"mytag")).
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).
 			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key1"), rmock.RedisString("key2"))))
 		rc.EXPECT().DoMulti(ctx,
-			rmock.Match("HGETALL", mediaPrefix+"key1"),
-			rmock.Match("HGETALL", mediaPrefix+"key2"),
+			rmock.Match("HGETALL", ks.media("key1")),
+			rmock.Match("HGETALL", ks.media("key2")),
 		).Return([]rueidis.RedisResult{
 			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
 				nameField: rmock.RedisString("name1"),
@@ -229,24 +521,273 @@ func TestMediaService_ListMedia(t *testing.T) {
 			})),
 		})
 
-		s := NewMediaService(rc, nil, parseURL(t, "http://test"), "mybucket")
+		s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "mybucket", TagRules{}, false)
+		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag"})
+
+		require.NoError(t, err)
+		require.Equal(t, ListMediaResult{Media: []MediaRecord{
+			{Key: "key1", Name: "name1", URL: parseURL(t, "http://test/mybucket/club/key1"), Tags: []string{"tag1", "tag2"}},
+			{Key: "key2", Name: "name2", URL: parseURL(t, "http://test/mybucket/club/key2"), Tags: []string{"tag2", "ta,g3"}},
+		}}, media)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it skips media missing their record", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key1"), rmock.RedisString("key2"))))
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("HGETALL", ks.media("key1")),
+			rmock.Match("HGETALL", ks.media("key2")),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{})),
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
+				nameField: rmock.RedisString("name2"),
+				tagsField: rmock.RedisString("mytag"),
+			})),
+		})
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "mybucket", TagRules{}, false)
 		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag"})
 
 		require.NoError(t, err)
-		require.Equal(t, ListMediaResult{
-			{Key: "key1", Name: "name1", URL: parseURL(t, "http://test/mybucket/key1"), Tags: []string{"tag1", "tag2"}},
-			{Key: "key2", Name: "name2", URL: parseURL(t, "http://test/mybucket/key2"), Tags: []string{"tag2", "ta,g3"}},
-		}, media)
+		require.Equal(t, ListMediaResult{Media: []MediaRecord{
+			{Key: "key2", Name: "name2", URL: parseURL(t, "http://test/mybucket/club/key2"), Tags: []string{"mytag"}},
+		}}, media)
 		require.True(t, ctrl.Satisfied())
 	})
+
+	t.Run("it repairs the tag index when enabled", func(t *testing.T) {
+		for name, repairErr := range map[string]error{"succeeding": nil, "failing": assert.AnError} {
+			t.Run(name, func(t *testing.T) {
+				ctrl := gomock.NewController(t)
+				rc := rmock.NewClient(ctrl)
+				rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).
+					Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key1"), rmock.RedisString("key2"), rmock.RedisString("key3"))))
+				rc.EXPECT().DoMulti(ctx,
+					rmock.Match("HGETALL", ks.media("key1")),
+					rmock.Match("HGETALL", ks.media("key2")),
+					rmock.Match("HGETALL", ks.media("key3")),
+				).Return([]rueidis.RedisResult{
+					rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{})),
+					rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
+						nameField: rmock.RedisString("name2"),
+						tagsField: rmock.RedisString("mytag"),
+					})),
+					rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{})),
+				})
+				repair := rmock.Result(rmock.RedisInt64(2))
+				if repairErr != nil {
+					repair = rmock.ErrorResult(repairErr)
+				}
+				rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", scriptSHA(syncTagIndexSource), "4", ks.tag("mytag"), ks.tags(), ks.media("key1"), ks.media("key3"), "mytag", "key1", "key3")).
+					Return(repair)
+
+				s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "mybucket", TagRules{}, true)
+				media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag"})
+
+				require.NoError(t, err)
+				require.Len(t, media.Media, 1)
+				require.Equal(t, "key2", media.Media[0].Key)
+				require.True(t, ctrl.Satisfied())
+			})
+		}
+	})
+
+	t.Run("it reads from the replicas and repairs on the primary", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		replica := rmock.NewClient(ctrl)
+		replica.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key1"), rmock.RedisString("key2"))))
+		replica.EXPECT().DoMulti(ctx,
+			rmock.Match("HGETALL", ks.media("key1")),
+			rmock.Match("HGETALL", ks.media("key2")),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{})),
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
+				nameField: rmock.RedisString("name2"),
+				tagsField: rmock.RedisString(`["mytag"]`),
+			})),
+		})
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", scriptSHA(syncTagIndexSource), "3", ks.tag("mytag"), ks.tags(), ks.media("key1"), "mytag", "key1")).
+			Return(rmock.Result(rmock.RedisInt64(1)))
+
+		s := NewMediaService(rc, replica, Namespace{}, nil, parseURL(t, "http://test"), "mybucket", TagRules{}, true)
+		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag"})
+
+		require.NoError(t, err)
+		require.Len(t, media.Media, 1)
+		require.Equal(t, "key2", media.Media[0].Key)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it counts co-occurring tags", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SMEMBERS", ks.tag("mytag"))).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisString("key1"), rmock.RedisString("key2"), rmock.RedisString("key3"))))
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("HGETALL", ks.media("key1")),
+			rmock.Match("HGETALL", ks.media("key2")),
+			rmock.Match("HGETALL", ks.media("key3")),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
+				nameField: rmock.RedisString("name1"),
+				tagsField: rmock.RedisString("mytag,tag2"),
+			})),
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
+				nameField: rmock.RedisString("name2"),
+				tagsField: rmock.RedisString("mytag,tag3,tag2"),
+			})),
+			rmock.Result(rmock.RedisMap(map[string]rueidis.RedisMessage{
+				nameField: rmock.RedisString("name3"),
+				tagsField: rmock.RedisString("mytag,tag1"),
+			})),
+		})
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, parseURL(t, "http://test"), "mybucket", TagRules{}, false)
+		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "mytag", Facets: true})
+
+		require.NoError(t, err)
+		require.Len(t, media.Media, 3)
+		require.Equal(t, []TagCount{{Tag: "tag2", Count: 2}, {Tag: "tag1", Count: 1}, {Tag: "tag3", Count: 1}}, media.Facets)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it reads tags stored before the rules", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{maxLength: 3, lower: true}, false)
+		seedMedia(t, ctx, s, ks, "a", "Legacy")
+
+		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "Legacy"})
+
+		require.NoError(t, err)
+		require.Len(t, media.Media, 1)
+		require.Equal(t, []string{"Legacy"}, media.Media[0].Tags)
+	})
+
+	t.Run("it normalises the tag", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		rules, err := NewTagRules(TagConfig{MaxLength: 64, Allowed: ".*", Case: CaseLower})
+		require.NoError(t, err)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", rules, false)
+		seedMedia(t, ctx, s, ks, "a", "goal", "t2")
+
+		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "GOAL", Facets: true})
+
+		require.NoError(t, err)
+		require.Len(t, media.Media, 1)
+		require.Equal(t, []TagCount{{Tag: "t2", Count: 1}}, media.Facets)
+	})
 }
 
 func TestMediaService_CreateMedia(t *testing.T) {
-	ctx := context.Background()
+	ctx, ks := tenantContext()
+
+	t.Run("it fails if a tag is invalid", func(t *testing.T) {
+		s := NewMediaService(nil, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+
+		result, err := s.CreateMedia(ctx, CreateMediaParams{Name: "name1", Tags: []string{"tag1", ""}})
+
+		require.Nil(t, result)
+		require.ErrorIs(t, err, ErrInvalidTag)
+	})
+
+	t.Run("it fails on unknown tags in strict mode", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SMISMEMBER", ks.tags(), "tag1", "tag2", "tag3")).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisInt64(0), rmock.RedisInt64(1), rmock.RedisInt64(0))))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{strict: true}, false)
+		result, err := s.CreateMedia(ctx, CreateMediaParams{Name: "name1", Tags: []string{"tag1", "tag2", "tag3"}})
+
+		require.Nil(t, result)
+		require.ErrorIs(t, err, ErrUnknownTags)
+		require.EqualError(t, err, "unknown tags: tag1, tag3")
+		var unknown *UnknownTagsError
+		require.ErrorAs(t, err, &unknown)
+		require.Equal(t, []Tag{"tag1", "tag3"}, unknown.Tags)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if checking tags fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SMISMEMBER", ks.tags(), "tag1")).Return(rmock.ErrorResult(assert.AnError))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{strict: true}, false)
+		result, err := s.CreateMedia(ctx, CreateMediaParams{Name: "name1", Tags: []string{"tag1"}})
+
+		require.Nil(t, result)
+		require.EqualError(t, err, "checking tags: "+assert.AnError.Error())
+		require.ErrorIs(t, err, ErrUnavailable)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it replays an earlier request with the same idempotency key", func(t *testing.T) {
+		id, err := uuid.NewV7()
+		require.NoError(t, err)
+		params := CreateMediaParams{Name: "name1", Tags: []string{"tag1"}, IdempotencyKey: "idem"}
+
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id, nil).Once().
+			On("PresignPutObject", ctx, &s3.PutObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(id.String())), Metadata: map[string]string{"name": "name1", "tags": "tag1"}}, ([]func(*s3.PresignOptions))(nil)).
+			Return(&v4.PresignedHTTPRequest{URL: "http://test/bucket/club/new", Method: http.MethodPut}, nil).Once().
+			On("PresignPutObject", ctx, &s3.PutObjectInput{Bucket: pT("bucket"), Key: pT(ks.object("earlier")), Metadata: map[string]string{"name": "name1", "tags": "tag1"}}, ([]func(*s3.PresignOptions))(nil)).
+			Return(&v4.PresignedHTTPRequest{URL: "http://test/bucket/club/earlier", Method: http.MethodPut}, nil).Once()
+		pc := &mockPresignClient{m: m}
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SET", ks.idempotency(mediaKey, "idem"), fingerprint(params)+" "+id.String(), "NX", "GET", "PX", "86400000")).
+			Return(rmock.Result(rmock.RedisString(fingerprint(params) + " earlier")))
+
+		s := NewMediaService(rc, nil, Namespace{}, pc, url.URL{}, "bucket", TagRules{}, false)
+		s.generateUUID = mockUUID(m)
+		result, err := s.CreateMedia(ctx, params)
+
+		require.NoError(t, err)
+		require.Equal(t, &CreateMediaResult{Key: "earlier", URL: "http://test/bucket/club/earlier", Method: http.MethodPut}, result)
+		require.True(t, ctrl.Satisfied())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it releases the idempotency key if redis fails", func(t *testing.T) {
+		id, err := uuid.NewV7()
+		require.NoError(t, err)
+		params := CreateMediaParams{Name: "name1", Tags: []string{}, IdempotencyKey: "idem"}
+
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id, nil).Once().
+			On("PresignPutObject", ctx, &s3.PutObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(id.String())), Metadata: map[string]string{"name": "name1", "tags": ""}}, ([]func(*s3.PresignOptions))(nil)).
+			Return(&v4.PresignedHTTPRequest{URL: "http://test/bucket/club/new", Method: http.MethodPut}, nil).Once()
+		pc := &mockPresignClient{m: m}
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		gomock.InOrder(
+			rc.EXPECT().Do(ctx, rmock.Match("SET", ks.idempotency(mediaKey, "idem"), fingerprint(params)+" "+id.String(), "NX", "GET", "PX", "86400000")).
+				Return(rmock.Result(rmock.RedisNil())),
+			rc.EXPECT().Do(ctx, rmock.Match(createMediaCommand(ks, scriptSHA(createMediaSource), id, "name1")...)).
+				Return(rmock.ErrorResult(assert.AnError)),
+			rc.EXPECT().Do(ctx, rmock.Match("DEL", ks.idempotency(mediaKey, "idem"))).Return(rmock.Result(rmock.RedisInt64(1))),
+		)
+
+		s := NewMediaService(rc, nil, Namespace{}, pc, url.URL{}, "bucket", TagRules{}, false)
+		s.generateUUID = mockUUID(m)
+		result, err := s.CreateMedia(ctx, params)
+
+		require.Nil(t, result)
+		require.EqualError(t, err, "storing media: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
 
 	t.Run("it fails if generating UUID fails", func(t *testing.T) {
 		m := &mock.Mock{}
-		s := NewMediaService(nil, nil, url.URL{}, "")
+		s := NewMediaService(nil, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
 		s.generateUUID = mockUUID(m)
 		m.On("generateUUID").Return(uuid.UUID{}, assert.AnError).Once()
 
@@ -264,13 +805,14 @@ func TestMediaService_CreateMedia(t *testing.T) {
 		m.On("generateUUID").Return(id, nil).Once().
 			On("PresignPutObject", ctx,
 				&s3.PutObjectInput{
-					Bucket: pT("bucket"),
-					Key:    pT(id.String()),
+					Bucket:   pT("bucket"),
+					Key:      pT(ks.object(id.String())),
+					Metadata: map[string]string{"name": "", "tags": ""},
 				}, ([]func(*s3.PresignOptions))(nil)).
 			Return((*v4.PresignedHTTPRequest)(nil), assert.AnError).Once()
 		pc := &mockPresignClient{m: m}
 
-		s := NewMediaService(nil, pc, url.URL{}, "bucket")
+		s := NewMediaService(nil, nil, Namespace{}, pc, url.URL{}, "bucket", TagRules{}, false)
 		s.generateUUID = mockUUID(m)
 		result, err := s.CreateMedia(ctx, CreateMediaParams{})
 
@@ -286,11 +828,12 @@ func TestMediaService_CreateMedia(t *testing.T) {
 		m.On("generateUUID").Return(id, nil).Once().
 			On("PresignPutObject", ctx,
 				&s3.PutObjectInput{
-					Bucket: pT("bucket"),
-					Key:    pT(id.String()),
+					Bucket:   pT("bucket"),
+					Key:      pT(ks.object(id.String())),
+					Metadata: map[string]string{"name": "name1", "tags": "tag1,ta%2Cg2"},
 				}, ([]func(*s3.PresignOptions))(nil)).
 			Return(&v4.PresignedHTTPRequest{
-				URL:          "http://test/mybucket/key1",
+				URL:          "http://test/mybucket/club/key1",
 				Method:       http.MethodPut,
 				SignedHeader: http.Header{"X-Amz-Security-Token": []string{"token"}},
 			}, nil).Once()
@@ -298,22 +841,15 @@ func TestMediaService_CreateMedia(t *testing.T) {
 
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().DoMulti(ctx,
-			rmock.Match("HSET", mediaPrefix+id.String(), nameField, "name1", tagsField, "tag1,tag2"),
-			rmock.Match("SADD", tagsKey, "tag1"),
-			rmock.Match("SADD", tagsPrefix+"tag1", id.String()),
-			rmock.Match("SADD", tagsKey, "tag2"),
-			rmock.Match("SADD", tagsPrefix+"tag2", id.String()),
-		).Return([]rueidis.RedisResult{
-			rmock.ErrorResult(assert.AnError),
-		})
+		rc.EXPECT().Do(ctx, rmock.Match(createMediaCommand(ks, scriptSHA(createMediaSource), id, "name1", "tag1", "ta,g2")...)).
+			Return(rmock.ErrorResult(assert.AnError))
 
-		s := NewMediaService(rc, pc, url.URL{}, "bucket")
+		s := NewMediaService(rc, nil, Namespace{}, pc, url.URL{}, "bucket", TagRules{}, false)
 		s.generateUUID = mockUUID(m)
-		result, err := s.CreateMedia(ctx, CreateMediaParams{Name: "name1", Tags: []string{"tag1", "tag2"}})
+		result, err := s.CreateMedia(ctx, CreateMediaParams{Name: "name1", Tags: []string{"tag1", "ta,g2"}})
 
 		require.Nil(t, result)
-		require.EqualError(t, err, "executing command 0: "+assert.AnError.Error())
+		require.EqualError(t, err, "storing media: "+assert.AnError.Error())
 		require.True(t, ctrl.Satisfied())
 	})
 
@@ -325,11 +861,12 @@ func TestMediaService_CreateMedia(t *testing.T) {
 		m.On("generateUUID").Return(id, nil).Once().
 			On("PresignPutObject", ctx,
 				&s3.PutObjectInput{
-					Bucket: pT("bucket"),
-					Key:    pT(id.String()),
+					Bucket:   pT("bucket"),
+					Key:      pT(ks.object(id.String())),
+					Metadata: map[string]string{"name": "name1", "tags": "tag1,tag2"},
 				}, ([]func(*s3.PresignOptions))(nil)).
 			Return(&v4.PresignedHTTPRequest{
-				URL:          "http://test/mybucket/key1",
+				URL:          "http://test/mybucket/club/key1",
 				Method:       http.MethodPut,
 				SignedHeader: http.Header{"X-Amz-Security-Token": []string{"token"}},
 			}, nil).Once()
@@ -337,30 +874,346 @@ func TestMediaService_CreateMedia(t *testing.T) {
 
 		ctrl := gomock.NewController(t)
 		rc := rmock.NewClient(ctrl)
-		rc.EXPECT().DoMulti(ctx,
-			rmock.Match("HSET", mediaPrefix+id.String(), nameField, "name1", tagsField, "tag1,tag2"),
-			rmock.Match("SADD", tagsKey, "tag1"),
-			rmock.Match("SADD", tagsPrefix+"tag1", id.String()),
-			rmock.Match("SADD", tagsKey, "tag2"),
-			rmock.Match("SADD", tagsPrefix+"tag2", id.String()),
-		).Return([]rueidis.RedisResult{
-			rmock.Result(rmock.RedisInt64(1)),
-			rmock.Result(rmock.RedisInt64(1)),
-			rmock.Result(rmock.RedisInt64(1)),
-			rmock.Result(rmock.RedisInt64(1)),
-			rmock.Result(rmock.RedisInt64(1)),
-		})
+		rc.EXPECT().Do(ctx, rmock.Match(createMediaCommand(ks, scriptSHA(createMediaSource), id, "name1", "tag1", "tag2")...)).
+			Return(rmock.Result(rmock.RedisInt64(1)))
 
-		s := NewMediaService(rc, pc, url.URL{}, "bucket")
+		s := NewMediaService(rc, nil, Namespace{}, pc, url.URL{}, "bucket", TagRules{}, false)
 		s.generateUUID = mockUUID(m)
 		result, err := s.CreateMedia(ctx, CreateMediaParams{Name: "name1", Tags: []string{"tag1", "tag2"}})
 
 		require.NoError(t, err)
-		require.Equal(t, &v4.PresignedHTTPRequest{
-			URL:          "http://test/mybucket/key1",
+		require.Equal(t, &CreateMediaResult{
+			Key:          id.String(),
+			URL:          "http://test/mybucket/club/key1",
 			Method:       http.MethodPut,
 			SignedHeader: http.Header{"X-Amz-Security-Token": []string{"token"}},
 		}, result)
 		require.True(t, ctrl.Satisfied())
 	})
 }
+
+func TestMediaService_CreateMediaBatch(t *testing.T) {
+	ctx, ks := tenantContext()
+	sha := scriptSHA(createMediaSource)
+
+	t.Run("it reports unknown tags per item in strict mode", func(t *testing.T) {
+		id, err := uuid.NewV7()
+		require.NoError(t, err)
+
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id, nil).Once().
+			On("PresignPutObject", ctx, &s3.PutObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(id.String())), Metadata: map[string]string{"name": "name1", "tags": "tag1"}}, ([]func(*s3.PresignOptions))(nil)).
+			Return(&v4.PresignedHTTPRequest{URL: "http://test/bucket/club/1", Method: http.MethodPut}, nil).Once()
+		pc := &mockPresignClient{m: m}
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SMISMEMBER", ks.tags(), "tag1", "tag2")).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisInt64(1), rmock.RedisInt64(0))))
+		rc.EXPECT().Nodes().Return(map[string]rueidis.Client{"node": rc})
+		rc.EXPECT().Do(ctx, rmock.Match("SCRIPT", "LOAD", createMediaSource)).Return(rmock.Result(rmock.RedisString(sha)))
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match(createMediaCommand(ks, sha, id, "name1", "tag1")...),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisInt64(1)),
+		})
+
+		s := NewMediaService(rc, nil, Namespace{}, pc, url.URL{}, "bucket", TagRules{strict: true}, false)
+		s.generateUUID = mockUUID(m)
+		result := s.CreateMediaBatch(ctx, []CreateMediaParams{
+			{Name: "name1", Tags: []string{"tag1", "tag1"}},
+			{Name: "name2", Tags: []string{"tag2", "tag1"}},
+			{Name: "name3", Tags: []string{" "}},
+		})
+
+		require.Len(t, result, 3)
+		require.NoError(t, result[0].Err)
+		require.EqualError(t, result[1].Err, "unknown tags: tag2")
+		require.Equal(t, &UnknownTagsError{Tags: []Tag{"tag2"}}, result[1].Err)
+		require.ErrorIs(t, result[2].Err, ErrInvalidTag)
+		require.True(t, ctrl.Satisfied())
+		require.True(t, m.AssertExpectations(t))
+	})
+
+	t.Run("it reports failures per item", func(t *testing.T) {
+		id1, err := uuid.NewV7()
+		require.NoError(t, err)
+		id2, err := uuid.NewV7()
+		require.NoError(t, err)
+		id3, err := uuid.NewV7()
+		require.NoError(t, err)
+
+		m := &mock.Mock{}
+		m.On("generateUUID").Return(id1, nil).Once().
+			On("generateUUID").Return(id2, nil).Once().
+			On("generateUUID").Return(id3, nil).Once().
+			On("PresignPutObject", ctx, &s3.PutObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(id1.String())), Metadata: map[string]string{"name": "name1", "tags": "tag1"}}, ([]func(*s3.PresignOptions))(nil)).
+			Return(&v4.PresignedHTTPRequest{URL: "http://test/bucket/club/1", Method: http.MethodPut}, nil).Once().
+			On("PresignPutObject", ctx, &s3.PutObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(id2.String())), Metadata: map[string]string{"name": "name2", "tags": "tag2"}}, ([]func(*s3.PresignOptions))(nil)).
+			Return((*v4.PresignedHTTPRequest)(nil), assert.AnError).Once().
+			On("PresignPutObject", ctx, &s3.PutObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(id3.String())), Metadata: map[string]string{"name": "name3", "tags": "tag3"}}, ([]func(*s3.PresignOptions))(nil)).
+			Return(&v4.PresignedHTTPRequest{URL: "http://test/bucket/club/3", Method: http.MethodPut}, nil).Once()
+		pc := &mockPresignClient{m: m}
+
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Nodes().Return(map[string]rueidis.Client{"node": rc})
+		rc.EXPECT().Do(ctx, rmock.Match("SCRIPT", "LOAD", createMediaSource)).Return(rmock.Result(rmock.RedisString(sha)))
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match(createMediaCommand(ks, sha, id1, "name1", "tag1")...),
+			rmock.Match(createMediaCommand(ks, sha, id3, "name3", "tag3")...),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisInt64(1)),
+			rmock.ErrorResult(assert.AnError),
+		})
+
+		s := NewMediaService(rc, nil, Namespace{}, pc, url.URL{}, "bucket", TagRules{}, false)
+		s.generateUUID = mockUUID(m)
+		result := s.CreateMediaBatch(ctx, []CreateMediaParams{
+			{Name: "name1", Tags: []string{"tag1"}},
+			{Name: "name2", Tags: []string{"tag2"}},
+			{Name: "name3", Tags: []string{"tag3"}},
+		})
+
+		require.Len(t, result, 3)
+		require.Equal(t, CreateMediaBatchItem{Result: &CreateMediaResult{Key: id1.String(), URL: "http://test/bucket/club/1", Method: http.MethodPut}}, result[0])
+		require.Nil(t, result[1].Result)
+		require.EqualError(t, result[1].Err, "presigning put object: "+assert.AnError.Error())
+		require.Nil(t, result[2].Result)
+		require.EqualError(t, result[2].Err, "storing media: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+}
+
+func TestMediaService_TagMediaBatch(t *testing.T) {
+	ctx, ks := tenantContext()
+	sha := scriptSHA(tagMediaSource)
+
+	t.Run("it fails every item on unknown tags in strict mode", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("SMISMEMBER", ks.tags(), "tag1")).Return(rmock.Result(rmock.RedisArray(rmock.RedisInt64(0))))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{strict: true}, false)
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{Keys: []string{"key1", "key2"}, Add: []string{"tag1"}, Remove: []string{"tag2"}})
+
+		require.Len(t, result, 2)
+		require.EqualError(t, result[0].Err, "unknown tags: tag1")
+		require.EqualError(t, result[1].Err, "unknown tags: tag1")
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails every item if the script cannot be loaded", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx, rmock.Match("HGET", ks.media("key1"), tagsField), rmock.Match("HGET", ks.media("key2"), tagsField)).
+			Return([]rueidis.RedisResult{rmock.Result(rmock.RedisNil()), rmock.Result(rmock.RedisNil())})
+		rc.EXPECT().Nodes().Return(map[string]rueidis.Client{"node": rc})
+		rc.EXPECT().Do(ctx, rmock.Match("SCRIPT", "LOAD", tagMediaSource)).Return(rmock.ErrorResult(assert.AnError))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{Keys: []string{"key1", "key2"}, Add: []string{"tag1"}})
+
+		require.Len(t, result, 2)
+		require.Equal(t, "key1", result[0].Key)
+		require.EqualError(t, result[0].Err, "updating tags: "+assert.AnError.Error())
+		require.Equal(t, "key2", result[1].Key)
+		require.EqualError(t, result[1].Err, "updating tags: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it reports results per item", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match("HGET", ks.media("key1"), tagsField),
+			rmock.Match("HGET", ks.media("key2"), tagsField),
+			rmock.Match("HGET", ks.media("key3"), tagsField),
+			rmock.Match("HGET", ks.media("key4"), tagsField),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisString(`["tag3","tag4"]`)),
+			rmock.Result(rmock.RedisNil()),
+			rmock.Result(rmock.RedisString("tag4")),
+			rmock.Result(rmock.RedisString("[")),
+		})
+		rc.EXPECT().Nodes().Return(map[string]rueidis.Client{"node": rc})
+		rc.EXPECT().Do(ctx, rmock.Match("SCRIPT", "LOAD", tagMediaSource)).Return(rmock.Result(rmock.RedisString(sha)))
+		rc.EXPECT().DoMulti(ctx,
+			rmock.Match(tagMediaCommand(ks, sha, "key1", `["tag3","tag4"]`, []Tag{"tag3", "tag4"}, []Tag{"tag 1", "ta,g2"}, []Tag{"tag3"})...),
+			rmock.Match(tagMediaCommand(ks, sha, "key2", "", nil, []Tag{"tag 1", "ta,g2"}, []Tag{"tag3"})...),
+			rmock.Match(tagMediaCommand(ks, sha, "key3", "tag4", []Tag{"tag4"}, []Tag{"tag 1", "ta,g2"}, []Tag{"tag3"})...),
+		).Return([]rueidis.RedisResult{
+			rmock.Result(rmock.RedisInt64(1)),
+			rmock.Result(rmock.RedisInt64(0)),
+			rmock.ErrorResult(assert.AnError),
+		})
+		rc.EXPECT().Do(ctx, rmock.Match("ZADD", ks.pendingMetadata(), "1000", "key1")).Return(rmock.Result(rmock.RedisInt64(1)))
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		s.now = func() time.Time { return time.UnixMilli(1000) }
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{
+			Keys:   []string{"key1", "key2", "key3", "key4"},
+			Add:    []string{"tag 1", "ta,g2"},
+			Remove: []string{"tag3"},
+		})
+
+		require.Len(t, result, 4)
+		require.Equal(t, TagMediaBatchItem{Key: "key1"}, result[0])
+		require.Equal(t, "key2", result[1].Key)
+		require.ErrorIs(t, result[1].Err, ErrNotFound)
+		require.EqualError(t, result[1].Err, "media not found: key2")
+		require.Equal(t, "key3", result[2].Key)
+		require.EqualError(t, result[2].Err, "updating tags: "+assert.AnError.Error())
+		require.Equal(t, "key4", result[3].Key)
+		require.ErrorContains(t, result[3].Err, "decoding tags")
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it reads the tags again if they changed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Nodes().Return(map[string]rueidis.Client{"node": rc}).Times(2)
+		rc.EXPECT().Do(ctx, rmock.Match("SCRIPT", "LOAD", tagMediaSource)).Return(rmock.Result(rmock.RedisString(sha))).Times(2)
+		gomock.InOrder(
+			rc.EXPECT().DoMulti(ctx, rmock.Match("HGET", ks.media("key1"), tagsField), rmock.Match("HGET", ks.media("key2"), tagsField)).
+				Return([]rueidis.RedisResult{rmock.Result(rmock.RedisString(`["tag2"]`)), rmock.Result(rmock.RedisNil())}),
+			rc.EXPECT().DoMulti(ctx,
+				rmock.Match(tagMediaCommand(ks, sha, "key1", `["tag2"]`, []Tag{"tag2"}, []Tag{"tag1"}, nil)...),
+				rmock.Match(tagMediaCommand(ks, sha, "key2", "", nil, []Tag{"tag1"}, nil)...),
+			).Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(-1)), rmock.Result(rmock.RedisInt64(1))}),
+			rc.EXPECT().DoMulti(ctx, rmock.Match("HGET", ks.media("key1"), tagsField)).
+				Return([]rueidis.RedisResult{rmock.Result(rmock.RedisString(`["tag3"]`))}),
+			rc.EXPECT().DoMulti(ctx, rmock.Match(tagMediaCommand(ks, sha, "key1", `["tag3"]`, []Tag{"tag3"}, []Tag{"tag1"}, nil)...)).
+				Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(1))}),
+			rc.EXPECT().Do(ctx, rmock.Match("ZADD", ks.pendingMetadata(), "1000", "key1", "1000", "key2")).
+				Return(rmock.Result(rmock.RedisInt64(2))),
+		)
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		s.now = func() time.Time { return time.UnixMilli(1000) }
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{Keys: []string{"key1", "key2"}, Add: []string{"tag1"}})
+
+		require.Equal(t, TagMediaBatchResult{{Key: "key1"}, {Key: "key2"}}, result)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it gives up on media whose tags keep changing", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Nodes().Return(map[string]rueidis.Client{"node": rc}).Times(maxTagsReads)
+		rc.EXPECT().Do(ctx, rmock.Match("SCRIPT", "LOAD", tagMediaSource)).Return(rmock.Result(rmock.RedisString(sha))).Times(maxTagsReads)
+		rc.EXPECT().DoMulti(ctx, rmock.Match("HGET", ks.media("key1"), tagsField)).
+			Return([]rueidis.RedisResult{rmock.Result(rmock.RedisNil())}).Times(maxTagsReads)
+		rc.EXPECT().DoMulti(ctx, rmock.Match(tagMediaCommand(ks, sha, "key1", "", nil, []Tag{"tag1"}, nil)...)).
+			Return([]rueidis.RedisResult{rmock.Result(rmock.RedisInt64(-1))}).Times(maxTagsReads)
+
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{Keys: []string{"key1"}, Add: []string{"tag1"}})
+
+		require.ErrorIs(t, result[0].Err, ErrConflict)
+		require.EqualError(t, result[0].Err, "updating tags: conflict: media tags changing concurrently")
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it queues the tagged media for a metadata write", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "bucket", TagRules{}, false)
+		s.now = func() time.Time { return time.UnixMilli(1000) }
+		seedMedia(t, ctx, s, ks, "a", "t1")
+		seedMedia(t, ctx, s, ks, "b", "t1")
+
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{Keys: []string{"a", "b", "c"}, Add: []Tag{"t 2"}, Remove: []Tag{"t1"}})
+
+		require.NoError(t, result[0].Err)
+		require.NoError(t, result[1].Err)
+		require.ErrorIs(t, result[2].Err, ErrMediaNotFound)
+		queued, err := m.ZMembers(ks.pendingMetadata())
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, queued)
+		score, err := m.ZScore(ks.pendingMetadata(), "a")
+		require.NoError(t, err)
+		require.Equal(t, float64(1000), score)
+	})
+
+	t.Run("it keeps the tags if the media cannot be queued", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "bucket", TagRules{}, false)
+		seedMedia(t, ctx, s, ks, "a", "t1")
+		m.Set(ks.pendingMetadata(), "not a sorted set")
+
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{Keys: []string{"a"}, Add: []Tag{"t2"}})
+
+		require.NoError(t, result[0].Err)
+		require.Equal(t, `["t1","t2"]`, m.HGet(ks.media("a"), tagsField))
+	})
+
+	t.Run("it normalises the tags to remove", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		rules, err := NewTagRules(TagConfig{MaxLength: 64, Allowed: ".*", Case: CaseLower})
+		require.NoError(t, err)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", rules, false)
+		seedMedia(t, ctx, s, ks, "a", "goal", "t2")
+
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{Keys: []string{"a"}, Remove: []Tag{"Goal"}})
+
+		require.NoError(t, result[0].Err)
+		require.Equal(t, `["t2"]`, m.HGet(ks.media("a"), tagsField))
+	})
+}
+
+func TestDeleteMedia(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	t.Run("it deletes the record and its index entries", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		seedMedia(t, ctx, s, ks, "a", "t1", "t 2")
+		seedMedia(t, ctx, s, ks, "b", "t1", "t 2")
+
+		err := deleteMedia(ctx, rc, ks, "a")
+
+		require.NoError(t, err)
+		require.False(t, m.Exists(ks.media("a")))
+		members, err := m.SMembers(ks.tag("t1"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, members)
+		score, err := m.ZScore(ks.related("t 2"), "t1")
+		require.NoError(t, err)
+		require.Equal(t, float64(1), score)
+		pending, err := m.ZMembers(ks.pendingUploads())
+		require.NoError(t, err)
+		require.Equal(t, []string{ks.object("b")}, pending)
+	})
+
+	t.Run("the script leaves the record alone if its tags changed since they were read", func(t *testing.T) {
+		m, rc := newMiniredisClient(t)
+		s := NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false)
+		seedMedia(t, ctx, s, ks, "a", "t1")
+
+		keys := ks.appendTagKeys([]string{ks.media("a"), ks.pendingUploads()}, nil)
+		deleted, err := deleteMediaScript.Exec(ctx, rc, keys, []string{"a", ks.object("a"), "[]"}).AsInt64()
+
+		require.NoError(t, err)
+		require.Equal(t, int64(tagsChanged), deleted)
+		require.True(t, m.Exists(ks.media("a")))
+		require.True(t, m.Exists(ks.tag("t1")))
+	})
+}
+
+func TestDecodeTags(t *testing.T) {
+	for field, expected := range map[string][]Tag{
+		`["t 1","t,2"]`: {"t 1", "t,2"},
+		"[]":            {},
+		"t+1,t%2C2":     {"t 1", "t,2"},
+		"":              {},
+	} {
+		tags, err := decodeTags(field)
+		require.NoError(t, err, field)
+		require.Equal(t, expected, tags, field)
+	}
+
+	_, err := decodeTags("t%")
+	require.EqualError(t, err, `decoding tag 0: invalid URL escape "%"`)
+	_, err = decodeTags("[")
+	require.EqualError(t, err, "decoding tags: unexpected end of JSON input")
+}
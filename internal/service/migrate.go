@@ -0,0 +1,472 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/rueidis"
+
+	"scoreplay/internal/tenant"
+)
+
+const (
+	// SchemaVersion is the version of the data model read and written by this code.
+	SchemaVersion = 4
+	// schemaVersionKey holds the version of the data model stored. This key is shared by all
+	// tenants.
+	schemaVersionKey = "schema:version"
+	// legacySchemaVersion is the version of the data stored before the version was recorded.
+	legacySchemaVersion = 1
+)
+
+var (
+	ErrSchemaOutdated    = errors.New("schema is outdated")
+	ErrSchemaUnsupported = errors.New("schema is newer than supported")
+)
+
+// errKeyFound stops a scan at the first key found.
+var errKeyFound = errors.New("key found")
+
+// migration brings the data model from the version before its own to its version. It must be
+// safe to run again, after a failure or concurrently with itself.
+type migration struct {
+	version int
+	summary string
+	// run migrates the keys, and returns how many it changed.
+	run func(ctx context.Context, m migrator) (int, error)
+}
+
+var migrations = []migration{ //nolint: gochecknoglobals
+	{version: 2, summary: "Store the tags of the media records as JSON arrays", run: migrateTagsField},
+	{version: 3, summary: "Move the pending uploads under their tenants", run: migratePendingUploads},
+	{version: 4, summary: "Move the data stored before tenancy into the legacy tenant", run: migrateLegacyData},
+}
+
+// objectMover moves objects within the bucket.
+type objectMover interface {
+	objectCopier
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+type MigrationResult struct {
+	Version int
+	Summary string
+	// Migrated is the number of keys, or of the members of a key, changed.
+	Migrated int
+}
+
+type migrator struct {
+	bucket        string
+	legacyTenant  string
+	migrations    []migration
+	ns            Namespace
+	objects       objectMover
+	rueidisClient rueidis.Client
+}
+
+// NewMigrator creates the migrator bringing the data model stored to SchemaVersion. The data
+// stored before tenancy is moved into the legacy tenant, and left alone if there is none: the keys
+// it was stored under, unprefixed, may belong to another application.
+func NewMigrator(rueidisClient rueidis.Client, ns Namespace, objects objectMover, bucket, legacyTenant string) *migrator {
+	return &migrator{
+		bucket:        bucket,
+		legacyTenant:  legacyTenant,
+		migrations:    migrations,
+		ns:            ns,
+		objects:       objects,
+		rueidisClient: rueidisClient,
+	}
+}
+
+// Version returns the version of the data model stored. A keyspace without tenant data, nor data
+// stored before tenancy when there is a legacy tenant, is at SchemaVersion, while data stored
+// before versions were recorded is at version 1.
+func (m migrator) Version(ctx context.Context) (int, error) {
+	version, _, err := m.version(ctx)
+
+	return version, err
+}
+
+// Init records SchemaVersion on a keyspace without data, so that the data written next is not
+// taken for data stored before versions were recorded.
+func (m migrator) Init(ctx context.Context) error {
+	version, recorded, err := m.version(ctx)
+	if err != nil || recorded || version != SchemaVersion {
+		return err
+	}
+
+	return m.setVersion(ctx, SchemaVersion)
+}
+
+// version returns the version of the data model stored, and whether it was recorded.
+func (m migrator) version(ctx context.Context) (int, bool, error) {
+	version, err := m.rueidisClient.Do(ctx, m.rueidisClient.B().Get().Key(m.ns.key(schemaVersionKey)).Build()).AsInt64()
+	if err == nil {
+		return int(version), true, nil
+	}
+	if !rueidis.IsRedisNil(err) {
+		return 0, false, fmt.Errorf("getting schema version: %w", unavailable(err))
+	}
+
+	err = scanKeys(ctx, m.rueidisClient, m.ns.tenantPattern(), func([]string) error { return errKeyFound })
+	if errors.Is(err, errKeyFound) {
+		return legacySchemaVersion, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	// The data stored before tenancy is under none of the tenant keys.
+	found, err := m.legacyDataFound(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if found {
+		return legacySchemaVersion, false, nil
+	}
+
+	return SchemaVersion, false, nil
+}
+
+// Check fails unless the data model stored is at SchemaVersion.
+func (m migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	switch {
+	case err != nil:
+		return err
+	case version < SchemaVersion:
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaOutdated, version, SchemaVersion)
+	case version > SchemaVersion:
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaUnsupported, version, SchemaVersion)
+	}
+
+	return nil
+}
+
+// Migrate runs the migrations the data model stored is missing, in order, recording the version
+// after each one, while a keyspace without data is recorded at SchemaVersion. On a dry run, the
+// migrations are only reported.
+func (m migrator) Migrate(ctx context.Context, dryRun bool) ([]MigrationResult, error) {
+	version, recorded, err := m.version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !recorded && version == SchemaVersion && !dryRun {
+		return nil, m.setVersion(ctx, SchemaVersion)
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("%w: version %d, expected %d", ErrSchemaUnsupported, version, SchemaVersion)
+	}
+
+	var results []MigrationResult
+	for _, mig := range m.migrations {
+		if mig.version <= version {
+			continue
+		}
+		result := MigrationResult{Version: mig.version, Summary: mig.summary}
+		if !dryRun {
+			if result.Migrated, err = mig.run(ctx, m); err != nil {
+				return results, fmt.Errorf("migrating to version %d: %w", mig.version, err)
+			}
+			if err := m.setVersion(ctx, mig.version); err != nil {
+				return results, err
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (m migrator) setVersion(ctx context.Context, version int) error {
+	err := setSchemaVersionScript.Exec(ctx, m.rueidisClient, []string{m.ns.key(schemaVersionKey)}, []string{strconv.Itoa(version)}).Error()
+	if err != nil {
+		return fmt.Errorf("recording schema version: %w", unavailable(err))
+	}
+
+	return nil
+}
+
+// migrateTagsField rewrites the tags field of every media record as a JSON array.
+func migrateTagsField(ctx context.Context, m migrator) (int, error) {
+	rc, ns := m.rueidisClient, m.ns
+	var migrated int
+	err := scanKeys(ctx, rc, ns.tenantPattern(), func(keys []string) error {
+		var execs []rueidis.LuaExec
+		for _, key := range keys {
+			if _, rest, ok := ns.splitKey(key); ok && strings.HasPrefix(rest, mediaPrefix) {
+				execs = append(execs, rueidis.LuaExec{Keys: []string{key}})
+			}
+		}
+		if len(execs) == 0 {
+			return nil
+		}
+		for _, resp := range migrateTagsScript.ExecMulti(ctx, rc, execs...) {
+			changed, err := resp.AsInt64()
+			if err != nil {
+				return fmt.Errorf("migrating tags field: %w", unavailable(err))
+			}
+			migrated += int(changed)
+		}
+		return nil
+	})
+
+	return migrated, err
+}
+
+// migratePendingUploads moves the pending uploads shared by all tenants under the tenant of their
+// object, batch by batch. The uploads of objects out of the tenant directories are dropped.
+func migratePendingUploads(ctx context.Context, m migrator) (int, error) {
+	rc, ns := m.rueidisClient, m.ns
+	sharedKey := ns.key(pendingUploadsKey)
+	var migrated int
+	for {
+		uploads, err := rc.Do(ctx, rc.B().Zrange().Key(sharedKey).Min("0").Max(strconv.Itoa(scanCount-1)).Withscores().Build()).AsZScores()
+		if err != nil {
+			return migrated, fmt.Errorf("getting pending uploads: %w", unavailable(err))
+		}
+		if len(uploads) == 0 {
+			return migrated, nil
+		}
+
+		var moves rueidis.Commands
+		objects := make([]string, len(uploads))
+		for i, u := range uploads {
+			objects[i] = u.Member
+			if id, _, ok := strings.Cut(u.Member, "/"); ok && tenant.Valid(id) {
+				moves = append(moves, rc.B().Zadd().Key(ns.keyspace(id).pendingUploads()).ScoreMember().ScoreMember(u.Score, u.Member).Build())
+			}
+		}
+		for _, resp := range rc.DoMulti(ctx, moves...) {
+			if err := resp.Error(); err != nil {
+				return migrated, fmt.Errorf("moving pending uploads: %w", unavailable(err))
+			}
+		}
+		// The uploads leave the shared key once stored under their tenant, so that a failed batch
+		// is moved again.
+		if err := rc.Do(ctx, rc.B().Zrem().Key(sharedKey).Member(objects...).Build()).Error(); err != nil {
+			return migrated, fmt.Errorf("moving pending uploads: %w", unavailable(err))
+		}
+		migrated += len(moves)
+	}
+}
+
+// migrateLegacyData moves the catalog stored before tenancy, under the unprefixed keys and the
+// objects of no tenant, into the legacy tenant. Only the keys of that layout are read: the media
+// hashes, and the collections and tags listed by the legacy indexes. Every key is deleted once
+// moved, along with the tag indexes and related tags counts, which the media moved rebuilt.
+func migrateLegacyData(ctx context.Context, m migrator) (int, error) {
+	if m.legacyTenant == "" {
+		return 0, nil
+	}
+	if !tenant.Valid(m.legacyTenant) {
+		return 0, fmt.Errorf("invalid legacy tenant %q", m.legacyTenant)
+	}
+	ks := m.ns.keyspace(m.legacyTenant)
+	rc := m.rueidisClient
+
+	var migrated int
+	err := m.scanLegacyMedia(ctx, func(keys []string) error {
+		records := make(map[string]map[string]string, len(keys))
+		err := fetchKeys(ctx, rc, keys, func(key string) rueidis.Completed {
+			return rc.B().Hgetall().Key(key).Build()
+		}, func(key string, resp rueidis.RedisResult) error {
+			record, err := resp.AsStrMap()
+			if err != nil {
+				return fmt.Errorf("getting media record: %w", unavailable(err))
+			}
+			// The record was moved since the scan, or is no media record.
+			if _, ok := record[nameField]; ok {
+				records[strings.TrimPrefix(key, mediaPrefix)] = record
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(records) {
+			if err := m.moveLegacyMedia(ctx, ks, key, records[key]); err != nil {
+				return err
+			}
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		return migrated, err
+	}
+
+	ids, err := rc.Do(ctx, rc.B().Smembers().Key(collectionsKey).Build()).AsStrSlice()
+	if err != nil {
+		return migrated, fmt.Errorf("getting collections: %w", unavailable(err))
+	}
+	for _, id := range ids {
+		moved, err := m.moveLegacyCollection(ctx, ks, id)
+		if err != nil {
+			return migrated, err
+		}
+		if moved {
+			migrated++
+		}
+	}
+	if err := rc.Do(ctx, rc.B().Del().Key(collectionsKey).Build()).Error(); err != nil {
+		return migrated, fmt.Errorf("deleting collections: %w", unavailable(err))
+	}
+
+	tags, err := rc.Do(ctx, rc.B().Smembers().Key(tagsKey).Build()).AsStrSlice()
+	if err != nil {
+		return migrated, fmt.Errorf("getting tags: %w", unavailable(err))
+	}
+	if len(tags) == 0 {
+		return migrated, nil
+	}
+	if err := rc.Do(ctx, rc.B().Sadd().Key(ks.tags()).Member(tags...).Build()).Error(); err != nil {
+		return migrated, fmt.Errorf("moving tags: %w", unavailable(err))
+	}
+	// The tags are deleted last, so that a failed run deletes their indexes again. Keys of
+	// different slots cannot be deleted together.
+	cmds := make(rueidis.Commands, 0, 2*len(tags)+1)
+	for _, tag := range tags {
+		cmds = append(cmds, rc.B().Del().Key(tagsPrefix+tag).Build(), rc.B().Del().Key(relatedPrefix+tag).Build())
+	}
+	cmds = append(cmds, rc.B().Del().Key(tagsKey).Build())
+	for chunk := range slices.Chunk(cmds, scanCount) {
+		for _, resp := range rc.DoMulti(ctx, chunk...) {
+			if err := resp.Error(); err != nil {
+				return migrated, fmt.Errorf("deleting tags: %w", unavailable(err))
+			}
+		}
+	}
+
+	return migrated + len(tags), nil
+}
+
+// legacyDataFound reports whether any data was stored before tenancy. It is only looked for when
+// there is a legacy tenant to move it into.
+func (m migrator) legacyDataFound(ctx context.Context) (bool, error) {
+	if m.legacyTenant == "" {
+		return false, nil
+	}
+	// Keys of different slots cannot be checked together.
+	for _, resp := range m.rueidisClient.DoMulti(ctx,
+		m.rueidisClient.B().Exists().Key(tagsKey).Build(),
+		m.rueidisClient.B().Exists().Key(collectionsKey).Build(),
+	) {
+		n, err := resp.AsInt64()
+		if err != nil {
+			return false, fmt.Errorf("getting legacy keys: %w", unavailable(err))
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	err := m.scanLegacyMedia(ctx, func([]string) error { return errKeyFound })
+	if errors.Is(err, errKeyFound) {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// scanLegacyMedia calls fn with every page of the media hashes stored before tenancy, leaving out
+// the keys of the tenants a prefix would make match.
+func (m migrator) scanLegacyMedia(ctx context.Context, fn func(keys []string) error) error {
+	return scanKeysOfType(ctx, m.rueidisClient, mediaPrefix+"*", "hash", func(keys []string) error {
+		keys = slices.DeleteFunc(keys, func(key string) bool {
+			_, _, ok := m.ns.splitKey(key)
+			return ok
+		})
+		if len(keys) == 0 {
+			return nil
+		}
+		return fn(keys)
+	})
+}
+
+// moveLegacyMedia moves a media stored before tenancy, and its object, into the given keyspace.
+// The legacy record is deleted last, so that a failed move is run again.
+func (m migrator) moveLegacyMedia(ctx context.Context, ks keyspace, key string, record map[string]string) error {
+	tags, err := decodeTags(record[tagsField])
+	if err != nil {
+		return fmt.Errorf("decoding tags of media %s: %w", key, err)
+	}
+
+	legacyUploaded, err := objectExists(ctx, m.objects, m.bucket, key)
+	if err != nil {
+		return err
+	}
+	uploaded := legacyUploaded
+	if legacyUploaded {
+		_, err := m.objects.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     &m.bucket,
+			Key:        aws.String(ks.object(key)),
+			CopySource: aws.String(copySource(m.bucket, key)),
+		})
+		if err != nil {
+			return fmt.Errorf("copying object of media %s: %w", key, err)
+		}
+	} else if uploaded, err = objectExists(ctx, m.objects, m.bucket, ks.object(key)); err != nil {
+		// A failed move may have copied the object already.
+		return err
+	}
+
+	exec := createMediaExec(ks, key, CreateMediaParams{Name: record[nameField], Tags: tags})
+	if err := createMediaScript.Exec(ctx, m.rueidisClient, exec.Keys, exec.Args).Error(); err != nil {
+		return fmt.Errorf("storing media %s: %w", key, unavailable(err))
+	}
+	// The script marks the upload as pending. The uploads never completed are left to the janitor.
+	if uploaded {
+		if err := m.rueidisClient.Do(ctx, m.rueidisClient.B().Zrem().Key(ks.pendingUploads()).Member(ks.object(key)).Build()).Error(); err != nil {
+			return fmt.Errorf("confirming upload of media %s: %w", key, unavailable(err))
+		}
+	}
+	if legacyUploaded {
+		if _, err := m.objects.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &m.bucket, Key: &key}); err != nil {
+			return fmt.Errorf("deleting object of media %s: %w", key, err)
+		}
+	}
+	if err := m.rueidisClient.Do(ctx, m.rueidisClient.B().Del().Key(mediaPrefix+key).Build()).Error(); err != nil {
+		return fmt.Errorf("deleting media record %s: %w", key, unavailable(err))
+	}
+
+	return nil
+}
+
+// moveLegacyCollection moves a collection stored before tenancy into the given keyspace. It
+// reports whether the collection still existed.
+func (m migrator) moveLegacyCollection(ctx context.Context, ks keyspace, key string) (bool, error) {
+	legacyKey := collectionPrefix + key
+	record, err := m.rueidisClient.Do(ctx, m.rueidisClient.B().Hgetall().Key(legacyKey).Build()).AsStrMap()
+	if err != nil {
+		return false, fmt.Errorf("getting collection %s: %w", key, unavailable(err))
+	}
+	if len(record) == 0 {
+		return false, nil
+	}
+	items, err := m.rueidisClient.Do(ctx, m.rueidisClient.B().Lrange().Key(legacyKey+itemsSuffix).Start(0).Stop(-1).Build()).AsStrSlice()
+	if err != nil {
+		return false, fmt.Errorf("getting items of collection %s: %w", key, unavailable(err))
+	}
+
+	args := append([]string{key, record[nameField], record[coverField]}, items...)
+	err = storeCollectionScript.Exec(ctx, m.rueidisClient, []string{ks.collections(), ks.collection(key), ks.collectionItems(key)}, args).Error()
+	if err != nil {
+		return false, fmt.Errorf("storing collection %s: %w", key, unavailable(err))
+	}
+	// Keys of different slots cannot be deleted together.
+	for _, resp := range m.rueidisClient.DoMulti(ctx,
+		m.rueidisClient.B().Del().Key(legacyKey+itemsSuffix).Build(),
+		m.rueidisClient.B().Del().Key(legacyKey).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return false, fmt.Errorf("deleting collection %s: %w", key, unavailable(err))
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator_Version(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it reports the current version of an empty keyspace without recording it", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+
+		version, err := NewMigrator(rc, Namespace{}, nil, "", "").Version(ctx)
+
+		require.NoError(t, err)
+		require.Equal(t, SchemaVersion, version)
+		require.False(t, s.Exists(schemaVersionKey))
+	})
+
+	t.Run("it reports version 1 for data stored before versions were recorded", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		_, err := s.SAdd("tenant:club:tags", "t1")
+		require.NoError(t, err)
+
+		version, err := NewMigrator(rc, Namespace{}, nil, "", "").Version(ctx)
+
+		require.NoError(t, err)
+		require.Equal(t, 1, version)
+		require.False(t, s.Exists(schemaVersionKey))
+	})
+
+	t.Run("it reports version 1 for data stored before tenancy", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.HSet("media:a", nameField, "a", tagsField, "t1")
+
+		version, err := NewMigrator(rc, Namespace{Prefix: "app:"}, nil, "", "club").Version(ctx)
+
+		require.NoError(t, err)
+		require.Equal(t, 1, version)
+		require.False(t, s.Exists("app:"+schemaVersionKey))
+	})
+
+	t.Run("it ignores the keys of no tenant without a legacy tenant", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.HSet("media:a", nameField, "a", tagsField, "t1")
+
+		version, err := NewMigrator(rc, Namespace{Prefix: "app:"}, nil, "", "").Version(ctx)
+
+		require.NoError(t, err)
+		require.Equal(t, SchemaVersion, version)
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.SetError("oops")
+
+		_, err := NewMigrator(rc, Namespace{}, nil, "", "").Version(ctx)
+
+		require.EqualError(t, err, "getting schema version: oops")
+	})
+}
+
+func TestMigrator_Init(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it records the current version on an empty keyspace", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+
+		err := NewMigrator(rc, Namespace{}, nil, "", "").Init(ctx)
+
+		require.NoError(t, err)
+		v, err := s.Get(schemaVersionKey)
+		require.NoError(t, err)
+		require.Equal(t, "4", v)
+	})
+
+	t.Run("it records nothing on data stored before versions were recorded", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		_, err := s.SAdd("tenant:club:tags", "t1")
+		require.NoError(t, err)
+
+		err = NewMigrator(rc, Namespace{}, nil, "", "").Init(ctx)
+
+		require.NoError(t, err)
+		require.False(t, s.Exists(schemaVersionKey))
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.SetError("oops")
+
+		err := NewMigrator(rc, Namespace{}, nil, "", "").Init(ctx)
+
+		require.EqualError(t, err, "getting schema version: oops")
+	})
+}
+
+func TestMigrator_Check(t *testing.T) {
+	ctx := context.Background()
+
+	for version, expected := range map[string]string{
+		"1": "schema is outdated: version 1, expected 4",
+		"2": "schema is outdated: version 2, expected 4",
+		"3": "schema is outdated: version 3, expected 4",
+		"4": "",
+		"5": "schema is newer than supported: version 5, expected 4",
+	} {
+		s, rc := newMiniredisClient(t)
+		require.NoError(t, s.Set(schemaVersionKey, version))
+
+		err := NewMigrator(rc, Namespace{}, nil, "", "").Check(ctx)
+
+		if expected == "" {
+			require.NoError(t, err)
+		} else {
+			require.EqualError(t, err, expected)
+		}
+	}
+}
+
+func TestMigrator_Migrate(t *testing.T) {
+	ctx, ks := tenantContext()
+
+	other := Namespace{}.keyspace("other")
+	setup := func(t *testing.T) (*mediaService, func(key string) string, func() string) {
+		t.Helper()
+		s, rc := newMiniredisClient(t)
+		for score, object := range []string{ks.object("a"), other.object("d"), "not-an-object"} {
+			_, err := s.ZAdd(pendingUploadsKey, float64(score), object)
+			require.NoError(t, err)
+		}
+		s.HSet(ks.media("a"), nameField, "a", tagsField, "t+1,t%2C2")
+		s.HSet(ks.media("b"), nameField, "b", tagsField, "")
+		s.HSet(ks.media("c"), nameField, "c", tagsField, `["t3"]`)
+		require.NoError(t, s.Set(ks.idempotency(mediaKey, "idem"), "fingerprint a"))
+		_, err := s.SAdd(ks.tag("t 1"), "a")
+		require.NoError(t, err)
+		field := func(key string) string { return s.HGet(ks.media(key), tagsField) }
+		version := func() string {
+			v, _ := s.Get(schemaVersionKey)
+			return v
+		}
+		return NewMediaService(rc, nil, Namespace{}, nil, url.URL{}, "", TagRules{}, false), field, version
+	}
+
+	t.Run("it runs every migration", func(t *testing.T) {
+		s, field, version := setup(t)
+		m := NewMigrator(s.rueidisClient, Namespace{}, nil, "", "")
+
+		results, err := m.Migrate(ctx, false)
+
+		require.NoError(t, err)
+		require.Equal(t, []MigrationResult{
+			{Version: 2, Summary: "Store the tags of the media records as JSON arrays", Migrated: 2},
+			{Version: 3, Summary: "Move the pending uploads under their tenants", Migrated: 2},
+			{Version: 4, Summary: "Move the data stored before tenancy into the legacy tenant"},
+		}, results)
+		require.Equal(t, `["t 1","t,2"]`, field("a"))
+		require.Equal(t, "[]", field("b"))
+		require.Equal(t, `["t3"]`, field("c"))
+		require.Equal(t, "4", version())
+
+		results, err = m.Migrate(ctx, false)
+
+		require.NoError(t, err)
+		require.Empty(t, results)
+	})
+
+	t.Run("it only reports the migrations on a dry run", func(t *testing.T) {
+		s, field, version := setup(t)
+
+		results, err := NewMigrator(s.rueidisClient, Namespace{}, nil, "", "").Migrate(ctx, true)
+
+		require.NoError(t, err)
+		require.Equal(t, []MigrationResult{
+			{Version: 2, Summary: "Store the tags of the media records as JSON arrays"},
+			{Version: 3, Summary: "Move the pending uploads under their tenants"},
+			{Version: 4, Summary: "Move the data stored before tenancy into the legacy tenant"},
+		}, results)
+		require.Equal(t, "t+1,t%2C2", field("a"))
+		require.Empty(t, version())
+	})
+
+	t.Run("it moves the pending uploads under their tenants", func(t *testing.T) {
+		s, _, version := setup(t)
+		rc := s.rueidisClient
+		require.NoError(t, rc.Do(ctx, rc.B().Set().Key(schemaVersionKey).Value("2").Build()).Error())
+		pending := func(key string) []rueidis.ZScore {
+			scores, err := rc.Do(ctx, rc.B().Zrange().Key(key).Min("0").Max("-1").Withscores().Build()).AsZScores()
+			require.NoError(t, err)
+			return scores
+		}
+
+		results, err := NewMigrator(rc, Namespace{}, nil, "", "").Migrate(ctx, false)
+
+		require.NoError(t, err)
+		require.Equal(t, []MigrationResult{
+			{Version: 3, Summary: "Move the pending uploads under their tenants", Migrated: 2},
+			{Version: 4, Summary: "Move the data stored before tenancy into the legacy tenant"},
+		}, results)
+		require.Equal(t, []rueidis.ZScore{{Member: ks.object("a"), Score: 0}}, pending(ks.pendingUploads()))
+		require.Equal(t, []rueidis.ZScore{{Member: other.object("d"), Score: 1}}, pending(other.pendingUploads()))
+		require.Empty(t, pending(pendingUploadsKey))
+		require.Equal(t, "4", version())
+	})
+
+	t.Run("the scripts read the tags fields not migrated yet", func(t *testing.T) {
+		s, field, _ := setup(t)
+
+		result := s.TagMediaBatch(ctx, TagMediaBatchParams{Keys: []string{"a"}, Add: []Tag{"t4"}, Remove: []Tag{"t 1"}})
+
+		require.NoError(t, result[0].Err)
+		require.Equal(t, `["t,2","t4"]`, field("a"))
+		media, err := s.ListMedia(ctx, ListMediaParams{Tag: "t4"})
+		require.NoError(t, err)
+		require.Equal(t, []Tag{"t,2", "t4"}, media.Media[0].Tags)
+	})
+
+	t.Run("it records the current version on an empty keyspace", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+
+		results, err := NewMigrator(rc, Namespace{}, nil, "", "").Migrate(ctx, false)
+
+		require.NoError(t, err)
+		require.Empty(t, results)
+		v, err := s.Get(schemaVersionKey)
+		require.NoError(t, err)
+		require.Equal(t, "4", v)
+	})
+
+	t.Run("it fails on a newer version", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		require.NoError(t, s.Set(schemaVersionKey, "5"))
+
+		results, err := NewMigrator(rc, Namespace{}, nil, "", "").Migrate(ctx, false)
+
+		require.Empty(t, results)
+		require.ErrorIs(t, err, ErrSchemaUnsupported)
+	})
+
+	t.Run("it fails if a migration fails", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		require.NoError(t, s.Set(ks.media("a"), "not a hash"))
+
+		results, err := NewMigrator(rc, Namespace{}, nil, "", "").Migrate(ctx, false)
+
+		require.Empty(t, results)
+		require.ErrorContains(t, err, "migrating to version 2: migrating tags field: ")
+		require.False(t, s.Exists(schemaVersionKey))
+	})
+}
+
+func TestMigrator_MigrateLegacyData(t *testing.T) {
+	ctx := context.Background()
+	ks := Namespace{Prefix: "app:"}.keyspace("club")
+	summary := "Move the data stored before tenancy into the legacy tenant"
+
+	// setup stores a catalog the way it was stored before tenancy: neither prefix nor tenant on
+	// the keys, tags joined by commas, objects at the root of the bucket, and no version recorded.
+	setup := func(t *testing.T) (*miniredis.Miniredis, rueidis.Client) {
+		t.Helper()
+		s, rc := newMiniredisClient(t)
+		_, err := s.SAdd("tags", "t 1", "t,2", "unused")
+		require.NoError(t, err)
+		s.HSet("media:a", nameField, "a", tagsField, "t+1,t%2C2")
+		s.HSet("media:b", nameField, "b", tagsField, "t+1")
+		_, err = s.SAdd("tags:t 1", "a", "b")
+		require.NoError(t, err)
+		_, err = s.SAdd("tags:t,2", "a")
+		require.NoError(t, err)
+		_, err = s.ZAdd("related:t 1", 1, "t,2")
+		require.NoError(t, err)
+		_, err = s.ZAdd("related:t,2", 1, "t 1")
+		require.NoError(t, err)
+		_, err = s.SAdd("collections", "c")
+		require.NoError(t, err)
+		s.HSet("collection:c", nameField, "best", coverField, "a")
+		_, err = s.Push("collection:c:items", "b", "a")
+		require.NoError(t, err)
+		// A tenant holding data already.
+		_, err = s.SAdd(ks.tags(), "t3")
+		require.NoError(t, err)
+		// The keys of another application.
+		require.NoError(t, s.Set("media:other", "x"))
+		s.HSet("media:config", "size", "1")
+		_, err = s.SAdd("tags:other", "x")
+		require.NoError(t, err)
+		s.HSet("collection:other", nameField, "x")
+		return s, rc
+	}
+	foreignKeys := []string{"collection:other", "media:config", "media:other", "tags:other"}
+
+	t.Run("it moves the data into the legacy tenant", func(t *testing.T) {
+		s, rc := setup(t)
+		m := &mock.Mock{}
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT("a")}).Return(&s3.HeadObjectOutput{}, nil).Once().
+			On("CopyObject", ctx, &s3.CopyObjectInput{Bucket: pT("bucket"), Key: pT(ks.object("a")), CopySource: pT("bucket/a")}).
+			Return(&s3.CopyObjectOutput{}, nil).Once().
+			On("DeleteObject", ctx, &s3.DeleteObjectInput{Bucket: pT("bucket"), Key: pT("a")}).Return(&s3.DeleteObjectOutput{}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT("b")}).
+			Return((*s3.HeadObjectOutput)(nil), &types.NotFound{}).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object("b"))}).
+			Return((*s3.HeadObjectOutput)(nil), &types.NotFound{}).Once()
+		migrator := NewMigrator(rc, Namespace{Prefix: "app:"}, &mockObjectStore{m: m}, "bucket", "club")
+
+		results, err := migrator.Migrate(ctx, false)
+
+		require.NoError(t, err)
+		require.Equal(t, []MigrationResult{
+			{Version: 2, Summary: "Store the tags of the media records as JSON arrays"},
+			{Version: 3, Summary: "Move the pending uploads under their tenants"},
+			{Version: 4, Summary: summary, Migrated: 6},
+		}, results)
+		for _, key := range s.Keys() {
+			require.True(t, strings.HasPrefix(key, "app:") || slices.Contains(foreignKeys, key), key)
+		}
+		for _, key := range foreignKeys {
+			require.True(t, s.Exists(key), key)
+		}
+		require.Equal(t, `["t 1","t,2"]`, s.HGet(ks.media("a"), tagsField))
+		require.Equal(t, "a", s.HGet(ks.media("a"), nameField))
+		require.Equal(t, `["t 1"]`, s.HGet(ks.media("b"), tagsField))
+		tags, err := s.SMembers(ks.tags())
+		require.NoError(t, err)
+		require.Equal(t, []string{"t 1", "t,2", "t3", "unused"}, tags)
+		members, err := s.SMembers(ks.tag("t 1"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, members)
+		score, err := s.ZScore(ks.related("t 1"), "t,2")
+		require.NoError(t, err)
+		require.Equal(t, 1.0, score)
+		// The media never uploaded is left to the janitor.
+		pending, err := s.ZMembers(ks.pendingUploads())
+		require.NoError(t, err)
+		require.Equal(t, []string{ks.object("b")}, pending)
+		collections, err := s.SMembers(ks.collections())
+		require.NoError(t, err)
+		require.Equal(t, []string{"c"}, collections)
+		require.Equal(t, "best", s.HGet(ks.collection("c"), nameField))
+		require.Equal(t, "a", s.HGet(ks.collection("c"), coverField))
+		items, err := s.List(ks.collectionItems("c"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "a"}, items)
+		version, err := s.Get("app:" + schemaVersionKey)
+		require.NoError(t, err)
+		require.Equal(t, "4", version)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it finishes a failed move", func(t *testing.T) {
+		s, rc := setup(t)
+		s.HSet(ks.media("a"), nameField, "a", tagsField, `["t 1","t,2"]`)
+		_, err := s.SAdd(ks.tag("t 1"), "a")
+		require.NoError(t, err)
+		m := &mock.Mock{}
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT("a")}).
+			Return((*s3.HeadObjectOutput)(nil), &types.NotFound{}).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object("a"))}).Return(&s3.HeadObjectOutput{}, nil).Once().
+			On("HeadObject", ctx, mock.Anything).Return((*s3.HeadObjectOutput)(nil), &types.NotFound{})
+
+		_, err = NewMigrator(rc, Namespace{Prefix: "app:"}, &mockObjectStore{m: m}, "bucket", "club").Migrate(ctx, false)
+
+		require.NoError(t, err)
+		// The media moved already is not counted twice.
+		require.False(t, s.Exists(ks.related("t 1")))
+		pending, err := s.ZMembers(ks.pendingUploads())
+		require.NoError(t, err)
+		require.Equal(t, []string{ks.object("b")}, pending)
+		require.False(t, s.Exists("media:a"))
+	})
+
+	t.Run("it leaves the keys of no tenant alone without a legacy tenant", func(t *testing.T) {
+		s, rc := setup(t)
+		keys := s.Keys()
+
+		results, err := NewMigrator(rc, Namespace{Prefix: "app:"}, nil, "bucket", "").Migrate(ctx, false)
+
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		require.Zero(t, results[2].Migrated)
+		require.ElementsMatch(t, append(keys, "app:"+schemaVersionKey), s.Keys())
+		require.Equal(t, "t+1,t%2C2", s.HGet("media:a", tagsField))
+	})
+
+	t.Run("it fails on an invalid legacy tenant", func(t *testing.T) {
+		s, rc := setup(t)
+
+		results, err := NewMigrator(rc, Namespace{Prefix: "app:"}, nil, "bucket", "a b").Migrate(ctx, false)
+
+		require.Len(t, results, 2)
+		require.EqualError(t, err, `migrating to version 4: invalid legacy tenant "a b"`)
+		require.Equal(t, "t+1,t%2C2", s.HGet("media:a", tagsField))
+	})
+
+	t.Run("it fails if an object cannot be moved", func(t *testing.T) {
+		s, rc := setup(t)
+		m := &mock.Mock{}
+		m.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT("a")}).Return(&s3.HeadObjectOutput{}, nil).Once().
+			On("CopyObject", ctx, mock.Anything).Return((*s3.CopyObjectOutput)(nil), assert.AnError).Once()
+
+		results, err := NewMigrator(rc, Namespace{Prefix: "app:"}, &mockObjectStore{m: m}, "bucket", "club").Migrate(ctx, false)
+
+		require.Len(t, results, 2)
+		require.ErrorIs(t, err, assert.AnError)
+		require.ErrorContains(t, err, "migrating to version 4: copying object of media a: ")
+		require.Equal(t, "t+1,t%2C2", s.HGet("media:a", tagsField))
+		require.False(t, s.Exists(ks.media("a")))
+	})
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+const rateLimitPrefix = "ratelimit:"
+
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int64
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// rateLimiter keeps the rate limit state of every client in Redis, so limits hold across replicas.
+type rateLimiter struct {
+	ns            Namespace
+	rueidisClient rueidis.Client
+}
+
+func NewRateLimiter(rueidisClient rueidis.Client, ns Namespace) *rateLimiter {
+	return &rateLimiter{ns: ns, rueidisClient: rueidisClient}
+}
+
+// Allow counts a request of the client identified by key against the given limit.
+func (s rateLimiter) Allow(ctx context.Context, key string, limit RateLimit) (*RateLimitResult, error) {
+	values, err := rateLimitScript.Exec(ctx, s.rueidisClient,
+		[]string{s.ns.key(rateLimitPrefix + key)},
+		[]string{fmt.Sprint(limit.Requests), fmt.Sprint(limit.Period.Milliseconds())},
+	).AsIntSlice()
+	if err != nil {
+		return nil, fmt.Errorf("counting request: %w", err)
+	}
+	if len(values) != 4 {
+		return nil, fmt.Errorf("counting request: unexpected reply %v", values)
+	}
+
+	return &RateLimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  values[1],
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
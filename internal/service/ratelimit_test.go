@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rmock "github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	rc := rmock.NewClient(nil)
+
+	s := NewRateLimiter(rc, Namespace{})
+
+	require.NotNil(t, s)
+	require.Equal(t, rc, s.rueidisClient)
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	ctx := context.Background()
+	sha := scriptSHA(rateLimitSource)
+	limit := RateLimit{Requests: 10, Period: time.Minute}
+
+	t.Run("it allows the request", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "1", rateLimitPrefix+"client", "10", "60000")).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisInt64(1), rmock.RedisInt64(9), rmock.RedisInt64(0), rmock.RedisInt64(6000))))
+
+		result, err := NewRateLimiter(rc, Namespace{}).Allow(ctx, "client", limit)
+
+		require.NoError(t, err)
+		require.Equal(t, &RateLimitResult{Allowed: true, Remaining: 9, Reset: 6 * time.Second}, result)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it denies the request", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "1", rateLimitPrefix+"client", "10", "60000")).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisInt64(0), rmock.RedisInt64(0), rmock.RedisInt64(1500), rmock.RedisInt64(60000))))
+
+		result, err := NewRateLimiter(rc, Namespace{}).Allow(ctx, "client", limit)
+
+		require.NoError(t, err)
+		require.Equal(t, &RateLimitResult{RetryAfter: 1500 * time.Millisecond, Reset: time.Minute}, result)
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "1", rateLimitPrefix+"client", "10", "60000")).Return(rmock.ErrorResult(assert.AnError))
+
+		_, err := NewRateLimiter(rc, Namespace{}).Allow(ctx, "client", limit)
+
+		require.EqualError(t, err, "counting request: "+assert.AnError.Error())
+		require.True(t, ctrl.Satisfied())
+	})
+
+	t.Run("it fails on an unexpected reply", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rc := rmock.NewClient(ctrl)
+		rc.EXPECT().Do(ctx, rmock.Match("EVALSHA", sha, "1", rateLimitPrefix+"client", "10", "60000")).
+			Return(rmock.Result(rmock.RedisArray(rmock.RedisInt64(1))))
+
+		_, err := NewRateLimiter(rc, Namespace{}).Allow(ctx, "client", limit)
+
+		require.EqualError(t, err, "counting request: unexpected reply [1]")
+		require.True(t, ctrl.Satisfied())
+	})
+}
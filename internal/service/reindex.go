@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/redis/rueidis"
+
+	"scoreplay/internal/tenant"
+)
+
+type ReindexOptions struct {
+	// Tenant restricts the reindex to the objects of a single tenant.
+	Tenant string
+	// DryRun reports the media which would be rebuilt without storing them.
+	DryRun bool
+}
+
+type ReindexedMedia struct {
+	Tenant string
+	Media  string
+	Name   string
+	Tags   []Tag
+	// Skipped tells the object carries no metadata to rebuild the media from, having been
+	// uploaded before the metadata was bound into the uploads.
+	Skipped bool
+}
+
+func (m ReindexedMedia) String() string {
+	if m.Skipped {
+		return fmt.Sprintf("skipped tenant=%q media=%q", m.Tenant, m.Media)
+	}
+
+	return fmt.Sprintf("reindexed tenant=%q media=%q name=%q tags=%q", m.Tenant, m.Media, m.Name, strings.Join(m.Tags, ","))
+}
+
+type reindexer struct {
+	bucket        string
+	ns            Namespace
+	objects       objectStore
+	rueidisClient rueidis.Client
+}
+
+// NewReindexer creates the reindexer rebuilding the media records from the metadata of the
+// objects of the bucket.
+func NewReindexer(rueidisClient rueidis.Client, ns Namespace, objects objectStore, bucket string) *reindexer {
+	return &reindexer{
+		bucket:        bucket,
+		ns:            ns,
+		objects:       objects,
+		rueidisClient: rueidisClient,
+	}
+}
+
+// Reindex rebuilds the records of the media missing from Redis, along with their tags, tag
+// indexes and related tags counts, from the metadata of their objects. The records which exist
+// are left alone, as their tags may have changed since the upload.
+func (r reindexer) Reindex(ctx context.Context, opts ReindexOptions) ([]ReindexedMedia, error) {
+	input := &s3.ListObjectsV2Input{Bucket: &r.bucket}
+	if opts.Tenant != "" {
+		input.Prefix = aws.String(opts.Tenant + "/")
+	}
+
+	var reindexed []ReindexedMedia
+	pages := s3.NewListObjectsV2Paginator(r.objects, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return reindexed, fmt.Errorf("listing objects: %w", err)
+		}
+		media, err := r.reindexObjects(ctx, page.Contents, opts.DryRun)
+		if err != nil {
+			return reindexed, err
+		}
+		reindexed = append(reindexed, media...)
+	}
+
+	return reindexed, nil
+}
+
+// reindexObjects rebuilds the media of the given objects whose record is missing, all of them in
+// a single pipelined round trip.
+func (r reindexer) reindexObjects(ctx context.Context, objects []types.Object, dryRun bool) ([]ReindexedMedia, error) {
+	var candidates []ReindexedMedia
+	for _, o := range objects {
+		if o.Key == nil {
+			continue
+		}
+		id, key, ok := strings.Cut(*o.Key, "/")
+		// Objects laid out otherwise do not belong to the catalog.
+		if !ok || !tenant.Valid(id) || key == "" || strings.Contains(key, "/") {
+			continue
+		}
+		candidates = append(candidates, ReindexedMedia{Tenant: id, Media: key})
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	cmds := make(rueidis.Commands, len(candidates))
+	for i, m := range candidates {
+		cmds[i] = r.rueidisClient.B().Exists().Key(r.ns.keyspace(m.Tenant).media(m.Media)).Build()
+	}
+	var missing []ReindexedMedia
+	for i, resp := range r.rueidisClient.DoMulti(ctx, cmds...) {
+		exists, err := resp.AsBool()
+		if err != nil {
+			return nil, fmt.Errorf("checking media: %w", unavailable(err))
+		}
+		if !exists {
+			missing = append(missing, candidates[i])
+		}
+	}
+
+	var reindexed []ReindexedMedia
+	var execs []rueidis.LuaExec
+	var confirms rueidis.Commands
+	for _, m := range missing {
+		ks := r.ns.keyspace(m.Tenant)
+		found, err := r.readMetadata(ctx, ks, &m)
+		if err != nil {
+			return nil, err
+		}
+		// The object was deleted since the listing.
+		if !found {
+			continue
+		}
+		reindexed = append(reindexed, m)
+		if !m.Skipped {
+			execs = append(execs, createMediaExec(ks, m.Media, CreateMediaParams{Name: m.Name, Tags: m.Tags}))
+			confirms = append(confirms, r.rueidisClient.B().Zrem().Key(ks.pendingUploads()).Member(ks.object(m.Media)).Build())
+		}
+	}
+	if dryRun || len(execs) == 0 {
+		return reindexed, nil
+	}
+
+	for i, resp := range createMediaScript.ExecMulti(ctx, r.rueidisClient, execs...) {
+		if err := resp.Error(); err != nil {
+			return nil, fmt.Errorf("storing media %d: %w", i, unavailable(err))
+		}
+	}
+	// The script marks the uploads as pending, while the objects are in the bucket already.
+	for _, resp := range r.rueidisClient.DoMulti(ctx, confirms...) {
+		if err := resp.Error(); err != nil {
+			return nil, fmt.Errorf("confirming uploads: %w", unavailable(err))
+		}
+	}
+
+	return reindexed, nil
+}
+
+// readMetadata fills the name and tags of the given media from the metadata of its object, or
+// marks it skipped if the object carries none. It reports whether the object still exists.
+func (r reindexer) readMetadata(ctx context.Context, ks keyspace, m *ReindexedMedia) (bool, error) {
+	out, err := r.objects.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &r.bucket, Key: aws.String(ks.object(m.Media))})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("getting object metadata: %w", err)
+	}
+
+	name, ok := out.Metadata[nameMetadata]
+	if !ok {
+		m.Skipped = true
+		return true, nil
+	}
+	if m.Name, err = url.QueryUnescape(name); err != nil {
+		return false, fmt.Errorf("decoding name of media %s: %w", m.Media, err)
+	}
+	if m.Tags, err = splitTags(out.Metadata[tagsMetadata]); err != nil {
+		return false, fmt.Errorf("decoding tags of media %s: %w", m.Media, err)
+	}
+
+	return true, nil
+}
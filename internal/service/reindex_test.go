@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReindexedMedia_String(t *testing.T) {
+	require.Equal(t, `reindexed tenant="club" media="key1" name="a b" tags="t1,t,2"`,
+		ReindexedMedia{Tenant: "club", Media: "key1", Name: "a b", Tags: []Tag{"t1", "t,2"}}.String())
+	require.Equal(t, `skipped tenant="club" media="key1"`, ReindexedMedia{Tenant: "club", Media: "key1", Skipped: true}.String())
+}
+
+func TestReindexer_Reindex(t *testing.T) {
+	ctx := context.Background()
+	ks := keyspace{tenant: "club"}
+	a, b, c, d := uuidAt(t, time.Now()), uuidAt(t, time.Now()), uuidAt(t, time.Now()), uuidAt(t, time.Now())
+	listed := &s3.ListObjectsV2Output{Contents: []types.Object{
+		{Key: pT(ks.object(a))},
+		{Key: pT(ks.object(b))},
+		{Key: pT(ks.object(c))},
+		{Key: pT(ks.object(d))},
+		{Key: pT(".backups/snapshot.json.gz")},
+	}}
+	// setup lists the objects, where a is indexed already, b carries metadata, c carries none and
+	// d was deleted since the listing.
+	setup := func(t *testing.T, input *s3.ListObjectsV2Input) *mock.Mock {
+		t.Helper()
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, input).Return(listed, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(b))}).
+			Return(&s3.HeadObjectOutput{Metadata: objectMetadata(CreateMediaParams{Name: "b 1", Tags: []Tag{"t1", "t,2"}})}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(c))}).
+			Return(&s3.HeadObjectOutput{}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(d))}).
+			Return((*s3.HeadObjectOutput)(nil), &types.NotFound{}).Once()
+		return m
+	}
+	expected := []ReindexedMedia{
+		{Tenant: "club", Media: b, Name: "b 1", Tags: []Tag{"t1", "t,2"}},
+		{Tenant: "club", Media: c, Skipped: true},
+	}
+
+	t.Run("it rebuilds the missing media from the metadata of their objects", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.HSet(ks.media(a), nameField, "a", tagsField, "t3")
+		m := setup(t, &s3.ListObjectsV2Input{Bucket: pT("bucket"), Prefix: pT("club/")})
+
+		reindexed, err := NewReindexer(rc, Namespace{}, &mockObjectStore{m: m}, "bucket").Reindex(ctx, ReindexOptions{Tenant: "club"})
+
+		require.NoError(t, err)
+		require.Equal(t, expected, reindexed)
+		require.Equal(t, "b 1", s.HGet(ks.media(b), nameField))
+		require.Equal(t, `["t1","t,2"]`, s.HGet(ks.media(b), tagsField))
+		require.Equal(t, "t3", s.HGet(ks.media(a), tagsField))
+		require.False(t, s.Exists(ks.media(c)))
+		tags, err := s.SMembers(ks.tags())
+		require.NoError(t, err)
+		require.Equal(t, []string{"t,2", "t1"}, tags)
+		members, err := s.SMembers(ks.tag("t,2"))
+		require.NoError(t, err)
+		require.Equal(t, []string{b}, members)
+		score, err := s.ZScore(ks.related("t1"), "t,2")
+		require.NoError(t, err)
+		require.Equal(t, 1.0, score)
+		require.False(t, s.Exists(ks.pendingUploads()))
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it writes nothing on a dry run", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.HSet(ks.media(a), nameField, "a", tagsField, "t3")
+		m := setup(t, &s3.ListObjectsV2Input{Bucket: pT("bucket")})
+
+		reindexed, err := NewReindexer(rc, Namespace{}, &mockObjectStore{m: m}, "bucket").Reindex(ctx, ReindexOptions{DryRun: true})
+
+		require.NoError(t, err)
+		require.Equal(t, expected, reindexed)
+		require.Equal(t, []string{ks.media(a)}, s.Keys())
+		m.AssertExpectations(t)
+	})
+
+	t.Run("it fails if the objects cannot be listed", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, &s3.ListObjectsV2Input{Bucket: pT("bucket")}).
+			Return((*s3.ListObjectsV2Output)(nil), assert.AnError).Once()
+
+		reindexed, err := NewReindexer(rc, Namespace{}, &mockObjectStore{m: m}, "bucket").Reindex(ctx, ReindexOptions{})
+
+		require.Empty(t, reindexed)
+		require.EqualError(t, err, "listing objects: "+assert.AnError.Error())
+	})
+
+	t.Run("it fails if the metadata cannot be read", func(t *testing.T) {
+		_, rc := newMiniredisClient(t)
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, &s3.ListObjectsV2Input{Bucket: pT("bucket")}).
+			Return(&s3.ListObjectsV2Output{Contents: []types.Object{{Key: pT(ks.object(b))}}}, nil).Once().
+			On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: pT("bucket"), Key: pT(ks.object(b))}).
+			Return((*s3.HeadObjectOutput)(nil), assert.AnError).Once()
+
+		reindexed, err := NewReindexer(rc, Namespace{}, &mockObjectStore{m: m}, "bucket").Reindex(ctx, ReindexOptions{})
+
+		require.Empty(t, reindexed)
+		require.EqualError(t, err, "getting object metadata: "+assert.AnError.Error())
+	})
+
+	t.Run("it fails if redis fails", func(t *testing.T) {
+		s, rc := newMiniredisClient(t)
+		s.SetError("oops")
+		m := &mock.Mock{}
+		m.On("ListObjectsV2", ctx, &s3.ListObjectsV2Input{Bucket: pT("bucket")}).Return(listed, nil).Once()
+
+		reindexed, err := NewReindexer(rc, Namespace{}, &mockObjectStore{m: m}, "bucket").Reindex(ctx, ReindexOptions{})
+
+		require.Empty(t, reindexed)
+		require.EqualError(t, err, "checking media: oops")
+	})
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/redis/rueidis"
+)
+
+// scanCount is the number of keys scanned, and of commands pipelined, per round trip.
+const scanCount = 1000
+
+// scanKeys calls fn with every page of keys matching the pattern, on every primary node. Keys are
+// reported once per primary holding them.
+func scanKeys(ctx context.Context, rc rueidis.Client, match string, fn func(keys []string) error) error {
+	return scanKeysOfType(ctx, rc, match, "", fn)
+}
+
+// scanKeysOfType is scanKeys restricted to the keys of a type, such as hash, unless typ is empty.
+func scanKeysOfType(ctx context.Context, rc rueidis.Client, match, typ string, fn func(keys []string) error) error {
+	nodes, err := primaryNodes(ctx, rc)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		var cursor uint64
+		for {
+			var cmd rueidis.Completed
+			if typ == "" {
+				cmd = node.B().Scan().Cursor(cursor).Match(match).Count(scanCount).Build()
+			} else {
+				cmd = node.B().Scan().Cursor(cursor).Match(match).Count(scanCount).Type(typ).Build()
+			}
+			entry, err := node.Do(ctx, cmd).AsScanEntry()
+			if err != nil {
+				return fmt.Errorf("scanning keys: %w", unavailable(err))
+			}
+			if len(entry.Elements) > 0 {
+				if err := fn(entry.Elements); err != nil {
+					return err
+				}
+			}
+			if entry.Cursor == 0 {
+				break
+			}
+			cursor = entry.Cursor
+		}
+	}
+
+	return nil
+}
+
+// primaryNodes returns the primary nodes of the client. The replicas of a cluster hold the keys of
+// their primary, which they would report again.
+func primaryNodes(ctx context.Context, rc rueidis.Client) ([]rueidis.Client, error) {
+	nodes := rc.Nodes()
+	// A single node, standalone or the primary chosen by the sentinels, needs no check.
+	if len(nodes) == 1 {
+		return slices.Collect(maps.Values(nodes)), nil
+	}
+
+	var primaries []rueidis.Client
+	for _, node := range nodes {
+		role, err := node.Do(ctx, node.B().Role().Build()).ToArray()
+		if err != nil {
+			return nil, fmt.Errorf("getting node role: %w", unavailable(err))
+		}
+		if len(role) > 0 {
+			if name, _ := role[0].ToString(); name == "master" {
+				primaries = append(primaries, node)
+			}
+		}
+	}
+
+	return primaries, nil
+}
+
+// patternEscaper escapes the special characters of the patterns of SCAN.
+var patternEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`) //nolint: gochecknoglobals
+
+// escapePattern escapes s so that a SCAN pattern matches it literally.
+func escapePattern(s string) string {
+	return patternEscaper.Replace(s)
+}
+
+// fetchKeys runs the command built for every key, in pipelined chunks, and hands its reply to read.
+func fetchKeys(ctx context.Context, rc rueidis.Client, keys []string, build func(key string) rueidis.Completed, read func(key string, resp rueidis.RedisResult) error) error {
+	for chunk := range slices.Chunk(slices.Compact(slices.Sorted(slices.Values(keys))), scanCount) {
+		cmds := make(rueidis.Commands, len(chunk))
+		for i, key := range chunk {
+			cmds[i] = build(key)
+		}
+		for i, resp := range rc.DoMulti(ctx, cmds...) {
+			if err := resp.Error(); err != nil {
+				return unavailable(err)
+			}
+			if err := read(chunk[i], resp); err != nil {
+				return fmt.Errorf("reading %s: %w", chunk[i], err)
+			}
+		}
+	}
+
+	return nil
+}
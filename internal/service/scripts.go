@@ -0,0 +1,49 @@
+package service
+
+import (
+	_ "embed"
+
+	"github.com/redis/rueidis"
+)
+
+var (
+	//go:embed lua/create_media.lua
+	createMediaSource string
+	createMediaScript = rueidis.NewLuaScript(createMediaSource)
+
+	//go:embed lua/delete_media.lua
+	deleteMediaSource string
+	deleteMediaScript = rueidis.NewLuaScript(deleteMediaSource)
+
+	//go:embed lua/sync_tag_index.lua
+	syncTagIndexSource string
+	syncTagIndexScript = rueidis.NewLuaScript(syncTagIndexSource)
+
+	//go:embed lua/tag_media.lua
+	tagMediaSource string
+	tagMediaScript = rueidis.NewLuaScript(tagMediaSource)
+
+	//go:embed lua/update_collection.lua
+	updateCollectionSource string
+	updateCollectionScript = rueidis.NewLuaScript(updateCollectionSource)
+
+	//go:embed lua/update_collection_items.lua
+	updateCollectionItemsSource string
+	updateCollectionItemsScript = rueidis.NewLuaScript(updateCollectionItemsSource)
+
+	//go:embed lua/store_collection.lua
+	storeCollectionSource string
+	storeCollectionScript = rueidis.NewLuaScript(storeCollectionSource)
+
+	//go:embed lua/migrate_tags.lua
+	migrateTagsSource string
+	migrateTagsScript = rueidis.NewLuaScript(migrateTagsSource)
+
+	//go:embed lua/set_schema_version.lua
+	setSchemaVersionSource string
+	setSchemaVersionScript = rueidis.NewLuaScript(setSchemaVersionSource)
+
+	//go:embed lua/rate_limit.lua
+	rateLimitSource string
+	rateLimitScript = rueidis.NewLuaScript(rateLimitSource)
+)
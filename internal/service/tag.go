@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	CasePreserve = "preserve"
+	CaseLower    = "lower"
+
+	// MaxTagLength is the longest tag the API accepts, whatever the configured rules.
+	MaxTagLength = 255
+)
+
+type TagConfig struct {
+	MaxLength int    `env:"MAX_LENGTH" default:"64"`
+	Allowed   string `env:"ALLOWED" default:"^[\\p{L}\\p{M}\\p{N}\\p{P}\\p{S} ]+$"`
+	Case      string `env:"CASE" default:"preserve"`
+	Strict    bool   `env:"STRICT" default:"false"`
+}
+
+// TagRules normalises tag names and rejects the invalid ones. The zero value only trims and
+// normalises tags, and rejects empty ones.
+type TagRules struct {
+	maxLength int
+	allowed   *regexp.Regexp
+	lower     bool
+	strict    bool
+}
+
+// NewTagRules compiles the configured rules. Rules left empty are not enforced.
+func NewTagRules(cfg TagConfig) (TagRules, error) {
+	rules := TagRules{maxLength: cfg.MaxLength, lower: cfg.Case == CaseLower, strict: cfg.Strict}
+	if cfg.MaxLength < 0 || cfg.MaxLength > MaxTagLength {
+		return TagRules{}, fmt.Errorf("max length must be between 0 and %d", MaxTagLength)
+	}
+	if cfg.Allowed != "" {
+		allowed, err := regexp.Compile(cfg.Allowed)
+		if err != nil {
+			return TagRules{}, fmt.Errorf("compiling allowed characters: %w", err)
+		}
+		rules.allowed = allowed
+	}
+	if cfg.Case != "" && cfg.Case != CasePreserve && cfg.Case != CaseLower {
+		return TagRules{}, fmt.Errorf("unknown case policy %q", cfg.Case)
+	}
+
+	return rules, nil
+}
+
+// Normalize trims the tag, puts it in Unicode NFC, applies the case policy, and then checks it
+// against the rules.
+func (r TagRules) Normalize(tag string) (Tag, error) {
+	if !utf8.ValidString(tag) {
+		return "", fmt.Errorf("%w: not valid UTF-8", ErrInvalidTag)
+	}
+	normalized := r.normalize(tag)
+
+	switch {
+	case normalized == "":
+		return "", fmt.Errorf("%w: empty", ErrInvalidTag)
+	case r.maxLength > 0 && utf8.RuneCountInString(normalized) > r.maxLength:
+		return "", fmt.Errorf("%w %q: longer than %d characters", ErrInvalidTag, normalized, r.maxLength)
+	case r.allowed != nil && !r.allowed.MatchString(normalized):
+		return "", fmt.Errorf("%w %q: contains characters that are not allowed", ErrInvalidTag, normalized)
+	}
+
+	return normalized, nil
+}
+
+// Lookup returns the names a tag naming stored data may be stored under: the tag normalised like
+// Normalize does, but not checked against the rules, and then the tag as given if it differs,
+// which tags stored before the rules changed may still be under.
+func (r TagRules) Lookup(tag string) []Tag {
+	normalized := r.normalize(tag)
+	if normalized == tag {
+		return []Tag{tag}
+	}
+
+	return []Tag{normalized, tag}
+}
+
+// LookupAll returns the names every tag may be stored under, without duplicates.
+func (r TagRules) LookupAll(tags []string) []Tag {
+	var names []Tag
+	for _, tag := range tags {
+		for _, name := range r.Lookup(tag) {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+func (r TagRules) normalize(tag string) Tag {
+	normalized := norm.NFC.String(strings.TrimSpace(tag))
+	if r.lower {
+		normalized = strings.ToLower(normalized)
+	}
+
+	return normalized
+}
+
+// NormalizeAll normalises every tag, dropping the ones that turn out to be duplicates. All the
+// invalid tags are reported together.
+func (r TagRules) NormalizeAll(tags []string) ([]Tag, error) {
+	normalized := make([]Tag, 0, len(tags))
+	var errs []error
+	for _, tag := range tags {
+		n, err := r.Normalize(tag)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !slices.Contains(normalized, n) {
+			normalized = append(normalized, n)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return normalized, nil
+}
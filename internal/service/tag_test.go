@@ -0,0 +1,116 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTagRules(t *testing.T) {
+	t.Run("it compiles the rules", func(t *testing.T) {
+		rules, err := NewTagRules(TagConfig{MaxLength: 64, Allowed: "^[a-z]+$", Case: CaseLower, Strict: true})
+
+		require.NoError(t, err)
+		require.Equal(t, 64, rules.maxLength)
+		require.Equal(t, "^[a-z]+$", rules.allowed.String())
+		require.True(t, rules.lower)
+		require.True(t, rules.strict)
+	})
+
+	t.Run("it fails on an invalid max length", func(t *testing.T) {
+		_, err := NewTagRules(TagConfig{MaxLength: MaxTagLength + 1, Allowed: ".*", Case: CasePreserve})
+
+		require.EqualError(t, err, "max length must be between 0 and 255")
+	})
+
+	t.Run("it leaves empty rules out", func(t *testing.T) {
+		rules, err := NewTagRules(TagConfig{})
+
+		require.NoError(t, err)
+		require.Equal(t, TagRules{}, rules)
+	})
+
+	t.Run("it fails on invalid allowed characters", func(t *testing.T) {
+		_, err := NewTagRules(TagConfig{MaxLength: 64, Allowed: "[", Case: CasePreserve})
+
+		require.ErrorContains(t, err, "compiling allowed characters: ")
+	})
+
+	t.Run("it fails on an unknown case policy", func(t *testing.T) {
+		_, err := NewTagRules(TagConfig{MaxLength: 64, Allowed: ".*", Case: "upper"})
+
+		require.EqualError(t, err, `unknown case policy "upper"`)
+	})
+}
+
+func TestTagRules_Normalize(t *testing.T) {
+	rules, err := NewTagRules(TagConfig{MaxLength: 8, Allowed: `^[\p{L}\p{M}\p{N} ]+$`, Case: CasePreserve})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		tag  string
+		want Tag
+		err  string
+	}{
+		{name: "it trims spaces", tag: " Arsenal\t", want: "Arsenal"},
+		{name: "it composes characters", tag: "Mesu\u0308t", want: "Mes\u00fct"},
+		{name: "it rejects empty tags", tag: "   ", err: "invalid tag: empty"},
+		{name: "it rejects long tags", tag: strings.Repeat("a", 9), err: `invalid tag "aaaaaaaaa": longer than 8 characters`},
+		{name: "it counts characters", tag: "\u00e9\u00e9\u00e9\u00e9\u00e9\u00e9\u00e9\u00e9", want: "\u00e9\u00e9\u00e9\u00e9\u00e9\u00e9\u00e9\u00e9"},
+		{name: "it rejects characters that are not allowed", tag: "a,b", err: `invalid tag "a,b": contains characters that are not allowed`},
+		{name: "it rejects invalid UTF-8", tag: "\xff", err: "invalid tag: not valid UTF-8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tag, err := rules.Normalize(tc.tag)
+
+			if tc.err != "" {
+				require.ErrorIs(t, err, ErrInvalidTag)
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, tag)
+		})
+	}
+
+	t.Run("it lowers the case", func(t *testing.T) {
+		tag, err := TagRules{lower: true}.Normalize("Wembley")
+
+		require.NoError(t, err)
+		require.Equal(t, "wembley", tag)
+	})
+}
+
+func TestTagRules_NormalizeAll(t *testing.T) {
+	t.Run("it drops duplicates", func(t *testing.T) {
+		tags, err := TagRules{lower: true}.NormalizeAll([]string{"Arsenal", "chelsea", " arsenal"})
+
+		require.NoError(t, err)
+		require.Equal(t, []Tag{"arsenal", "chelsea"}, tags)
+	})
+
+	t.Run("it reports every invalid tag", func(t *testing.T) {
+		_, err := TagRules{maxLength: 3}.NormalizeAll([]string{"", "ok", "long"})
+
+		require.ErrorIs(t, err, ErrInvalidTag)
+		require.EqualError(t, err, "invalid tag: empty\ninvalid tag \"long\": longer than 3 characters")
+	})
+}
+
+func TestTagRules_Lookup(t *testing.T) {
+	t.Run("it normalises the tag without checking it", func(t *testing.T) {
+		require.Equal(t, []Tag{"arsenal fc", " Arsenal FC"}, TagRules{maxLength: 3, lower: true}.Lookup(" Arsenal FC"))
+	})
+
+	t.Run("it returns a normalised tag alone", func(t *testing.T) {
+		require.Equal(t, []Tag{"arsenal"}, TagRules{lower: true}.Lookup("arsenal"))
+	})
+}
+
+func TestTagRules_LookupAll(t *testing.T) {
+	t.Run("it drops duplicates", func(t *testing.T) {
+		require.Equal(t, []Tag{"arsenal", "Arsenal", "chelsea"}, TagRules{lower: true}.LookupAll([]string{"Arsenal", "chelsea", "arsenal"}))
+	})
+}
@@ -0,0 +1,25 @@
+package tenant
+
+import (
+	"context"
+	"regexp"
+)
+
+// validID keeps tenant IDs safe to embed into Redis keys and object keys.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type ctxKey struct{}
+
+// Valid reports whether id can be used as a tenant ID.
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxKey{}).(string)
+	return id, ok && id != ""
+}
@@ -0,0 +1,33 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValid(t *testing.T) {
+	require.True(t, Valid("arsenal-fc_1"))
+	require.False(t, Valid(""))
+	require.False(t, Valid("arsenal:fc"))
+	require.False(t, Valid("arsenal/fc"))
+	require.False(t, Valid("arsenal fc"))
+	require.False(t, Valid(strings.Repeat("a", 65)))
+}
+
+func TestFromContext(t *testing.T) {
+	t.Run("it returns the tenant of the context", func(t *testing.T) {
+		id, ok := FromContext(WithID(context.Background(), "club"))
+		require.True(t, ok)
+		require.Equal(t, "club", id)
+	})
+
+	t.Run("it reports a context without tenant", func(t *testing.T) {
+		_, ok := FromContext(context.Background())
+		require.False(t, ok)
+		_, ok = FromContext(WithID(context.Background(), ""))
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,157 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+
+	"scoreplay/internal/tenant"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type Config struct {
+	JWKSFile    string `env:"JWKS_FILE" default:""`
+	JWKSURL     string `env:"JWKS_URL" default:""`
+	Issuer      string `env:"ISSUER" default:""`
+	Audience    string `env:"AUDIENCE" default:""`
+	RolesClaim  string `env:"ROLES_CLAIM" default:"roles"`
+	TenantClaim string `env:"TENANT_CLAIM" default:"tenant"`
+}
+
+type Role string
+
+const (
+	RoleViewer      Role = "viewer"
+	RoleContributor Role = "contributor"
+	RoleAdmin       Role = "admin"
+)
+
+// roleRanks orders the roles, each one granting the ones ranked below it.
+var roleRanks = map[Role]int{
+	RoleViewer:      1,
+	RoleContributor: 2,
+	RoleAdmin:       3,
+}
+
+// Allows reports whether r grants the required role.
+func (r Role) Allows(required Role) bool {
+	rank, ok := roleRanks[required]
+	return ok && roleRanks[r] >= rank
+}
+
+type Claims struct {
+	Subject string
+	Tenant  string
+	Role    Role
+}
+
+// Verifier verifies RS256 and ES256 signed JWTs against a JWK Set. A verifier without JWK Set
+// rejects every token.
+type Verifier struct {
+	keys        keyfunc.Keyfunc
+	parser      *jwt.Parser
+	rolesClaim  string
+	tenantClaim string
+}
+
+// NewVerifier loads the JWK Set from the configured file, or from the configured URL, which is
+// then refreshed in the background until ctx is done.
+func NewVerifier(ctx context.Context, cfg Config) (*Verifier, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v := &Verifier{
+		parser:      jwt.NewParser(opts...),
+		rolesClaim:  cfg.RolesClaim,
+		tenantClaim: cfg.TenantClaim,
+	}
+
+	var err error
+	switch {
+	case cfg.JWKSFile != "":
+		raw, err := os.ReadFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWKS file: %w", err)
+		}
+		if v.keys, err = keyfunc.NewJWKSetJSON(raw); err != nil {
+			return nil, fmt.Errorf("parsing JWKS file: %w", err)
+		}
+	case cfg.JWKSURL != "":
+		if v.keys, err = keyfunc.NewDefaultCtx(ctx, []string{cfg.JWKSURL}); err != nil {
+			return nil, fmt.Errorf("loading JWKS: %w", err)
+		}
+	}
+
+	return v, nil
+}
+
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Claims, error) {
+	if v.keys == nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims jwt.MapClaims
+	if _, err := v.parser.ParseWithClaims(raw, &claims, v.keys.KeyfuncCtx(ctx)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	id, _ := lookup(claims, v.tenantClaim).(string)
+	if !tenant.Valid(id) {
+		return nil, fmt.Errorf("%w: missing or invalid tenant", ErrInvalidToken)
+	}
+	subject, _ := claims.GetSubject()
+
+	return &Claims{Subject: subject, Tenant: id, Role: strongestRole(lookup(claims, v.rolesClaim))}, nil
+}
+
+// lookup returns the claim at the given dot separated path, such as realm_access.roles.
+func lookup(claims map[string]any, path string) any {
+	var value any = claims
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = obj[name]
+	}
+
+	return value
+}
+
+// strongestRole returns the highest ranked role found in a claim holding either a list of roles
+// or a space separated string of roles.
+func strongestRole(claim any) Role {
+	var names []string
+	switch c := claim.(type) {
+	case string:
+		names = strings.Fields(c)
+	case []any:
+		for _, n := range c {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+
+	var role Role
+	for _, name := range names {
+		if r := Role(name); roleRanks[r] > roleRanks[role] {
+			role = r
+		}
+	}
+
+	return role
+}
@@ -0,0 +1,182 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	file string
+}
+
+// newTestKeys generates an RSA and an EC key, and writes their public JWK Set to a file.
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecPoint, err := ecKey.PublicKey.ECDH()
+	require.NoError(t, err)
+	xy := ecPoint.Bytes()[1:]
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "alg": "ES256", "use": "sig", "crv": "P-256", "x": b64(xy[:32]), "y": b64(xy[32:])},
+	}})
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(file, jwks, 0o600))
+
+	return testKeys{rsa: rsaKey, ec: ecKey, file: file}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(key)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestRole_Allows(t *testing.T) {
+	require.True(t, RoleAdmin.Allows(RoleContributor))
+	require.True(t, RoleContributor.Allows(RoleContributor))
+	require.True(t, RoleContributor.Allows(RoleViewer))
+	require.False(t, RoleViewer.Allows(RoleContributor))
+	require.False(t, Role("").Allows(RoleViewer))
+	require.False(t, RoleAdmin.Allows(Role("owner")))
+}
+
+func TestNewVerifier(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it fails if the JWKS file cannot be read", func(t *testing.T) {
+		_, err := NewVerifier(ctx, Config{JWKSFile: filepath.Join(t.TempDir(), "missing.json")})
+		require.ErrorContains(t, err, "reading JWKS file: ")
+	})
+
+	t.Run("it fails if the JWKS file is not a JWK Set", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(file, []byte("{"), 0o600))
+
+		_, err := NewVerifier(ctx, Config{JWKSFile: file})
+		require.ErrorContains(t, err, "parsing JWKS file: ")
+	})
+
+	t.Run("it rejects every token without JWKS", func(t *testing.T) {
+		keys := newTestKeys(t)
+		v, err := NewVerifier(ctx, Config{})
+		require.NoError(t, err)
+
+		_, err = v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, jwt.MapClaims{"tenant": "club"}))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	ctx := context.Background()
+	keys := newTestKeys(t)
+	exp := time.Now().Add(time.Hour).Unix()
+
+	v, err := NewVerifier(ctx, Config{JWKSFile: keys.file, Issuer: "https://sso", Audience: "scoreplay", RolesClaim: "realm_access.roles", TenantClaim: "tenant"})
+	require.NoError(t, err)
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"iss": "https://sso", "aud": "scoreplay", "sub": "jane", "exp": exp, "tenant": "club"}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	t.Run("it verifies an RS256 token", func(t *testing.T) {
+		raw := sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims(jwt.MapClaims{
+			"realm_access": map[string]any{"roles": []string{"offline_access", "viewer", "contributor"}},
+		}))
+
+		c, err := v.Verify(ctx, raw)
+
+		require.NoError(t, err)
+		require.Equal(t, &Claims{Subject: "jane", Tenant: "club", Role: RoleContributor}, c)
+	})
+
+	t.Run("it verifies an ES256 token", func(t *testing.T) {
+		raw := sign(t, jwt.SigningMethodES256, "ec", keys.ec, claims(jwt.MapClaims{
+			"realm_access": map[string]any{"roles": "admin viewer"},
+		}))
+
+		c, err := v.Verify(ctx, raw)
+
+		require.NoError(t, err)
+		require.Equal(t, &Claims{Subject: "jane", Tenant: "club", Role: RoleAdmin}, c)
+	})
+
+	t.Run("it verifies a token without role", func(t *testing.T) {
+		c, err := v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims(nil)))
+
+		require.NoError(t, err)
+		require.Equal(t, Role(""), c.Role)
+	})
+
+	t.Run("it rejects a token signed by another key", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		_, err = v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", other, claims(nil)))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("it rejects an HS256 token", func(t *testing.T) {
+		_, err := v.Verify(ctx, sign(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(nil)))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("it rejects an expired token", func(t *testing.T) {
+		_, err := v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("it rejects a token without expiration", func(t *testing.T) {
+		c := claims(nil)
+		delete(c, "exp")
+
+		_, err := v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, c))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("it rejects a token of another issuer", func(t *testing.T) {
+		_, err := v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims(jwt.MapClaims{"iss": "https://other"})))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("it rejects a token for another audience", func(t *testing.T) {
+		_, err := v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims(jwt.MapClaims{"aud": "other"})))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("it rejects a token without tenant", func(t *testing.T) {
+		c := claims(nil)
+		delete(c, "tenant")
+
+		_, err := v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, c))
+		require.ErrorIs(t, err, ErrInvalidToken)
+		require.EqualError(t, err, "invalid token: missing or invalid tenant")
+	})
+}
@@ -18,23 +18,76 @@ import (
 // Base64 encoded, gzipped, json marshaled Swagger object
 var swaggerSpec = []string{
 
-	"H4sIAAAAAAAC/7xWS2/bRhD+K4NtgbYAI9lJX9DN7SExkDSCLaMHw4fxckRuyn14dxmHNfTfi9mlHhQZ",
-	"IanRniSSO69v5vtmn4S02llDJgaxeBKegrMmUHr43RNGKvlvSUF65aKyRix2HzabQgRZk8Z0/h2VCvmP",
-	"89aRjyq7Mahp7OMP1AR2DbEm0GwIKpIWhYidI7EQIXplKrEpRMQqjO3fqhCTPVbAEQJgCFYqTgweVaz3",
-	"nkUh2HdyMvaeX6D32PFz65txsJurtxAtoJQUwkHKa9XQOOVNITw9tMozdre5/r6M7P9uZ2LvP5CMHHeF",
-	"1WmQIlZT6Ny4xmJ5RQ8thTgGX1Os7UQL36xWS8gfubJ7gjZQCd+3ocWm6WB5swLrYfn+evXDVNigKkPl",
-	"G8KSPHvHslTsGpvlIP7IcCKNOnkJsAUN1tankn1f1TFchfj0orIv+pd1jG7WZ/K5Bq5qAufpRU4b+n62",
-	"CbvBoJxuJfsutpgegTBuKlsrs7bjdC6Wl6lIjQYrZSpubgA0ZT9X0ppIJqYzwVkfA1hfoVF/I3sIM85U",
-	"xYajXUvradlgB4l/cLG8FIX4SD7kWOez89kZA2MdGXRKLMSr2dnsTBTCYaxTl+Z6S92K4jjdK4pe0cdD",
-	"oga47yAQellz/pwoQnAk1VpJLodT5EFICV+WYiFeU3zXo+zQo6ZIPojF7ahXWHFzsvMM0z4sc5kPPbTk",
-	"O1H04iIyOfatir6lrTZNjOHmrhhK3cuzM/7pcU8D7VyjZMp+/iFwYk8H/nZ68q2ntViIb+Z7HZ33kjjP",
-	"1R6NULa8OxaezYgaF9D0EneIusaYEd9KQtLgVmv0HQ9DBi1ZpBalI4VwNkz09TUZbhABHpODUc/s4Fh4",
-	"IHdFGlNPlQqR/JF8Z+HVFLHEiBBaWQOGpM/JjMd8Bu+NpCMRBRX6eFRCG7YFDrMqeqPeuwqAbbQao5JJ",
-	"s7ZJUTkevqUNu+nrZeU3W3Zf1fRnrTX6hNplwraOPBglCZySsfX0r7be6YW3C3crzl+++lEU4qeff/mV",
-	"5+5LV+GJLTYtdUP2bUYMO/8qsE8Ra7jzJrizHE5zntgmIcWC7HWKesSdfKvpu5YAHY5fOj7f9uW0UOKO",
-	"vNg0eeyn9HCV7wT/vRStsgw8U4hS7UPQ0kBua/y80vTYYrquPdZK1iDRgCfnKfCmQ9PFpGuN+ivpUYMd",
-	"+e+ydhTQ2Fx/sd8xVfpgPXC5FNPtY5r3O5T/H9rnXbTn+5+k7xvq4DpiqVr9ZffFZ1Bsag525+aDu/vx",
-	"7CMYeuzXymbzTwAAAP//dHdqZR0MAAA=",
+	"H4sIAAAAAAAC/+w8a3PcNpJ/BcVL1d3VckYjRU42+iY7dlaJ7aik0WVrZe1VD9lDYkUCDABKmrjmv281",
+	"wOeQnIdsK7Z3PklDEuhGv7vRwHsvkGkmBQqjvZP3XgYKUjSo7K8XMkkwMFyKs5B+h6gDxTN64J14ZyEK",
+	"w+ccFZNzZmJkQfW953ucvsnAxJ7vCUjRO/F46Pmewt9zrjD0TozK0fd0EGMKNL1ZZPSVNoqLyFsufQKR",
+	"ZtKgCBa/4KKLwi+4YNyhseAiskjQ/KiNz3LBf8+RGelwSzgK4zOdBzEDzYBdXZ39OGYXmCEYGgzlUHbP",
+	"TWwHaUiR3eLCPuGCHR2zWOZKM4UmV0Lbj2RuApliSYQ5V9owKZBxoQ1CSC8gyxKLITcMIuCCAOfagSUA",
+	"c6kYCGliVBUanOD8CwODoUMJ2PHkh3FJ2xghRFVTt0GsEVGrSdoUHl6jiEzsnRw9e+Z7KRfl70O/Q/gl",
+	"cUlnUmi0cvAcwguHE/0KpDAo7L+0LB4AceMgU3KWYPqXf2lizfsG8G8Uzr0T778Oalk7cG/1wbkb5YC2",
+	"mfscQlaCXfreCynmCQ+eFIUKJsFXCAZ79KB8sfS9V1LNeBiieEoka6CkMcKgEpBcorpD9VIpqZ4SlxI8",
+	"c/CZQ2Dpe2/lixqF9pi3kpXv7IfmlcxF+JRYv5WGOaBL3yPMeYBXAu6AJzBL8ClRKaCzJvil702lfANi",
+	"UaiDfkqMplIygs0q4EvfuxKQm1gq/gc+KadacC0emZIBak2EeikMN4unRacBnhXw6bNiLE19mvHCd2VK",
+	"ZqgMd0aVb+VST8/PyD94HSPte7d9HnFKXgsDhYaGkfPTKELGhZ3t76PT8zNyDqxyHp1pnTfpKCl5Qvdo",
+	"VnraWCZhjekAlgYF9Gn91D4vR7KI36FmEBAxmZHdmZbNyOHahRLF3AXOjiI31Ug5I99JODwHE8RXWSLJ",
+	"j+k8MV1uYGkp21heIGjpaMcNpiyQeRIyIQ2bIVMYcW2QMOpZeC5uhbwXU4h0z+oh0iXh7MQmBsPuUSET",
+	"SIYzcE7FZ/cxOvjaWVQpkoWlU2Z0+RUzBMT3aCa9SZyn4Pji8AWlYGHRteTZNLgkYuGVl33EzpPbKUSN",
+	"gKFNaQjDAXoYySAM6Q+RYMFSDDlY8nzg2nio1+laxYkaoEUmz0Iw2ITdYXIKD2fu5bPJxIZVxc/DLhYK",
+	"U3mHw2t379lcyfTjEqCjOtq7Wce4x2pIjW5bTxwde5VkOyPYosNuinYahoV+/Nkq1jVgfUyoU64uBwJ5",
+	"h2o3erFcY0jpjh3LeAoR9tHQvr5SSXf2q4vXVjELyxzjpqn47qniLj5oi/F9rsLOuJ7gV07dn5LsO65z",
+	"fdbWs7hXEKDB8A0h9pr3WeM5fTHkoAI5kkGQKwLQSIoRVBA7lfBZKrVhc6I3CuOS3x3U5IXMXdi/aiwt",
+	"LTdNUK9rleduuF8ur4/xb0oIjwnL1lukzWxdP970mjJaqB0PESMImoHWMuBQ1Qeqmdf6rI7n307tHcpz",
+	"nuAOGlesxQEZ5EIpmltJjR3Rtw774tIKZ+3DpMBf597J9daC5K//sqNRyxtKWvF+KMz/sHAaHyDNElrm",
+	"G4phWQgLlsXSyEhBFqPSHzXkrqGB0igg8XwvA2NQ0fB/Xp+O/gGjPyajH/5/dPP+0P/uePnNRllox+h9",
+	"IvAW7/8kr7ej+V1lxgVmUhkWQZKgWmxhn5t0WUeOAdO0o1mp0b3MM1RM8ABZxgOTK3yU1VljcMbsx9zl",
+	"2SRSClmoZJZhOGZXIiktSBFeFVTQbkojKUQso6wZzqXCGETos5RrWyB1kFX10bi5tmvv8OjbY8/3nn33",
+	"/V+Jnh8tQGvarz4+lWWBDsV+RAM8sUkFCGbDZp+EMcQ5F7TGBbt49YJ9/9fJ97SUNo9DO7g76cuHLAFh",
+	"yxhMZxjwOQ9caZtr5pw0iqAShKLS0ZKCWpZtYD63Ba++AE5oAyLAPqdwtlJu3wC8M7c2YPIeKfvbdHrO",
+	"3EsWyLCaqixDNxdyPDmuZubCYIS2wmi4SXpwvoxJR3WepqAW5bQ0eh2p6oKgP+Q/Cc4crJfxYCZzczJL",
+	"QNx6/kaaDYF/RAXh80pp7MuSERWv+3SHZltryGxoaRRPUzLkglJJlULCya4bya4Et1Ly9tWLMbOUmCmE",
+	"24q+EDGVJ1gl9gUVyIh0dlSOjloGxfsN01mCC3ZpIOQ5MWb95knTQf7z3buHyWT07t3D4fzdu4fv5zd/",
+	"+aaPs1Xk2+Pt8t4aeZ7OnKdr1igCUGrRWLTXqxcQbcXcVWba6Rw6fSxsV4IeGUbXFbQNEXGKJpbhgN1w",
+	"LwtPYl3//+Q6hyRZsPOrKZOKnf96Of3fvnk1jwSGf3PFUFec4jQ1JOet5XQG9qDhSqq69G+h3dNr7Eh6",
+	"q1T0vYdRJEfFw9iYbFxgMhSVT63BwJFDmxVBuivdtaL/LeJzAlARdoUSXYYTrTDIFTeLS5IaR5bTMOXi",
+	"F1yc5ibuYmvfcm0UGKko5PRZIMWcRzlRRzYNlO/4ZiRLQUBU1b314G7n30d2+mKns7RLLghf+kXVfQAv",
+	"NzfjWucFm4C5OHVc7XJYY6EDmTm0rIbZTxoB+jrkzs+GUHuOoFCVqM3sr1dk3ox34v3827TjQi4uj559",
+	"R2L80v5TMP/n36blCmYLR0ouogTt+5EU5FnueIhqhzUZeYtizKZx8VkZxJAquNiDFmg/VTJB2s0uoznr",
+	"cxQyIK6wSIEgTyOFUXyWGxsCibD5oPzmjuM9qnG5XY3eSUGTmnakGm77hYu57GcocdHKTh0yitKqFNtB",
+	"9hudSWU0kyoCwf+wi9LjymudeJeBVHiewILZKJwE0fO9O1TawTocH44nxEaZoYCMeyfet+PJeOJSpdiq",
+	"xYElwgFkfEQyTI8yqXvM+hnxjwETeF9KPKVltIQqL3N5CBhIZGTZUYnqtEjkuNGYzBnXzs273gSrYQHa",
+	"yNM+5kazGHRM32kjlQukK85Sh4d3LrWxauXURxftGqjNcxmu21nbbUetTpeXy+VqR8hq88HR5PCjAW5C",
+	"7VrWQpsKRhCPjyeToSkrHA8a3RF2yOHmIavbmMdHP2wetLoHvPS9Z9vg19cSYMd+u3lsz2Z40xXYwkrb",
+	"CVzfLG98r4i3axEXNVmX/qqCHLzn4dJpR4IG+zYZ7uRtc5aGTct12VfTjO7sLoqQ90yKrpj/aME0Bf3M",
+	"dSa1xO54M3nqroanFJbtMDNVR8NXLF0dwSDYzSa261222D+8ZY2KgQd1om0lKULTJ9FGcbwj458UFRZI",
+	"kka9SfuM6oG6qKl3hfgnNC8agDriO9nJam6VBNbwenLBjkk9rVbWJMiTKsoWMtjqnvriNKVSBFumW5Eg",
+	"qwu9cYdrWWPAgly5goFUoU3D6uEryWazczK15U/VKn/2BxOrEvpJYommWD5tPLEKuRtTlLWWoKU6n7MG",
+	"HG0Dptt19cX7mUbCeH1D20DNPO3aa2Qu3ooTqrWpKQ5tR7AxxHExSWsSF+E3Cz7c2FwGuHC53C1mZii8",
+	"aajelxXd7Cyy/1nx0OPltEfECLX18QlFSDbpd+l7tZ/mUtGGsG4IUPpkcPKUhvhLMcBfvTRX8vgTmo4w",
+	"rsTufRDqTw5aB1RI2DOKTPqk2YlwK7xRLIhBRGgrIw3JHrNTwTDNzKJ46hrzyPr2BDkEsCvmHz/O6fQp",
+	"bRXqPKWGFW1+X7Om7WOjXX2OE9YtYqODKgPd7I+aIdGKG/IZF20tD1F11XbVN7mu3U+oPs1WtV7tWVmT",
+	"2buszzbNLpv2O8L3MRxY/z6B0KhMOxUQRrYdGjjMqK1LsExq7vRBqvINinDMrKQzSBRCuCjPhTRnUcjI",
+	"3YUbE/qm1jzO361uGT+qS59b2li9t8q+vl9/XYN+SbMuFv9AJUcz0BhWhG0f93RIYFhuYNPGZ5qn3smk",
+	"uyW/VTv+Ns79PzqF27viXV3xaRjWWtMxW3mv080SCHDY4NVdgM7qtOqFXDTeDPjh8/wzsyhb2pFHHLDZ",
+	"a/Reoz+yRl+gldR1AclwqH3w3or92aadV3soDZrd1XZvdV2hstGRsHWF0mr+G4fRvlq5r1ZWkicGhO5D",
+	"g21/x1M+PZvDaUNcd9ohxodMKjOY7F4ahdA9BFqi5fp/fHvHSIaKJVyg9VtCsgyU4UGegCpcbtHGVO5D",
+	"ScWcqocszFXZO+rwYSksqIUywblhMjdjdsrmwJNcoe0lan6qDdh4F2a2narZq+0zTfGFUbkILMxiSACC",
+	"ZjcyCRkQnjVPqfXWEBDsTdVfOnJt2Nl3PXQlkRxQn/18+etb9poL1LT2F5f/Vzb+up49puR9ydzfc3eM",
+	"o+Du3M7Xukalbvmm0CPxfA8FRfnX1e9A3zV8f831nUoLDyMRdmObedkjOOMC1KK3y9PggzkgHHYcuakk",
+	"4VeRHleMh77dEvBtn53vZIuiQcOLfYKri9f7jf5PU4FwutAwT0VroHP11bHJ9UW0Zgo9WxQnOskYuF7Y",
+	"+oAJRL0K+aZoM16rj1OI3P0TNLmduQF2QOdc1/mwNd2ii73THJpoWfREMtvOrhuHDLY54zpkHtzR0l7z",
+	"MIdEY6VlMykTBLGzGXhEhbF1/HFtT05TBGw/R+Mcgc/uFWSuSzhCeyeVowxEJQntARNHgqLxzipJqY/7",
+	"9obPyWY4qXAstwpv3Gme/mLjTyhI3SnoXzlrQDrsDhu4i8vqI8Guu7s8zrF6HNMKT4oGQjBQ9RFVu8qk",
+	"h2P2axlh1LNSAuHgYVj0eZrOCQi/GFTMzjWD3MgUDA/sEZD6jEl/RXPAlm2IJVdupnOa/Um6mxx+T9zY",
+	"tHrPS8eQnLclw3E/seEeFy7e+AL2LbZQ6OYVcPsaxaObo5xSW0PQVt9G3HJAhuBklie3wycmqIjphM1m",
+	"hzSCScE05UqQtLwa5QIiwDF7CUHceOOsiturBiNTZyf8elpInE+z6kMfzQ0qVh4N067aoayPLZogNRk1",
+	"4jnlGGXKlGEz4rEJWnmz41A1tDRHdKSRruP5RL0EK1c0fYJOgq3aidsXDm3RUXyOamQ56IivuzTdRx9f",
+	"465Fj8KndCNhWrjG0oCczMr2n37jcVHGJ2vMhQUW9YZAxTnLMhJCMisulUirfVWFrGCf9YQhZihCFIYM",
+	"jJbMbG05drYZ9pK7DzAYW6lsHY/scvfZn2VfOtf+7W3M3sasiVEqm9IXqGhnaMpbUnY51WMTnL46ytTd",
+	"wPDpVaG4vqG1S2nH3exyjseufV/X+7QHeEoibzi5Q7WY+5gHsa2rK8wUahSGgVgYW8tJOB2MY1kCC1T/",
+	"rYuKbSKdIPl1kS+yL6SydXg0vL+bm/xMIa5/Wqbcfx3SrjdbDNy8tHWCvSFfrG8D32e+X79XsQeZBw8E",
+	"0bl+A1HtOQ7ek/QtD4payXbtrjSwfc9isijvy2iVZ6tum6GbGQedEN29c1HgtKGsv3JNT//OqP3zkQv5",
+	"b+CBeuqYqO7CKStOprpBl6r8A/X6hKd8YDfvcGKjWdexd1gEs8Wvnv69myfy2AOXY67z0C2KVEIhem8P",
+	"MjEumI5BYY/47IPczzREaHLYW+5sr9xtK2Swlv8eAIcFKw78ZQAA",
 }
 
 // GetSwagger returns the content of the embedded swagger specification file
@@ -4,11 +4,109 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+
+	"github.com/oapi-codegen/runtime"
+)
+
+const (
+	AdminKeyAuthScopes = "AdminKeyAuth.Scopes"
+	ApiKeyAuthScopes   = "ApiKeyAuth.Scopes"
+	BearerAuthScopes   = "BearerAuth.Scopes"
 )
 
+// Defines values for GetExportParamsFormat.
+const (
+	Csv   GetExportParamsFormat = "csv"
+	Jsonl GetExportParamsFormat = "jsonl"
+)
+
+// ApiKey defines model for ApiKey.
+type ApiKey struct {
+	// Id Identifier of the API key
+	Id string `json:"id"`
+
+	// Key The secret key to send in the X-API-Key header
+	Key string `json:"key"`
+
+	// Name Name describing the holder of the key
+	Name string `json:"name"`
+
+	// Tenant Tenant the key gives access to
+	Tenant string `json:"tenant"`
+}
+
+// BatchUploadResult defines model for BatchUploadResult.
+type BatchUploadResult struct {
+	// Error Reason the item could not be registered
+	Error *string `json:"error,omitempty"`
+
+	// UnknownTags Tags of the item that were never created, when the server only accepts created tags
+	UnknownTags *[]Tag         `json:"unknownTags,omitempty"`
+	Upload      *UploadRequest `json:"upload,omitempty"`
+}
+
+// BulkTagRequest defines model for BulkTagRequest.
+type BulkTagRequest struct {
+	// Add Tags to add to every media item
+	Add *[]Tag `json:"add,omitempty"`
+
+	// Ids Identifiers of the media items to update
+	Ids []string `json:"ids"`
+
+	// Remove Tags to remove from every media item
+	Remove *[]Tag `json:"remove,omitempty"`
+}
+
+// BulkTagResult defines model for BulkTagResult.
+type BulkTagResult struct {
+	// Error Reason the media item could not be updated
+	Error *string `json:"error,omitempty"`
+
+	// Id Identifier of the media item
+	Id string `json:"id"`
+
+	// UnknownTags Added tags that were never created, when the server only accepts created tags
+	UnknownTags *[]Tag `json:"unknownTags,omitempty"`
+}
+
+// Collection defines model for Collection.
+type Collection struct {
+	// Cover Identifier of the media item used as cover image
+	Cover *string `json:"cover,omitempty"`
+
+	// CoverUrl URL to access the cover image
+	CoverUrl *string `json:"coverUrl,omitempty"`
+
+	// Id Identifier of the collection
+	Id string `json:"id"`
+
+	// Name Name of the collection
+	Name string `json:"name"`
+}
+
+// CollectionUpdate defines model for CollectionUpdate.
+type CollectionUpdate struct {
+	// Cover Identifier of the media item used as cover image
+	Cover *string `json:"cover,omitempty"`
+
+	// Name Name of the collection
+	Name *string `json:"name,omitempty"`
+}
+
+// FacetedMediaList defines model for FacetedMediaList.
+type FacetedMediaList struct {
+	// Facets Tags co-occurring with the searched tag, most frequent first
+	Facets []TagCount `json:"facets"`
+	Media  MediaList  `json:"media"`
+}
+
 // Media defines model for Media.
 type Media struct {
+	// Id Identifier of the media item
+	Id string `json:"id"`
+
 	// Name Name of the media item
 	Name string `json:"name"`
 
@@ -19,11 +117,79 @@ type Media struct {
 	Url string `json:"url"`
 }
 
-// Tag Name of the tag
+// MediaList defines model for MediaList.
+type MediaList = []Media
+
+// MediaSearchResult defines model for MediaSearchResult.
+type MediaSearchResult struct {
+	union json.RawMessage
+}
+
+// NewApiKey defines model for NewApiKey.
+type NewApiKey struct {
+	// Name Name describing the holder of the key
+	Name string `json:"name"`
+
+	// Tenant Tenant the key gives access to
+	Tenant string `json:"tenant"`
+}
+
+// NewCollection defines model for NewCollection.
+type NewCollection struct {
+	// Cover Identifier of the media item used as cover image
+	Cover *string `json:"cover,omitempty"`
+
+	// Name Name of the collection
+	Name string `json:"name"`
+}
+
+// NewMedia defines model for NewMedia.
+type NewMedia struct {
+	// Name Name of the media item
+	Name string `json:"name"`
+
+	// Tags List of tags associated with the media. Duplicates are dropped. Unless the server requires tags to be created beforehand, missing tags are created.
+	Tags []Tag `json:"tags"`
+}
+
+// Problem Details of an error, as defined by RFC 7807.
+type Problem struct {
+	// Detail Explanation specific to this occurrence of the problem
+	Detail *string `json:"detail,omitempty"`
+
+	// Instance URI identifying this occurrence of the problem
+	Instance *string `json:"instance,omitempty"`
+
+	// Status HTTP status code of the response
+	Status int `json:"status"`
+
+	// Title Short summary of the type of the problem
+	Title string `json:"title"`
+
+	// Type URI identifying the type of the problem
+	Type string `json:"type"`
+
+	// UnknownTags Tags that were never created, when the server only accepts created tags
+	UnknownTags *[]Tag `json:"unknownTags,omitempty"`
+}
+
+// Tag Name of the tag, trimmed and normalised to Unicode NFC. Tags breaking the tag rules of the server are rejected with a 422.
 type Tag = string
 
+// TagCount defines model for TagCount.
+type TagCount struct {
+	// Count Number of media items carrying the tag
+	Count int `json:"count"`
+
+	// Tag Name of the tag, trimmed and normalised to Unicode NFC. Tags breaking the tag rules of the server are rejected with a 422.
+	Tag Tag `json:"tag"`
+}
+
 // UploadRequest defines model for UploadRequest.
 type UploadRequest struct {
+	// Id Identifier of the registered media item
+	Id string `json:"id"`
+
 	// Method HTTP method to be used (usually PUT or POST)
 	Method string `json:"method"`
 
@@ -34,29 +200,178 @@ type UploadRequest struct {
 	Url string `json:"url"`
 }
 
+// BadRequest Details of an error, as defined by RFC 7807.
+type BadRequest = Problem
+
+// Conflict Details of an error, as defined by RFC 7807.
+type Conflict = Problem
+
+// Forbidden Details of an error, as defined by RFC 7807.
+type Forbidden = Problem
+
+// InternalServerError Details of an error, as defined by RFC 7807.
+type InternalServerError = Problem
+
+// NotFound Details of an error, as defined by RFC 7807.
+type NotFound = Problem
+
+// ServiceUnavailable Details of an error, as defined by RFC 7807.
+type ServiceUnavailable = Problem
+
+// TooManyRequests Details of an error, as defined by RFC 7807.
+type TooManyRequests = Problem
+
+// Unauthorized Details of an error, as defined by RFC 7807.
+type Unauthorized = Problem
+
+// UnprocessableEntity Details of an error, as defined by RFC 7807.
+type UnprocessableEntity = Problem
+
+// PostCollectionsIdItemsJSONBody defines parameters for PostCollectionsIdItems.
+type PostCollectionsIdItemsJSONBody struct {
+	// Ids Identifiers of the media items to insert, in order
+	Ids []string `json:"ids"`
+
+	// Position Zero-based position of the first inserted item
+	Position *int `json:"position,omitempty"`
+}
+
+// PutCollectionsIdItemsJSONBody defines parameters for PutCollectionsIdItems.
+type PutCollectionsIdItemsJSONBody struct {
+	// Ids Identifiers of the media items, in order
+	Ids []string `json:"ids"`
+}
+
+// GetExportParams defines parameters for GetExport.
+type GetExportParams struct {
+	// Format Format of the export, JSON Lines or CSV with a header row
+	Format *GetExportParamsFormat `form:"format,omitempty" json:"format,omitempty"`
+}
+
+// GetExportParamsFormat defines parameters for GetExport.
+type GetExportParamsFormat string
+
 // GetMediaParams defines parameters for GetMedia.
 type GetMediaParams struct {
 	// Tag Tag to search for media items
-	Tag string `form:"tag" json:"tag"`
+	Tag Tag `form:"tag" json:"tag"`
+
+	// Facets Also return counts of the tags co-occurring with the searched tag
+	Facets *bool `form:"facets,omitempty" json:"facets,omitempty"`
 }
 
-// PostMediaJSONBody defines parameters for PostMedia.
-type PostMediaJSONBody struct {
-	// Name Name of the media item
-	Name string `json:"name"`
+// PostMediaParams defines parameters for PostMedia.
+type PostMediaParams struct {
+	// IdempotencyKey Key identifying the request, unique to the client, such as a UUID. Repeating a request with the same key within 24 hours returns the outcome of the first one instead of applying it again. Reusing a key for another request is rejected with a 409.
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
+}
 
-	// Tags List of tags associated with the media
-	Tags []string `json:"tags"`
+// PostMediaBatchJSONBody defines parameters for PostMediaBatch.
+type PostMediaBatchJSONBody = []NewMedia
+
+// PostTagsParams defines parameters for PostTags.
+type PostTagsParams struct {
+	// IdempotencyKey Key identifying the request, unique to the client, such as a UUID. Repeating a request with the same key within 24 hours returns the outcome of the first one instead of applying it again. Reusing a key for another request is rejected with a 409.
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
 }
 
 // PostTagsJSONBody defines parameters for PostTags.
 type PostTagsJSONBody struct {
-	// Name Name of the tag
-	Name string `json:"name"`
+	// Name Name of the tag, trimmed and normalised to Unicode NFC. Tags breaking the tag rules of the server are rejected with a 422.
+	Name Tag `json:"name"`
+}
+
+// GetTagsNameRelatedParams defines parameters for GetTagsNameRelated.
+type GetTagsNameRelatedParams struct {
+	// Limit Maximum number of related tags to return
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
 }
 
+// PostAdminApiKeysJSONRequestBody defines body for PostAdminApiKeys for application/json ContentType.
+type PostAdminApiKeysJSONRequestBody = NewApiKey
+
+// PostCollectionsJSONRequestBody defines body for PostCollections for application/json ContentType.
+type PostCollectionsJSONRequestBody = NewCollection
+
+// PatchCollectionsIdJSONRequestBody defines body for PatchCollectionsId for application/json ContentType.
+type PatchCollectionsIdJSONRequestBody = CollectionUpdate
+
+// PostCollectionsIdItemsJSONRequestBody defines body for PostCollectionsIdItems for application/json ContentType.
+type PostCollectionsIdItemsJSONRequestBody PostCollectionsIdItemsJSONBody
+
+// PutCollectionsIdItemsJSONRequestBody defines body for PutCollectionsIdItems for application/json ContentType.
+type PutCollectionsIdItemsJSONRequestBody PutCollectionsIdItemsJSONBody
+
 // PostMediaJSONRequestBody defines body for PostMedia for application/json ContentType.
-type PostMediaJSONRequestBody PostMediaJSONBody
+type PostMediaJSONRequestBody = NewMedia
+
+// PostMediaTagsBulkJSONRequestBody defines body for PostMediaTagsBulk for application/json ContentType.
+type PostMediaTagsBulkJSONRequestBody = BulkTagRequest
+
+// PostMediaBatchJSONRequestBody defines body for PostMediaBatch for application/json ContentType.
+type PostMediaBatchJSONRequestBody PostMediaBatchJSONBody
 
 // PostTagsJSONRequestBody defines body for PostTags for application/json ContentType.
 type PostTagsJSONRequestBody PostTagsJSONBody
+
+// AsMediaList returns the union data inside the MediaSearchResult as a MediaList
+func (t MediaSearchResult) AsMediaList() (MediaList, error) {
+	var body MediaList
+	err := json.Unmarshal(t.union, &body)
+	return body, err
+}
+
+// FromMediaList overwrites any union data inside the MediaSearchResult as the provided MediaList
+func (t *MediaSearchResult) FromMediaList(v MediaList) error {
+	b, err := json.Marshal(v)
+	t.union = b
+	return err
+}
+
+// MergeMediaList performs a merge with any union data inside the MediaSearchResult, using the provided MediaList
+func (t *MediaSearchResult) MergeMediaList(v MediaList) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	merged, err := runtime.JSONMerge(t.union, b)
+	t.union = merged
+	return err
+}
+
+// AsFacetedMediaList returns the union data inside the MediaSearchResult as a FacetedMediaList
+func (t MediaSearchResult) AsFacetedMediaList() (FacetedMediaList, error) {
+	var body FacetedMediaList
+	err := json.Unmarshal(t.union, &body)
+	return body, err
+}
+
+// FromFacetedMediaList overwrites any union data inside the MediaSearchResult as the provided FacetedMediaList
+func (t *MediaSearchResult) FromFacetedMediaList(v FacetedMediaList) error {
+	b, err := json.Marshal(v)
+	t.union = b
+	return err
+}
+
+// MergeFacetedMediaList performs a merge with any union data inside the MediaSearchResult, using the provided FacetedMediaList
+func (t *MediaSearchResult) MergeFacetedMediaList(v FacetedMediaList) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	merged, err := runtime.JSONMerge(t.union, b)
+	t.union = merged
+	return err
+}
+
+func (t MediaSearchResult) MarshalJSON() ([]byte, error) {
+	b, err := t.union.MarshalJSON()
+	return b, err
+}
+
+func (t *MediaSearchResult) UnmarshalJSON(b []byte) error {
+	err := t.union.UnmarshalJSON(b)
+	return err
+}
@@ -4,7 +4,9 @@ package tests
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
@@ -22,17 +24,55 @@ func TestBasic(t *testing.T) {
 		Storage struct {
 			Bucket string `env:"BUCKET,required"`
 		} `env:"STORAGE"`
+		Auth struct {
+			AdminKey string `env:"ADMIN_KEY,required"`
+		} `env:"AUTH"`
 		APIURL string `env:"APIURL,required"`
 	}
 	err := env.Load(&cfg, &env.Options{NameSep: "_", SliceSep: ","})
 	require.NoError(t, err)
 
-	c, err := api.NewClientWithResponses(cfg.APIURL)
-	require.NoError(t, err)
-
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
+	withHeader := func(name, value string) api.ClientOption {
+		return api.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+			req.Header.Set(name, value)
+			return nil
+		})
+	}
+	admin, err := api.NewClientWithResponses(cfg.APIURL, withHeader("X-Admin-Key", cfg.Auth.AdminKey))
+	require.NoError(t, err)
+	issueKey := func(tenant string) api.ApiKey {
+		resp, err := admin.PostAdminApiKeysWithResponse(ctx, api.NewApiKey{Tenant: tenant, Name: "integration tests"})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		require.Equal(t, tenant, resp.JSON201.Tenant)
+		return *resp.JSON201
+	}
+
+	anonymous, err := api.NewClientWithResponses(cfg.APIURL)
+	require.NoError(t, err)
+	anonymousResp, err := anonymous.GetTagsWithResponse(ctx)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, anonymousResp.StatusCode())
+
+	// Every run works in a tenant of its own, so runs start from an empty catalog.
+	tenant := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	key := issueKey(tenant)
+	c, err := api.NewClientWithResponses(cfg.APIURL, withHeader("X-API-Key", key.Key))
+	require.NoError(t, err)
+	otherKey := issueKey(tenant + "-other")
+	other, err := api.NewClientWithResponses(cfg.APIURL, withHeader("X-API-Key", otherKey.Key))
+	require.NoError(t, err)
+	defer func() {
+		for _, k := range []api.ApiKey{key, otherKey} {
+			resp, err := admin.DeleteAdminApiKeysIdWithResponse(ctx, k.Id)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusNoContent, resp.StatusCode())
+		}
+	}()
+
 	expectTags := func(tags []api.Tag) {
 		resp, err := c.GetTagsWithResponse(ctx)
 		require.NoError(t, err)
@@ -43,24 +83,66 @@ func TestBasic(t *testing.T) {
 		resp, err := c.GetMediaWithResponse(ctx, &api.GetMediaParams{Tag: tag})
 		require.NoError(t, err)
 		require.Equal(t, http.StatusOK, resp.StatusCode())
-		for i, m := range *resp.JSON200 {
+		list, err := resp.JSON200.AsMediaList()
+		require.NoError(t, err)
+		for i, m := range list {
 			require.Equal(t, media[i].Name, m.Name, i)
 			require.Equal(t, media[i].Tags, m.Tags, i)
-			require.Contains(t, m.Url, cfg.AWS.EndpointUrl+"/"+cfg.Storage.Bucket+"/")
+			require.Contains(t, m.Url, cfg.AWS.EndpointUrl+"/"+cfg.Storage.Bucket+"/"+tenant+"/")
 		}
 	}
+	expectFacets := func(tag api.Tag, facets []api.TagCount) {
+		resp, err := c.GetMediaWithResponse(ctx, &api.GetMediaParams{Tag: tag, Facets: &[]bool{true}[0]})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		list, err := resp.JSON200.AsFacetedMediaList()
+		require.NoError(t, err)
+		require.Equal(t, facets, list.Facets)
+	}
 	addTag := func(name string) {
-		resp, err := c.PostTagsWithResponse(ctx, api.PostTagsJSONRequestBody{Name: name})
+		resp, err := c.PostTagsWithResponse(ctx, nil, api.PostTagsJSONRequestBody{Name: name})
 		require.NoError(t, err)
 		require.Equal(t, http.StatusCreated, resp.StatusCode())
 	}
 	addMedia := func(tags []api.Tag, name string) {
-		resp, err := c.PostMediaWithResponse(ctx, api.PostMediaJSONRequestBody{Tags: tags, Name: name})
+		resp, err := c.PostMediaWithResponse(ctx, nil, api.PostMediaJSONRequestBody{Tags: tags, Name: name})
 		require.NoError(t, err)
 		require.Equal(t, http.StatusCreated, resp.StatusCode())
 		require.Equal(t, http.MethodPut, resp.JSON201.Method)
-		require.Equal(t, http.Header{"Host": []string{"localstack:4566"}}, resp.JSON201.SignedHeader)
-		require.Contains(t, resp.JSON201.Url, cfg.AWS.EndpointUrl+"/"+cfg.Storage.Bucket+"/")
+		require.Equal(t, []string{"localstack:4566"}, resp.JSON201.SignedHeader["Host"])
+		require.Equal(t, []string{url.QueryEscape(name)}, resp.JSON201.SignedHeader["X-Amz-Meta-Name"])
+		require.Contains(t, resp.JSON201.SignedHeader, "X-Amz-Meta-Tags")
+		require.Contains(t, resp.JSON201.Url, cfg.AWS.EndpointUrl+"/"+cfg.Storage.Bucket+"/"+tenant+"/"+resp.JSON201.Id)
+	}
+	addMediaBatch := func(media []api.NewMedia) {
+		resp, err := c.PostMediaBatchWithResponse(ctx, media)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		require.Len(t, *resp.JSON200, len(media))
+		for i, r := range *resp.JSON200 {
+			require.Nil(t, r.Error, i)
+			require.Equal(t, http.MethodPut, r.Upload.Method, i)
+		}
+	}
+	bulkTag := func(tag api.Tag, add, remove []api.Tag) {
+		resp, err := c.GetMediaWithResponse(ctx, &api.GetMediaParams{Tag: tag})
+		require.NoError(t, err)
+		list, err := resp.JSON200.AsMediaList()
+		require.NoError(t, err)
+		ids := []string{"missing"}
+		for _, m := range list {
+			ids = append(ids, m.Id)
+		}
+
+		bulkResp, err := c.PostMediaTagsBulkWithResponse(ctx, api.BulkTagRequest{Ids: ids, Add: &add, Remove: &remove})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, bulkResp.StatusCode())
+		require.Len(t, *bulkResp.JSON200, len(ids))
+		require.NotNil(t, (*bulkResp.JSON200)[0].Error)
+		for i, r := range (*bulkResp.JSON200)[1:] {
+			require.Equal(t, ids[i+1], r.Id)
+			require.Nil(t, r.Error, i)
+		}
 	}
 
 	expectTags([]api.Tag{})
@@ -84,4 +166,74 @@ func TestBasic(t *testing.T) {
 	expectMedia("ta,g3", []api.Media{
 		{Name: "media2", Tags: []api.Tag{"tag2", "ta,g3"}},
 	})
+	expectFacets("tag2", []api.TagCount{{Tag: "ta,g3", Count: 1}, {Tag: "tag1", Count: 1}})
+
+	idempotencyKey := fmt.Sprintf("retry-%d", time.Now().UnixNano())
+	first, err := c.PostMediaWithResponse(ctx, &api.PostMediaParams{IdempotencyKey: &idempotencyKey}, api.PostMediaJSONRequestBody{Tags: []api.Tag{"retried"}, Name: "media3"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, first.StatusCode())
+	retry, err := c.PostMediaWithResponse(ctx, &api.PostMediaParams{IdempotencyKey: &idempotencyKey}, api.PostMediaJSONRequestBody{Tags: []api.Tag{"retried"}, Name: "media3"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, retry.StatusCode())
+	require.Equal(t, first.JSON201.Id, retry.JSON201.Id)
+	reused, err := c.PostMediaWithResponse(ctx, &api.PostMediaParams{IdempotencyKey: &idempotencyKey}, api.PostMediaJSONRequestBody{Tags: []api.Tag{"retried"}, Name: "media4"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, reused.StatusCode())
+	expectMedia("retried", []api.Media{{Name: "media3", Tags: []api.Tag{"retried"}}})
+
+	otherResp, err := other.GetTagsWithResponse(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &[]api.Tag{}, otherResp.JSON200)
+	otherMedia, err := other.GetMediaWithResponse(ctx, &api.GetMediaParams{Tag: "tag2"})
+	require.NoError(t, err)
+	otherList, err := otherMedia.JSON200.AsMediaList()
+	require.NoError(t, err)
+	require.Empty(t, otherList)
+
+	addMediaBatch([]api.NewMedia{{Name: "media3", Tags: []api.Tag{"tag4"}}, {Name: "media4", Tags: []api.Tag{"tag4"}}})
+	expectFacets("tag4", []api.TagCount{})
+	bulkTag("tag4", []api.Tag{"tag5"}, []api.Tag{"tag4"})
+	expectMedia("tag4", []api.Media{})
+	expectMedia("tag5", []api.Media{
+		{Name: "media3", Tags: []api.Tag{"tag5"}},
+		{Name: "media4", Tags: []api.Tag{"tag5"}},
+	})
+
+	collResp, err := c.PostCollectionsWithResponse(ctx, api.NewCollection{Name: "gallery"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, collResp.StatusCode())
+	collection := collResp.JSON201.Id
+
+	mediaResp, err := c.GetMediaWithResponse(ctx, &api.GetMediaParams{Tag: "tag2"})
+	require.NoError(t, err)
+	list, err := mediaResp.JSON200.AsMediaList()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	addResp, err := c.PostCollectionsIdItemsWithResponse(ctx, collection, api.PostCollectionsIdItemsJSONRequestBody{Ids: []string{list[0].Id, list[1].Id}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, addResp.StatusCode())
+	addResp, err = c.PostCollectionsIdItemsWithResponse(ctx, collection, api.PostCollectionsIdItemsJSONRequestBody{Ids: []string{list[1].Id}, Position: &[]int{0}[0]})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, addResp.StatusCode())
+	addResp, err = c.PostCollectionsIdItemsWithResponse(ctx, collection, api.PostCollectionsIdItemsJSONRequestBody{Ids: []string{"missing"}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, addResp.StatusCode())
+
+	itemsResp, err := c.GetCollectionsIdItemsWithResponse(ctx, collection)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, itemsResp.StatusCode())
+	require.Equal(t, api.MediaList{list[1], list[0]}, *itemsResp.JSON200)
+
+	patchResp, err := c.PatchCollectionsIdWithResponse(ctx, collection, api.CollectionUpdate{Cover: &list[0].Id})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, patchResp.StatusCode())
+	require.Equal(t, &list[0].Url, patchResp.JSON200.CoverUrl)
+
+	deleteResp, err := c.DeleteCollectionsIdWithResponse(ctx, collection)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, deleteResp.StatusCode())
+	getResp, err := c.GetCollectionsIdWithResponse(ctx, collection)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, getResp.StatusCode())
 }